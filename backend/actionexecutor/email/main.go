@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"net/smtp"
 	"os"
 	"runtime"
 	"strings"
 	"text/template" // For simple text templating
+	"time"
 
+	"example.com/project/secrets"
 	"github.com/nats-io/nats.go"
 )
 
@@ -50,8 +53,15 @@ var (
 	smtpUser         string
 	smtpPass         string
 	defaultFromEmail string
+	// processingServiceURL is where redacted PII attributes of entity instances are revealed.
+	processingServiceURL string
 )
 
+// redactedValue is the placeholder of PII attribute values in task messages.
+const redactedValue = "[REDACTED]"
+
+var processingClient = &http.Client{Timeout: 10 * time.Second}
+
 func loadConfig() {
 	natsURL = os.Getenv("NATS_URL")
 	if natsURL == "" {
@@ -62,7 +72,27 @@ func loadConfig() {
 	smtpPort = os.Getenv("SMTP_PORT")
 	smtpUser = os.Getenv("SMTP_USER")
 	smtpPass = os.Getenv("SMTP_PASS")
+	// SMTP_USER_REF and SMTP_PASS_REF take secret references ("env:NAME" or "file:/run/secrets/smtp_pass")
+	// and override SMTP_USER and SMTP_PASS.
+	if ref := os.Getenv("SMTP_USER_REF"); ref != "" {
+		user, err := secrets.Resolve(ref)
+		if err != nil {
+			log.Fatalf("Failed to resolve SMTP_USER_REF: %v", err)
+		}
+		smtpUser = user
+	}
+	if ref := os.Getenv("SMTP_PASS_REF"); ref != "" {
+		pass, err := secrets.Resolve(ref)
+		if err != nil {
+			log.Fatalf("Failed to resolve SMTP_PASS_REF: %v", err)
+		}
+		smtpPass = pass
+	}
 	defaultFromEmail = os.Getenv("DEFAULT_FROM_EMAIL")
+	processingServiceURL = os.Getenv("PROCESSING_SERVICE_URL")
+	if processingServiceURL == "" {
+		processingServiceURL = "http://localhost:8082"
+	}
 
 	if smtpHost == "" || smtpPort == "" {
 		log.Println("Warning: SMTP_HOST or SMTP_PORT not configured. Email sending will be simulated.")
@@ -101,7 +131,7 @@ func handleEmailTask(msg *nats.Msg) {
 
 	var task TaskMessage
 	if err := json.Unmarshal(msg.Data, &task); err != nil {
-		log.Printf("Error unmarshalling TaskMessage for TaskID %s: %v. Message data: %s", task.TaskID, err, string(msg.Data))
+		log.Printf("Error unmarshalling TaskMessage for TaskID %s: %v (%d bytes)", task.TaskID, err, len(msg.Data))
 		// Consider sending to a dead-letter queue or logging more permanently
 		return
 	}
@@ -118,6 +148,11 @@ func handleEmailTask(msg *nats.Msg) {
 		return
 	}
 
+	if err := revealEntityInstance(&task); err != nil {
+		log.Printf("TaskID %s: Error revealing PII attributes of entity %s: %v. Skipping email.", task.TaskID, task.EntityInstanceID, err)
+		return
+	}
+
 	templateData := TemplateData{
 		Entity: task.EntityInstance,
 		Params: task.ActionParams,
@@ -203,7 +238,46 @@ func handleEmailTask(msg *nats.Msg) {
 		return
 	}
 
-	log.Printf("TaskID %s: Email successfully sent to %s (CC: %s, BCC: %s) via %s", task.TaskID, strings.Join(toRecipients, ", "), strings.Join(ccRecipients, ", "), strings.Join(bccRecipients, ", "), smtpAddr)
+	log.Printf("TaskID %s: Email successfully sent to %d recipients (%d CC, %d BCC) via %s", task.TaskID, len(toRecipients), len(ccRecipients), len(bccRecipients), smtpAddr)
+}
+
+// revealEntityInstance replaces the entity instance of a task with the one revealed by the processing
+// service if PII attributes were redacted. The processing service decrypts only the attributes that
+// the EMAIL action type is authorized for.
+func revealEntityInstance(task *TaskMessage) error {
+	redacted := false
+	for _, value := range task.EntityInstance {
+		if value == redactedValue {
+			redacted = true
+			break
+		}
+	}
+	if !redacted || task.EntityInstanceID == "" {
+		return nil
+	}
+
+	reqBody, err := json.Marshal(map[string]string{"action_type": "EMAIL"})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/internal/v1/entities/%s/reveal", processingServiceURL, task.EntityInstanceID)
+	resp, err := processingClient.Post(url, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to call processing service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("processing service returned non-OK status %d", resp.StatusCode)
+	}
+
+	var revealed struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&revealed); err != nil {
+		return fmt.Errorf("failed to decode revealed entity: %w", err)
+	}
+	task.EntityInstance = revealed.Attributes
+	return nil
 }
 
 func applyTemplate(templateName string, templateStr string, data TemplateData) (string, error) {
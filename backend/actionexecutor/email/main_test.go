@@ -3,7 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -332,4 +335,55 @@ func TestHandleEmailTask(t *testing.T) {
 	})
 }
 
+
+func TestRevealEntityInstance(t *testing.T) {
+	smtpHost = ""
+	smtpPort = ""
+	emailContent := EmailTemplateContent{
+		SubjectTemplate:      "Hello {{ .Entity.name }}",
+		BodyTemplate:         "Body",
+		ToRecipientsTemplate: "{{ .Entity.email }}",
+	}
+	templateContentJSON, _ := json.Marshal(emailContent)
+	newTask := func(taskID string) TaskMessage {
+		return TaskMessage{
+			TaskID:           taskID,
+			ActionType:       "EMAIL",
+			TemplateContent:  string(templateContentJSON),
+			EntityInstanceID: "entity789",
+			EntityInstance:   map[string]interface{}{"name": "Alice", "email": redactedValue},
+		}
+	}
+
+	t.Run("Redacted PII attributes are revealed before templating", func(t *testing.T) {
+		var revealRequest map[string]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/internal/v1/entities/entity789/reveal", r.URL.Path)
+			json.NewDecoder(r.Body).Decode(&revealRequest)
+			fmt.Fprintln(w, `{"entity_instance_id": "entity789", "attributes": {"name": "Alice", "email": "alice@example.com"}, "revealed_attributes": ["email"]}`)
+		}))
+		defer server.Close()
+		processingServiceURL = server.URL
+
+		logOutput := captureOutput(func() { handleEmailTask(newTestNatsMsg(t, newTask("taskReveal"))) })
+
+		assert.Equal(t, "EMAIL", revealRequest["action_type"])
+		assert.Contains(t, logOutput, "SIMULATING EMAIL SEND for TaskID taskReveal")
+		assert.Contains(t, logOutput, "To: alice@example.com")
+	})
+
+	t.Run("Email is skipped if the processing service refuses to reveal", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+		processingServiceURL = server.URL
+
+		logOutput := captureOutput(func() { handleEmailTask(newTestNatsMsg(t, newTask("taskRevealFails"))) })
+
+		assert.Contains(t, logOutput, "TaskID taskRevealFails: Error revealing PII attributes of entity entity789")
+		assert.NotContains(t, logOutput, "SIMULATING EMAIL SEND for TaskID taskRevealFails")
+	})
+}
+
 ```
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available in webhook templates. secret resolves a secret reference, so that
+// credentials are kept out of action templates, e.g.
+// "headers_template": {"Authorization": "Bearer {{secret \"env:CRM_TOKEN\"}}"}.
+var templateFuncs = template.FuncMap{
+	"secret": resolveSecretRef,
+}
+
+// resolveSecretRef returns the secret named by ref: "env:NAME" reads an environment variable and
+// "file:/path" a file without its trailing newline. An unset or empty secret is an error.
+// It mirrors secrets.Resolve of the root module.
+func resolveSecretRef(ref string) (string, error) {
+	kind, name, _ := strings.Cut(ref, ":")
+	if name == "" {
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+	switch kind {
+	case "env":
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", ref, name)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		value := strings.TrimRight(string(content), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("secret %s: file is empty", ref)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretTemplateFunc(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_TOKEN", "tok3n")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	render := func(text string) (string, error) {
+		tmpl, err := template.New("header").Funcs(templateFuncs).Parse(text)
+		require.NoError(t, err)
+		var out bytes.Buffer
+		err = tmpl.Execute(&out, TemplateData{})
+		return out.String(), err
+	}
+
+	rendered, err := render(`Bearer {{secret "env:TEST_WEBHOOK_TOKEN"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer tok3n", rendered)
+
+	rendered, err = render(`{{secret "file:` + secretFile + `"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", rendered)
+
+	_, err = render(`{{secret "env:TEST_WEBHOOK_TOKEN_UNSET"}}`)
+	assert.Error(t, err)
+}
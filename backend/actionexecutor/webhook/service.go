@@ -17,13 +17,19 @@ import (
 type WebhookExecutorService struct {
 	natsJS     nats.JetStreamContext
 	httpClient *http.Client
+	// processingServiceURL is where redacted PII attributes of entity instances are revealed.
+	processingServiceURL string
 }
 
+// redactedValue is the placeholder of PII attribute values in task messages.
+const redactedValue = "[REDACTED]"
+
 // NewWebhookExecutorService creates a new WebhookExecutorService.
 func NewWebhookExecutorService(js nats.JetStreamContext) *WebhookExecutorService {
 	return &WebhookExecutorService{
-		natsJS:     js,
-		httpClient: &http.Client{Timeout: 30 * time.Second}, // Configurable timeout
+		natsJS:               js,
+		httpClient:           &http.Client{Timeout: 30 * time.Second}, // Configurable timeout
+		processingServiceURL: getEnv("PROCESSING_SERVICE_URL", "http://localhost:8082"),
 	}
 }
 
@@ -126,6 +132,10 @@ func (s *WebhookExecutorService) processWebhookTask(task TaskMessage) error {
 		return fmt.Errorf("failed to unmarshal TemplateContent for TaskID %s: %w", task.TaskID, err)
 	}
 
+	if err := s.revealEntityInstance(&task); err != nil {
+		return fmt.Errorf("failed to reveal PII attributes of entity %s for TaskID %s: %w", task.EntityInstanceID, task.TaskID, err)
+	}
+
 	// Prepare data for templating
 	templateCtx := TemplateData{
 		EntityInstance: task.EntityInstance,
@@ -157,14 +167,14 @@ func (s *WebhookExecutorService) processWebhookTask(task TaskMessage) error {
 
 	// Render Payload (if applicable)
 	var reqBodyReader *bytes.Reader
-	var renderedPayloadStr string // For logging
+	var renderedPayloadStr string
 	if (strings.ToUpper(webhookTmpl.Method) == "POST" || strings.ToUpper(webhookTmpl.Method) == "PUT") && webhookTmpl.PayloadTemplate != "" {
 		renderedPayload, err := s.renderTemplate("payload", webhookTmpl.PayloadTemplate, templateCtx)
 		if err != nil {
 			return fmt.Errorf("failed to render payload for TaskID %s: %w", task.TaskID, err)
 		}
 		reqBodyReader = bytes.NewReader([]byte(renderedPayload))
-		renderedPayloadStr = renderedPayload // Store for logging
+		renderedPayloadStr = renderedPayload
 	} else {
 		reqBodyReader = bytes.NewReader([]byte{}) // Empty body for GET/DELETE or if no template
 	}
@@ -178,10 +188,16 @@ func (s *WebhookExecutorService) processWebhookTask(task TaskMessage) error {
 
 	log.Printf("Executing Webhook TaskID %s: %s %s", task.TaskID, req.Method, req.URL)
 	if renderedPayloadStr != "" {
-		log.Printf(" TaskID %s - Payload: %s", task.TaskID, s.truncateString(renderedPayloadStr, 256))
+		// Only the payload size is logged: the payload may carry PII attributes of the entity.
+		log.Printf(" TaskID %s - Payload: %d bytes", task.TaskID, len(renderedPayloadStr))
 	}
 	if len(req.Header) > 0 {
-		log.Printf(" TaskID %s - Headers: %v", task.TaskID, req.Header)
+		// Only header names are logged: values may carry credentials.
+		headerNames := make([]string, 0, len(req.Header))
+		for name := range req.Header {
+			headerNames = append(headerNames, name)
+		}
+		log.Printf(" TaskID %s - Headers: %v", task.TaskID, headerNames)
 	}
 
 
@@ -207,12 +223,51 @@ func (s *WebhookExecutorService) processWebhookTask(task TaskMessage) error {
 	return nil
 }
 
+// revealEntityInstance replaces the entity instance of a task with the one revealed by the processing
+// service if PII attributes were redacted. The processing service decrypts only the attributes that
+// the task's action type is authorized for.
+func (s *WebhookExecutorService) revealEntityInstance(task *TaskMessage) error {
+	redacted := false
+	for _, value := range task.EntityInstance {
+		if value == redactedValue {
+			redacted = true
+			break
+		}
+	}
+	if !redacted || task.EntityInstanceID == "" {
+		return nil
+	}
+
+	reqBody, err := json.Marshal(map[string]string{"action_type": task.ActionType})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/internal/v1/entities/%s/reveal", s.processingServiceURL, task.EntityInstanceID)
+	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to call processing service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("processing service returned non-OK status %d", resp.StatusCode)
+	}
+
+	var revealed struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&revealed); err != nil {
+		return fmt.Errorf("failed to decode revealed entity: %w", err)
+	}
+	task.EntityInstance = revealed.Attributes
+	return nil
+}
+
 // renderTemplate executes a Go template with the given data.
 func (s *WebhookExecutorService) renderTemplate(templateName, templateStr string, data TemplateData) (string, error) {
 	if templateStr == "" {
 		return "", nil
 	}
-	tmpl, err := template.New(templateName).Parse(templateStr)
+	tmpl, err := template.New(templateName).Funcs(templateFuncs).Parse(templateStr)
 	if err != nil {
 		return "", fmt.Errorf("error parsing template %s: %w", templateName, err)
 	}
@@ -254,6 +254,61 @@ func TestProcessWebhookTask(t *testing.T) {
 		require.NoError(t, err)
 		assert.Empty(t, receivedContentType)
 	})
+
+	t.Run("Redacted PII attributes are revealed by the processing service", func(t *testing.T) {
+		var revealRequest map[string]string
+		var receivedBody map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/internal/v1/entities/entity123/reveal" {
+				json.NewDecoder(r.Body).Decode(&revealRequest)
+				fmt.Fprintln(w, `{"entity_instance_id": "entity123", "attributes": {"email": "ann@example.com", "ssn": "[REDACTED]"}, "revealed_attributes": ["email"]}`)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&receivedBody)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		service := NewWebhookExecutorService()
+		service.processingServiceURL = server.URL
+		templateContentJSON := fmt.Sprintf(`{"url_template": "%s/submit", "method": "POST", "payload_template": "{\"email\": \"{{.EntityInstance.email}}\", \"ssn\": \"{{.EntityInstance.ssn}}\"}"}`, server.URL)
+		task := TaskMessage{
+			ActionType:       "webhook",
+			TemplateContent:  templateContentJSON,
+			EntityInstanceID: "entity123",
+			EntityInstance:   map[string]interface{}{"email": "[REDACTED]", "ssn": "[REDACTED]"},
+		}
+
+		err := service.processWebhookTask(task)
+		require.NoError(t, err)
+		assert.Equal(t, "webhook", revealRequest["action_type"])
+		assert.Equal(t, "ann@example.com", receivedBody["email"])
+		assert.Equal(t, "[REDACTED]", receivedBody["ssn"])
+	})
+
+	t.Run("Task fails if the processing service refuses to reveal", func(t *testing.T) {
+		webhookCalled := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/reveal") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			webhookCalled = true
+		}))
+		defer server.Close()
+		service := NewWebhookExecutorService()
+		service.processingServiceURL = server.URL
+		task := TaskMessage{
+			ActionType:       "webhook",
+			TemplateContent:  fmt.Sprintf(`{"url_template": "%s", "method": "GET"}`, server.URL),
+			EntityInstanceID: "entity123",
+			EntityInstance:   map[string]interface{}{"email": "[REDACTED]"},
+		}
+
+		err := service.processWebhookTask(task)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "non-OK status 500")
+		assert.False(t, webhookCalled)
+	})
 }
 
 // --- Tests for NATS Message Handling (Simplified) ---
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -182,6 +183,10 @@ func main() {
 	orchestrationClient := NewHTTPOrchestrationClient(orchestrationServiceURL)
 	groupingService := NewGroupingService(metadataClient, orchestrationClient, db)
 
+	// Trim calculation logs to the limits of the retention policies.
+	sweepInterval, batchSize := retentionSettings()
+	go groupingService.RunRetentionJanitor(context.Background(), sweepInterval, batchSize)
+
 	// --- HTTP Server Setup ---
 	router := gin.Default()
 	// API Gateway expects /api/v1/groups/* to be handled by this service.
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// The retention janitor trims group_calculation_logs to the CalculationLogsKeep most recent logs of each group
+// of the entity definitions with a retention policy in the metadata service. It deletes a bounded number of
+// logs per statement, so that a sweep never locks much of the table at once.
+
+// defaultRetentionBatchSize is the number of calculation logs the retention janitor deletes per statement.
+const defaultRetentionBatchSize = 500
+
+// RetentionPolicy mirrors the retention policy of an entity definition or data source in the metadata
+// service. Only entity policies limit calculation logs.
+type RetentionPolicy struct {
+	ID                  string `json:"id"`
+	SourceID            string `json:"source_id,omitempty"`
+	EntityID            string `json:"entity_id,omitempty"`
+	CalculationLogsKeep *int   `json:"calculation_logs_keep,omitempty"`
+}
+
+// RetentionPurge reports the calculation logs the retention janitor deleted under one policy in a sweep.
+type RetentionPurge struct {
+	PolicyID string `json:"policy_id"`
+	EntityID string `json:"entity_id"`
+	Logs     int64  `json:"logs"`
+}
+
+// retentionSettings reads how often the retention janitor sweeps (RETENTION_SWEEP_INTERVAL, a duration such
+// as "1h") and how many logs it deletes per statement (RETENTION_BATCH_SIZE).
+func retentionSettings() (time.Duration, int) {
+	interval, err := time.ParseDuration(getEnv("RETENTION_SWEEP_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid RETENTION_SWEEP_INTERVAL, using 1h: %v", err)
+		interval = time.Hour
+	}
+	batchSize, err := strconv.Atoi(getEnv("RETENTION_BATCH_SIZE", strconv.Itoa(defaultRetentionBatchSize)))
+	if err != nil || batchSize <= 0 {
+		log.Printf("Invalid RETENTION_BATCH_SIZE, using %d: %v", defaultRetentionBatchSize, err)
+		batchSize = defaultRetentionBatchSize
+	}
+	return interval, batchSize
+}
+
+// TrimCalculationLogs deletes the calculation logs beyond the CalculationLogsKeep most recent ones of each group,
+// at most batchSize per statement, and reports the logs deleted per policy. A policy that fails does not stop
+// the others; the errors are returned together.
+func (s *GroupingService) TrimCalculationLogs(batchSize int) ([]RetentionPurge, error) {
+	policies, err := s.metadataClient.ListRetentionPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch retention policies: %w", err)
+	}
+	query := `
+        DELETE FROM group_calculation_logs WHERE ctid IN (
+            SELECT ctid FROM (
+                SELECT ctid, ROW_NUMBER() OVER (PARTITION BY group_definition_id ORDER BY calculated_at DESC) AS position
+                FROM group_calculation_logs WHERE entity_definition_id = $1
+            ) ranked
+            WHERE position > $2 LIMIT $3
+        )`
+	purges := []RetentionPurge{}
+	var errs []error
+	for _, policy := range policies {
+		if policy.EntityID == "" || policy.CalculationLogsKeep == nil {
+			continue
+		}
+		var total int64
+		for {
+			result, err := s.db.Exec(query, policy.EntityID, *policy.CalculationLogsKeep, batchSize)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to trim calculation logs under retention policy %s: %w", policy.ID, err))
+				break
+			}
+			deleted, err := result.RowsAffected()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get rows affected under retention policy %s: %w", policy.ID, err))
+				break
+			}
+			total += deleted
+			if deleted < int64(batchSize) {
+				break
+			}
+		}
+		if total > 0 {
+			purges = append(purges, RetentionPurge{PolicyID: policy.ID, EntityID: policy.EntityID, Logs: total})
+		}
+	}
+	return purges, errors.Join(errs...)
+}
+
+// RunRetentionJanitor trims calculation logs right away and then every interval until ctx is done.
+func (s *GroupingService) RunRetentionJanitor(ctx context.Context, interval time.Duration, batchSize int) {
+	log.Printf("Retention janitor started; sweeping every %s in batches of %d logs.", interval, batchSize)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purges, err := s.TrimCalculationLogs(batchSize)
+		if err != nil {
+			log.Printf("Retention janitor sweep failed: %v", err)
+		}
+		for _, purge := range purges {
+			log.Printf("Retention janitor purged %d calculation logs of entity %s under retention policy %s.", purge.Logs, purge.EntityID, purge.PolicyID)
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Retention janitor stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -88,6 +88,7 @@ type MetadataServiceAPIClient interface {
 	GetAttributeDefinition(entityID string, attributeID string) (*AttributeDefinition, error)
 	GetEntityRelationship(relationshipID string) (*EntityRelationshipDefinition, error) // Added
 	ListWorkflows() ([]WorkflowDefinition, error)
+	ListRetentionPolicies() ([]RetentionPolicy, error)
 }
 type HTTPMetadataClient struct {
 	BaseURL    string
@@ -138,6 +139,15 @@ func (c *HTTPMetadataClient) GetEntityRelationship(relationshipID string) (*Enti
 	return &relDef, err
 }
 
+func (c *HTTPMetadataClient) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	var list struct {
+		Data []RetentionPolicy `json:"data"`
+	}
+	url := fmt.Sprintf("%s/api/v1/retention-policies", c.BaseURL)
+	err := c.fetchMetadata(url, &list)
+	return list.Data, err
+}
+
 // --- Orchestration Service Client ---
 type OrchestrationServiceAPIClient interface {
 	TriggerWorkflow(workflowID string) error
@@ -967,6 +977,8 @@ func (s *GroupingService) CalculateGroup(groupID string) ([]string, error) {
 	if recursiveWhereClause != "" {
 		actualWhereConditions = append(actualWhereConditions, fmt.Sprintf("(%s)", recursiveWhereClause))
 	}
+	// Entities deleted at their source (CDC tombstones) are never group members.
+	actualWhereConditions = append(actualWhereConditions, fmt.Sprintf("%s.deleted_at IS NULL", primaryTableAlias))
 
 	if len(actualWhereConditions) > 0 {
 		finalQuery.WriteString(" WHERE " + strings.Join(actualWhereConditions, " AND "))
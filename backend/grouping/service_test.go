@@ -26,6 +26,7 @@ type MockMetadataServiceClient struct {
 	GetAttributeDefinitionFunc func(entityID string, attributeID string) (*AttributeDefinition, error)
 	GetEntityRelationshipFunc  func(relationshipID string) (*EntityRelationshipDefinition, error) // Added
 	ListWorkflowsFunc          func() ([]WorkflowDefinition, error)
+	ListRetentionPoliciesFunc  func() ([]RetentionPolicy, error)
 }
 
 func (m *MockMetadataServiceClient) GetGroupDefinition(groupID string) (*GroupDefinition, error) {
@@ -63,6 +64,13 @@ func (m *MockMetadataServiceClient) GetEntityRelationship(relationshipID string)
 	return nil, fmt.Errorf("GetEntityRelationshipFunc not implemented")
 }
 
+func (m *MockMetadataServiceClient) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	if m.ListRetentionPoliciesFunc != nil {
+		return m.ListRetentionPoliciesFunc()
+	}
+	return nil, fmt.Errorf("ListRetentionPoliciesFunc not implemented")
+}
+
 // --- Mock OrchestrationServiceClient ---
 type MockOrchestrationServiceClient struct {
 	TriggerWorkflowFunc func(workflowID string) error
@@ -1131,3 +1139,51 @@ func TestGetGroupResults(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+// --- Tests for TrimCalculationLogs (DB Interaction with sqlmock) ---
+func TestTrimCalculationLogs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keep := 3
+	mockMetaClient := &MockMetadataServiceClient{
+		ListRetentionPoliciesFunc: func() ([]RetentionPolicy, error) {
+			return []RetentionPolicy{
+				{ID: "policy-entity", EntityID: "entity-1", CalculationLogsKeep: &keep},
+				{ID: "policy-source", SourceID: "source-1"},
+				{ID: "policy-no-logs", EntityID: "entity-2"},
+			}, nil
+		},
+	}
+	// NewGroupingService initializes the schema, which is not under test here.
+	service := &GroupingService{metadataClient: mockMetaClient, orchestrationClient: &MockOrchestrationServiceClient{}, db: db}
+
+	t.Run("Logs Beyond The Limit Are Deleted In Batches", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM group_calculation_logs").WithArgs("entity-1", keep, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("DELETE FROM group_calculation_logs").WithArgs("entity-1", keep, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		purges, err := service.TrimCalculationLogs(2)
+		require.NoError(t, err)
+		assert.Equal(t, []RetentionPurge{{PolicyID: "policy-entity", EntityID: "entity-1", Logs: 3}}, purges)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing To Trim", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM group_calculation_logs").WithArgs("entity-1", keep, 10).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		purges, err := service.TrimCalculationLogs(10)
+		require.NoError(t, err)
+		assert.Empty(t, purges)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Failures Are Reported", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM group_calculation_logs").WithArgs("entity-1", keep, 10).WillReturnError(fmt.Errorf("db error"))
+
+		_, err := service.TrimCalculationLogs(10)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "policy-entity")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
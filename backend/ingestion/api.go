@@ -0,0 +1,210 @@
+package ingestion
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API exposes the ingestion service over HTTP.
+type API struct {
+	service *IngestionService
+}
+
+// NewAPI creates a new API handler.
+func NewAPI(service *IngestionService) *API {
+	return &API{service: service}
+}
+
+// RegisterRoutes sets up the routes for the ingestion service.
+func (a *API) RegisterRoutes(router *gin.Engine) {
+	v1 := router.Group("/api/v1")
+	ingestRoutes := v1.Group("/ingest")
+	{
+		ingestRoutes.POST("/trigger/:source_id", a.triggerIngestionHandler)
+		ingestRoutes.POST("/upload/:source_id", a.uploadHandler)
+		ingestRoutes.POST("/push/:source_id", a.pushHandler)
+		// The gateway also routes POST /api/v1/datasources/:source_id/{test,discover} here.
+		ingestRoutes.POST("/test/:source_id", a.testConnectionHandler)
+		ingestRoutes.POST("/discover/:source_id", a.discoverHandler)
+	}
+}
+
+// triggerIngestionHandler starts an ingestion run in the background and responds immediately
+// with the run ID. Progress is available from the metadata service under
+// /api/v1/datasources/:source_id/runs/:run_id.
+// The optional batch_size query parameter sets the number of records per processing request.
+func (a *API) triggerIngestionHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	if sourceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source_id is required"})
+		return
+	}
+
+	batchSize, ok := batchSizeParam(c)
+	if !ok {
+		return
+	}
+
+	run, err := a.service.StartIngestionJob(sourceID, batchSize)
+	if err != nil {
+		log.Printf("Error starting ingestion for source ID %s: %v", sourceID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message":   "Failed to start ingestion",
+			"source_id": sourceID,
+			"error":     err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Ingestion started",
+		"source_id":  sourceID,
+		"job_id":     run.ID,
+		"status":     run.Status,
+		"status_url": fmt.Sprintf("/api/v1/datasources/%s/runs/%s", sourceID, run.ID),
+	})
+}
+
+// uploadHandler ingests the file sent in the "file" field of a multipart form using the format
+// configured for the data source. It responds once every record was sent to the processing service.
+func (a *API) uploadHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	batchSize, ok := batchSizeParam(c)
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named 'file' is required: " + err.Error()})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	progress, err := a.service.IngestUpload(sourceID, fileHeader.Filename, file, batchSize)
+	if err != nil {
+		log.Printf("Error ingesting upload %s for source ID %s: %v", fileHeader.Filename, sourceID, err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUploadNotSupported) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"message":   "Failed to ingest uploaded file",
+			"source_id": sourceID,
+			"error":     err.Error(),
+			"progress":  progress,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Uploaded file ingested successfully",
+		"source_id": sourceID,
+		"file_name": fileHeader.Filename,
+		"progress":  progress,
+	})
+}
+
+// MaxPushBodyBytes limits the size of a push request body.
+const MaxPushBodyBytes = 10 << 20
+
+// pushHandler accepts a JSON record or array of records for a push data source and processes
+// them immediately, or responds with 202 once they are queued on the INGEST stream. The sender
+// authenticates with the source's shared secret or HMAC signature.
+func (a *API) pushHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxPushBodyBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Failed to read request body: " + err.Error()})
+		return
+	}
+
+	result, err := a.service.IngestPush(sourceID, body, c.Request.Header)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, ErrPushUnauthorized):
+			// Do not tell the sender why authentication failed.
+			log.Printf("Rejected push for source ID %s: %v", sourceID, err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		case errors.Is(err, ErrNotPushSource), errors.Is(err, ErrInvalidPushBody):
+			status = http.StatusBadRequest
+		}
+		log.Printf("Error ingesting push for source ID %s: %v", sourceID, err)
+		c.JSON(status, gin.H{
+			"message":   "Failed to ingest pushed records",
+			"source_id": sourceID,
+			"error":     err.Error(),
+		})
+		return
+	}
+	if result.RecordsQueued > 0 {
+		c.JSON(http.StatusAccepted, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// testConnectionHandler checks that the connection details of a data source work. A failed
+// connection is a successful test with ok=false.
+func (a *API) testConnectionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, a.service.TestConnection(c.Param("source_id")))
+}
+
+// discoverHandler samples the records of a data source and returns their fields with inferred types.
+// The optional sample_size query parameter sets the number of records sampled (at most MaxDiscoverySampleSize).
+func (a *API) discoverHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	sampleSize := DefaultDiscoverySampleSize
+	if sampleSizeStr := c.Query("sample_size"); sampleSizeStr != "" {
+		var err error
+		sampleSize, err = strconv.Atoi(sampleSizeStr)
+		if err != nil || sampleSize <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sample_size parameter. Must be a positive integer."})
+			return
+		}
+	}
+
+	result, err := a.service.DiscoverSchema(sourceID, sampleSize)
+	if err != nil {
+		log.Printf("Error discovering schema of source ID %s: %v", sourceID, err)
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrDiscoveryNotSupported) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"message":   "Failed to discover data source schema",
+			"source_id": sourceID,
+			"error":     err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// batchSizeParam parses the optional batch_size query parameter. It writes a 400 response and
+// returns false if the value is invalid.
+func batchSizeParam(c *gin.Context) (int, bool) {
+	batchSizeStr := c.Query("batch_size")
+	if batchSizeStr == "" {
+		return DefaultBatchSize, true
+	}
+	batchSize, err := strconv.Atoi(batchSizeStr)
+	if err != nil || batchSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch_size parameter. Must be a positive integer."})
+		return 0, false
+	}
+	return batchSize, true
+}
@@ -0,0 +1,342 @@
+package ingestion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestRouter(service *IngestionService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+	return router
+}
+
+func TestTriggerIngestionHandler(t *testing.T) {
+	t.Run("Returns Job ID Immediately", func(t *testing.T) {
+		release := make(chan struct{})
+		done := make(chan IngestionRun, 1)
+		mockMetaClient := &MockMetadataServiceClient{
+			GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+				<-release // Block the background run until the response has been checked
+				return nil, fmt.Errorf("source not found")
+			},
+			CreateIngestionRunFunc: func(run IngestionRun) (*IngestionRun, error) {
+				assert.Equal(t, "ds-1", run.SourceID)
+				run.ID = "job-123"
+				return &run, nil
+			},
+			UpdateIngestionRunFunc: func(run IngestionRun) error {
+				done <- run
+				return nil
+			},
+		}
+		router := setupTestRouter(NewIngestionService(mockMetaClient, &MockProcessingServiceClient{}))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/trigger/ds-1?batch_size=50", nil)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "job-123", resp["job_id"])
+		assert.Equal(t, "running", resp["status"])
+		assert.Equal(t, "/api/v1/datasources/ds-1/runs/job-123", resp["status_url"])
+
+		close(release)
+		final := <-done
+		assert.Equal(t, IngestionRunFailed, final.Status)
+		assert.Contains(t, final.Error, "source not found")
+	})
+
+	t.Run("Invalid Batch Size", func(t *testing.T) {
+		router := setupTestRouter(NewIngestionService(&MockMetadataServiceClient{}, &MockProcessingServiceClient{}))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/trigger/ds-1?batch_size=-1", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Run Cannot Be Recorded", func(t *testing.T) {
+		mockMetaClient := &MockMetadataServiceClient{
+			CreateIngestionRunFunc: func(run IngestionRun) (*IngestionRun, error) {
+				return nil, fmt.Errorf("metadata unavailable")
+			},
+		}
+		router := setupTestRouter(NewIngestionService(mockMetaClient, &MockProcessingServiceClient{}))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/trigger/ds-1", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+// multipartUpload builds a multipart request body with content in the "file" field.
+func multipartUpload(t *testing.T, url, fileName, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", fileName)
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadHandler(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	mockProcClient := &MockProcessingServiceClient{}
+	router := setupTestRouter(NewIngestionService(mockMetaClient, mockProcClient))
+	useSource := func(sourceType, details string) {
+		mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: sourceType, ConnectionDetails: details, EntityID: "TestEntity"}, nil
+		}
+	}
+
+	t.Run("CSV Upload Uses Source Options", func(t *testing.T) {
+		useSource("csv", `{"filepath": "/not/used.csv", "delimiter": ";"}`)
+		var sent []map[string]interface{}
+		mockProcClient.CallProcessDataFunc = func(payload ProcessDataRequest) error {
+			sent = append(sent, payload.RawData...)
+			return nil
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartUpload(t, "/api/v1/ingest/upload/ds-1?batch_size=1", "extract.csv", "id;name\n1;a\n2;b\n"))
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, sent, 2)
+		assert.Equal(t, "b", sent[1]["name"])
+		var resp struct {
+			Progress IngestionProgress `json:"progress"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 2, resp.Progress.RowsSent)
+		assert.Len(t, resp.Progress.Batches, 2)
+	})
+
+	t.Run("Gzip Upload For Directory Source", func(t *testing.T) {
+		useSource("directory", `{"directory": "/not/used", "format": "csv"}`)
+		mockProcClient.CallProcessDataFunc = nil
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte("id\n1\n"))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartUpload(t, "/api/v1/ingest/upload/ds-2", "extract.csv.gz", buf.String()))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NotNil(t, mockProcClient.CapturedProcessDataRequest)
+		assert.Equal(t, "1", mockProcClient.CapturedProcessDataRequest.RawData[0]["id"])
+	})
+
+	t.Run("Malformed File", func(t *testing.T) {
+		useSource("ndjson", `{}`)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartUpload(t, "/api/v1/ingest/upload/ds-3", "events.ndjson", "{\"id\": 1}\nnot json\n"))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "line 2")
+	})
+
+	t.Run("Source Type Without Uploads", func(t *testing.T) {
+		useSource("postgresql", `{}`)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, multipartUpload(t, "/api/v1/ingest/upload/ds-4", "extract.csv", "id\n1\n"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "does not accept file uploads")
+	})
+
+	t.Run("Missing File Field", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/upload/ds-5", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestPushHandler(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	mockProcClient := &MockProcessingServiceClient{}
+	router := setupTestRouter(NewIngestionService(mockMetaClient, mockProcClient))
+	useSource := func(sourceType, details string) {
+		mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: sourceType, ConnectionDetails: details, EntityID: "TestEntity"}, nil
+		}
+	}
+	push := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/push/hook-1", strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Shared Secret", func(t *testing.T) {
+		t.Setenv("TEST_PUSH_SECRET", "let-me-in")
+		useSource("push", `{"secret_env": "TEST_PUSH_SECRET"}`)
+		mockProcClient.CapturedProcessDataRequest = nil
+
+		w := push(`{"id": "1", "status": "paid"}`, map[string]string{"X-Ingest-Token": "let-me-in"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NotNil(t, mockProcClient.CapturedProcessDataRequest)
+		assert.Equal(t, "hook-1", mockProcClient.CapturedProcessDataRequest.SourceID)
+		assert.Equal(t, "TestEntity", mockProcClient.CapturedProcessDataRequest.EntityTypeName)
+		assert.Equal(t, "paid", mockProcClient.CapturedProcessDataRequest.RawData[0]["status"])
+
+		w = push(`[{"id": "1"}, {"id": "2"}]`, map[string]string{"Authorization": "Bearer let-me-in"})
+		require.Equal(t, http.StatusOK, w.Code)
+		var result PushResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, 2, result.RecordsReceived)
+		assert.Equal(t, 2, result.RecordsProcessed)
+
+		mockProcClient.CapturedProcessDataRequest = nil
+		w = push(`{"id": "1"}`, map[string]string{"X-Ingest-Token": "wrong"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotContains(t, w.Body.String(), "token", "the reason is not disclosed")
+		assert.Nil(t, mockProcClient.CapturedProcessDataRequest)
+	})
+
+	t.Run("HMAC Signature", func(t *testing.T) {
+		useSource("push", `{"auth": "hmac", "secret": "k3y", "signature_header": "X-Hub-Signature-256", "records_path": "$.data", "flatten": true}`)
+		body := `{"data": [{"id": "9", "customer": {"email": "a@example.com"}}]}`
+		mac := hmac.New(sha256.New, []byte("k3y"))
+		mac.Write([]byte(body))
+		signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+		w := push(body, map[string]string{"X-Hub-Signature-256": signature})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "a@example.com", mockProcClient.CapturedProcessDataRequest.RawData[0]["customer.email"])
+
+		w = push(body+" ", map[string]string{"X-Hub-Signature-256": signature})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "a modified body must not verify")
+		w = push(body, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Missing Secret Configuration Rejects", func(t *testing.T) {
+		useSource("push", `{"secret_env": "TEST_PUSH_SECRET_UNSET"}`)
+		w := push(`{"id": "1"}`, map[string]string{"X-Ingest-Token": ""})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Not A Push Source", func(t *testing.T) {
+		useSource("csv", `{"filepath": "/tmp/x.csv"}`)
+		w := push(`{"id": "1"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Invalid Body", func(t *testing.T) {
+		useSource("push", `{"secret": "s"}`)
+		w := push(`[1, 2]`, map[string]string{"X-Ingest-Token": "s"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = push(`{not json`, map[string]string{"X-Ingest-Token": "s"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Queued On The Ingest Stream", func(t *testing.T) {
+		useSource("push", `{"secret": "s"}`)
+		js := NewMockIngestJetStream()
+		client, err := NewJetStreamProcessingServiceClient(js)
+		require.NoError(t, err)
+		queueRouter := setupTestRouter(NewIngestionService(mockMetaClient, client))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/ingest/push/hook-1", strings.NewReader(`[{"id": "1"}, {"id": "2"}]`))
+		req.Header.Set("X-Ingest-Token", "s")
+		queueRouter.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var result PushResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, PushResult{SourceID: "hook-1", RecordsReceived: 2, RecordsQueued: 2}, result)
+		assert.Len(t, js.PublishedMessages[IngestBatchSubject], 1)
+	})
+
+	t.Run("Processing Failure", func(t *testing.T) {
+		useSource("push", `{"secret": "s"}`)
+		mockProcClient.CallProcessDataFunc = func(payload ProcessDataRequest) error { return fmt.Errorf("processing unavailable") }
+		defer func() { mockProcClient.CallProcessDataFunc = nil }()
+		w := push(`{"id": "1"}`, map[string]string{"X-Ingest-Token": "s"})
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+}
+
+func TestDiscoveryHandlers(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	router := setupTestRouter(NewIngestionService(mockMetaClient, &MockProcessingServiceClient{}))
+	useSource := func(sourceType, details string) {
+		mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: sourceType, ConnectionDetails: details}, nil
+		}
+	}
+	post := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	path := createTempCSV(t, "id,name\n1,a\n2,b\n3,c\n")
+
+	t.Run("Test Connection", func(t *testing.T) {
+		useSource("csv", fmt.Sprintf(`{"filepath": %q}`, path))
+		w := post("/api/v1/ingest/test/ds-1")
+		require.Equal(t, http.StatusOK, w.Code)
+		var result ConnectionTestResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.True(t, result.OK)
+		assert.Equal(t, "ds-1", result.SourceID)
+
+		useSource("csv", `{"filepath": "/does/not/exist.csv"}`)
+		w = post("/api/v1/ingest/test/ds-1")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.False(t, result.OK)
+		assert.NotEmpty(t, result.Error)
+	})
+
+	t.Run("Discover", func(t *testing.T) {
+		useSource("csv", fmt.Sprintf(`{"filepath": %q}`, path))
+		w := post("/api/v1/ingest/discover/ds-1?sample_size=2")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var result DiscoveryResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, 2, result.RowsSampled)
+		assert.Equal(t, []DiscoveredColumn{{Name: "id", InferredType: "integer"}, {Name: "name", InferredType: "string"}}, result.Columns)
+	})
+
+	t.Run("Discover Errors", func(t *testing.T) {
+		useSource("csv", fmt.Sprintf(`{"filepath": %q}`, path))
+		assert.Equal(t, http.StatusBadRequest, post("/api/v1/ingest/discover/ds-1?sample_size=0").Code)
+
+		useSource("push", `{}`)
+		assert.Equal(t, http.StatusBadRequest, post("/api/v1/ingest/discover/ds-1").Code)
+
+		useSource("csv", `{"filepath": "/does/not/exist.csv"}`)
+		assert.Equal(t, http.StatusBadGateway, post("/api/v1/ingest/discover/ds-1").Code)
+	})
+}
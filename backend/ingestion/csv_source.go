@@ -0,0 +1,294 @@
+package ingestion
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVConnectionParams defines the structure for CSV connection details.
+type CSVConnectionParams struct {
+	Filepath string `json:"filepath"`
+	// Delimiter is the field separator, e.g. ";" or "\t". Defaults to ",".
+	Delimiter string `json:"delimiter,omitempty"`
+	// Comment is a character that starts a comment line, e.g. "#". Comment lines are ignored.
+	Comment string `json:"comment,omitempty"`
+	// LazyQuotes allows quotes to appear in unquoted fields and non-doubled quotes in quoted fields.
+	LazyQuotes bool `json:"lazy_quotes,omitempty"`
+	// Encoding of the file: "utf-8" (default) or "latin-1" (alias "iso-8859-1"). A UTF-8 byte order mark is always stripped.
+	Encoding string `json:"encoding,omitempty"`
+	// HasHeader indicates whether the first row (after SkipRows) holds the column names. Defaults to true.
+	HasHeader *bool `json:"has_header,omitempty"`
+	// Columns names the columns explicitly. It is required when HasHeader is false and replaces the
+	// header row's names otherwise.
+	Columns []string `json:"columns,omitempty"`
+	// SkipRows is the number of lines to discard before the header (or first data row), e.g. a title banner.
+	SkipRows int `json:"skip_rows,omitempty"`
+	// Compression is "auto" (default, detected from a .gz or .zip extension), "none", "gzip" or "zip".
+	Compression string `json:"compression,omitempty"`
+	// ZipEntry selects the file inside a zip archive. Defaults to the first .csv entry, or the first file.
+	ZipEntry string `json:"zip_entry,omitempty"`
+}
+
+// readCSVRecords reads a CSV file row by row, keying each row by the header row or the configured columns.
+// A row whose number of fields differs from the number of columns is reported as an error.
+func readCSVRecords(sourceID string, params CSVConnectionParams, handle RecordHandler) error {
+	if params.Filepath == "" {
+		return fmt.Errorf("filepath is required for CSV data source type, source ID %s", sourceID)
+	}
+	hasHeader := params.HasHeader == nil || *params.HasHeader
+	if !hasHeader && len(params.Columns) == 0 {
+		return fmt.Errorf("columns are required when has_header is false, source ID %s", sourceID)
+	}
+	delimiter, err := csvOptionRune("delimiter", params.Delimiter, ',')
+	if err != nil {
+		return fmt.Errorf("invalid CSV options for source ID %s: %w", sourceID, err)
+	}
+	comment, err := csvOptionRune("comment", params.Comment, 0)
+	if err != nil {
+		return fmt.Errorf("invalid CSV options for source ID %s: %w", sourceID, err)
+	}
+
+	input, closeInput, err := openCSVInput(params)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+	}
+	defer closeInput()
+
+	buffered := bufio.NewReader(input)
+	for i := 0; i < params.SkipRows; i++ {
+		if _, err := buffered.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				log.Printf("CSV file %s for source ID %s has no rows after skipping %d lines.", params.Filepath, sourceID, params.SkipRows)
+				return nil
+			}
+			return fmt.Errorf("failed to skip rows of CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+		}
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.ReuseRecord = true
+	reader.Comma = delimiter
+	reader.Comment = comment
+	reader.LazyQuotes = params.LazyQuotes
+	reader.FieldsPerRecord = -1 // Checked below so the error names the expected column count
+
+	headers := params.Columns
+	if hasHeader {
+		headerRow, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				log.Printf("CSV file %s for source ID %s is empty or only contains headers.", params.Filepath, sourceID)
+				return nil // Empty results if only headers or empty
+			}
+			return fmt.Errorf("failed to read header row from CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+		}
+		if len(headers) == 0 {
+			headers = append([]string(nil), headerRow...) // ReuseRecord would otherwise overwrite the headers
+		} else if len(headers) != len(headerRow) {
+			return fmt.Errorf("CSV file %s for source ID %s has %d header columns but %d columns are configured", params.Filepath, sourceID, len(headerRow), len(headers))
+		}
+	}
+
+	count := 0
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break // End of file
+			}
+			return fmt.Errorf("failed to read row from CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+		}
+		if len(row) != len(headers) {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("CSV file %s for source ID %s: record on line %d has %d fields, expected %d", params.Filepath, sourceID, line+params.SkipRows, len(row), len(headers))
+		}
+
+		rowData := make(map[string]interface{}, len(headers))
+		for i, header := range headers {
+			rowData[header] = row[i]
+		}
+		if err := handle(rowData); err != nil {
+			return err
+		}
+		count++
+	}
+	log.Printf("Successfully ingested %d records for source ID: %s from CSV file %s.", count, sourceID, params.Filepath)
+	return nil
+}
+
+// csvOptionRune parses a single-character CSV option. "\t" may also be given as the two characters `\t`.
+func csvOptionRune(name, value string, fallback rune) (rune, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if value == `\t` || strings.EqualFold(value, "tab") {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == utf8.RuneError || r == '\r' || r == '\n' || r == '"' {
+		return 0, fmt.Errorf("%s must be a single character other than a quote or line break, got %q", name, value)
+	}
+	return r, nil
+}
+
+// openCSVInput opens the file, decompresses it and converts it to UTF-8 according to params.
+// The returned function closes every underlying resource.
+func openCSVInput(params CSVConnectionParams) (io.Reader, func(), error) {
+	compression := strings.ToLower(params.Compression)
+	if compression == "" || compression == "auto" {
+		switch strings.ToLower(path.Ext(params.Filepath)) {
+		case ".gz", ".gzip":
+			compression = "gzip"
+		case ".zip":
+			compression = "zip"
+		default:
+			compression = "none"
+		}
+	}
+
+	var input io.Reader
+	var closers []io.Closer
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}
+
+	switch compression {
+	case "none":
+		file, err := os.Open(params.Filepath)
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, file)
+		input = file
+	case "gzip":
+		file, err := os.Open(params.Filepath)
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, file)
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		closers = append(closers, gz)
+		input = gz
+	case "zip":
+		archive, err := zip.OpenReader(params.Filepath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		closers = append(closers, archive)
+		entry, err := selectZipEntry(archive.File, params.ZipEntry)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to open zip entry %s: %w", entry.Name, err)
+		}
+		closers = append(closers, rc)
+		input = rc
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression %q. Supported values are auto, none, gzip and zip", params.Compression)
+	}
+
+	switch strings.ToLower(strings.ReplaceAll(params.Encoding, "_", "-")) {
+	case "", "utf-8", "utf8":
+		input = newBOMStrippingReader(input)
+	case "latin-1", "latin1", "iso-8859-1":
+		input = &latin1Reader{r: bufio.NewReader(input)}
+	default:
+		closeAll()
+		return nil, nil, fmt.Errorf("unsupported encoding %q. Supported values are utf-8 and latin-1", params.Encoding)
+	}
+	return input, closeAll, nil
+}
+
+// selectZipEntry returns the named entry, or the first .csv entry, or the first regular file.
+func selectZipEntry(files []*zip.File, name string) (*zip.File, error) {
+	var first *zip.File
+	for _, f := range files {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if name != "" {
+			if f.Name == name {
+				return f, nil
+			}
+			continue
+		}
+		if strings.EqualFold(path.Ext(f.Name), ".csv") {
+			return f, nil
+		}
+		if first == nil {
+			first = f
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("zip entry %s not found", name)
+	}
+	if first == nil {
+		return nil, errors.New("zip archive contains no files")
+	}
+	return first, nil
+}
+
+// newBOMStrippingReader drops a leading UTF-8 byte order mark, which would otherwise become part of
+// the first column name.
+func newBOMStrippingReader(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		buffered.Discard(3)
+	}
+	return buffered
+}
+
+// latin1Reader converts ISO-8859-1 input to UTF-8. Every Latin-1 byte maps to the Unicode code point
+// of the same value.
+type latin1Reader struct {
+	r       *bufio.Reader
+	pending []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			copied := copy(p[n:], l.pending)
+			l.pending = l.pending[copied:]
+			n += copied
+			continue
+		}
+		if n > 0 && l.r.Buffered() == 0 {
+			return n, nil // Do not block for more input once something was read
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		var buf [2]byte
+		size := utf8.EncodeRune(buf[:], rune(b))
+		l.pending = append(l.pending[:0], buf[:size]...)
+	}
+	return n, nil
+}
@@ -0,0 +1,155 @@
+package ingestion
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAllCSV reads a CSV source described by params and returns its records.
+func readAllCSV(t *testing.T, params CSVConnectionParams) ([]map[string]interface{}, error) {
+	t.Helper()
+	var records []map[string]interface{}
+	err := readCSVRecords("csvSource", params, func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func TestReadCSVRecords_Options(t *testing.T) {
+	noHeader := false
+
+	t.Run("Semicolon Delimiter And Comments", func(t *testing.T) {
+		path := createTempFile(t, "semi.csv", "# exported 2024-01-01\nid;name\n1;\"a;b\"\n# trailing note\n2;c\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, Delimiter: ";", Comment: "#"})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "a;b", records[0]["name"])
+		assert.Equal(t, "c", records[1]["name"])
+	})
+
+	t.Run("Tab Delimiter", func(t *testing.T) {
+		path := createTempFile(t, "tabs.tsv", "id\tname\n1\ta\n")
+		for _, delimiter := range []string{"\t", `\t`, "tab"} {
+			records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, Delimiter: delimiter})
+			require.NoError(t, err, "delimiter %q", delimiter)
+			require.Len(t, records, 1)
+			assert.Equal(t, "a", records[0]["name"])
+		}
+	})
+
+	t.Run("Lazy Quotes", func(t *testing.T) {
+		path := createTempFile(t, "quotes.csv", "id,name\n1,the \"big\" one\n")
+		_, err := readAllCSV(t, CSVConnectionParams{Filepath: path})
+		require.Error(t, err)
+
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, LazyQuotes: true})
+		require.NoError(t, err)
+		assert.Equal(t, `the "big" one`, records[0]["name"])
+	})
+
+	t.Run("UTF-8 BOM Is Stripped", func(t *testing.T) {
+		path := createTempFile(t, "bom.csv", "\xef\xbb\xbfid,name\n1,a\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "1", records[0]["id"])
+	})
+
+	t.Run("Latin-1 Encoding", func(t *testing.T) {
+		path := createTempFile(t, "latin1.csv", "id,city\n1,M\xfcnchen\n2,S\xe3o Paulo\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, Encoding: "ISO-8859-1"})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "München", records[0]["city"])
+		assert.Equal(t, "São Paulo", records[1]["city"])
+	})
+
+	t.Run("No Header With Explicit Columns And Skip Rows", func(t *testing.T) {
+		path := createTempFile(t, "noheader.csv", "Partner export\nGenerated nightly\n1,a\n2,b\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, HasHeader: &noHeader, Columns: []string{"id", "name"}, SkipRows: 2})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, map[string]interface{}{"id": "1", "name": "a"}, records[0])
+	})
+
+	t.Run("Explicit Columns Replace Header", func(t *testing.T) {
+		path := createTempFile(t, "rename.csv", "Customer ID,Customer Name\n1,a\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, Columns: []string{"id", "name"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"id": "1", "name": "a"}, records[0])
+
+		_, err = readAllCSV(t, CSVConnectionParams{Filepath: path, Columns: []string{"id"}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "has 2 header columns but 1 columns are configured")
+	})
+
+	t.Run("No Header Requires Columns", func(t *testing.T) {
+		path := createTempFile(t, "nocols.csv", "1,a\n")
+		_, err := readAllCSV(t, CSVConnectionParams{Filepath: path, HasHeader: &noHeader})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "columns are required when has_header is false")
+	})
+
+	t.Run("Wrong Field Count Is An Error", func(t *testing.T) {
+		path := createTempFile(t, "short.csv", "banner\nid,name,value\n1,a,10\n2,b\n")
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path, SkipRows: 1})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "record on line 4 has 2 fields, expected 3")
+		assert.Len(t, records, 1, "rows before the malformed one are still handled")
+	})
+
+	t.Run("Gzip Compression", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte("id,name\n1,a\n2,b\n"))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		path := createTempFile(t, "data.csv.gz", buf.String())
+
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path})
+		require.NoError(t, err)
+		assert.Len(t, records, 2)
+	})
+
+	t.Run("Zip Compression", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.zip")
+		file, err := os.Create(path)
+		require.NoError(t, err)
+		zw := zip.NewWriter(file)
+		for name, content := range map[string]string{"README.txt": "not data", "data/customers.csv": "id,name\n1,a\n"} {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		require.NoError(t, file.Close())
+
+		records, err := readAllCSV(t, CSVConnectionParams{Filepath: path})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "a", records[0]["name"])
+
+		_, err = readAllCSV(t, CSVConnectionParams{Filepath: path, ZipEntry: "missing.csv"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "zip entry missing.csv not found")
+	})
+
+	t.Run("Invalid Options", func(t *testing.T) {
+		path := createTempFile(t, "plain.csv", "id\n1\n")
+		_, err := readAllCSV(t, CSVConnectionParams{Filepath: path, Delimiter: ";;"})
+		assert.ErrorContains(t, err, "delimiter must be a single character")
+		_, err = readAllCSV(t, CSVConnectionParams{Filepath: path, Encoding: "utf-16"})
+		assert.ErrorContains(t, err, "unsupported encoding")
+		_, err = readAllCSV(t, CSVConnectionParams{Filepath: path, Compression: "bzip2"})
+		assert.ErrorContains(t, err, "unsupported compression")
+	})
+}
@@ -0,0 +1,208 @@
+package ingestion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ledger statuses of ingested files.
+const (
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// DirectoryConnectionParams defines the structure for directory connection details. Every file in
+// Directory that matches Pattern and is not yet in the processed-file ledger is ingested.
+type DirectoryConnectionParams struct {
+	Directory string `json:"directory"`
+	// Pattern is a filepath.Match pattern relative to Directory, e.g. "*.csv" or "*/export-*.csv.gz".
+	// Defaults to "*".
+	Pattern string `json:"pattern,omitempty"`
+	// Format of the files: "csv" (default), "json" or "ndjson".
+	Format string `json:"format,omitempty"`
+	// CSV and JSON hold the reader options for the format. Their Filepath is ignored.
+	CSV  CSVConnectionParams  `json:"csv"`
+	JSON JSONConnectionParams `json:"json"`
+	// ArchiveDir and ErrorDir, if set, receive files that were ingested or could not be read.
+	// Relative paths are resolved against Directory.
+	ArchiveDir string `json:"archive_dir,omitempty"`
+	ErrorDir   string `json:"error_dir,omitempty"`
+}
+
+// IngestedFile mirrors an entry of the processed-file ledger kept by the metadata service.
+type IngestedFile struct {
+	SourceID string `json:"source_id"`
+	FileName string `json:"file_name"` // Relative to the source directory
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // Hex-encoded SHA-256 of the content
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// pendingFile is a file that was read and is waiting for its records to be processed.
+type pendingFile struct {
+	entry      IngestedFile
+	path       string
+	archiveDir string
+}
+
+// readDirectory reads every new file matching params and returns them for commitFiles.
+// A file that cannot be read is recorded in the ledger with the error status (and moved to the error
+// directory) straight away, and the remaining files are still read. Records of such a file that were
+// handled before the read error stay handled. An error returned by handle stops the read.
+func (s *IngestionService) readDirectory(sourceID string, params DirectoryConnectionParams, handle RecordHandler) ([]pendingFile, error) {
+	if params.Directory == "" {
+		return nil, fmt.Errorf("directory is required for directory data source type, source ID %s", sourceID)
+	}
+	pattern := params.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+	format := strings.ToLower(params.Format)
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "json", "ndjson":
+	default:
+		return nil, fmt.Errorf("unsupported file format %q for source ID %s. Supported formats are csv, json and ndjson", params.Format, sourceID)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(params.Directory, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q for source ID %s: %w", pattern, sourceID, err)
+	}
+	sort.Strings(matches) // Ingest in name order, e.g. timestamped exports oldest first
+
+	var pending []pendingFile
+	skipped := 0
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil {
+			return pending, fmt.Errorf("failed to stat %s for source ID %s: %w", path, sourceID, err)
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		name, err := filepath.Rel(params.Directory, path)
+		if err != nil {
+			return pending, fmt.Errorf("failed to resolve %s for source ID %s: %w", path, sourceID, err)
+		}
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			return pending, fmt.Errorf("failed to checksum %s for source ID %s: %w", path, sourceID, err)
+		}
+		entry := IngestedFile{SourceID: sourceID, FileName: filepath.ToSlash(name), Size: info.Size(), Checksum: checksum}
+
+		seen, err := s.metadataClient.FindIngestedFile(sourceID, entry.FileName, checksum)
+		if err != nil {
+			return pending, fmt.Errorf("failed to look up %s in the processed-file ledger of source ID %s: %w", entry.FileName, sourceID, err)
+		}
+		if seen != nil {
+			skipped++
+			continue
+		}
+
+		var handleErr error
+		fileHandle := func(record map[string]interface{}) error {
+			handleErr = handle(record)
+			return handleErr
+		}
+		switch format {
+		case "csv":
+			csvParams := params.CSV
+			csvParams.Filepath = path
+			err = readCSVRecords(sourceID, csvParams, fileHandle)
+		default:
+			jsonParams := params.JSON
+			jsonParams.Filepath = path
+			err = readJSONRecords(sourceID, format, jsonParams, fileHandle)
+		}
+		if handleErr != nil {
+			return pending, handleErr
+		}
+		if err != nil {
+			log.Printf("Skipping unreadable file %s of source ID %s: %v", entry.FileName, sourceID, err)
+			entry.Status, entry.Error = FileStatusError, err.Error()
+			s.settleFile(pendingFile{entry: entry, path: path, archiveDir: resolveDir(params.Directory, params.ErrorDir)})
+			continue
+		}
+		entry.Status = FileStatusProcessed
+		pending = append(pending, pendingFile{entry: entry, path: path, archiveDir: resolveDir(params.Directory, params.ArchiveDir)})
+	}
+	log.Printf("Read %d new files for source ID %s from %s (%d already ingested).", len(pending), sourceID, params.Directory, skipped)
+	return pending, nil
+}
+
+// commitFiles adds files whose records were processed to the ledger and archives them.
+func (s *IngestionService) commitFiles(files []pendingFile) error {
+	for _, file := range files {
+		if err := s.metadataClient.RecordIngestedFile(file.entry); err != nil {
+			return fmt.Errorf("failed to record ingested file %s of source ID %s: %w", file.entry.FileName, file.entry.SourceID, err)
+		}
+		if err := moveFile(file.path, file.archiveDir); err != nil {
+			// The ledger already prevents the file from being ingested again.
+			log.Printf("Error archiving file %s of source ID %s: %v", file.path, file.entry.SourceID, err)
+		}
+	}
+	return nil
+}
+
+// settleFile records a file that could not be read and moves it out of the way. Failures are only
+// logged: the file is then simply retried by the next run.
+func (s *IngestionService) settleFile(file pendingFile) {
+	if err := s.metadataClient.RecordIngestedFile(file.entry); err != nil {
+		log.Printf("Error recording failed file %s of source ID %s: %v", file.entry.FileName, file.entry.SourceID, err)
+		return
+	}
+	if err := moveFile(file.path, file.archiveDir); err != nil {
+		log.Printf("Error moving failed file %s of source ID %s: %v", file.path, file.entry.SourceID, err)
+	}
+}
+
+// fileChecksum returns the hex-encoded SHA-256 digest of the file content.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resolveDir resolves dir against base unless it is empty or absolute.
+func resolveDir(base, dir string) string {
+	if dir == "" || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(base, dir)
+}
+
+// moveFile moves path into dir, creating dir if needed. A file of the same name already in dir is
+// kept by giving the moved file a timestamp suffix. An empty dir leaves the file in place.
+func moveFile(path, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(target); err == nil {
+		ext := filepath.Ext(target)
+		target = fmt.Sprintf("%s.%s%s", strings.TrimSuffix(target, ext), time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	}
+	return os.Rename(path, target)
+}
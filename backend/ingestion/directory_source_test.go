@@ -0,0 +1,164 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLedgerMock returns a metadata client mock for a directory source whose processed-file ledger is kept in memory.
+func newLedgerMock(t *testing.T, params DirectoryConnectionParams) (*MockMetadataServiceClient, map[string]IngestedFile) {
+	t.Helper()
+	details, err := json.Marshal(params)
+	require.NoError(t, err)
+
+	ledger := make(map[string]IngestedFile)
+	return &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: "directory", ConnectionDetails: string(details), EntityID: "TestEntity"}, nil
+		},
+		FindIngestedFileFunc: func(sourceID, fileName, checksum string) (*IngestedFile, error) {
+			if file, ok := ledger[fileName+"@"+checksum]; ok {
+				return &file, nil
+			}
+			return nil, nil
+		},
+		RecordIngestedFileFunc: func(file IngestedFile) error {
+			ledger[file.FileName+"@"+file.Checksum] = file
+			return nil
+		},
+	}, ledger
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func TestIngestData_Directory(t *testing.T) {
+	t.Run("Ingests Only New Files And Archives Them", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "export-01.csv", "id,name\n1,a\n2,b\n")
+		writeFile(t, dir, "export-02.csv", "id,name\n3,c\n")
+		writeFile(t, dir, "notes.txt", "not matched")
+
+		mockMetaClient, ledger := newLedgerMock(t, DirectoryConnectionParams{Directory: dir, Pattern: "export-*.csv", ArchiveDir: "archive"})
+		service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+		results, err := service.IngestData("dropFolder")
+		require.NoError(t, err)
+		assert.Len(t, results, 3)
+		require.Len(t, ledger, 2)
+		for _, file := range ledger {
+			assert.Equal(t, FileStatusProcessed, file.Status)
+			assert.Len(t, file.Checksum, 64)
+		}
+		assert.FileExists(t, filepath.Join(dir, "archive", "export-01.csv"))
+		assert.NoFileExists(t, filepath.Join(dir, "export-01.csv"))
+		assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+
+		// The next scheduled run only sees the newly dropped file
+		writeFile(t, dir, "export-03.csv", "id,name\n4,d\n")
+		results, err = service.IngestData("dropFolder")
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "4", results[0]["id"])
+		assert.Len(t, ledger, 3)
+	})
+
+	t.Run("Skips Files Already In Ledger Without Archive", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "a.ndjson", "{\"id\": 1}\n")
+		mockMetaClient, _ := newLedgerMock(t, DirectoryConnectionParams{Directory: dir, Pattern: "*.ndjson", Format: "ndjson"})
+		service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+		results, err := service.IngestData("ndjsonFolder")
+		require.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		results, err = service.IngestData("ndjsonFolder")
+		require.NoError(t, err)
+		assert.Empty(t, results, "unchanged file must not be ingested twice")
+
+		// Same name with new content is a new file
+		writeFile(t, dir, "a.ndjson", "{\"id\": 1}\n{\"id\": 2}\n")
+		results, err = service.IngestData("ndjsonFolder")
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+	})
+
+	t.Run("Unreadable File Goes To Error Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "bad.csv", "id,name\n1\n")
+		writeFile(t, dir, "good.csv", "id,name\n2,b\n")
+		mockMetaClient, ledger := newLedgerMock(t, DirectoryConnectionParams{Directory: dir, Pattern: "*.csv", ErrorDir: "errors"})
+		service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+		results, err := service.IngestData("mixedFolder")
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "2", results[0]["id"])
+		assert.FileExists(t, filepath.Join(dir, "errors", "bad.csv"))
+		assert.FileExists(t, filepath.Join(dir, "good.csv"), "processed files stay in place without an archive directory")
+
+		var statuses []string
+		for _, file := range ledger {
+			statuses = append(statuses, file.Status)
+			if file.Status == FileStatusError {
+				assert.Contains(t, file.Error, "has 1 fields, expected 2")
+			}
+		}
+		assert.ElementsMatch(t, []string{FileStatusProcessed, FileStatusError}, statuses)
+	})
+
+	t.Run("Failed Processing Leaves Files For Next Run", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "a.csv", "id\n1\n2\n3\n")
+		mockMetaClient, ledger := newLedgerMock(t, DirectoryConnectionParams{Directory: dir, ArchiveDir: "archive"})
+		mockProcClient := &MockProcessingServiceClient{CallProcessDataFunc: func(payload ProcessDataRequest) error {
+			return fmt.Errorf("processing unavailable")
+		}}
+		service := NewIngestionService(mockMetaClient, mockProcClient)
+
+		_, err := service.IngestDataStreaming("retryFolder", 2)
+		require.Error(t, err)
+		assert.Empty(t, ledger)
+		assert.FileExists(t, filepath.Join(dir, "a.csv"))
+
+		mockProcClient.CallProcessDataFunc = nil
+		progress, err := service.IngestDataStreaming("retryFolder", 2)
+		require.NoError(t, err)
+		assert.Equal(t, 3, progress.RowsSent)
+		assert.Len(t, ledger, 1)
+		assert.FileExists(t, filepath.Join(dir, "archive", "a.csv"))
+	})
+
+	t.Run("Missing Directory", func(t *testing.T) {
+		mockMetaClient, _ := newLedgerMock(t, DirectoryConnectionParams{})
+		service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+		_, err := service.IngestData("noDir")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "directory is required")
+	})
+}
+
+func TestMoveFile_KeepsExistingTarget(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	require.NoError(t, os.MkdirAll(archive, 0o755))
+	writeFile(t, archive, "a.csv", "old")
+	writeFile(t, dir, "a.csv", "new")
+
+	require.NoError(t, moveFile(filepath.Join(dir, "a.csv"), archive))
+	entries, err := os.ReadDir(archive)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	content, err := os.ReadFile(filepath.Join(archive, "a.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+}
@@ -0,0 +1,265 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample sizes of schema discovery.
+const (
+	DefaultDiscoverySampleSize = 20
+	MaxDiscoverySampleSize     = 1000
+)
+
+// ErrDiscoveryNotSupported is returned for data source types that have nothing to connect to.
+var ErrDiscoveryNotSupported = errors.New("data source type cannot be tested or discovered")
+
+// errSampleComplete stops a source read once enough records were sampled.
+var errSampleComplete = errors.New("sample complete")
+
+// ConnectionTestResult reports whether a data source could be opened and read.
+type ConnectionTestResult struct {
+	SourceID  string `json:"source_id"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// DiscoveredColumn is a field found in the sampled records of a data source. InferredType is one of
+// the metadata service's base data type names (string, integer, float, boolean, datetime, date,
+// time, array, object or json), so it can be used as is for a new AttributeDefinition.
+type DiscoveredColumn struct {
+	Name         string `json:"name"`
+	InferredType string `json:"inferred_type"`
+	// Nullable is true if the field was null or missing in at least one sampled record.
+	Nullable bool `json:"nullable"`
+}
+
+// DiscoveryResult describes the fields of a data source from a sample of its records.
+type DiscoveryResult struct {
+	SourceID    string                   `json:"source_id"`
+	Columns     []DiscoveredColumn       `json:"columns"`
+	SampleRows  []map[string]interface{} `json:"sample_rows"`
+	RowsSampled int                      `json:"rows_sampled"`
+}
+
+// TestConnection opens a data source with its connection details and reads at most one record.
+// Failures are reported in the result rather than as an error.
+func (s *IngestionService) TestConnection(sourceID string) *ConnectionTestResult {
+	start := time.Now()
+	result := &ConnectionTestResult{SourceID: sourceID}
+	_, err := s.sampleRecords(sourceID, 1)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		log.Printf("Connection test for source ID %s failed: %v", sourceID, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+// DiscoverSchema reads up to sampleSize records from a data source and infers the type of every
+// field found in them. Nothing is sent to the processing service and no ingestion state (watermarks,
+// file ledger, replication slots) is touched.
+func (s *IngestionService) DiscoverSchema(sourceID string, sampleSize int) (*DiscoveryResult, error) {
+	if sampleSize <= 0 {
+		sampleSize = DefaultDiscoverySampleSize
+	}
+	if sampleSize > MaxDiscoverySampleSize {
+		sampleSize = MaxDiscoverySampleSize
+	}
+	records, err := s.sampleRecords(sourceID, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DiscoveryResult{
+		SourceID:    sourceID,
+		Columns:     inferColumns(records),
+		SampleRows:  records,
+		RowsSampled: len(records),
+	}, nil
+}
+
+// sampleRecords returns the first limit records of a data source.
+func (s *IngestionService) sampleRecords(sourceID string, limit int) ([]map[string]interface{}, error) {
+	dsConfig, err := s.metadataClient.GetDataSourceConfig(sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data source config for ID %s: %w", sourceID, err)
+	}
+	records := make([]map[string]interface{}, 0, limit)
+	err = s.sampleSource(dsConfig, limit, func(record map[string]interface{}) error {
+		records = append(records, record)
+		if len(records) >= limit {
+			return errSampleComplete
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampleComplete) {
+		return nil, err
+	}
+	return records, nil
+}
+
+// sampleSource reads records of a data source with the same readers as readSource, but without
+// incremental state: PostgreSQL sources (including CDC sources, whose table_or_query names the
+// replicated table) run their query with a LIMIT, and directory sources read matching files in
+// name order whether they were ingested already or not.
+func (s *IngestionService) sampleSource(dsConfig *DataSourceConfig, limit int, handle RecordHandler) error {
+	switch strings.ToLower(dsConfig.Type) {
+	case "csv", "json", "ndjson", "http_api":
+		_, err := s.readSource(dsConfig, 0, handle)
+		return err
+	case "postgresql":
+		var params ConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return fmt.Errorf("failed to parse PostgreSQL connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		if params.TableOrQuery != "" {
+			params.TableOrQuery = fmt.Sprintf("SELECT * FROM (%s) AS src LIMIT %d", buildSourceQuery(params.TableOrQuery), limit)
+		}
+		_, err := readPostgresRecords(dsConfig.ID, params, "", 0, handle)
+		return err
+	case "directory":
+		var params DirectoryConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return fmt.Errorf("failed to parse directory connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		if params.Directory == "" {
+			return fmt.Errorf("directory is required for directory data source type, source ID %s", dsConfig.ID)
+		}
+		matches, err := filepath.Glob(filepath.Join(params.Directory, defaultString(params.Pattern, "*")))
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q for source ID %s: %w", params.Pattern, dsConfig.ID, err)
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			if err := readUploadedFile(dsConfig, path, handle); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s (source ID %s)", ErrDiscoveryNotSupported, dsConfig.Type, dsConfig.ID)
+	}
+}
+
+// inferColumns infers the type of every field of records, in field name order.
+func inferColumns(records []map[string]interface{}) []DiscoveredColumn {
+	types := make(map[string]string)
+	seen := make(map[string]int)
+	nullable := make(map[string]bool)
+	for _, record := range records {
+		for name, value := range record {
+			seen[name]++
+			valueType := inferValueType(value)
+			if valueType == "" {
+				nullable[name] = true
+				continue
+			}
+			types[name] = mergeInferredTypes(types[name], valueType)
+		}
+	}
+
+	columns := make([]DiscoveredColumn, 0, len(seen))
+	for name, count := range seen {
+		column := DiscoveredColumn{Name: name, InferredType: types[name], Nullable: nullable[name] || count < len(records)}
+		if column.InferredType == "" {
+			column.InferredType = "string" // Only nulls were sampled
+		}
+		columns = append(columns, column)
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+// Layouts of string values recognized as dates, times and datetimes.
+var (
+	discoveryDateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"}
+	discoveryDateLayouts     = []string{"2006-01-02"}
+	discoveryTimeLayouts     = []string{"15:04:05.999999999", "15:04"}
+)
+
+// inferValueType returns the base data type name of a sampled value, or "" for null.
+// Strings, as read from CSV files or replicated rows, are inspected for numbers, booleans and dates.
+func inferValueType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return "boolean"
+	case int, int32, int64:
+		return "integer"
+	case float32, float64:
+		return "float"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "float"
+	case time.Time:
+		return "datetime"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return ""
+		}
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return "integer"
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return "float"
+		}
+		if strings.EqualFold(s, "true") || strings.EqualFold(s, "false") {
+			return "boolean"
+		}
+		if matchesLayout(s, discoveryDateLayouts) {
+			return "date"
+		}
+		if matchesLayout(s, discoveryDateTimeLayouts) {
+			return "datetime"
+		}
+		if matchesLayout(s, discoveryTimeLayouts) {
+			return "time"
+		}
+		return "string"
+	default:
+		return "json"
+	}
+}
+
+func matchesLayout(s string, layouts []string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeInferredTypes returns the narrowest type that holds values of both types.
+func mergeInferredTypes(current, next string) string {
+	switch {
+	case current == "" || current == next:
+		return next
+	case (current == "integer" && next == "float") || (current == "float" && next == "integer"):
+		return "float"
+	case (current == "date" && next == "datetime") || (current == "datetime" && next == "date"):
+		return "datetime"
+	case current == "object" || current == "array" || next == "object" || next == "array" || current == "json":
+		return "json"
+	default:
+		return "string"
+	}
+}
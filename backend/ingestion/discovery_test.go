@@ -0,0 +1,126 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferValueType(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, ""},
+		{"", ""},
+		{"42", "integer"},
+		{"-3.5", "float"},
+		{"TRUE", "boolean"},
+		{"2024-02-29", "date"},
+		{"2024-02-29T10:00:00Z", "datetime"},
+		{"2024-02-29 10:00:00", "datetime"},
+		{"10:30:00", "time"},
+		{"hello", "string"},
+		{json.Number("7"), "integer"},
+		{json.Number("7.25"), "float"},
+		{int64(7), "integer"},
+		{7.0, "float"},
+		{true, "boolean"},
+		{time.Now(), "datetime"},
+		{map[string]interface{}{"a": 1}, "object"},
+		{[]interface{}{1}, "array"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v", tt.value), func(t *testing.T) {
+			assert.Equal(t, tt.expected, inferValueType(tt.value))
+		})
+	}
+}
+
+func TestInferColumns(t *testing.T) {
+	columns := inferColumns([]map[string]interface{}{
+		{"id": "1", "amount": "10", "created": "2024-01-01", "note": nil, "tags": []interface{}{"a"}},
+		{"id": "2", "amount": "10.5", "created": "2024-01-02T08:00:00Z", "note": nil, "tags": map[string]interface{}{}},
+		{"id": "3", "amount": "n/a", "created": "2024-01-03"},
+	})
+	assert.Equal(t, []DiscoveredColumn{
+		{Name: "amount", InferredType: "string"},
+		{Name: "created", InferredType: "datetime"},
+		{Name: "id", InferredType: "integer"},
+		{Name: "note", InferredType: "string", Nullable: true},
+		{Name: "tags", InferredType: "json", Nullable: true},
+	}, columns)
+}
+
+func TestDiscoverSchema(t *testing.T) {
+	configFor := func(sourceType string, details interface{}) *MockMetadataServiceClient {
+		raw, err := json.Marshal(details)
+		require.NoError(t, err)
+		return &MockMetadataServiceClient{
+			GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+				return &DataSourceConfig{ID: sourceID, Type: sourceType, ConnectionDetails: string(raw)}, nil
+			},
+		}
+	}
+
+	t.Run("CSV Sample Is Limited", func(t *testing.T) {
+		path := createTempCSV(t, "id,price,active\n1,9.99,true\n2,5,false\n3,1.5,true\n")
+		service := NewIngestionService(configFor("csv", CSVConnectionParams{Filepath: path}), &MockProcessingServiceClient{})
+
+		result, err := service.DiscoverSchema("csvSource", 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.RowsSampled)
+		assert.Len(t, result.SampleRows, 2)
+		assert.Equal(t, []DiscoveredColumn{
+			{Name: "active", InferredType: "boolean"},
+			{Name: "id", InferredType: "integer"},
+			{Name: "price", InferredType: "float"},
+		}, result.Columns)
+	})
+
+	t.Run("Directory Sample Ignores Ledger", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, dir, "a.ndjson", "{\"id\": 1, \"name\": \"x\"}\n")
+		mockMetaClient := configFor("directory", DirectoryConnectionParams{Directory: dir, Format: "ndjson", ArchiveDir: "archive"})
+		mockMetaClient.FindIngestedFileFunc = func(sourceID, fileName, checksum string) (*IngestedFile, error) {
+			return &IngestedFile{FileName: fileName}, nil // Already ingested
+		}
+		mockMetaClient.RecordIngestedFileFunc = func(file IngestedFile) error {
+			t.Errorf("discovery must not record files, got %s", file.FileName)
+			return nil
+		}
+		service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+		result, err := service.DiscoverSchema("dirSource", 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.RowsSampled)
+		assert.Len(t, result.Columns, 2)
+		_, err = os.Stat(filepath.Join(dir, "a.ndjson"))
+		assert.NoError(t, err, "discovery must not archive files")
+	})
+
+	t.Run("Push Source Is Not Supported", func(t *testing.T) {
+		service := NewIngestionService(configFor("push", PushConnectionParams{}), &MockProcessingServiceClient{})
+		_, err := service.DiscoverSchema("pushSource", 0)
+		assert.ErrorIs(t, err, ErrDiscoveryNotSupported)
+	})
+
+	t.Run("Connection Test Reports Failure", func(t *testing.T) {
+		service := NewIngestionService(configFor("csv", CSVConnectionParams{Filepath: filepath.Join(t.TempDir(), "missing.csv")}), &MockProcessingServiceClient{})
+		result := service.TestConnection("badSource")
+		assert.False(t, result.OK)
+		assert.Contains(t, result.Error, "missing.csv")
+
+		path := createTempCSV(t, "id\n1\n")
+		service = NewIngestionService(configFor("csv", CSVConnectionParams{Filepath: path}), &MockProcessingServiceClient{})
+		result = service.TestConnection("goodSource")
+		assert.True(t, result.OK)
+		assert.Empty(t, result.Error)
+	})
+}
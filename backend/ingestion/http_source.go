@@ -0,0 +1,287 @@
+package ingestion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pagination strategies supported by the http_api data source type.
+const (
+	PaginationNone       = "none"
+	PaginationPageNumber = "page_number"
+	PaginationOffset     = "offset_limit"
+	PaginationCursor     = "cursor"
+	PaginationLinkHeader = "link_header"
+)
+
+// defaultMaxPages bounds a single read so that a misbehaving API cannot page forever.
+const defaultMaxPages = 10000
+
+// HTTPAPIConnectionParams defines the structure for http_api connection details.
+type HTTPAPIConnectionParams struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"` // Defaults to GET
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is sent as-is with every request, e.g. for search endpoints that expect POST.
+	Body string          `json:"body,omitempty"`
+	Auth *HTTPAuthParams `json:"auth,omitempty"`
+	// RecordsPath is a JSONPath-style selector for the records array in each response (see
+	// JSONConnectionParams.RootPath). Empty selects the response body itself.
+	RecordsPath string            `json:"records_path,omitempty"`
+	Flatten     bool              `json:"flatten,omitempty"`
+	Pagination  HTTPAPIPagination `json:"pagination"`
+	// TimeoutSeconds applies to each request. Defaults to 30.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// HTTPAuthParams describes where the value of the authentication header comes from.
+// The value is read from the environment variable ValueEnv, or from Value, which should be a secret
+// reference ({"secret_ref": "env:NAME"} or {"secret_ref": "file:/path"}) so that tokens are not
+// stored in the data source configuration. A plain Value is fine for non-sensitive keys.
+type HTTPAuthParams struct {
+	Header   string      `json:"header"`           // e.g. "Authorization" or "X-API-Key"
+	Prefix   string      `json:"prefix,omitempty"` // e.g. "Bearer "
+	ValueEnv string      `json:"value_env,omitempty"`
+	Value    SecretValue `json:"value,omitempty"`
+}
+
+// HTTPAPIPagination configures how the next page is requested.
+type HTTPAPIPagination struct {
+	// Type is one of "none" (default), "page_number", "offset_limit", "cursor" or "link_header".
+	Type string `json:"type,omitempty"`
+
+	// page_number: PageParam (default "page") starts at StartPage (default 1) and is incremented.
+	PageParam string `json:"page_param,omitempty"`
+	StartPage int    `json:"start_page,omitempty"`
+
+	// offset_limit: OffsetParam (default "offset") advances by the number of records received.
+	OffsetParam string `json:"offset_param,omitempty"`
+
+	// page_number and offset_limit: SizeParam (e.g. "per_page" or "limit") is set to PageSize when both
+	// are given. Reading stops at the first empty page, or at the first page shorter than PageSize.
+	SizeParam string `json:"size_param,omitempty"`
+	PageSize  int    `json:"page_size,omitempty"`
+
+	// cursor: the token found at CursorPath in each response is sent as CursorParam (default "cursor").
+	// Reading stops when the token is missing or empty.
+	CursorParam string `json:"cursor_param,omitempty"`
+	CursorPath  string `json:"cursor_path,omitempty"`
+
+	// MaxPages limits the number of requests per read. Defaults to 10000.
+	MaxPages int `json:"max_pages,omitempty"`
+}
+
+// readHTTPAPIRecords requests params.URL, following the configured pagination, and calls handle
+// for every record selected by params.RecordsPath.
+func readHTTPAPIRecords(sourceID string, params HTTPAPIConnectionParams, handle RecordHandler) error {
+	if params.URL == "" {
+		return fmt.Errorf("url is required for http_api data source type, source ID %s", sourceID)
+	}
+	recordSegments, err := parseJSONPath(params.RecordsPath)
+	if err != nil {
+		return fmt.Errorf("invalid records_path for source ID %s: %w", sourceID, err)
+	}
+	pagination := params.Pagination
+	if pagination.Type == "" {
+		pagination.Type = PaginationNone
+	}
+	var cursorSegments []jsonPathSegment
+	switch pagination.Type {
+	case PaginationNone, PaginationPageNumber, PaginationOffset, PaginationLinkHeader:
+	case PaginationCursor:
+		if pagination.CursorPath == "" {
+			return fmt.Errorf("pagination.cursor_path is required for cursor pagination, source ID %s", sourceID)
+		}
+		if cursorSegments, err = parseJSONPath(pagination.CursorPath); err != nil {
+			return fmt.Errorf("invalid pagination.cursor_path for source ID %s: %w", sourceID, err)
+		}
+	default:
+		return fmt.Errorf("unsupported pagination type %q for source ID %s", pagination.Type, sourceID)
+	}
+	maxPages := pagination.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+	timeout := 30 * time.Second
+	if params.TimeoutSeconds > 0 {
+		timeout = time.Duration(params.TimeoutSeconds) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	authValue := ""
+	if params.Auth != nil {
+		if params.Auth.Header == "" {
+			return fmt.Errorf("auth.header is required when auth is configured, source ID %s", sourceID)
+		}
+		var err error
+		if authValue, err = params.Auth.Value.Resolve(); err != nil {
+			return fmt.Errorf("failed to resolve the auth header of source ID %s: %w", sourceID, err)
+		}
+		if params.Auth.ValueEnv != "" {
+			authValue = os.Getenv(params.Auth.ValueEnv)
+			if authValue == "" {
+				return fmt.Errorf("environment variable %s for the auth header of source ID %s is not set", params.Auth.ValueEnv, sourceID)
+			}
+		}
+		authValue = params.Auth.Prefix + authValue
+	}
+
+	pageURL, err := url.Parse(params.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url for source ID %s: %w", sourceID, err)
+	}
+
+	page := pagination.StartPage
+	if pagination.Type == PaginationPageNumber && page == 0 {
+		page = 1
+	}
+	offset := 0
+	total := 0
+	for pageCount := 1; ; pageCount++ {
+		if pageCount > maxPages {
+			return fmt.Errorf("stopped reading source ID %s after max_pages (%d) requests", sourceID, maxPages)
+		}
+
+		requestURL := *pageURL
+		query := requestURL.Query()
+		switch pagination.Type {
+		case PaginationPageNumber:
+			query.Set(defaultString(pagination.PageParam, "page"), strconv.Itoa(page))
+		case PaginationOffset:
+			query.Set(defaultString(pagination.OffsetParam, "offset"), strconv.Itoa(offset))
+		}
+		if (pagination.Type == PaginationPageNumber || pagination.Type == PaginationOffset) && pagination.SizeParam != "" && pagination.PageSize > 0 {
+			query.Set(pagination.SizeParam, strconv.Itoa(pagination.PageSize))
+		}
+		requestURL.RawQuery = query.Encode()
+
+		doc, header, err := fetchHTTPAPIPage(client, params, authValue, requestURL.String())
+		if err != nil {
+			return fmt.Errorf("failed to fetch page %d for source ID %s: %w", pageCount, sourceID, err)
+		}
+		count, err := emitJSONRecords(doc, recordSegments, params.Flatten, handle)
+		total += count
+		if err != nil {
+			return fmt.Errorf("failed to read records of page %d for source ID %s: %w", pageCount, sourceID, err)
+		}
+
+		switch pagination.Type {
+		case PaginationNone:
+			log.Printf("Successfully ingested %d records for source ID: %s from %s.", total, sourceID, params.URL)
+			return nil
+		case PaginationPageNumber, PaginationOffset:
+			if count == 0 || (pagination.PageSize > 0 && count < pagination.PageSize) {
+				log.Printf("Successfully ingested %d records in %d pages for source ID: %s from %s.", total, pageCount, sourceID, params.URL)
+				return nil
+			}
+			page++
+			offset += count
+		case PaginationCursor:
+			cursor := ""
+			if matches := selectJSONPath(doc, cursorSegments); len(matches) > 0 && matches[0] != nil {
+				cursor = fmt.Sprint(matches[0])
+			}
+			if cursor == "" {
+				log.Printf("Successfully ingested %d records in %d pages for source ID: %s from %s.", total, pageCount, sourceID, params.URL)
+				return nil
+			}
+			query := pageURL.Query()
+			query.Set(defaultString(pagination.CursorParam, "cursor"), cursor)
+			pageURL.RawQuery = query.Encode()
+		case PaginationLinkHeader:
+			next := nextLinkURL(header.Values("Link"))
+			if next == "" {
+				log.Printf("Successfully ingested %d records in %d pages for source ID: %s from %s.", total, pageCount, sourceID, params.URL)
+				return nil
+			}
+			nextURL, err := requestURL.Parse(next) // Resolves relative links
+			if err != nil {
+				return fmt.Errorf("invalid next link %q for source ID %s: %w", next, sourceID, err)
+			}
+			pageURL = nextURL
+		}
+	}
+}
+
+// fetchHTTPAPIPage performs a single request and decodes its JSON body.
+func fetchHTTPAPIPage(client *http.Client, params HTTPAPIConnectionParams, authValue, requestURL string) (interface{}, http.Header, error) {
+	method := strings.ToUpper(defaultString(params.Method, http.MethodGet))
+	var body io.Reader
+	if params.Body != "" {
+		body = bytes.NewBufferString(params.Body)
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if params.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range params.Headers {
+		req.Header.Set(name, value)
+	}
+	if params.Auth != nil {
+		req.Header.Set(params.Auth.Header, authValue)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request to %s failed: %w", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, nil, fmt.Errorf("%s returned non-OK status %d. Response: %s", requestURL, resp.StatusCode, string(snippet))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode JSON response from %s: %w", requestURL, err)
+	}
+	return doc, resp.Header, nil
+}
+
+// nextLinkURL returns the target of the rel="next" entry of RFC 8288 Link header values, or "".
+func nextLinkURL(values []string) string {
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, attr := range parts[1:] {
+				name, val, ok := strings.Cut(strings.TrimSpace(attr), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(val), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
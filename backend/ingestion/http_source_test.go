@@ -0,0 +1,227 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAllHTTPAPI reads an http_api source described by params and returns its records.
+func readAllHTTPAPI(t *testing.T, params HTTPAPIConnectionParams) ([]map[string]interface{}, error) {
+	t.Helper()
+	var records []map[string]interface{}
+	err := readHTTPAPIRecords("httpSource", params, func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// itemsPage writes {"items": [...]} with ids from first to last (inclusive).
+func itemsPage(w http.ResponseWriter, first, last int, extra map[string]interface{}) {
+	items := []map[string]interface{}{}
+	for id := first; id <= last; id++ {
+		items = append(items, map[string]interface{}{"id": id, "detail": map[string]interface{}{"name": fmt.Sprintf("item-%d", id)}})
+	}
+	body := map[string]interface{}{"items": items}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestReadHTTPAPIRecords(t *testing.T) {
+	t.Run("Single Request With Headers And Auth", func(t *testing.T) {
+		t.Setenv("TEST_API_TOKEN", "s3cr3t")
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+			assert.Equal(t, "v2", r.Header.Get("X-Api-Version"))
+			itemsPage(w, 1, 2, nil)
+		}))
+		defer server.Close()
+
+		records, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL + "/search",
+			Method:      "post",
+			Headers:     map[string]string{"X-Api-Version": "v2"},
+			Auth:        &HTTPAuthParams{Header: "Authorization", Prefix: "Bearer ", ValueEnv: "TEST_API_TOKEN"},
+			RecordsPath: "$.items",
+			Flatten:     true,
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, json.Number("2"), records[1]["id"])
+		assert.Equal(t, "item-2", records[1]["detail.name"])
+	})
+
+	t.Run("Page Number Pagination", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "2", r.URL.Query().Get("per_page"))
+			assert.Equal(t, "keep", r.URL.Query().Get("filter"), "configured query parameters must be preserved")
+			switch r.URL.Query().Get("p") {
+			case "1":
+				itemsPage(w, 1, 2, nil)
+			case "2":
+				itemsPage(w, 3, 4, nil)
+			case "3":
+				itemsPage(w, 5, 5, nil)
+			default:
+				t.Errorf("unexpected page %q", r.URL.Query().Get("p"))
+			}
+		}))
+		defer server.Close()
+
+		records, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL + "/items?filter=keep",
+			RecordsPath: "items",
+			Pagination:  HTTPAPIPagination{Type: PaginationPageNumber, PageParam: "p", SizeParam: "per_page", PageSize: 2},
+		})
+		require.NoError(t, err)
+		assert.Len(t, records, 5)
+	})
+
+	t.Run("Offset Limit Pagination", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			if offset >= 3 {
+				itemsPage(w, 1, 0, nil) // Empty page ends the read
+				return
+			}
+			itemsPage(w, offset+1, offset+3, nil)
+		}))
+		defer server.Close()
+
+		records, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL,
+			RecordsPath: "$.items",
+			Pagination:  HTTPAPIPagination{Type: PaginationOffset},
+		})
+		require.NoError(t, err)
+		assert.Len(t, records, 3)
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("Cursor Pagination", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Query().Get("after") {
+			case "":
+				itemsPage(w, 1, 2, map[string]interface{}{"meta": map[string]interface{}{"next": "abc"}})
+			case "abc":
+				itemsPage(w, 3, 3, map[string]interface{}{"meta": map[string]interface{}{"next": nil}})
+			default:
+				t.Errorf("unexpected cursor %q", r.URL.Query().Get("after"))
+			}
+		}))
+		defer server.Close()
+
+		records, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL,
+			RecordsPath: "$.items",
+			Pagination:  HTTPAPIPagination{Type: PaginationCursor, CursorParam: "after", CursorPath: "$.meta.next"},
+		})
+		require.NoError(t, err)
+		assert.Len(t, records, 3)
+	})
+
+	t.Run("Link Header Pagination", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/items" {
+				w.Header().Set("Link", `</items/page2>; rel="next", </items>; rel="first"`)
+				itemsPage(w, 1, 2, nil)
+				return
+			}
+			w.Header().Set("Link", `</items>; rel="first"`)
+			itemsPage(w, 3, 3, nil)
+		}))
+		defer server.Close()
+
+		records, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL + "/items",
+			RecordsPath: "$.items",
+			Pagination:  HTTPAPIPagination{Type: PaginationLinkHeader},
+		})
+		require.NoError(t, err)
+		assert.Len(t, records, 3)
+	})
+
+	t.Run("Max Pages", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			itemsPage(w, 1, 1, map[string]interface{}{"next": "same"})
+		}))
+		defer server.Close()
+
+		_, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:         server.URL,
+			RecordsPath: "$.items",
+			Pagination:  HTTPAPIPagination{Type: PaginationCursor, CursorPath: "next", MaxPages: 3},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "max_pages (3)")
+	})
+
+	t.Run("Non OK Status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		_, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{URL: server.URL})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "non-OK status 429")
+		assert.Contains(t, err.Error(), "rate limited")
+	})
+
+	t.Run("Missing Auth Environment Variable", func(t *testing.T) {
+		_, err := readAllHTTPAPI(t, HTTPAPIConnectionParams{
+			URL:  "http://localhost",
+			Auth: &HTTPAuthParams{Header: "X-API-Key", ValueEnv: "TEST_API_TOKEN_UNSET"},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "TEST_API_TOKEN_UNSET")
+	})
+}
+
+func TestNextLinkURL(t *testing.T) {
+	assert.Equal(t, "https://api.example.com/items?page=3",
+		nextLinkURL([]string{`<https://api.example.com/items?page=1>; rel="prev", <https://api.example.com/items?page=3>; rel="next"`}))
+	assert.Equal(t, "/p2", nextLinkURL([]string{`</p2>; rel="next last"`}))
+	assert.Equal(t, "", nextLinkURL([]string{`</p1>; rel="prev"`}))
+	assert.Equal(t, "", nextLinkURL(nil))
+}
+
+func TestIngestData_HTTPAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		itemsPage(w, 1, 3, nil)
+	}))
+	defer server.Close()
+
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{
+				ID:                sourceID,
+				Type:              "http_api",
+				ConnectionDetails: fmt.Sprintf(`{"url": %q, "records_path": "$.items", "flatten": true}`, server.URL),
+				EntityID:          "TestEntity",
+			}, nil
+		},
+	}
+	mockProcClient := &MockProcessingServiceClient{}
+	service := NewIngestionService(mockMetaClient, mockProcClient)
+
+	results, err := service.IngestData("httpSource")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NotNil(t, mockProcClient.CapturedProcessDataRequest)
+	assert.Equal(t, "TestEntity", mockProcClient.CapturedProcessDataRequest.EntityTypeName)
+	assert.Equal(t, "item-1", mockProcClient.CapturedProcessDataRequest.RawData[0]["detail.name"])
+}
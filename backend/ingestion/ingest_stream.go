@@ -0,0 +1,88 @@
+package ingestion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Record batches can be handed to the processing service through the durable INGEST stream of NATS
+// JetStream instead of over HTTP. Publishing only waits for the stream to store the batch; the processing
+// service consumes the stream at its own pace, acknowledges every batch once it is stored and moves batches
+// that keep failing to IngestDeadLetterSubject. Batches are never lost when processing is down or slow.
+
+const (
+	// IngestStreamName is the JetStream stream of record batches. It must match the processing service.
+	IngestStreamName = "INGEST"
+	// IngestBatchSubject is the subject record batches are published to.
+	IngestBatchSubject = "ingest.batches"
+	// IngestDeadLetterSubject is the subject of the batches the processing service gave up on.
+	IngestDeadLetterSubject = "ingest.dead"
+)
+
+// IngestJetStreamPublisher defines the NATS JetStream operations used to publish record batches.
+// nats.JetStreamContext implements it.
+type IngestJetStreamPublisher interface {
+	Publish(subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error)
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+}
+
+// JetStreamProcessingServiceClient is an implementation of ProcessingServiceAPIClient that publishes
+// every ProcessDataRequest to the INGEST stream. Its responses are Queued.
+type JetStreamProcessingServiceClient struct {
+	js IngestJetStreamPublisher
+}
+
+// NewJetStreamProcessingServiceClient creates a client publishing to the INGEST stream, which it creates if
+// it does not exist yet.
+func NewJetStreamProcessingServiceClient(js IngestJetStreamPublisher) (*JetStreamProcessingServiceClient, error) {
+	if err := ensureIngestStream(js); err != nil {
+		return nil, err
+	}
+	return &JetStreamProcessingServiceClient{js: js}, nil
+}
+
+// ensureIngestStream creates the INGEST stream if it does not exist (idempotent). Batches stay in the
+// stream until the processing service acknowledges them; dead letters stay until they are purged.
+func ensureIngestStream(js IngestJetStreamPublisher) error {
+	if _, err := js.StreamInfo(IngestStreamName); err == nil {
+		return nil
+	}
+	log.Printf("Stream %s not found, attempting to create it for subject %s...", IngestStreamName, "ingest.>")
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:      IngestStreamName,
+		Subjects:  []string{"ingest.>"},
+		Retention: nats.WorkQueuePolicy,
+		Storage:   nats.FileStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create NATS stream %s: %w", IngestStreamName, err)
+	}
+	log.Printf("Successfully created NATS stream %s", IngestStreamName)
+	return nil
+}
+
+// CallProcessData publishes the batch to the INGEST stream. The message ID is derived from the payload, so
+// that JetStream drops a batch republished after a lost acknowledgement.
+func (c *JetStreamProcessingServiceClient) CallProcessData(payload ProcessDataRequest) (*ProcessDataResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal process data payload: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	pubAck, err := c.js.Publish(IngestBatchSubject, body, nats.MsgId(hex.EncodeToString(sum[:])))
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish batch for source ID %s to stream %s: %w", payload.SourceID, IngestStreamName, err)
+	}
+	if pubAck.Duplicate {
+		log.Printf("Batch of %d records for SourceID %s was already queued (Stream: %s, Sequence: %d)", len(payload.RawData), payload.SourceID, pubAck.Stream, pubAck.Sequence)
+	} else {
+		log.Printf("Queued batch of %d records for SourceID %s (Stream: %s, Sequence: %d)", len(payload.RawData), payload.SourceID, pubAck.Stream, pubAck.Sequence)
+	}
+	return &ProcessDataResponse{RecordsReceived: len(payload.RawData), Queued: true}, nil
+}
@@ -0,0 +1,111 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// --- Mock IngestJetStreamPublisher ---
+type MockIngestJetStream struct {
+	Streams           map[string]*nats.StreamConfig
+	PublishedMessages map[string][][]byte // Payloads by subject
+	PublishErr        error
+}
+
+func NewMockIngestJetStream() *MockIngestJetStream {
+	return &MockIngestJetStream{
+		Streams:           make(map[string]*nats.StreamConfig),
+		PublishedMessages: make(map[string][][]byte),
+	}
+}
+
+func (m *MockIngestJetStream) Publish(subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	if m.PublishErr != nil {
+		return nil, m.PublishErr
+	}
+	m.PublishedMessages[subj] = append(m.PublishedMessages[subj], data)
+	return &nats.PubAck{Stream: IngestStreamName, Sequence: uint64(len(m.PublishedMessages[subj]))}, nil
+}
+
+func (m *MockIngestJetStream) StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	if cfg, ok := m.Streams[stream]; ok {
+		return &nats.StreamInfo{Config: *cfg}, nil
+	}
+	return nil, nats.ErrStreamNotFound
+}
+
+func (m *MockIngestJetStream) AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	m.Streams[cfg.Name] = cfg
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func TestJetStreamProcessingServiceClient(t *testing.T) {
+	js := NewMockIngestJetStream()
+	client, err := NewJetStreamProcessingServiceClient(js)
+	require.NoError(t, err)
+
+	t.Run("Creates The Stream", func(t *testing.T) {
+		require.Contains(t, js.Streams, IngestStreamName)
+		cfg := js.Streams[IngestStreamName]
+		assert.Equal(t, []string{"ingest.>"}, cfg.Subjects)
+		assert.Equal(t, nats.WorkQueuePolicy, cfg.Retention)
+		assert.Equal(t, nats.FileStorage, cfg.Storage)
+
+		cfg.Description = "existing"
+		_, err := NewJetStreamProcessingServiceClient(js)
+		require.NoError(t, err)
+		assert.Equal(t, "existing", js.Streams[IngestStreamName].Description, "an existing stream is kept")
+	})
+
+	t.Run("Publishes Batches", func(t *testing.T) {
+		resp, err := client.CallProcessData(ProcessDataRequest{
+			SourceID:       "src-1",
+			EntityTypeName: "Customer",
+			RunID:          "run-1",
+			RawData:        []map[string]interface{}{{"id": "1"}, {"id": "2"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, &ProcessDataResponse{RecordsReceived: 2, Queued: true}, resp)
+
+		require.Len(t, js.PublishedMessages[IngestBatchSubject], 1)
+		var published ProcessDataRequest
+		require.NoError(t, json.Unmarshal(js.PublishedMessages[IngestBatchSubject][0], &published))
+		assert.Equal(t, "src-1", published.SourceID)
+		assert.Equal(t, "run-1", published.RunID)
+		assert.Len(t, published.RawData, 2)
+	})
+
+	t.Run("Publish Failure", func(t *testing.T) {
+		js.PublishErr = fmt.Errorf("no responders")
+		defer func() { js.PublishErr = nil }()
+		_, err := client.CallProcessData(ProcessDataRequest{SourceID: "src-1", RawData: []map[string]interface{}{{"id": "1"}}})
+		assert.ErrorContains(t, err, "no responders")
+	})
+
+	t.Run("Streaming Ingestion Does Not Count Queued Records As Rejected", func(t *testing.T) {
+		mockMetaClient := &MockMetadataServiceClient{
+			GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+				return &DataSourceConfig{ID: sourceID, Type: "csv", EntityID: "TestEntity"}, nil
+			},
+		}
+		service := NewIngestionService(mockMetaClient, client)
+		read := func(dsConfig *DataSourceConfig, fetchSize int, handle RecordHandler) (*readCheckpoint, error) {
+			for i := 0; i < 3; i++ {
+				if err := handle(map[string]interface{}{"id": i}); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}
+		progress, err := service.ingestStreaming("src-2", "", 2, read, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 3, progress.RowsSent)
+		assert.Zero(t, progress.RowsRejected)
+		assert.Len(t, js.PublishedMessages[IngestBatchSubject], 3)
+	})
+}
@@ -0,0 +1,313 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONConnectionParams defines the structure for JSON and NDJSON connection details.
+type JSONConnectionParams struct {
+	Filepath string `json:"filepath"`
+	// RootPath is a JSONPath-style selector for the records inside each document, e.g. "$.data.items",
+	// "$.pages[*].items" or "$.results[0].rows". The selected value may be an array of records or a single
+	// record object. Empty or "$" selects the document itself.
+	RootPath string `json:"root_path,omitempty"`
+	// Flatten turns nested objects into dotted field names ({"a":{"b":1}} becomes {"a.b":1}), so that
+	// DataSourceFieldMapping.SourceFieldName can address nested values. Arrays are kept as values.
+	Flatten bool `json:"flatten,omitempty"`
+}
+
+// jsonPathSegment is one step of a parsed root selector: an object key, an array index or a wildcard.
+type jsonPathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the supported subset of JSONPath: an optional leading "$", dot-separated keys,
+// bracketed indices ("[0]"), wildcards ("[*]" or ".*") and quoted keys ("['a.b']").
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	var segments []jsonPathSegment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("invalid root path %q: empty key at position %d", path, i)
+			}
+			if key := path[i:end]; key == "*" {
+				segments = append(segments, jsonPathSegment{wildcard: true})
+			} else {
+				segments = append(segments, jsonPathSegment{key: key})
+			}
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid root path %q: unterminated '['", path)
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+			switch {
+			case inner == "*":
+				segments = append(segments, jsonPathSegment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				segments = append(segments, jsonPathSegment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid root path %q: unsupported selector [%s]", path, inner)
+				}
+				segments = append(segments, jsonPathSegment{index: index, isIndex: true})
+			}
+		default:
+			if len(segments) > 0 {
+				return nil, fmt.Errorf("invalid root path %q: expected '.' or '[' at position %d", path, i)
+			}
+			// Allow a bare leading key ("data.items") for convenience.
+			path = "." + path[i:]
+			i = 0
+		}
+	}
+	return segments, nil
+}
+
+// selectJSONPath returns every value matched by segments within doc. Missing keys and out-of-range
+// indices match nothing.
+func selectJSONPath(doc interface{}, segments []jsonPathSegment) []interface{} {
+	nodes := []interface{}{doc}
+	for _, seg := range segments {
+		var next []interface{}
+		for _, node := range nodes {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				if seg.wildcard {
+					keys := make([]string, 0, len(v))
+					for key := range v {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys) // Deterministic record order
+					for _, key := range keys {
+						next = append(next, v[key])
+					}
+				} else if child, ok := v[seg.key]; ok && !seg.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if seg.wildcard {
+					next = append(next, v...)
+				} else if seg.isIndex && seg.index < len(v) {
+					next = append(next, v[seg.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// flattenRecord returns a copy of record where nested objects are replaced by dotted keys.
+func flattenRecord(record map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{}, len(record))
+	var walk func(prefix string, obj map[string]interface{})
+	walk = func(prefix string, obj map[string]interface{}) {
+		for key, value := range obj {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(name, nested)
+				continue
+			}
+			flat[name] = value
+		}
+	}
+	walk("", record)
+	return flat
+}
+
+// emitJSONRecords applies the root selector to a decoded document and calls handle for every record.
+// It returns the number of records emitted.
+func emitJSONRecords(doc interface{}, segments []jsonPathSegment, flatten bool, handle RecordHandler) (int, error) {
+	count := 0
+	emit := func(value interface{}) error {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected a JSON object for each record, got %T", value)
+		}
+		if flatten {
+			record = flattenRecord(record)
+		}
+		count++
+		return handle(record)
+	}
+
+	for _, node := range selectJSONPath(doc, segments) {
+		if items, ok := node.([]interface{}); ok {
+			for _, item := range items {
+				if err := emit(item); err != nil {
+					return count, err
+				}
+			}
+			continue
+		}
+		if err := emit(node); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// readJSONRecords reads a JSON document (format "json") or a file with one JSON document per line
+// (format "ndjson") and calls handle for every record selected by params.RootPath.
+// A top-level JSON array without a root selector is decoded one element at a time.
+// Numbers are decoded as json.Number so that large integers keep their precision.
+func readJSONRecords(sourceID, format string, params JSONConnectionParams, handle RecordHandler) error {
+	if params.Filepath == "" {
+		return fmt.Errorf("filepath is required for %s data source type, source ID %s", format, sourceID)
+	}
+	segments, err := parseJSONPath(params.RootPath)
+	if err != nil {
+		return fmt.Errorf("invalid root_path for source ID %s: %w", sourceID, err)
+	}
+
+	file, err := os.Open(params.Filepath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s file %s for source ID %s: %w", format, params.Filepath, sourceID, err)
+	}
+	defer file.Close()
+
+	var count int
+	if format == "ndjson" {
+		count, err = readNDJSON(file, segments, params.Flatten, handle)
+	} else {
+		count, err = readJSONDocument(file, segments, params.Flatten, handle)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s file %s for source ID %s: %w", format, params.Filepath, sourceID, err)
+	}
+	log.Printf("Successfully ingested %d records for source ID: %s from %s file %s.", count, sourceID, format, params.Filepath)
+	return nil
+}
+
+func readJSONDocument(r io.Reader, segments []jsonPathSegment, flatten bool, handle RecordHandler) (int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	decoder.UseNumber()
+
+	if len(segments) > 0 {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return emitJSONRecords(doc, segments, flatten, handle)
+	}
+
+	// Without a selector, stream the elements of a top-level array instead of decoding it whole.
+	tok, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	switch delim, _ := tok.(json.Delim); delim {
+	case '[':
+	case '{':
+		// A single record object.
+		var record map[string]interface{}
+		if err := decodeObjectBody(decoder, &record); err != nil {
+			return 0, err
+		}
+		return emitJSONRecords(record, nil, flatten, handle)
+	default:
+		return 0, fmt.Errorf("expected a JSON array or object at the top level, got %v", tok)
+	}
+
+	count := 0
+	for decoder.More() {
+		var item interface{}
+		if err := decoder.Decode(&item); err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		if _, err := emitJSONRecords(item, nil, flatten, handle); err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		count++
+	}
+	if _, err := decoder.Token(); err != nil { // closing ']'
+		return count, err
+	}
+	return count, nil
+}
+
+// decodeObjectBody decodes the members of an object whose opening '{' was already consumed.
+func decodeObjectBody(decoder *json.Decoder, out *map[string]interface{}) error {
+	obj := make(map[string]interface{})
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected an object key, got %v", tok)
+		}
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		obj[key] = value
+	}
+	if _, err := decoder.Token(); err != nil { // closing '}'
+		return err
+	}
+	*out = obj
+	return nil
+}
+
+func readNDJSON(r io.Reader, segments []jsonPathSegment, flatten bool, handle RecordHandler) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // Allow long lines
+	count, lineNumber := 0, 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			return count, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		n, err := emitJSONRecords(doc, segments, flatten, handle)
+		count += n
+		if err != nil {
+			return count, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
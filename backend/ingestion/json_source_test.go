@@ -0,0 +1,154 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Helper to create a temporary file with the given name and content for testing
+func createTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644), "Failed to write temp file")
+	return path
+}
+
+func TestParseJSONPath(t *testing.T) {
+	segments, err := parseJSONPath("$.data.pages[*]['item.list'][0]")
+	require.NoError(t, err)
+	assert.Equal(t, []jsonPathSegment{
+		{key: "data"},
+		{key: "pages"},
+		{wildcard: true},
+		{key: "item.list"},
+		{index: 0, isIndex: true},
+	}, segments)
+
+	segments, err = parseJSONPath("data.items")
+	require.NoError(t, err)
+	assert.Equal(t, []jsonPathSegment{{key: "data"}, {key: "items"}}, segments)
+
+	segments, err = parseJSONPath("$")
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+
+	for _, invalid := range []string{"$..items", "$.items[", "$.items[-1]", "$.items[?(@.a)]"} {
+		_, err := parseJSONPath(invalid)
+		assert.Error(t, err, "expected %q to be rejected", invalid)
+	}
+}
+
+func TestFlattenRecord(t *testing.T) {
+	record := map[string]interface{}{
+		"id":       json.Number("1"),
+		"customer": map[string]interface{}{"name": "Ada", "address": map[string]interface{}{"city": "London"}},
+		"tags":     []interface{}{"a", "b"},
+		"empty":    map[string]interface{}{},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"id":                    json.Number("1"),
+		"customer.name":         "Ada",
+		"customer.address.city": "London",
+		"tags":                  []interface{}{"a", "b"},
+		"empty":                 map[string]interface{}{},
+	}, flattenRecord(record))
+}
+
+func TestIngestData_JSON(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	mockProcClient := &MockProcessingServiceClient{}
+	service := NewIngestionService(mockMetaClient, mockProcClient)
+
+	useSource := func(sourceType, path string, params string) {
+		mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{
+				ID:                sourceID,
+				Type:              sourceType,
+				ConnectionDetails: fmt.Sprintf(`{"filepath": %q%s}`, path, params),
+				EntityID:          "TestEntity",
+			}, nil
+		}
+	}
+
+	t.Run("Top Level Array", func(t *testing.T) {
+		path := createTempFile(t, "records.json", `[{"id": 1, "name": "a"}, {"id": 12345678901234567890, "name": "b"}]`)
+		useSource("json", path, "")
+
+		results, err := service.IngestData("jsonArray")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, "a", results[0]["name"])
+		assert.Equal(t, json.Number("12345678901234567890"), results[1]["id"], "large integers must keep their precision")
+	})
+
+	t.Run("Root Selector And Flatten", func(t *testing.T) {
+		content := `{"meta": {"page": 1}, "data": {"items": [
+			{"id": "1", "customer": {"name": "Ada", "address": {"city": "London"}}},
+			{"id": "2", "customer": {"name": "Grace"}}
+		]}}`
+		path := createTempFile(t, "nested.json", content)
+		useSource("JSON", path, `, "root_path": "$.data.items", "flatten": true`)
+
+		results, err := service.IngestData("jsonNested")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, "London", results[0]["customer.address.city"])
+		assert.Equal(t, "Grace", results[1]["customer.name"])
+		assert.NotContains(t, results[0], "customer")
+	})
+
+	t.Run("Single Object Document", func(t *testing.T) {
+		path := createTempFile(t, "single.json", `{"id": "only"}`)
+		useSource("json", path, "")
+
+		results, err := service.IngestData("jsonObject")
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "only", results[0]["id"])
+	})
+
+	t.Run("NDJSON With Root Selector", func(t *testing.T) {
+		content := "{\"event\": {\"id\": \"e1\", \"user\": {\"id\": 7}}}\n\n{\"event\": {\"id\": \"e2\", \"user\": {\"id\": 8}}}\n"
+		path := createTempFile(t, "events.ndjson", content)
+		useSource("ndjson", path, `, "root_path": "event", "flatten": true`)
+
+		results, err := service.IngestData("ndjsonEvents")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, "e2", results[1]["id"])
+		assert.Equal(t, json.Number("8"), results[1]["user.id"])
+	})
+
+	t.Run("NDJSON Malformed Line", func(t *testing.T) {
+		path := createTempFile(t, "bad.ndjson", "{\"id\": 1}\n{\"id\": \n")
+		useSource("ndjson", path, "")
+
+		_, err := service.IngestData("ndjsonBad")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 2")
+	})
+
+	t.Run("Non Object Records", func(t *testing.T) {
+		path := createTempFile(t, "scalars.json", `[1, 2, 3]`)
+		useSource("json", path, "")
+
+		_, err := service.IngestData("jsonScalars")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected a JSON object")
+	})
+
+	t.Run("Missing Filepath", func(t *testing.T) {
+		mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: "json", ConnectionDetails: `{}`}, nil
+		}
+		_, err := service.IngestData("jsonNoPath")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "filepath is required for json data source type")
+	})
+}
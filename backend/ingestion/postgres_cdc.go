@@ -0,0 +1,434 @@
+package ingestion
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Modes of PostgreSQL data sources.
+const (
+	PostgresModeQuery = "query"
+	PostgresModeCDC   = "cdc"
+)
+
+// DeletedRecordField marks a record as deleted at its source. It mirrors the processing service,
+// which keeps the stored instance as a tombstone.
+const DeletedRecordField = "_deleted"
+
+// cdcWatermarkColumn is the watermark column under which the replication position of a CDC source
+// is persisted.
+const cdcWatermarkColumn = "pg_lsn"
+
+// replicationSlot identifies the slot of a CDC read and the position it may be advanced to once
+// the changes read were processed.
+type replicationSlot struct {
+	sourceID string
+	params   ConnectionParams
+	lsn      string
+}
+
+// readPostgresChanges reads the changes committed since the position persisted by the previous run
+// from the logical replication slot of a CDC source, decoding the pgoutput protocol (version 1).
+// Inserts and updates are passed to handle as upserts and deletes as records marked with
+// DeletedRecordField. Columns unchanged in an update whose value is TOASTed are not sent by
+// PostgreSQL and are missing from the record.
+// Records without an "id" column get a "source_record_id" built from the replica identity key.
+// It returns the end position of the last transaction read, or "" if there were no new transactions.
+//
+// The slot is only peeked; it is advanced by commitRead, so changes are read again until they
+// were processed. Changes made before the slot was created are not replicated; load them with an
+// initial query-mode run.
+func readPostgresChanges(sourceID string, params ConnectionParams, since string, handle RecordHandler) (string, error) {
+	if params.ReplicationSlot == "" || params.Publication == "" {
+		return "", fmt.Errorf("missing required CDC parameters (replication_slot, publication) for source ID %s", sourceID)
+	}
+	sinceLSN, err := parseLSN(since)
+	if err != nil {
+		return "", fmt.Errorf("invalid replication position %q for source ID %s: %w", since, sourceID, err)
+	}
+
+	db, err := openPostgres(sourceID, params)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if err := ensureReplicationSlot(db, params.ReplicationSlot); err != nil {
+		return "", fmt.Errorf("failed to prepare replication slot for source ID %s: %w", sourceID, err)
+	}
+
+	var maxChanges sql.NullInt64
+	if params.MaxChanges > 0 {
+		maxChanges = sql.NullInt64{Int64: int64(params.MaxChanges), Valid: true}
+	}
+	rows, err := db.Query(`SELECT data FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)`,
+		params.ReplicationSlot, maxChanges, params.Publication)
+	if err != nil {
+		return "", fmt.Errorf("failed to read changes from replication slot %s for source ID %s: %w", params.ReplicationSlot, sourceID, err)
+	}
+	defer rows.Close()
+
+	decoder := newPgoutputDecoder()
+	var (
+		last    uint64
+		skip    bool
+		changes int
+	)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return "", fmt.Errorf("failed to scan change for source ID %s: %w", sourceID, err)
+		}
+		msg, err := decoder.decode(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode change for source ID %s: %w", sourceID, err)
+		}
+		switch msg.kind {
+		case 'B':
+			// Transactions ending at or before the persisted position were processed by a previous run.
+			skip = msg.lsn < sinceLSN
+		case 'C':
+			if !skip {
+				last = msg.lsn
+			}
+		case 'T':
+			if !skip {
+				log.Printf("Warning: TRUNCATE of %d table(s) replicated for source ID %s is not propagated", len(msg.relations), sourceID)
+			}
+		case 'I', 'U', 'D':
+			if skip || !cdcTableSelected(params.TableOrQuery, msg.relation) {
+				continue
+			}
+			if err := handle(cdcRecord(msg)); err != nil {
+				return "", err
+			}
+			changes++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to read changes from replication slot %s for source ID %s: %w", params.ReplicationSlot, sourceID, err)
+	}
+	log.Printf("Read %d changes for source ID %s from replication slot %s", changes, sourceID, params.ReplicationSlot)
+	if last == 0 {
+		return "", nil
+	}
+	return formatLSN(last), nil
+}
+
+// ensureReplicationSlot creates the logical replication slot if it does not exist yet.
+func ensureReplicationSlot(db *sql.DB, slot string) error {
+	var plugin sql.NullString
+	err := db.QueryRow("SELECT plugin FROM pg_replication_slots WHERE slot_name = $1", slot).Scan(&plugin)
+	if err == sql.ErrNoRows {
+		if _, err := db.Exec("SELECT pg_create_logical_replication_slot($1, 'pgoutput')", slot); err != nil {
+			return fmt.Errorf("failed to create replication slot %s: %w", slot, err)
+		}
+		log.Printf("Created logical replication slot %s", slot)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up replication slot %s: %w", slot, err)
+	}
+	if plugin.String != "pgoutput" {
+		return fmt.Errorf("replication slot %s uses output plugin %q, expected pgoutput", slot, plugin.String)
+	}
+	return nil
+}
+
+// advanceReplicationSlot releases the WAL of changes that were processed. Failing to do so only
+// retains WAL longer: the position persisted as watermark keeps them from being processed twice.
+func advanceReplicationSlot(slot *replicationSlot) {
+	db, err := openPostgres(slot.sourceID, slot.params)
+	if err != nil {
+		log.Printf("Warning: failed to advance replication slot %s for source ID %s: %v", slot.params.ReplicationSlot, slot.sourceID, err)
+		return
+	}
+	defer db.Close()
+	if _, err := db.Exec("SELECT pg_replication_slot_advance($1, $2::pg_lsn)", slot.params.ReplicationSlot, slot.lsn); err != nil {
+		log.Printf("Warning: failed to advance replication slot %s for source ID %s: %v", slot.params.ReplicationSlot, slot.sourceID, err)
+		return
+	}
+	log.Printf("Advanced replication slot %s for source ID %s to %s", slot.params.ReplicationSlot, slot.sourceID, slot.lsn)
+}
+
+// cdcTableSelected reports whether changes of rel are ingested. table is empty to ingest every
+// table of the publication, or a table name optionally qualified with its schema.
+func cdcTableSelected(table string, rel *pgRelation) bool {
+	if table == "" {
+		return true
+	}
+	if strings.Contains(table, ".") {
+		return table == rel.namespace+"."+rel.name
+	}
+	return table == rel.name
+}
+
+// cdcRecord converts a decoded change into a record for the processing service.
+func cdcRecord(msg *pgMessage) map[string]interface{} {
+	record := make(map[string]interface{}, len(msg.columns)+2)
+	for name, value := range msg.columns {
+		if msg.kind == 'D' && value == nil {
+			continue // Only the replica identity of a deleted row is sent
+		}
+		record[name] = value
+	}
+	if _, ok := record["id"]; !ok {
+		var key []string
+		for _, col := range msg.relation.columns {
+			if col.key {
+				key = append(key, fmt.Sprintf("%v", msg.columns[col.name]))
+			}
+		}
+		if len(key) > 0 {
+			record["source_record_id"] = strings.Join(key, "|")
+		}
+	}
+	if msg.kind == 'D' {
+		record[DeletedRecordField] = true
+	}
+	return record
+}
+
+// parseLSN parses a log sequence number in PostgreSQL's "XXX/XXX" notation. "" parses as 0.
+func parseLSN(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, errors.New("expected format XXX/XXX")
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return h<<32 | l, nil
+}
+
+// formatLSN renders a log sequence number in PostgreSQL's "XXX/XXX" notation.
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// pgRelation describes a replicated table as announced by a pgoutput Relation message.
+type pgRelation struct {
+	id        uint32
+	namespace string
+	name      string
+	columns   []pgColumn
+}
+
+type pgColumn struct {
+	name string
+	key  bool
+}
+
+// pgMessage is a decoded pgoutput message. Only the fields relevant to its kind are set:
+// lsn is the final LSN of a Begin and the end LSN of a Commit; relation and columns describe an
+// Insert, Update or Delete; relations lists the tables of a Truncate.
+type pgMessage struct {
+	kind      byte
+	lsn       uint64
+	relation  *pgRelation
+	columns   map[string]interface{}
+	relations []uint32
+}
+
+// pgoutputDecoder decodes pgoutput protocol version 1 messages. Relation messages are cached
+// because row changes only reference their table by OID.
+type pgoutputDecoder struct {
+	relations map[uint32]*pgRelation
+}
+
+func newPgoutputDecoder() *pgoutputDecoder {
+	return &pgoutputDecoder{relations: make(map[uint32]*pgRelation)}
+}
+
+// decode decodes one message. Message kinds without relevance to ingestion (Origin, Type, logical
+// decoding messages) are returned with only their kind set.
+func (d *pgoutputDecoder) decode(data []byte) (*pgMessage, error) {
+	r := &pgReader{data: data}
+	msg := &pgMessage{kind: r.byte()}
+	switch msg.kind {
+	case 'B':
+		msg.lsn = r.uint64() // Final LSN of the transaction; commit timestamp and xid follow
+	case 'C':
+		r.byte()             // Flags
+		r.uint64()           // Commit LSN
+		msg.lsn = r.uint64() // End LSN of the transaction
+	case 'R':
+		rel := &pgRelation{id: r.uint32(), namespace: r.string(), name: r.string()}
+		r.byte() // Replica identity setting
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			flags := r.byte()
+			rel.columns = append(rel.columns, pgColumn{key: flags&1 == 1, name: r.string()})
+			r.uint32() // Type OID
+			r.uint32() // Type modifier
+		}
+		if r.err == nil {
+			d.relations[rel.id] = rel
+		}
+	case 'I':
+		if msg.relation = d.relation(r); msg.relation != nil {
+			r.expect('N')
+			msg.columns = r.tuple(msg.relation)
+		}
+	case 'U':
+		if msg.relation = d.relation(r); msg.relation != nil {
+			if kind := r.peek(); kind == 'K' || kind == 'O' {
+				r.byte()
+				r.tuple(msg.relation) // Old key or row; the new row is complete apart from unchanged TOAST values
+			}
+			r.expect('N')
+			msg.columns = r.tuple(msg.relation)
+		}
+	case 'D':
+		if msg.relation = d.relation(r); msg.relation != nil {
+			if kind := r.byte(); kind != 'K' && kind != 'O' && r.err == nil {
+				r.err = fmt.Errorf("unexpected tuple type %q in delete", kind)
+			}
+			msg.columns = r.tuple(msg.relation)
+		}
+	case 'T':
+		n := int(r.uint32())
+		r.byte() // Options
+		for i := 0; i < n && r.err == nil; i++ {
+			msg.relations = append(msg.relations, r.uint32())
+		}
+	case 'O', 'Y', 'M':
+	default:
+		return nil, fmt.Errorf("unknown pgoutput message type %q", msg.kind)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed pgoutput message %q: %w", msg.kind, r.err)
+	}
+	return msg, nil
+}
+
+// relation reads a relation OID and returns the cached relation.
+func (d *pgoutputDecoder) relation(r *pgReader) *pgRelation {
+	id := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	rel, ok := d.relations[id]
+	if !ok {
+		r.err = fmt.Errorf("change references unknown relation %d", id)
+		return nil
+	}
+	return rel
+}
+
+// pgReader reads the big-endian fields of a pgoutput message. The first read past the end of the
+// message sets err; later reads return zero values.
+type pgReader struct {
+	data []byte
+	err  error
+}
+
+func (r *pgReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("unexpected end of message")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *pgReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pgReader) peek() byte {
+	if r.err != nil || len(r.data) == 0 {
+		return 0
+	}
+	return r.data[0]
+}
+
+func (r *pgReader) expect(kind byte) {
+	if got := r.byte(); got != kind && r.err == nil {
+		r.err = fmt.Errorf("expected tuple type %q, got %q", kind, got)
+	}
+}
+
+func (r *pgReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *pgReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *pgReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// string reads a null-terminated string.
+func (r *pgReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data, 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.data[:i])
+	r.data = r.data[i+1:]
+	return s
+}
+
+// tuple reads TupleData into a map keyed by column name. Values are sent in their text
+// representation; NULLs are nil and unchanged TOASTed values are left out.
+func (r *pgReader) tuple(rel *pgRelation) map[string]interface{} {
+	n := int(r.uint16())
+	if r.err == nil && n > len(rel.columns) {
+		r.err = fmt.Errorf("tuple has %d columns, relation %s.%s has %d", n, rel.namespace, rel.name, len(rel.columns))
+	}
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		switch kind := r.byte(); kind {
+		case 'n':
+			values[rel.columns[i].name] = nil
+		case 'u':
+		case 't':
+			length := int(r.uint32())
+			if b := r.next(length); b != nil {
+				values[rel.columns[i].name] = string(b)
+			}
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("unsupported tuple column type %q", kind)
+			}
+		}
+	}
+	return values
+}
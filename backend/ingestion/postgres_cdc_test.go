@@ -0,0 +1,231 @@
+package ingestion
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pgMessageBuilder assembles pgoutput messages for decoder tests.
+type pgMessageBuilder struct {
+	buf []byte
+}
+
+func pgMsg(kind byte) *pgMessageBuilder { return &pgMessageBuilder{buf: []byte{kind}} }
+
+func (b *pgMessageBuilder) u8(v byte) *pgMessageBuilder { b.buf = append(b.buf, v); return b }
+func (b *pgMessageBuilder) u16(v uint16) *pgMessageBuilder {
+	b.buf = binary.BigEndian.AppendUint16(b.buf, v)
+	return b
+}
+func (b *pgMessageBuilder) u32(v uint32) *pgMessageBuilder {
+	b.buf = binary.BigEndian.AppendUint32(b.buf, v)
+	return b
+}
+func (b *pgMessageBuilder) u64(v uint64) *pgMessageBuilder {
+	b.buf = binary.BigEndian.AppendUint64(b.buf, v)
+	return b
+}
+func (b *pgMessageBuilder) str(s string) *pgMessageBuilder {
+	b.buf = append(append(b.buf, s...), 0)
+	return b
+}
+
+// tuple appends TupleData; nil values are NULL and "\x00toast" marks an unchanged TOAST value.
+func (b *pgMessageBuilder) tuple(values ...interface{}) *pgMessageBuilder {
+	b.u16(uint16(len(values)))
+	for _, v := range values {
+		switch v {
+		case nil:
+			b.u8('n')
+		case "\x00toast":
+			b.u8('u')
+		default:
+			s := v.(string)
+			b.u8('t').u32(uint32(len(s)))
+			b.buf = append(b.buf, s...)
+		}
+	}
+	return b
+}
+
+func ordersRelation() []byte {
+	b := pgMsg('R').u32(16384).str("public").str("orders").u8('d').u16(3)
+	b.u8(1).str("order_no").u32(23).u32(0xFFFFFFFF)
+	b.u8(0).str("status").u32(25).u32(0xFFFFFFFF)
+	b.u8(0).str("notes").u32(25).u32(0xFFFFFFFF)
+	return b.buf
+}
+
+func TestPgoutputDecoder(t *testing.T) {
+	d := newPgoutputDecoder()
+
+	msg, err := d.decode(ordersRelation())
+	require.NoError(t, err)
+	assert.Equal(t, byte('R'), msg.kind)
+	require.Contains(t, d.relations, uint32(16384))
+
+	msg, err = d.decode(pgMsg('B').u64(0x1_00000010).u64(0).u32(42).buf)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x1_00000010), msg.lsn)
+
+	msg, err = d.decode(pgMsg('I').u32(16384).u8('N').tuple("7", "new", nil).buf)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", msg.relation.name)
+	assert.Equal(t, map[string]interface{}{"order_no": "7", "status": "new", "notes": nil}, msg.columns)
+
+	msg, err = d.decode(pgMsg('U').u32(16384).u8('K').tuple("6", nil, nil).u8('N').tuple("7", "paid", "\x00toast").buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"order_no": "7", "status": "paid"}, msg.columns, "unchanged TOAST values are left out")
+
+	msg, err = d.decode(pgMsg('D').u32(16384).u8('K').tuple("7", nil, nil).buf)
+	require.NoError(t, err)
+	assert.Equal(t, byte('D'), msg.kind)
+	assert.Equal(t, "7", msg.columns["order_no"])
+
+	msg, err = d.decode(pgMsg('T').u32(2).u8(0).u32(16384).u32(16390).buf)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{16384, 16390}, msg.relations)
+
+	msg, err = d.decode(pgMsg('C').u8(0).u64(0x1_00000010).u64(0x1_00000040).u64(0).buf)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x1_00000040), msg.lsn, "commit carries the end LSN of the transaction")
+
+	t.Run("Unknown Relation", func(t *testing.T) {
+		_, err := d.decode(pgMsg('I').u32(99).u8('N').tuple("1").buf)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown relation 99")
+	})
+
+	t.Run("Truncated Message", func(t *testing.T) {
+		_, err := d.decode(pgMsg('I').u32(16384).u8('N').u16(1).u8('t').u32(10).buf)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected end of message")
+	})
+
+	t.Run("Unknown Message Type", func(t *testing.T) {
+		_, err := d.decode([]byte{'Z'})
+		require.Error(t, err)
+	})
+}
+
+func TestCDCRecord(t *testing.T) {
+	d := newPgoutputDecoder()
+	_, err := d.decode(ordersRelation())
+	require.NoError(t, err)
+
+	t.Run("Upsert Gets Key As Source Record ID", func(t *testing.T) {
+		msg, err := d.decode(pgMsg('I').u32(16384).u8('N').tuple("7", "new", nil).buf)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"order_no": "7", "status": "new", "notes": nil, "source_record_id": "7"}, cdcRecord(msg))
+	})
+
+	t.Run("Delete Is Tombstone With Key Only", func(t *testing.T) {
+		msg, err := d.decode(pgMsg('D').u32(16384).u8('K').tuple("7", nil, nil).buf)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"order_no": "7", "source_record_id": "7", DeletedRecordField: true}, cdcRecord(msg))
+	})
+
+	t.Run("Id Column Is Kept As Identifier", func(t *testing.T) {
+		_, err := d.decode(pgMsg('R').u32(1).str("app").str("users").u8('d').u16(1).u8(1).str("id").u32(23).u32(0).buf)
+		require.NoError(t, err)
+		msg, err := d.decode(pgMsg('I').u32(1).u8('N').tuple("12").buf)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"id": "12"}, cdcRecord(msg))
+		assert.True(t, cdcTableSelected("app.users", msg.relation))
+		assert.True(t, cdcTableSelected("users", msg.relation))
+		assert.False(t, cdcTableSelected("public.users", msg.relation))
+	})
+}
+
+func TestLSN(t *testing.T) {
+	lsn, err := parseLSN("16/B374D848")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x16_B374D848), lsn)
+	assert.Equal(t, "16/B374D848", formatLSN(lsn))
+
+	lsn, err = parseLSN("")
+	require.NoError(t, err)
+	assert.Zero(t, lsn)
+
+	_, err = parseLSN("12345")
+	assert.Error(t, err)
+}
+
+// TestIngestData_PostgresCDC replicates changes from a local PostgreSQL server started with
+// wal_level=logical. It is skipped unless CDC_TEST_DB_HOST is set.
+func TestIngestData_PostgresCDC(t *testing.T) {
+	host := os.Getenv("CDC_TEST_DB_HOST")
+	if host == "" {
+		t.Skip("CDC_TEST_DB_HOST not set")
+	}
+	env := func(key, fallback string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+	port, _ := strconv.Atoi(env("CDC_TEST_DB_PORT", "5432"))
+	params := ConnectionParams{
+		Host: host, Port: port, User: env("CDC_TEST_DB_USER", "admin"), Password: SecretValue{Plain: env("CDC_TEST_DB_PASSWORD", "password")},
+		DBName: env("CDC_TEST_DB_NAME", "metadata_test_db"), Mode: PostgresModeCDC,
+		ReplicationSlot: "ingest_cdc_test", Publication: "ingest_cdc_test", TableOrQuery: "cdc_orders",
+	}
+	details, err := json.Marshal(params)
+	require.NoError(t, err)
+	db, err := openPostgres("cdcTest", params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	exec := func(query string) {
+		t.Helper()
+		_, err := db.Exec(query)
+		require.NoError(t, err)
+	}
+	db.Exec("SELECT pg_drop_replication_slot('ingest_cdc_test')")
+	exec("DROP TABLE IF EXISTS cdc_orders")
+	exec("CREATE TABLE cdc_orders (order_no INT PRIMARY KEY, status TEXT)")
+	exec("DROP PUBLICATION IF EXISTS ingest_cdc_test")
+	exec("CREATE PUBLICATION ingest_cdc_test FOR TABLE cdc_orders")
+	defer db.Exec("SELECT pg_drop_replication_slot('ingest_cdc_test')")
+	require.NoError(t, ensureReplicationSlot(db, params.ReplicationSlot))
+
+	var watermark *IngestionWatermark
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sourceID, Type: "postgresql", ConnectionDetails: string(details), EntityID: "Order"}, nil
+		},
+		GetIngestionWatermarkFunc: func(sourceID string) (*IngestionWatermark, error) { return watermark, nil },
+		UpdateIngestionWatermarkFunc: func(w IngestionWatermark) error {
+			watermark = &w
+			return nil
+		},
+	}
+	service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+	exec("INSERT INTO cdc_orders VALUES (1, 'new'), (2, 'new')")
+	exec("UPDATE cdc_orders SET status = 'paid' WHERE order_no = 1")
+	exec("DELETE FROM cdc_orders WHERE order_no = 2")
+
+	results, err := service.IngestData("cdcTest")
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, "paid", results[2]["status"])
+	assert.Equal(t, true, results[3][DeletedRecordField])
+	require.NotNil(t, watermark)
+	assert.Equal(t, cdcWatermarkColumn, watermark.WatermarkColumn)
+
+	results, err = service.IngestData("cdcTest")
+	require.NoError(t, err)
+	assert.Empty(t, results, "processed changes must not be replicated again")
+
+	var confirmed sql.NullString
+	require.NoError(t, db.QueryRow("SELECT confirmed_flush_lsn::text FROM pg_replication_slots WHERE slot_name = 'ingest_cdc_test'").Scan(&confirmed))
+	assert.Equal(t, watermark.LastValue, confirmed.String)
+}
@@ -0,0 +1,78 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretValue is a connection parameter given either as a plain string or as a secret reference
+// such as {"secret_ref": "env:PG_PASS"} or {"secret_ref": "file:/run/secrets/pg_pass"}, which is
+// resolved when connecting. It mirrors secrets.Value of the root module.
+type SecretValue struct {
+	Plain string
+	Ref   string
+}
+
+// UnmarshalJSON accepts a string, a {"secret_ref": "..."} object or null.
+func (v *SecretValue) UnmarshalJSON(data []byte) error {
+	*v = SecretValue{}
+	if string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &v.Plain); err == nil {
+		return nil
+	}
+	var ref map[string]string
+	if err := json.Unmarshal(data, &ref); err != nil || ref["secret_ref"] == "" {
+		return fmt.Errorf("secret value must be a string or an object with a \"secret_ref\" key")
+	}
+	v.Ref = ref["secret_ref"]
+	return nil
+}
+
+// MarshalJSON writes the reference object, or the plain string.
+func (v SecretValue) MarshalJSON() ([]byte, error) {
+	if v.Ref != "" {
+		return json.Marshal(map[string]string{"secret_ref": v.Ref})
+	}
+	return json.Marshal(v.Plain)
+}
+
+// Resolve returns the plain value or the secret its reference points to.
+func (v SecretValue) Resolve() (string, error) {
+	if v.Ref != "" {
+		return resolveSecretRef(v.Ref)
+	}
+	return v.Plain, nil
+}
+
+// resolveSecretRef returns the secret named by ref: "env:NAME" reads an environment variable and
+// "file:/path" a file without its trailing newline. An unset or empty secret is an error.
+func resolveSecretRef(ref string) (string, error) {
+	kind, name, _ := strings.Cut(ref, ":")
+	if name == "" {
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+	switch kind {
+	case "env":
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", ref, name)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		value := strings.TrimRight(string(content), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("secret %s: file is empty", ref)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+}
@@ -0,0 +1,65 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretValue(t *testing.T) {
+	t.Setenv("TEST_PG_PASS", "from-env")
+	secretFile := filepath.Join(t.TempDir(), "pg_pass")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	tests := []struct {
+		name     string
+		details  string
+		expected string
+		wantErr  bool
+	}{
+		{"Plain String", `{"password": "plain"}`, "plain", false},
+		{"Env Reference", `{"password": {"secret_ref": "env:TEST_PG_PASS"}}`, "from-env", false},
+		{"File Reference", `{"password": {"secret_ref": "file:` + secretFile + `"}}`, "from-file", false},
+		{"Unset Env Reference", `{"password": {"secret_ref": "env:TEST_PG_PASS_UNSET"}}`, "", true},
+		{"Unsupported Reference", `{"password": {"secret_ref": "vault:pg"}}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params ConnectionParams
+			require.NoError(t, json.Unmarshal([]byte(tt.details), &params))
+			password, err := params.Password.Resolve()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, password)
+		})
+	}
+
+	t.Run("Invalid Object", func(t *testing.T) {
+		var params ConnectionParams
+		assert.Error(t, json.Unmarshal([]byte(`{"password": {"env": "X"}}`), &params))
+	})
+
+	t.Run("HTTP Auth Header From Reference", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer from-file", r.Header.Get("Authorization"))
+			itemsPage(w, 1, 1, nil)
+		}))
+		defer server.Close()
+
+		var params HTTPAPIConnectionParams
+		require.NoError(t, json.Unmarshal([]byte(`{"url": "`+server.URL+`", "records_path": "$.items",
+			"auth": {"header": "Authorization", "prefix": "Bearer ", "value": {"secret_ref": "file:`+secretFile+`"}}}`), &params))
+		records, err := readAllHTTPAPI(t, params)
+		require.NoError(t, err)
+		assert.Len(t, records, 1)
+	})
+}
@@ -2,17 +2,17 @@ package ingestion
 
 import (
 	"bytes" // Used by HTTPProcessingServiceClient
+	"context"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
+	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
 	// Consider using pgx for more advanced features if needed: _ "github.com/jackc/pgx/v4/stdlib"
 )
 
@@ -20,6 +20,15 @@ import (
 // This allows for easier testing and decoupling.
 type MetadataServiceAPIClient interface {
 	GetDataSourceConfig(sourceID string) (*DataSourceConfig, error)
+	// GetIngestionWatermark returns nil without an error if the source has no watermark yet.
+	GetIngestionWatermark(sourceID string) (*IngestionWatermark, error)
+	UpdateIngestionWatermark(watermark IngestionWatermark) error
+	// CreateIngestionRun persists a new run and returns it with the ID assigned by the metadata service.
+	CreateIngestionRun(run IngestionRun) (*IngestionRun, error)
+	UpdateIngestionRun(run IngestionRun) error
+	// FindIngestedFile returns nil without an error if the file is not in the processed-file ledger.
+	FindIngestedFile(sourceID, fileName, checksum string) (*IngestedFile, error)
+	RecordIngestedFile(file IngestedFile) error
 }
 
 // HTTPMetadataClient is an implementation of MetadataServiceAPIClient using HTTP.
@@ -41,18 +50,55 @@ type DataSourceConfig struct {
 
 // ConnectionParams defines the structure for PostgreSQL connection details.
 type ConnectionParams struct {
-	Host          string `json:"host"`
-	Port          int    `json:"port"`
-	User          string `json:"user"`
-	Password      string `json:"password"`
-	DBName        string `json:"dbname"`
-	TableOrQuery  string `json:"table_or_query"`
-	SSLMode       string `json:"sslmode,omitempty"` // e.g., "disable", "require", "verify-full"
+	Host         string      `json:"host"`
+	Port         int         `json:"port"`
+	User         string      `json:"user"`
+	Password     SecretValue `json:"password"` // A plain string or a secret reference
+	DBName       string      `json:"dbname"`
+	TableOrQuery string      `json:"table_or_query"`
+	SSLMode      string      `json:"sslmode,omitempty"` // e.g., "disable", "require", "verify-full"
+	// WatermarkColumn enables incremental ingestion: only rows whose value in this column is greater
+	// than the watermark persisted by the previous successful run are fetched.
+	WatermarkColumn string `json:"watermark_column,omitempty"`
+	// Mode is "query" (default) or "cdc". In cdc mode the changes published by Publication are read
+	// from the logical replication slot ReplicationSlot (created if missing) instead of running a
+	// query, and TableOrQuery optionally names the only table to ingest. The server needs
+	// wal_level=logical and the user the REPLICATION attribute.
+	Mode            string `json:"mode,omitempty"`
+	ReplicationSlot string `json:"replication_slot,omitempty"`
+	Publication     string `json:"publication,omitempty"`
+	// MaxChanges limits the changes read per cdc run. The transaction containing the last change is
+	// always read completely. 0 reads all pending changes.
+	MaxChanges int `json:"max_changes,omitempty"`
 }
 
-// CSVConnectionParams defines the structure for CSV connection details.
-type CSVConnectionParams struct {
-	Filepath string `json:"filepath"`
+// IngestionWatermark mirrors the watermark persisted per data source by the metadata service.
+type IngestionWatermark struct {
+	SourceID        string `json:"source_id"`
+	WatermarkColumn string `json:"watermark_column"`
+	LastValue       string `json:"last_value"`
+}
+
+// IngestionRunStatus mirrors the run statuses of the metadata service.
+type IngestionRunStatus string
+
+const (
+	IngestionRunRunning   IngestionRunStatus = "running"
+	IngestionRunSucceeded IngestionRunStatus = "succeeded"
+	IngestionRunFailed    IngestionRunStatus = "failed"
+)
+
+// IngestionRun mirrors the ingestion run (job) record persisted by the metadata service.
+type IngestionRun struct {
+	ID           string             `json:"id"`
+	SourceID     string             `json:"source_id"`
+	Status       IngestionRunStatus `json:"status"`
+	StartedAt    time.Time          `json:"started_at"`
+	FinishedAt   *time.Time         `json:"finished_at,omitempty"`
+	RowsRead     int64              `json:"rows_read"`
+	RowsSent     int64              `json:"rows_sent"`
+	RowsRejected int64              `json:"rows_rejected"`
+	Error        string             `json:"error,omitempty"`
 }
 
 // NewHTTPMetadataClient creates a new client for the metadata service.
@@ -65,7 +111,8 @@ func NewHTTPMetadataClient(baseURL string) *HTTPMetadataClient {
 
 // GetDataSourceConfig fetches a DataSourceConfig from the metadata service.
 func (c *HTTPMetadataClient) GetDataSourceConfig(sourceID string) (*DataSourceConfig, error) {
-	url := fmt.Sprintf("%s/api/v1/datasources/%s", c.BaseURL, sourceID)
+	// The internal route returns the connection details without redacting their secrets.
+	url := fmt.Sprintf("%s/internal/v1/datasources/%s", c.BaseURL, sourceID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request to metadata service: %w", err)
@@ -89,9 +136,154 @@ func (c *HTTPMetadataClient) GetDataSourceConfig(sourceID string) (*DataSourceCo
 	return &config, nil
 }
 
-// ProcessingServiceAPIClient defines the interface for calling the processing service.
+// GetIngestionWatermark fetches the incremental ingestion watermark of a data source.
+func (c *HTTPMetadataClient) GetIngestionWatermark(sourceID string) (*IngestionWatermark, error) {
+	url := fmt.Sprintf("%s/api/v1/datasources/%s/watermark", c.BaseURL, sourceID)
+	resp, err := c.HttpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call metadata service at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // No successful incremental run yet
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned non-OK status: %d for watermark of source ID %s", resp.StatusCode, sourceID)
+	}
+
+	var watermark IngestionWatermark
+	if err := json.NewDecoder(resp.Body).Decode(&watermark); err != nil {
+		return nil, fmt.Errorf("failed to decode watermark response from metadata service: %w", err)
+	}
+	return &watermark, nil
+}
+
+// UpdateIngestionWatermark persists a new incremental ingestion watermark for a data source.
+func (c *HTTPMetadataClient) UpdateIngestionWatermark(watermark IngestionWatermark) error {
+	url := fmt.Sprintf("%s/api/v1/datasources/%s/watermark", c.BaseURL, watermark.SourceID)
+	body, err := json.Marshal(watermark)
+	if err != nil {
+		return fmt.Errorf("failed to marshal watermark payload: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to metadata service: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call metadata service at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata service returned non-OK status: %d when updating watermark of source ID %s", resp.StatusCode, watermark.SourceID)
+	}
+	return nil
+}
+
+// CreateIngestionRun records the start of an ingestion run in the metadata service.
+func (c *HTTPMetadataClient) CreateIngestionRun(run IngestionRun) (*IngestionRun, error) {
+	url := fmt.Sprintf("%s/api/v1/datasources/%s/runs/", c.BaseURL, run.SourceID)
+	body, err := json.Marshal(run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ingestion run payload: %w", err)
+	}
+
+	resp, err := c.HttpClient.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call metadata service at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("metadata service returned non-Created status: %d when creating ingestion run for source ID %s", resp.StatusCode, run.SourceID)
+	}
+
+	var created IngestionRun
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("failed to decode ingestion run response from metadata service: %w", err)
+	}
+	return &created, nil
+}
+
+// UpdateIngestionRun stores the current progress and status of an ingestion run.
+func (c *HTTPMetadataClient) UpdateIngestionRun(run IngestionRun) error {
+	url := fmt.Sprintf("%s/api/v1/datasources/%s/runs/%s", c.BaseURL, run.SourceID, run.ID)
+	body, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ingestion run payload: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request to metadata service: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call metadata service at %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata service returned non-OK status: %d when updating ingestion run %s of source ID %s", resp.StatusCode, run.ID, run.SourceID)
+	}
+	return nil
+}
+
+// FindIngestedFile looks up a file by name and checksum in the processed-file ledger of a data source.
+func (c *HTTPMetadataClient) FindIngestedFile(sourceID, fileName, checksum string) (*IngestedFile, error) {
+	query := url.Values{"file_name": {fileName}, "checksum": {checksum}, "limit": {"1"}}
+	requestURL := fmt.Sprintf("%s/api/v1/datasources/%s/files/?%s", c.BaseURL, sourceID, query.Encode())
+	resp, err := c.HttpClient.Get(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call metadata service at %s: %w", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned non-OK status: %d for ingested files of source ID %s", resp.StatusCode, sourceID)
+	}
+
+	var list struct {
+		Data []IngestedFile `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode ingested files response from metadata service: %w", err)
+	}
+	if len(list.Data) == 0 {
+		return nil, nil
+	}
+	return &list.Data[0], nil
+}
+
+// RecordIngestedFile adds a file to the processed-file ledger of a data source.
+func (c *HTTPMetadataClient) RecordIngestedFile(file IngestedFile) error {
+	requestURL := fmt.Sprintf("%s/api/v1/datasources/%s/files/", c.BaseURL, file.SourceID)
+	body, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ingested file payload: %w", err)
+	}
+
+	resp, err := c.HttpClient.Post(requestURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to call metadata service at %s: %w", requestURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("metadata service returned non-Created status: %d when recording file %s of source ID %s", resp.StatusCode, file.FileName, file.SourceID)
+	}
+	return nil
+}
+
+// ProcessingServiceAPIClient defines the interface for calling the processing service. The HTTP
+// implementation processes the records synchronously; JetStreamProcessingServiceClient queues them.
 type ProcessingServiceAPIClient interface {
-	CallProcessData(payload ProcessDataRequest) error
+	CallProcessData(payload ProcessDataRequest) (*ProcessDataResponse, error)
 }
 
 // HTTPProcessingServiceClient is an implementation of ProcessingServiceAPIClient.
@@ -112,29 +304,44 @@ func NewHTTPProcessingServiceClient(baseURL string) *HTTPProcessingServiceClient
 type ProcessDataRequest struct {
 	SourceID       string                   `json:"source_id"`
 	EntityTypeName string                   `json:"entity_type_name"`
+	RunID          string                   `json:"run_id,omitempty"` // Recorded by the processing service with quarantined records
 	RawData        []map[string]interface{} `json:"raw_data"`
 }
 
+// ProcessDataResponse is the success response of the processing service.
+// Records that were received but not processed were rejected (e.g. failed conversion).
+// Queued records are processed asynchronously, so how many are rejected is not known yet.
+type ProcessDataResponse struct {
+	RecordsReceived  int  `json:"records_received"`
+	RecordsProcessed int  `json:"records_processed"`
+	Queued           bool `json:"queued,omitempty"`
+}
+
 // CallProcessData makes a POST request to the processing service.
-func (c *HTTPProcessingServiceClient) CallProcessData(payload ProcessDataRequest) error {
+func (c *HTTPProcessingServiceClient) CallProcessData(payload ProcessDataRequest) (*ProcessDataResponse, error) {
 	url := fmt.Sprintf("%s/api/v1/process", c.BaseURL)
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal process data payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal process data payload: %w", err)
 	}
 
 	resp, err := c.HttpClient.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		return fmt.Errorf("failed to call processing service at %s: %w", url, err)
+		return nil, fmt.Errorf("failed to call processing service at %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		// TODO: Read body for more detailed error message from processing service
-		return fmt.Errorf("processing service returned non-OK status: %d for source ID %s", resp.StatusCode, payload.SourceID)
+		return nil, fmt.Errorf("processing service returned non-OK status: %d for source ID %s", resp.StatusCode, payload.SourceID)
+	}
+
+	var result ProcessDataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response from processing service: %w", err)
 	}
 	log.Printf("Successfully called processing service for SourceID: %s. Status: %d", payload.SourceID, resp.StatusCode)
-	return nil
+	return &result, nil
 }
 
 // IngestionService handles the data ingestion logic.
@@ -151,6 +358,34 @@ func NewIngestionService(metaClient MetadataServiceAPIClient, procClient Process
 	}
 }
 
+// DefaultBatchSize is the number of records sent per ProcessDataRequest in streaming mode
+// when the caller does not specify a batch size.
+const DefaultBatchSize = 1000
+
+// RecordHandler is invoked once for every record read from a data source.
+// Returning an error stops the read.
+type RecordHandler func(record map[string]interface{}) error
+
+// BatchProgress records the outcome of sending a single batch to the processing service.
+type BatchProgress struct {
+	BatchNumber int    `json:"batch_number"`
+	FirstRow    int    `json:"first_row"` // 1-based index of the first record in the batch
+	RowCount    int    `json:"row_count"`
+	Sent        bool   `json:"sent"`
+	Error       string `json:"error,omitempty"`
+}
+
+// IngestionProgress summarizes a streaming ingestion run.
+// Batches that were sent before a failure stay recorded as sent.
+type IngestionProgress struct {
+	SourceID     string          `json:"source_id"`
+	BatchSize    int             `json:"batch_size"`
+	RowsRead     int             `json:"rows_read"`
+	RowsSent     int             `json:"rows_sent"`
+	RowsRejected int             `json:"rows_rejected"` // Sent records the processing service did not store
+	Batches      []BatchProgress `json:"batches"`
+}
+
 // IngestData fetches data from a configured data source and sends it for processing.
 func (s *IngestionService) IngestData(sourceID string) ([]map[string]interface{}, error) {
 	log.Printf("Starting ingestion for source ID: %s", sourceID)
@@ -161,171 +396,498 @@ func (s *IngestionService) IngestData(sourceID string) ([]map[string]interface{}
 		return nil, fmt.Errorf("failed to get data source config for ID %s: %w", sourceID, err)
 	}
 
-	var results []map[string]interface{}
+	results := make([]map[string]interface{}, 0)
+	checkpoint, err := s.readSource(dsConfig, 0, func(record map[string]interface{}) error {
+		results = append(results, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	if strings.ToLower(dsConfig.Type) == "csv" {
-		log.Printf("Starting CSV ingestion for source ID: %s. ConnectionDetails: %s", sourceID, dsConfig.ConnectionDetails)
-		var params CSVConnectionParams
-		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
-			return nil, fmt.Errorf("failed to parse CSV connection details for source ID %s: %w", sourceID, err)
+	// After successful ingestion, call the processing service
+	if len(results) > 0 {
+		entityTypeName := dsConfig.EntityID // Using EntityID as EntityTypeName as per previous logic.
+		// If EntityID is an actual ID, we would need another call to metadata service to get EntityDefinition.Name
+		// This logic is consistent with how it was handled before for PostgreSQL.
+
+		if dsConfig.EntityID == "" {
+			log.Printf("Warning: DataSourceConfig.EntityID is empty for source %s. Processing might not determine entity type correctly.", sourceID)
 		}
 
-		if params.Filepath == "" {
-			return nil, fmt.Errorf("filepath is required for CSV data source type, source ID %s", sourceID)
+		processPayload := ProcessDataRequest{
+			SourceID:       sourceID,
+			EntityTypeName: entityTypeName,
+			RawData:        results,
 		}
 
-		file, err := os.Open(params.Filepath)
+		log.Printf("Sending %d ingested records for SourceID %s (EntityID from DSConfig: '%s') to processing service.", len(results), sourceID, dsConfig.EntityID)
+		_, err = s.processingClient.CallProcessData(processPayload)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+			log.Printf("Error calling processing service for source ID %s: %v", sourceID, err)
+			// Optionally, return this error depending on desired criticality
+			// return nil, fmt.Errorf("failed to send data to processing service: %w", err)
+		} else {
+			log.Printf("Successfully sent data for source ID %s to processing service.", sourceID)
+			if err := s.commitRead(checkpoint); err != nil {
+				log.Printf("Error committing ingestion progress for source ID %s: %v", sourceID, err)
+			}
+		}
+	} else {
+		log.Printf("No records ingested for source ID %s. Skipping call to processing service.", sourceID)
+		if err := s.commitRead(checkpoint); err != nil {
+			log.Printf("Error committing ingestion progress for source ID %s: %v", sourceID, err)
 		}
-		defer file.Close()
+	}
+
+	return results, nil
+}
+
+// IngestDataStreaming reads a data source incrementally and sends it to the processing service
+// in batches of batchSize records, so that large sources never have to fit in memory.
+// PostgreSQL sources are read through a server-side cursor fetching batchSize rows at a time.
+// If a batch fails, ingestion stops and the returned progress still lists every batch that was
+// already accepted by the processing service, together with the failed one.
+func (s *IngestionService) IngestDataStreaming(sourceID string, batchSize int) (*IngestionProgress, error) {
+	return s.ingestStreaming(sourceID, "", batchSize, s.readSource, nil)
+}
+
+// sourceReader reads the records of a data source; readSource is the default implementation.
+type sourceReader func(dsConfig *DataSourceConfig, fetchSize int, handle RecordHandler) (*readCheckpoint, error)
+
+// ingestStreaming implements IngestDataStreaming with records produced by read. runID, if not
+// empty, is the ingestion run the batches are sent for. onBatch, if not nil, is called after every
+// batch that the processing service accepted.
+func (s *IngestionService) ingestStreaming(sourceID string, runID string, batchSize int, read sourceReader, onBatch func(progress IngestionProgress)) (*IngestionProgress, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	log.Printf("Starting streaming ingestion for source ID: %s with batch size %d", sourceID, batchSize)
+
+	dsConfig, err := s.metadataClient.GetDataSourceConfig(sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data source config for ID %s: %w", sourceID, err)
+	}
+	if dsConfig.EntityID == "" {
+		log.Printf("Warning: DataSourceConfig.EntityID is empty for source %s. Processing might not determine entity type correctly.", sourceID)
+	}
+
+	progress := &IngestionProgress{SourceID: sourceID, BatchSize: batchSize, Batches: []BatchProgress{}}
+	batch := make([]map[string]interface{}, 0, batchSize)
 
-		reader := csv.NewReader(file)
-		headers, err := reader.Read()
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		bp := BatchProgress{
+			BatchNumber: len(progress.Batches) + 1,
+			FirstRow:    progress.RowsSent + 1,
+			RowCount:    len(batch),
+		}
+		result, err := s.processingClient.CallProcessData(ProcessDataRequest{
+			SourceID:       sourceID,
+			EntityTypeName: dsConfig.EntityID, // Same EntityID-as-EntityTypeName convention as IngestData
+			RunID:          runID,
+			RawData:        batch,
+		})
 		if err != nil {
-			if err == io.EOF {
-				log.Printf("CSV file %s for source ID %s is empty or only contains headers.", params.Filepath, sourceID)
-				return results, nil // Empty results if only headers or empty
-			}
-			return nil, fmt.Errorf("failed to read header row from CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
+			bp.Error = err.Error()
+			progress.Batches = append(progress.Batches, bp)
+			return fmt.Errorf("failed to send batch %d (rows %d-%d) for source ID %s: %w", bp.BatchNumber, bp.FirstRow, bp.FirstRow+bp.RowCount-1, sourceID, err)
+		}
+		bp.Sent = true
+		progress.Batches = append(progress.Batches, bp)
+		progress.RowsSent += len(batch)
+		if result != nil && !result.Queued && result.RecordsProcessed < result.RecordsReceived {
+			progress.RowsRejected += result.RecordsReceived - result.RecordsProcessed
 		}
+		log.Printf("Sent batch %d (%d records) for source ID %s. Total sent: %d", bp.BatchNumber, bp.RowCount, sourceID, progress.RowsSent)
+		if onBatch != nil {
+			onBatch(*progress)
+		}
+		// Allocate a fresh slice: the previous one may still be referenced by the processing client.
+		batch = make([]map[string]interface{}, 0, batchSize)
+		return nil
+	}
 
-		results = make([]map[string]interface{}, 0)
-		for {
-			row, err := reader.Read()
-			if err != nil {
-				if err == io.EOF {
-					break // End of file
-				}
-				return nil, fmt.Errorf("failed to read row from CSV file %s for source ID %s: %w", params.Filepath, sourceID, err)
-			}
+	checkpoint, err := read(dsConfig, batchSize, func(record map[string]interface{}) error {
+		progress.RowsRead++
+		batch = append(batch, record)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		// Only commit once every batch has been accepted; rows sharing the watermark value could
+		// otherwise be split across a failed batch boundary and skipped by the next run.
+		err = s.commitRead(checkpoint)
+	}
+	if err != nil {
+		log.Printf("Streaming ingestion for source ID %s stopped after %d sent records: %v", sourceID, progress.RowsSent, err)
+		return progress, err
+	}
 
-			rowData := make(map[string]interface{})
-			for i, header := range headers {
-				if i < len(row) {
-					rowData[header] = row[i]
-				} else {
-					rowData[header] = "" // Handle short rows if necessary
-				}
-			}
-			results = append(results, rowData)
+	log.Printf("Streaming ingestion for source ID %s completed: %d records read, %d sent in %d batches.", sourceID, progress.RowsRead, progress.RowsSent, len(progress.Batches))
+	return progress, nil
+}
+
+// StartIngestionJob records a new ingestion run for the data source and performs a streaming
+// ingestion in the background. It returns as soon as the run is recorded; the run's ID is the job
+// ID that can be used to follow its progress through the metadata service.
+func (s *IngestionService) StartIngestionJob(sourceID string, batchSize int) (*IngestionRun, error) {
+	run, err := s.metadataClient.CreateIngestionRun(IngestionRun{
+		SourceID:  sourceID,
+		Status:    IngestionRunRunning,
+		StartedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingestion run for source ID %s: %w", sourceID, err)
+	}
+
+	started := *run
+	go s.runIngestionJob(started, batchSize)
+	return run, nil
+}
+
+// runIngestionJob executes an ingestion run and keeps its record in the metadata service up to date.
+// Failures to update the run record are logged but never interrupt the ingestion itself.
+func (s *IngestionService) runIngestionJob(run IngestionRun, batchSize int) {
+	log.Printf("Ingestion run %s started for source ID %s", run.ID, run.SourceID)
+	record := func(progress IngestionProgress) {
+		run.RowsRead = int64(progress.RowsRead)
+		run.RowsSent = int64(progress.RowsSent)
+		run.RowsRejected = int64(progress.RowsRejected)
+	}
+
+	progress, err := s.ingestStreaming(run.SourceID, run.ID, batchSize, s.readSource, func(progress IngestionProgress) {
+		record(progress)
+		if err := s.metadataClient.UpdateIngestionRun(run); err != nil {
+			log.Printf("Error updating progress of ingestion run %s: %v", run.ID, err)
 		}
-		log.Printf("Successfully ingested %d records for source ID: %s from CSV file %s.", len(results), sourceID, params.Filepath)
+	})
+	if progress != nil {
+		record(*progress)
+	}
 
-	} else if strings.ToLower(dsConfig.Type) == "postgresql" {
-		// 2. Parse ConnectionDetails for PostgreSQL
+	finishedAt := time.Now().UTC()
+	run.FinishedAt = &finishedAt
+	if err != nil {
+		run.Status = IngestionRunFailed
+		run.Error = err.Error()
+	} else {
+		run.Status = IngestionRunSucceeded
+	}
+	if err := s.metadataClient.UpdateIngestionRun(run); err != nil {
+		log.Printf("Error recording final status %s of ingestion run %s: %v", run.Status, run.ID, err)
+	}
+	log.Printf("Ingestion run %s for source ID %s finished with status %s", run.ID, run.SourceID, run.Status)
+}
+
+// readCheckpoint holds the progress of a read that may only be persisted once its records were
+// accepted by the processing service.
+type readCheckpoint struct {
+	watermark *IngestionWatermark
+	files     []pendingFile
+	slot      *replicationSlot
+}
+
+// readSource dispatches to the reader for the data source type and calls handle for every record.
+// fetchSize controls how many rows are fetched per round trip from database sources; 0 reads
+// the whole result set with a single query.
+// For incremental sources it returns the watermark reached by this read, for directory sources
+// the files that were read and for CDC sources the replication position; the caller must persist them with commitRead once the records have
+// been processed successfully. The returned checkpoint may be nil.
+func (s *IngestionService) readSource(dsConfig *DataSourceConfig, fetchSize int, handle RecordHandler) (*readCheckpoint, error) {
+	switch strings.ToLower(dsConfig.Type) {
+	case "csv":
+		log.Printf("Starting CSV ingestion for source ID: %s. ConnectionDetails: %s", dsConfig.ID, dsConfig.ConnectionDetails)
+		var params CSVConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return nil, fmt.Errorf("failed to parse CSV connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		return nil, readCSVRecords(dsConfig.ID, params, handle)
+	case "postgresql":
 		var params ConnectionParams
 		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
-			return nil, fmt.Errorf("failed to parse PostgreSQL connection details for source ID %s: %w", sourceID, err)
+			return nil, fmt.Errorf("failed to parse PostgreSQL connection details for source ID %s: %w", dsConfig.ID, err)
 		}
-
-		if params.Host == "" || params.Port == 0 || params.User == "" || params.DBName == "" || params.TableOrQuery == "" {
-			return nil, fmt.Errorf("missing required PostgreSQL connection parameters (host, port, user, dbname, table_or_query) for source ID %s", sourceID)
+		if strings.EqualFold(params.Mode, PostgresModeCDC) {
+			return s.readPostgresCDC(dsConfig.ID, params, handle)
 		}
-		if params.SSLMode == "" {
-			params.SSLMode = "disable" // Default SSL mode
+		since, err := s.lastWatermark(dsConfig.ID, params.WatermarkColumn)
+		if err != nil {
+			return nil, err
 		}
-
-		// 3. Construct PostgreSQL connection string
-		connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-			params.Host, params.Port, params.User, params.Password, params.DBName, params.SSLMode)
-
-		// 4. Connect to PostgreSQL database
-		log.Printf("Connecting to PostgreSQL database: %s:%d/%s with user %s", params.Host, params.Port, params.DBName, params.User)
-		db, err := sql.Open("postgres", connStr)
+		last, err := readPostgresRecords(dsConfig.ID, params, since, fetchSize, handle)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to PostgreSQL for source ID %s: %w", sourceID, err)
+			return nil, err
 		}
-		defer db.Close()
-
-		err = db.Ping()
+		if params.WatermarkColumn == "" || last == "" {
+			return nil, nil
+		}
+		return &readCheckpoint{watermark: &IngestionWatermark{SourceID: dsConfig.ID, WatermarkColumn: params.WatermarkColumn, LastValue: last}}, nil
+	case "json", "ndjson":
+		var params JSONConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return nil, fmt.Errorf("failed to parse %s connection details for source ID %s: %w", strings.ToUpper(dsConfig.Type), dsConfig.ID, err)
+		}
+		return nil, readJSONRecords(dsConfig.ID, strings.ToLower(dsConfig.Type), params, handle)
+	case "http_api":
+		var params HTTPAPIConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return nil, fmt.Errorf("failed to parse HTTP API connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		return nil, readHTTPAPIRecords(dsConfig.ID, params, handle)
+	case "directory":
+		var params DirectoryConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return nil, fmt.Errorf("failed to parse directory connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		files, err := s.readDirectory(dsConfig.ID, params, handle)
 		if err != nil {
-			return nil, fmt.Errorf("failed to ping PostgreSQL for source ID %s: %w", sourceID, err)
+			return nil, err
 		}
-		log.Printf("Successfully connected to PostgreSQL for source ID: %s", sourceID)
+		return &readCheckpoint{files: files}, nil
+	default:
+		return nil, fmt.Errorf("unsupported data source type: %s. Only PostgreSQL, CSV, JSON, NDJSON, HTTP API and directory are currently supported", dsConfig.Type)
+	}
+}
+
+// lastWatermark returns the watermark value persisted by the previous successful run for column,
+// or "" when the source is not incremental or should be read from the beginning.
+func (s *IngestionService) lastWatermark(sourceID, column string) (string, error) {
+	if column == "" {
+		return "", nil
+	}
+	watermark, err := s.metadataClient.GetIngestionWatermark(sourceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get ingestion watermark for source ID %s: %w", sourceID, err)
+	}
+	if watermark == nil {
+		log.Printf("No ingestion watermark recorded for source ID %s. Performing initial full read.", sourceID)
+		return "", nil
+	}
+	if watermark.WatermarkColumn != column {
+		log.Printf("Ingestion watermark for source ID %s was recorded for column '%s' but '%s' is configured. Performing full read.", sourceID, watermark.WatermarkColumn, column)
+		return "", nil
+	}
+	log.Printf("Incremental ingestion for source ID %s: fetching rows with %s > %s", sourceID, column, watermark.LastValue)
+	return watermark.LastValue, nil
+}
+
+// advanceWatermark persists the watermark reached by a run. A nil watermark is a no-op.
+func (s *IngestionService) advanceWatermark(watermark *IngestionWatermark) error {
+	if watermark == nil {
+		return nil
+	}
+	if err := s.metadataClient.UpdateIngestionWatermark(*watermark); err != nil {
+		return fmt.Errorf("failed to advance ingestion watermark for source ID %s: %w", watermark.SourceID, err)
+	}
+	log.Printf("Advanced ingestion watermark for source ID %s to %s = %s", watermark.SourceID, watermark.WatermarkColumn, watermark.LastValue)
+	return nil
+}
+
+// commitRead persists the progress of a successful read: the watermark is advanced, the files
+// read are added to the processed-file ledger and archived, and the replication slot is advanced.
+func (s *IngestionService) commitRead(checkpoint *readCheckpoint) error {
+	if checkpoint == nil {
+		return nil
+	}
+	if err := s.advanceWatermark(checkpoint.watermark); err != nil {
+		return err
+	}
+	if checkpoint.slot != nil {
+		advanceReplicationSlot(checkpoint.slot)
+	}
+	return s.commitFiles(checkpoint.files)
+}
+
+// readPostgresCDC reads the changes of a CDC source past its persisted replication position.
+func (s *IngestionService) readPostgresCDC(sourceID string, params ConnectionParams, handle RecordHandler) (*readCheckpoint, error) {
+	since, err := s.lastWatermark(sourceID, cdcWatermarkColumn)
+	if err != nil {
+		return nil, err
+	}
+	last, err := readPostgresChanges(sourceID, params, since, handle)
+	if err != nil || last == "" {
+		return nil, err
+	}
+	return &readCheckpoint{
+		watermark: &IngestionWatermark{SourceID: sourceID, WatermarkColumn: cdcWatermarkColumn, LastValue: last},
+		slot:      &replicationSlot{sourceID: sourceID, params: params, lsn: last},
+	}, nil
+}
 
-		// 5. Determine query (simple table name or full query)
-		query := params.TableOrQuery
-		if !strings.Contains(query, " ") && !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
-			query = fmt.Sprintf("SELECT * FROM %q", params.TableOrQuery)
+// readPostgresRecords runs the configured table or query and calls handle for every row.
+// When fetchSize is positive, rows are read through a server-side cursor in chunks of fetchSize.
+// If params.WatermarkColumn is set, rows are ordered by it and restricted to values greater than
+// since (when non-empty); the highest watermark value read is returned.
+func readPostgresRecords(sourceID string, params ConnectionParams, since string, fetchSize int, handle RecordHandler) (string, error) {
+	if params.TableOrQuery == "" {
+		return "", fmt.Errorf("missing required PostgreSQL connection parameter table_or_query for source ID %s", sourceID)
+	}
+	db, err := openPostgres(sourceID, params)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	query, args := buildIncrementalQuery(buildSourceQuery(params.TableOrQuery), params.WatermarkColumn, since)
+	log.Printf("Executing query for source ID %s: %s", sourceID, query)
+
+	count := 0
+	last := ""
+	countingHandle := func(record map[string]interface{}) error {
+		count++
+		if params.WatermarkColumn != "" {
+			if v := record[params.WatermarkColumn]; v != nil {
+				last = watermarkString(v) // Rows arrive ordered by the watermark column
+			}
 		}
-		log.Printf("Executing query for source ID %s: %s", sourceID, query)
+		return handle(record)
+	}
 
-		// 6. Execute the query
-		rows, err := db.Query(query)
+	if fetchSize <= 0 {
+		rows, err := db.Query(query, args...)
 		if err != nil {
-			return nil, fmt.Errorf("failed to execute query for source ID %s: %w", sourceID, err)
+			return "", fmt.Errorf("failed to execute query for source ID %s: %w", sourceID, err)
 		}
 		defer rows.Close()
+		if _, err := scanRows(sourceID, rows, countingHandle); err != nil {
+			return "", err
+		}
+		log.Printf("Successfully ingested %d records for source ID: %s from database.", count, sourceID)
+		return last, nil
+	}
+
+	// Cursors only live inside a transaction. It is read-only and rolled back once done.
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to begin read transaction for source ID %s: %w", sourceID, err)
+	}
+	defer tx.Rollback()
 
-		// 7. Iterate over rows and scan into map[string]interface{}
-		columns, err := rows.Columns()
+	if _, err := tx.Exec("DECLARE ingest_cursor NO SCROLL CURSOR FOR "+query, args...); err != nil {
+		return "", fmt.Errorf("failed to declare cursor for source ID %s: %w", sourceID, err)
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM ingest_cursor", fetchSize)
+	for {
+		rows, err := tx.Query(fetch)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch from cursor for source ID %s: %w", sourceID, err)
+		}
+		n, err := scanRows(sourceID, rows, countingHandle)
+		rows.Close()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get columns for query result for source ID %s: %w", sourceID, err)
+			return "", err
 		}
+		if n < fetchSize {
+			break
+		}
+	}
+	log.Printf("Successfully ingested %d records for source ID: %s from database cursor.", count, sourceID)
+	return last, nil
+}
 
-		results = make([]map[string]interface{}, 0) // Initialize here for clarity
-		for rows.Next() {
-			values := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range columns {
-				valuePtrs[i] = &values[i]
-			}
+// openPostgres connects to the database of a PostgreSQL data source.
+func openPostgres(sourceID string, params ConnectionParams) (*sql.DB, error) {
+	if params.Host == "" || params.Port == 0 || params.User == "" || params.DBName == "" {
+		return nil, fmt.Errorf("missing required PostgreSQL connection parameters (host, port, user, dbname) for source ID %s", sourceID)
+	}
+	if params.SSLMode == "" {
+		params.SSLMode = "disable" // Default SSL mode
+	}
+	password, err := params.Password.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve PostgreSQL password for source ID %s: %w", sourceID, err)
+	}
 
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return nil, fmt.Errorf("failed to scan row for source ID %s: %w", sourceID, err)
-			}
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		params.Host, params.Port, params.User, password, params.DBName, params.SSLMode)
 
-			rowData := make(map[string]interface{})
-			for i, colName := range columns {
-				val := values[i]
-				if b, ok := val.([]byte); ok {
-					rowData[colName] = string(b)
-				} else {
-					rowData[colName] = val
-				}
-			}
-			results = append(results, rowData)
-		}
+	log.Printf("Connecting to PostgreSQL database: %s:%d/%s with user %s", params.Host, params.Port, params.DBName, params.User)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL for source ID %s: %w", sourceID, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL for source ID %s: %w", sourceID, err)
+	}
+	log.Printf("Successfully connected to PostgreSQL for source ID: %s", sourceID)
+	return db, nil
+}
 
-		if err = rows.Err(); err != nil { // Check for errors encountered during iteration
-			return nil, fmt.Errorf("error iterating rows for source ID %s: %w", sourceID, err)
-		}
-		log.Printf("Successfully ingested %d records for source ID: %s from database.", len(results), sourceID)
+// buildSourceQuery turns a bare table name into a SELECT; anything else is used as a query as-is.
+func buildSourceQuery(tableOrQuery string) string {
+	if !strings.Contains(tableOrQuery, " ") && !strings.HasPrefix(strings.ToUpper(tableOrQuery), "SELECT") {
+		return fmt.Sprintf("SELECT * FROM %q", tableOrQuery)
+	}
+	return tableOrQuery
+}
 
-	} else {
-		// Corrected bug: using dsConfig.Type instead of config.Type
-		return nil, fmt.Errorf("unsupported data source type: %s. Only PostgreSQL and CSV are currently supported", dsConfig.Type)
+// buildIncrementalQuery wraps query so that only rows past the watermark are returned, in watermark order.
+// It returns the query unchanged when column is empty.
+func buildIncrementalQuery(query, column, since string) (string, []interface{}) {
+	if column == "" {
+		return query, nil
+	}
+	col := pq.QuoteIdentifier(column)
+	if since == "" {
+		return fmt.Sprintf("SELECT * FROM (%s) AS src ORDER BY %s", query, col), nil
 	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS src WHERE %s > $1 ORDER BY %s", query, col, col), []interface{}{since}
+}
 
-	// After successful ingestion, call the processing service
-	if len(results) > 0 {
-		entityTypeName := dsConfig.EntityID // Using EntityID as EntityTypeName as per previous logic.
-		// If EntityID is an actual ID, we would need another call to metadata service to get EntityDefinition.Name
-		// This logic is consistent with how it was handled before for PostgreSQL.
+// watermarkString renders a watermark column value in a form PostgreSQL can compare against the column again.
+func watermarkString(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
 
-		if dsConfig.EntityID == "" {
-			log.Printf("Warning: DataSourceConfig.EntityID is empty for source %s. Processing might not determine entity type correctly.", sourceID)
+// scanRows converts every row in rows into a map keyed by column name and passes it to handle.
+// It returns the number of rows handled.
+func scanRows(sourceID string, rows *sql.Rows, handle RecordHandler) (int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get columns for query result for source ID %s: %w", sourceID, err)
+	}
+
+	n := 0
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range columns {
+			valuePtrs[i] = &values[i]
 		}
 
-		processPayload := ProcessDataRequest{
-			SourceID:       sourceID,
-			EntityTypeName: entityTypeName,
-			RawData:        results,
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return n, fmt.Errorf("failed to scan row for source ID %s: %w", sourceID, err)
 		}
 
-		log.Printf("Sending %d ingested records for SourceID %s (EntityID from DSConfig: '%s') to processing service.", len(results), sourceID, dsConfig.EntityID)
-		err = s.processingClient.CallProcessData(processPayload)
-		if err != nil {
-			log.Printf("Error calling processing service for source ID %s: %v", sourceID, err)
-			// Optionally, return this error depending on desired criticality
-			// return nil, fmt.Errorf("failed to send data to processing service: %w", err)
-		} else {
-			log.Printf("Successfully sent data for source ID %s to processing service.", sourceID)
+		rowData := make(map[string]interface{})
+		for i, colName := range columns {
+			val := values[i]
+			if b, ok := val.([]byte); ok {
+				rowData[colName] = string(b)
+			} else {
+				rowData[colName] = val
+			}
 		}
-	} else {
-		log.Printf("No records ingested for source ID %s. Skipping call to processing service.", sourceID)
+		if err := handle(rowData); err != nil {
+			return n, err
+		}
+		n++
 	}
 
-	return results, nil
+	if err := rows.Err(); err != nil { // Check for errors encountered during iteration
+		return n, fmt.Errorf("error iterating rows for source ID %s: %w", sourceID, err)
+	}
+	return n, nil
 }
@@ -1,11 +1,11 @@
 package ingestion
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -13,7 +13,13 @@ import (
 
 // --- Mock MetadataServiceAPIClient ---
 type MockMetadataServiceClient struct {
-	GetDataSourceConfigFunc func(sourceID string) (*DataSourceConfig, error)
+	GetDataSourceConfigFunc      func(sourceID string) (*DataSourceConfig, error)
+	GetIngestionWatermarkFunc    func(sourceID string) (*IngestionWatermark, error)
+	UpdateIngestionWatermarkFunc func(watermark IngestionWatermark) error
+	CreateIngestionRunFunc       func(run IngestionRun) (*IngestionRun, error)
+	UpdateIngestionRunFunc       func(run IngestionRun) error
+	FindIngestedFileFunc         func(sourceID, fileName, checksum string) (*IngestedFile, error)
+	RecordIngestedFileFunc       func(file IngestedFile) error
 }
 
 func (m *MockMetadataServiceClient) GetDataSourceConfig(sourceID string) (*DataSourceConfig, error) {
@@ -23,18 +29,66 @@ func (m *MockMetadataServiceClient) GetDataSourceConfig(sourceID string) (*DataS
 	return nil, fmt.Errorf("GetDataSourceConfigFunc not implemented")
 }
 
+func (m *MockMetadataServiceClient) GetIngestionWatermark(sourceID string) (*IngestionWatermark, error) {
+	if m.GetIngestionWatermarkFunc != nil {
+		return m.GetIngestionWatermarkFunc(sourceID)
+	}
+	return nil, nil // No watermark recorded
+}
+
+func (m *MockMetadataServiceClient) UpdateIngestionWatermark(watermark IngestionWatermark) error {
+	if m.UpdateIngestionWatermarkFunc != nil {
+		return m.UpdateIngestionWatermarkFunc(watermark)
+	}
+	return nil
+}
+
+func (m *MockMetadataServiceClient) CreateIngestionRun(run IngestionRun) (*IngestionRun, error) {
+	if m.CreateIngestionRunFunc != nil {
+		return m.CreateIngestionRunFunc(run)
+	}
+	run.ID = "run-1"
+	return &run, nil
+}
+
+func (m *MockMetadataServiceClient) UpdateIngestionRun(run IngestionRun) error {
+	if m.UpdateIngestionRunFunc != nil {
+		return m.UpdateIngestionRunFunc(run)
+	}
+	return nil
+}
+
+func (m *MockMetadataServiceClient) FindIngestedFile(sourceID, fileName, checksum string) (*IngestedFile, error) {
+	if m.FindIngestedFileFunc != nil {
+		return m.FindIngestedFileFunc(sourceID, fileName, checksum)
+	}
+	return nil, nil // Not in the ledger
+}
+
+func (m *MockMetadataServiceClient) RecordIngestedFile(file IngestedFile) error {
+	if m.RecordIngestedFileFunc != nil {
+		return m.RecordIngestedFileFunc(file)
+	}
+	return nil
+}
+
 // --- Mock ProcessingServiceAPIClient ---
 type MockProcessingServiceClient struct {
-	CallProcessDataFunc   func(payload ProcessDataRequest) error
+	CallProcessDataFunc        func(payload ProcessDataRequest) error
 	CapturedProcessDataRequest *ProcessDataRequest
+	// RejectPerBatch is the number of records of each successful call reported as not processed.
+	RejectPerBatch int
 }
 
-func (m *MockProcessingServiceClient) CallProcessData(payload ProcessDataRequest) error {
+func (m *MockProcessingServiceClient) CallProcessData(payload ProcessDataRequest) (*ProcessDataResponse, error) {
 	m.CapturedProcessDataRequest = &payload // Capture the payload
 	if m.CallProcessDataFunc != nil {
-		return m.CallProcessDataFunc(payload)
+		if err := m.CallProcessDataFunc(payload); err != nil {
+			return nil, err
+		}
 	}
-	return nil // Default to success
+	// Default to success
+	return &ProcessDataResponse{RecordsReceived: len(payload.RawData), RecordsProcessed: len(payload.RawData) - m.RejectPerBatch}, nil
 }
 
 // Helper to create a temporary CSV file for testing
@@ -196,3 +250,204 @@ func TestIngestData_CSV(t *testing.T) {
 		assert.Contains(t, err.Error(), "mock metadata service error")
 	})
 }
+
+func TestIngestDataStreaming_CSV(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	mockProcClient := &MockProcessingServiceClient{}
+	service := NewIngestionService(mockMetaClient, mockProcClient)
+
+	csvFilePath := createTempCSV(t, "id,name\n1,a\n2,b\n3,c\n4,d\n5,e")
+	mockMetaClient.GetDataSourceConfigFunc = func(sourceID string) (*DataSourceConfig, error) {
+		return &DataSourceConfig{ID: sourceID, Type: "csv", ConnectionDetails: fmt.Sprintf(`{"filepath": "%s"}`, csvFilePath), EntityID: "TestEntity"}, nil
+	}
+
+	t.Run("Sends Records In Batches", func(t *testing.T) {
+		var batches [][]map[string]interface{}
+		mockProcClient.CallProcessDataFunc = func(payload ProcessDataRequest) error {
+			batches = append(batches, payload.RawData)
+			return nil
+		}
+
+		progress, err := service.IngestDataStreaming("csvStream", 2)
+		require.NoError(t, err)
+		require.Len(t, batches, 3)
+		assert.Len(t, batches[0], 2)
+		assert.Len(t, batches[2], 1)
+		assert.Equal(t, "5", batches[2][0]["id"])
+
+		assert.Equal(t, 5, progress.RowsRead)
+		assert.Equal(t, 5, progress.RowsSent)
+		require.Len(t, progress.Batches, 3)
+		assert.Equal(t, BatchProgress{BatchNumber: 3, FirstRow: 5, RowCount: 1, Sent: true}, progress.Batches[2])
+	})
+
+	t.Run("Failed Batch Keeps Earlier Progress", func(t *testing.T) {
+		calls := 0
+		mockProcClient.CallProcessDataFunc = func(payload ProcessDataRequest) error {
+			calls++
+			if calls == 2 {
+				return fmt.Errorf("processing unavailable")
+			}
+			return nil
+		}
+
+		progress, err := service.IngestDataStreaming("csvStream", 2)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to send batch 2 (rows 3-4)")
+		require.NotNil(t, progress)
+		assert.Equal(t, 2, calls, "ingestion should stop after the failed batch")
+		assert.Equal(t, 2, progress.RowsSent)
+		require.Len(t, progress.Batches, 2)
+		assert.True(t, progress.Batches[0].Sent)
+		assert.False(t, progress.Batches[1].Sent)
+		assert.Equal(t, "processing unavailable", progress.Batches[1].Error)
+	})
+
+	t.Run("Defaults Batch Size", func(t *testing.T) {
+		mockProcClient.CallProcessDataFunc = nil
+		progress, err := service.IngestDataStreaming("csvStream", 0)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultBatchSize, progress.BatchSize)
+		assert.Len(t, progress.Batches, 1)
+	})
+}
+
+func TestStartIngestionJob(t *testing.T) {
+	csvFilePath := createTempCSV(t, "id,name\n1,a\n2,b\n3,c")
+
+	newService := func(mockProcClient *MockProcessingServiceClient) (*IngestionService, *MockMetadataServiceClient, chan IngestionRun) {
+		updates := make(chan IngestionRun, 10)
+		mockMetaClient := &MockMetadataServiceClient{
+			GetDataSourceConfigFunc: func(sourceID string) (*DataSourceConfig, error) {
+				return &DataSourceConfig{ID: sourceID, Type: "csv", ConnectionDetails: fmt.Sprintf(`{"filepath": "%s"}`, csvFilePath), EntityID: "TestEntity"}, nil
+			},
+			UpdateIngestionRunFunc: func(run IngestionRun) error {
+				updates <- run
+				return nil
+			},
+		}
+		return NewIngestionService(mockMetaClient, mockProcClient), mockMetaClient, updates
+	}
+
+	// waitForFinalRun drains progress updates until the run leaves the running state.
+	waitForFinalRun := func(t *testing.T, updates chan IngestionRun) IngestionRun {
+		t.Helper()
+		for {
+			select {
+			case run := <-updates:
+				if run.Status != IngestionRunRunning {
+					return run
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("ingestion run did not finish")
+			}
+		}
+	}
+
+	t.Run("Records Successful Run", func(t *testing.T) {
+		var sentRunIDs []string
+		service, _, updates := newService(&MockProcessingServiceClient{RejectPerBatch: 1, CallProcessDataFunc: func(payload ProcessDataRequest) error {
+			sentRunIDs = append(sentRunIDs, payload.RunID)
+			return nil
+		}})
+
+		run, err := service.StartIngestionJob("csvJob", 2)
+		require.NoError(t, err)
+		assert.Equal(t, "run-1", run.ID)
+		assert.Equal(t, IngestionRunRunning, run.Status)
+
+		final := waitForFinalRun(t, updates)
+		assert.Equal(t, "run-1", final.ID)
+		assert.Equal(t, IngestionRunSucceeded, final.Status)
+		assert.Equal(t, int64(3), final.RowsRead)
+		assert.Equal(t, int64(3), final.RowsSent)
+		assert.Equal(t, int64(2), final.RowsRejected, "one record rejected in each of the two batches")
+		assert.NotNil(t, final.FinishedAt)
+		assert.Empty(t, final.Error)
+		assert.Equal(t, []string{"run-1", "run-1"}, sentRunIDs, "batches carry the run ID for quarantined records")
+	})
+
+	t.Run("Records Failed Run", func(t *testing.T) {
+		service, _, updates := newService(&MockProcessingServiceClient{
+			CallProcessDataFunc: func(payload ProcessDataRequest) error { return fmt.Errorf("processing unavailable") },
+		})
+
+		_, err := service.StartIngestionJob("csvJob", 2)
+		require.NoError(t, err)
+
+		final := waitForFinalRun(t, updates)
+		assert.Equal(t, IngestionRunFailed, final.Status)
+		assert.Equal(t, int64(0), final.RowsSent)
+		assert.Contains(t, final.Error, "processing unavailable")
+	})
+
+	t.Run("Fails When Run Cannot Be Recorded", func(t *testing.T) {
+		service, mockMetaClient, _ := newService(&MockProcessingServiceClient{})
+		mockMetaClient.CreateIngestionRunFunc = func(run IngestionRun) (*IngestionRun, error) {
+			return nil, fmt.Errorf("metadata unavailable")
+		}
+
+		run, err := service.StartIngestionJob("csvJob", 2)
+		require.Error(t, err)
+		assert.Nil(t, run)
+		assert.Contains(t, err.Error(), "metadata unavailable")
+	})
+}
+
+func TestBuildIncrementalQuery(t *testing.T) {
+	t.Run("Not Incremental", func(t *testing.T) {
+		query, args := buildIncrementalQuery(`SELECT * FROM "orders"`, "", "")
+		assert.Equal(t, `SELECT * FROM "orders"`, query)
+		assert.Nil(t, args)
+	})
+
+	t.Run("First Run Reads Everything In Watermark Order", func(t *testing.T) {
+		query, args := buildIncrementalQuery(`SELECT * FROM "orders"`, "updated_at", "")
+		assert.Equal(t, `SELECT * FROM (SELECT * FROM "orders") AS src ORDER BY "updated_at"`, query)
+		assert.Nil(t, args)
+	})
+
+	t.Run("Later Runs Filter On Previous Watermark", func(t *testing.T) {
+		query, args := buildIncrementalQuery("SELECT id, updated_at FROM orders WHERE region = 'EU'", "updated_at", "2024-01-01T00:00:00Z")
+		assert.Equal(t, `SELECT * FROM (SELECT id, updated_at FROM orders WHERE region = 'EU') AS src WHERE "updated_at" > $1 ORDER BY "updated_at"`, query)
+		assert.Equal(t, []interface{}{"2024-01-01T00:00:00Z"}, args)
+	})
+}
+
+func TestWatermarkString(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	assert.Equal(t, "2024-03-01T12:30:00.123456Z", watermarkString(ts))
+	assert.Equal(t, "42", watermarkString(int64(42)))
+	assert.Equal(t, "abc", watermarkString("abc"))
+}
+
+func TestAdvanceWatermark(t *testing.T) {
+	mockMetaClient := &MockMetadataServiceClient{}
+	service := NewIngestionService(mockMetaClient, &MockProcessingServiceClient{})
+
+	var saved *IngestionWatermark
+	mockMetaClient.UpdateIngestionWatermarkFunc = func(watermark IngestionWatermark) error {
+		saved = &watermark
+		return nil
+	}
+
+	require.NoError(t, service.advanceWatermark(nil))
+	assert.Nil(t, saved, "nil watermark must not be persisted")
+
+	require.NoError(t, service.advanceWatermark(&IngestionWatermark{SourceID: "pg1", WatermarkColumn: "id", LastValue: "10"}))
+	require.NotNil(t, saved)
+	assert.Equal(t, "10", saved.LastValue)
+
+	t.Run("Stale Column Triggers Full Read", func(t *testing.T) {
+		mockMetaClient.GetIngestionWatermarkFunc = func(sourceID string) (*IngestionWatermark, error) {
+			return &IngestionWatermark{SourceID: sourceID, WatermarkColumn: "id", LastValue: "10"}, nil
+		}
+		since, err := service.lastWatermark("pg1", "updated_at")
+		require.NoError(t, err)
+		assert.Empty(t, since)
+
+		since, err = service.lastWatermark("pg1", "id")
+		require.NoError(t, err)
+		assert.Equal(t, "10", since)
+	})
+}
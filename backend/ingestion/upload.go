@@ -0,0 +1,88 @@
+package ingestion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IngestUpload ingests a file uploaded for a data source instead of the file configured in its
+// connection details. The file is parsed with the source's format and reader options (csv, json,
+// ndjson, or the format of a directory source) and sent to the processing service in batches like
+// IngestDataStreaming. fileName is only used to detect compression from its extension.
+func (s *IngestionService) IngestUpload(sourceID, fileName string, content io.Reader, batchSize int) (*IngestionProgress, error) {
+	tmp, err := os.CreateTemp("", "ingest-upload-*"+uploadExtension(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for upload to source ID %s: %w", sourceID, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to store upload %s for source ID %s: %w", fileName, sourceID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to store upload %s for source ID %s: %w", fileName, sourceID, err)
+	}
+
+	log.Printf("Ingesting uploaded file %s for source ID %s", fileName, sourceID)
+	return s.ingestStreaming(sourceID, "", batchSize, func(dsConfig *DataSourceConfig, fetchSize int, handle RecordHandler) (*readCheckpoint, error) {
+		return nil, readUploadedFile(dsConfig, tmp.Name(), handle)
+	}, nil)
+}
+
+// ErrUploadNotSupported is returned when the type of a data source cannot parse uploaded files.
+var ErrUploadNotSupported = errors.New("data source type does not accept file uploads")
+
+// readUploadedFile reads the file at path with the format and options configured for the data source.
+func readUploadedFile(dsConfig *DataSourceConfig, path string, handle RecordHandler) error {
+	sourceType := strings.ToLower(dsConfig.Type)
+	switch sourceType {
+	case "csv":
+		var params CSVConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return fmt.Errorf("failed to parse CSV connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		params.Filepath = path
+		return readCSVRecords(dsConfig.ID, params, handle)
+	case "json", "ndjson":
+		var params JSONConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return fmt.Errorf("failed to parse %s connection details for source ID %s: %w", strings.ToUpper(sourceType), dsConfig.ID, err)
+		}
+		params.Filepath = path
+		return readJSONRecords(dsConfig.ID, sourceType, params, handle)
+	case "directory":
+		var params DirectoryConnectionParams
+		if err := json.Unmarshal([]byte(dsConfig.ConnectionDetails), &params); err != nil {
+			return fmt.Errorf("failed to parse directory connection details for source ID %s: %w", dsConfig.ID, err)
+		}
+		switch format := strings.ToLower(params.Format); format {
+		case "", "csv":
+			params.CSV.Filepath = path
+			return readCSVRecords(dsConfig.ID, params.CSV, handle)
+		case "json", "ndjson":
+			params.JSON.Filepath = path
+			return readJSONRecords(dsConfig.ID, format, params.JSON, handle)
+		default:
+			return fmt.Errorf("unsupported file format %q for source ID %s. Supported formats are csv, json and ndjson", params.Format, dsConfig.ID)
+		}
+	default:
+		return fmt.Errorf("%w: %s (source ID %s)", ErrUploadNotSupported, dsConfig.Type, dsConfig.ID)
+	}
+}
+
+// uploadExtension returns the extension of an uploaded file name, which decides the compression
+// of CSV uploads in "auto" mode.
+func uploadExtension(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(filepath.Base(fileName)))
+	switch ext {
+	case ".gz", ".gzip", ".zip", ".csv", ".json", ".ndjson", ".txt", ".tsv":
+		return ext
+	}
+	return ""
+}
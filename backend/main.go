@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -79,16 +80,84 @@ func proxyTo(targetServiceBaseUrl string, prefixToStrip string, pathPrefixToRepl
 	}
 }
 
+// dataSourcesProxy proxies /api/v1/datasources/* to the metadata service, except for connection
+// tests and schema discovery (POST /api/v1/datasources/:source_id/test and /discover). Those open
+// the data source with the ingestion service's connectors and go to
+// /api/v1/ingest/{test,discover}/:source_id of the ingestion service.
+func dataSourcesProxy(metadataTarget, ingestTarget string) gin.HandlerFunc {
+	metadataProxy := proxyTo(metadataTarget, "", nil)
+	ingestProxy := proxyTo(ingestTarget, "", nil)
+	return func(c *gin.Context) {
+		parts := strings.Split(strings.Trim(c.Param("any"), "/"), "/")
+		if c.Request.Method == http.MethodPost && len(parts) == 2 && (parts[1] == "test" || parts[1] == "discover") {
+			c.Request.URL.Path = fmt.Sprintf("/api/v1/ingest/%s/%s", parts[1], parts[0])
+			c.Request.URL.RawPath = ""
+			ingestProxy(c)
+			return
+		}
+		metadataProxy(c)
+	}
+}
+
+// openMetadataStore connects to the metadata database and configures the master keys that encrypt
+// data source connection details at rest (METADATA_ENCRYPTION_KEYS, METADATA_ENCRYPTION_KEY_ID).
+func openMetadataStore(dbDataSourceName string) *metadata.PostgresStore {
+	store, err := metadata.NewPostgresStore(dbDataSourceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize metadata PostgreSQL store: %v", err)
+	}
+	keys, err := metadata.LoadKeyRingFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load metadata encryption keys: %v", err)
+	}
+	if keys == nil {
+		log.Println("METADATA_ENCRYPTION_KEYS is not set; data source connection details are stored unencrypted.")
+	} else {
+		log.Printf("Encrypting data source connection details with master key %s.", keys.ActiveKeyID())
+	}
+	store.Keys = keys
+	return store
+}
+
+// rotateDataSourceKeys re-encrypts the connection details of all data sources with the active
+// master key. Run it as "rotate-keys" after adding a new key to METADATA_ENCRYPTION_KEYS and making
+// it active; the previous keys can be removed once it has completed.
+func rotateDataSourceKeys(dbDataSourceName string) {
+	store := openMetadataStore(dbDataSourceName)
+	defer store.Close()
+	if store.Keys == nil {
+		log.Fatal("rotate-keys requires METADATA_ENCRYPTION_KEYS to be set")
+	}
+	count, err := store.RotateDataSourceKeys()
+	if err != nil {
+		log.Fatalf("Key rotation failed: %v", err)
+	}
+	log.Printf("Re-encrypted connection details of %d data sources with master key %s.", count, store.Keys.ActiveKeyID())
+}
+
+// retentionSettings reads how often the retention janitor sweeps (RETENTION_SWEEP_INTERVAL, a duration such
+// as "1h") and how many rows it deletes per statement (RETENTION_BATCH_SIZE).
+func retentionSettings() (time.Duration, int) {
+	interval, err := time.ParseDuration(getEnv("RETENTION_SWEEP_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid RETENTION_SWEEP_INTERVAL, using 1h: %v", err)
+		interval = time.Hour
+	}
+	batchSize, err := strconv.Atoi(getEnv("RETENTION_BATCH_SIZE", strconv.Itoa(metadata.DefaultRetentionBatchSize)))
+	if err != nil || batchSize <= 0 {
+		log.Printf("Invalid RETENTION_BATCH_SIZE, using %d: %v", metadata.DefaultRetentionBatchSize, err)
+		batchSize = metadata.DefaultRetentionBatchSize
+	}
+	return interval, batchSize
+}
+
 // runMetadataService starts the metadata service with a PostgreSQL backend.
 func runMetadataService(ctx context.Context, serviceAddr string, dbDataSourceName string) {
 	log.Printf("Attempting to connect to metadata database with DSN: %s (details omitted for security if password was included)", dbDataSourceName) // Simplified DSN logging
 	
 	// Initialize the PostgreSQL store for metadata
 	// The metadata.Store interface is implemented by metadata.PostgresStore
-	store, err := metadata.NewPostgresStore(dbDataSourceName)
-	if err != nil {
-		log.Fatalf("Failed to initialize metadata PostgreSQL store: %v", err)
-	}
+	store := openMetadataStore(dbDataSourceName)
 	defer func() {
 		log.Println("Closing metadata store connection...")
 		if err := store.Close(); err != nil {
@@ -119,6 +188,10 @@ func runMetadataService(ctx context.Context, serviceAddr string, dbDataSourceNam
 		}
 	}()
 
+	// Enforce the job history limits of the retention policies until shutdown.
+	sweepInterval, batchSize := retentionSettings()
+	go store.RunRetentionJanitor(ctx, sweepInterval, batchSize)
+
 	// Wait for shutdown signal
 	<-ctx.Done()
 	log.Println("Shutting down internal Metadata service...")
@@ -151,6 +224,11 @@ func main() {
 	log.Printf("Metadata DB DSN: host=%s port=%s user=%s password=*** dbname=%s sslmode=%s",
 		dbHost, dbPort, dbUser, dbName, dbSSLMode)
 
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		rotateDataSourceKeys(dbDataSourceName)
+		return
+	}
+
 
 	// Context for metadata service shutdown
 	metadataServiceCtx, metadataServiceCancel := context.WithCancel(context.Background())
@@ -168,21 +246,22 @@ func main() {
 	// --- Setup Gateway Routes ---
 	// --- Setup Gateway Routes ---
 	metadataTarget := "http://" + metadataInternalAddr
+	ingestTargetURL := getEnv("INGEST_SERVICE_URL", "http://localhost:8081")
 
 	// Standardized Metadata Service Routes:
 	// These routes proxy directly to the internal metadata service,
 	// and the internal metadata service's router handles the full /api/v1/... path.
 	// The `prefixToStrip` is empty, meaning the gateway path is sent as-is to the target.
 	gatewayRouter.Any("/api/v1/entities/*any", proxyTo(metadataTarget, "", nil))
-	gatewayRouter.Any("/api/v1/datasources/*any", proxyTo(metadataTarget, "", nil)) // Covers nested /mappings
+	gatewayRouter.Any("/api/v1/datasources/*any", dataSourcesProxy(metadataTarget, ingestTargetURL)) // Covers nested /mappings
 	gatewayRouter.Any("/api/v1/group-definitions/*any", proxyTo(metadataTarget, "", nil)) // New route for metadata GroupDefinitions
 	gatewayRouter.Any("/api/v1/workflows/*any", proxyTo(metadataTarget, "", nil))
 	gatewayRouter.Any("/api/v1/actiontemplates/*any", proxyTo(metadataTarget, "", nil))
 	gatewayRouter.Any("/api/v1/schedules/*any", proxyTo(metadataTarget, "", nil))
+	gatewayRouter.Any("/api/v1/retention-policies", proxyTo(metadataTarget, "", nil))
 
 
 	// Ingest Service: /api/v1/ingest/*any -> http://localhost:8081 (target handles full path)
-	ingestTargetURL := getEnv("INGEST_SERVICE_URL", "http://localhost:8081") 
 	gatewayRouter.Any("/api/v1/ingest/*any", proxyTo(ingestTargetURL, "", nil))
 
 	// Processing Service: /api/v1/processing/*any -> http://localhost:8082/api/v1/process/*any (path prefix replacement)
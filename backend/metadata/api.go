@@ -1,12 +1,17 @@
 package metadata
 
 import (
-	"net/http"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv" // Added for Atoi
 	"strings"
+	"time"
 
+	"example.com/project/secrets"
 	"github.com/gin-gonic/gin"
 	// Assuming ListParams is in models package after previous (intended) move
 	// If not, and it's still in metadata (store.go), this import might not be needed
@@ -46,6 +51,12 @@ func NewAPI(store *Store) *API {
 func (a *API) RegisterRoutes(router *gin.Engine) {
 	v1 := router.Group("/api/v1")
 
+	// Internal routes are called by other services directly and are not proxied by the gateway.
+	internalRoutes := router.Group("/internal/v1")
+	{
+		internalRoutes.GET("/datasources/:source_id", a.getDataSourceConnectionHandler)
+	}
+
 	// Entity Routes
 	entityRoutes := v1.Group("/entities")
 	{
@@ -63,6 +74,25 @@ func (a *API) RegisterRoutes(router *gin.Engine) {
 			attributeRoutes.GET("/:attribute_id", a.getAttributeHandler)
 			attributeRoutes.PUT("/:attribute_id", a.updateAttributeHandler)
 			attributeRoutes.DELETE("/:attribute_id", a.deleteAttributeHandler)
+			attributeRoutes.GET("/:attribute_id/index", a.getAttributeIndexHandler)
+			attributeRoutes.PUT("/:attribute_id/index", a.rebuildAttributeIndexHandler)
+			attributeRoutes.GET("/:attribute_id/pii-policy", a.getAttributePiiPolicyHandler)
+			attributeRoutes.PUT("/:attribute_id/pii-policy", a.setAttributePiiPolicyHandler)
+			attributeRoutes.DELETE("/:attribute_id/pii-policy", a.deleteAttributePiiPolicyHandler)
+		}
+		entityRoutes.GET("/:entity_id/indexes", a.listAttributeIndexesHandler)
+		entityRoutes.GET("/:entity_id/retention-policy", a.getRetentionPolicyHandler)
+		entityRoutes.PUT("/:entity_id/retention-policy", a.setRetentionPolicyHandler)
+		entityRoutes.DELETE("/:entity_id/retention-policy", a.deleteRetentionPolicyHandler)
+
+		// Data Quality Rule Routes (nested under Entities)
+		entityQualityRuleRoutes := entityRoutes.Group("/:entity_id/quality-rules")
+		{
+			entityQualityRuleRoutes.POST("/", a.createQualityRuleHandler)
+			entityQualityRuleRoutes.GET("/", a.listQualityRulesHandler)
+			entityQualityRuleRoutes.GET("/:rule_id", a.getQualityRuleHandler)
+			entityQualityRuleRoutes.PUT("/:rule_id", a.updateQualityRuleHandler)
+			entityQualityRuleRoutes.DELETE("/:rule_id", a.deleteQualityRuleHandler)
 		}
 	}
 
@@ -74,6 +104,27 @@ func (a *API) RegisterRoutes(router *gin.Engine) {
 		dataSourceRoutes.GET("/:source_id", a.getDataSourceHandler)
 		dataSourceRoutes.PUT("/:source_id", a.updateDataSourceHandler)
 		dataSourceRoutes.DELETE("/:source_id", a.deleteDataSourceHandler)
+		dataSourceRoutes.GET("/:source_id/watermark", a.getIngestionWatermarkHandler)
+		dataSourceRoutes.PUT("/:source_id/watermark", a.putIngestionWatermarkHandler)
+		dataSourceRoutes.GET("/:source_id/retention-policy", a.getRetentionPolicyHandler)
+		dataSourceRoutes.PUT("/:source_id/retention-policy", a.setRetentionPolicyHandler)
+		dataSourceRoutes.DELETE("/:source_id/retention-policy", a.deleteRetentionPolicyHandler)
+
+		// Ingestion Run Routes (nested under data sources)
+		runRoutes := dataSourceRoutes.Group("/:source_id/runs")
+		{
+			runRoutes.POST("/", a.createIngestionRunHandler)
+			runRoutes.GET("/", a.listIngestionRunsHandler)
+			runRoutes.GET("/:run_id", a.getIngestionRunHandler)
+			runRoutes.PUT("/:run_id", a.updateIngestionRunHandler)
+		}
+
+		// Processed-file ledger of directory data sources
+		fileRoutes := dataSourceRoutes.Group("/:source_id/files")
+		{
+			fileRoutes.POST("/", a.recordIngestedFileHandler)
+			fileRoutes.GET("/", a.listIngestedFilesHandler)
+		}
 
 		// Field Mapping Routes (nested under data sources)
 		mappingRoutes := dataSourceRoutes.Group("/:source_id/mappings")
@@ -84,8 +135,21 @@ func (a *API) RegisterRoutes(router *gin.Engine) {
 			mappingRoutes.PUT("/:mapping_id", a.updateFieldMappingHandler)
 			mappingRoutes.DELETE("/:mapping_id", a.deleteFieldMappingHandler)
 		}
+
+		// Data Quality Rule Routes (nested under Data Sources)
+		sourceQualityRuleRoutes := dataSourceRoutes.Group("/:source_id/quality-rules")
+		{
+			sourceQualityRuleRoutes.POST("/", a.createQualityRuleHandler)
+			sourceQualityRuleRoutes.GET("/", a.listQualityRulesHandler)
+			sourceQualityRuleRoutes.GET("/:rule_id", a.getQualityRuleHandler)
+			sourceQualityRuleRoutes.PUT("/:rule_id", a.updateQualityRuleHandler)
+			sourceQualityRuleRoutes.DELETE("/:rule_id", a.deleteQualityRuleHandler)
+		}
 	}
 
+	// Retention policies of all entity definitions and data sources, read by the retention janitors
+	v1.GET("/retention-policies", a.listRetentionPoliciesHandler)
+
 	// Group Definition Routes
 	groupDefinitionRoutes := v1.Group("/group-definitions") // Renamed from /groups
 	{
@@ -732,6 +796,7 @@ func (a *API) createAttributeHandler(c *gin.Context) {
 		handleAPIError(c, http.StatusInternalServerError, "Failed to create attribute: "+err.Error())
 		return
 	}
+	a.syncAttributeIndex(attribute)
 	c.JSON(http.StatusCreated, attribute)
 }
 
@@ -822,6 +887,7 @@ func (a *API) updateAttributeHandler(c *gin.Context) {
 		handleStoreError(c, err, "Attribute")
 		return
 	}
+	a.syncAttributeIndex(attribute)
 	c.JSON(http.StatusOK, attribute)
 }
 
@@ -836,11 +902,16 @@ func (a *API) deleteAttributeHandler(c *gin.Context) {
 		return
 	}
 
+	// The index status is deleted with the attribute, the index itself is dropped afterwards.
+	index, indexErr := a.store.GetAttributeIndex(entityID, attributeID)
 	err := a.store.DeleteAttribute(entityID, attributeID)
 	if err != nil {
 		handleStoreError(c, err, "Attribute")
 		return
 	}
+	if indexErr == nil && index.Status != IndexStatusDropped {
+		a.store.DropAttributeIndex(index)
+	}
 	c.JSON(http.StatusNoContent, nil)
 }
 
@@ -864,6 +935,7 @@ func (a *API) createDataSourceHandler(c *gin.Context) {
 		handleStoreError(c, err, "DataSource")
 		return
 	}
+	redactDataSource(&ds)
 	c.JSON(http.StatusCreated, ds)
 }
 
@@ -890,6 +962,9 @@ func (a *API) listDataSourcesHandler(c *gin.Context) {
 		handleAPIError(c, http.StatusInternalServerError, "Failed to list data sources: "+err.Error())
 		return
 	}
+	for i := range sources {
+		redactDataSource(&sources[i])
+	}
 	response := ListResponse{Data: sources, Total: total}
 	c.JSON(http.StatusOK, response)
 }
@@ -901,9 +976,34 @@ func (a *API) getDataSourceHandler(c *gin.Context) {
 		handleStoreError(c, err, "Data Source")
 		return
 	}
+	redactDataSource(&ds)
 	c.JSON(http.StatusOK, ds)
 }
 
+// getDataSourceConnectionHandler returns a data source with its connection details unredacted,
+// for the ingestion service to connect with. Connection details encrypted at rest are decrypted
+// here and nowhere else.
+func (a *API) getDataSourceConnectionHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	ds, err := a.store.GetDataSourceConnection(sourceID)
+	if err != nil {
+		handleStoreError(c, err, "Data Source")
+		return
+	}
+	c.JSON(http.StatusOK, ds)
+}
+
+// redactDataSource replaces plaintext secrets in the connection details of a data source for API
+// responses. Secret references ({"secret_ref": "env:NAME"}) are returned as they are. Connection
+// details encrypted at rest are replaced as a whole.
+func redactDataSource(ds *DataSourceConfig) {
+	if IsEncrypted(ds.ConnectionDetails) {
+		ds.ConnectionDetails = secrets.Redacted
+		return
+	}
+	ds.ConnectionDetails = secrets.RedactJSON(ds.ConnectionDetails)
+}
+
 func (a *API) updateDataSourceHandler(c *gin.Context) {
 	sourceID := c.Param("source_id")
 	var req DataSourceConfig
@@ -915,11 +1015,28 @@ func (a *API) updateDataSourceHandler(c *gin.Context) {
 	// The store.UpdateDataSource function now expects DataSourceConfig object
 	// which includes EntityID.
 
+	// Connection details read from this API have their secrets redacted. Keep the stored secrets
+	// wherever the request sends a redacted placeholder back, or all of them when the request sends
+	// back the placeholder of encrypted connection details.
+	if strings.Contains(req.ConnectionDetails, secrets.Redacted) {
+		existing, err := a.store.GetDataSourceConnection(sourceID)
+		if err != nil {
+			handleStoreError(c, err, "Data Source")
+			return
+		}
+		if req.ConnectionDetails == secrets.Redacted {
+			req.ConnectionDetails = existing.ConnectionDetails
+		} else {
+			req.ConnectionDetails = secrets.RestoreRedacted(req.ConnectionDetails, existing.ConnectionDetails)
+		}
+	}
+
 	ds, err := a.store.UpdateDataSource(sourceID, req, req.Metadata) // Pass req.Metadata
 	if err != nil {
 		handleStoreError(c, err, "Data Source")
 		return
 	}
+	redactDataSource(&ds)
 	c.JSON(http.StatusOK, ds)
 }
 
@@ -933,6 +1050,201 @@ func (a *API) deleteDataSourceHandler(c *gin.Context) {
 	c.JSON(http.StatusNoContent, nil)
 }
 
+// --- IngestionWatermark Handlers ---
+
+func (a *API) getIngestionWatermarkHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	wm, err := a.store.GetIngestionWatermark(sourceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The ingestion service treats 404 as "no previous successful run".
+			handleAPIError(c, http.StatusNotFound, "Ingestion watermark not found for data source "+sourceID)
+			return
+		}
+		handleStoreError(c, err, "Ingestion Watermark")
+		return
+	}
+	c.JSON(http.StatusOK, wm)
+}
+
+func (a *API) putIngestionWatermarkHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	var req IngestionWatermark
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if req.SourceID != "" && req.SourceID != sourceID {
+		handleAPIError(c, http.StatusBadRequest, "SourceID in path and payload do not match")
+		return
+	}
+	req.SourceID = sourceID
+
+	wm, err := a.store.UpsertIngestionWatermark(req)
+	if err != nil {
+		handleStoreError(c, err, "Ingestion Watermark")
+		return
+	}
+	c.JSON(http.StatusOK, wm)
+}
+
+// --- IngestionRun Handlers ---
+
+func (a *API) createIngestionRunHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	var req IngestionRun
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if req.SourceID != "" && req.SourceID != sourceID {
+		handleAPIError(c, http.StatusBadRequest, "SourceID in path and payload do not match")
+		return
+	}
+	req.SourceID = sourceID
+	req.ID = "" // ID is set by the store
+
+	run, err := a.store.CreateIngestionRun(req)
+	if err != nil {
+		handleStoreError(c, err, "Ingestion Run")
+		return
+	}
+	c.JSON(http.StatusCreated, run)
+}
+
+func (a *API) listIngestionRunsHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	if _, err := a.store.GetDataSource(sourceID); err != nil {
+		handleStoreError(c, err, "Data Source")
+		return
+	}
+
+	offsetStr := c.DefaultQuery("offset", "0")
+	limitStr := c.DefaultQuery("limit", DefaultLimitStr)
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		handleAPIError(c, http.StatusBadRequest, "Invalid offset parameter. Must be a non-negative integer.")
+		return
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		handleAPIError(c, http.StatusBadRequest, "Invalid limit parameter. Must be a positive integer.")
+		return
+	}
+
+	params := ListParams{Offset: offset, Limit: limit, Filters: make(map[string]interface{})}
+
+	runs, total, err := a.store.ListIngestionRuns(sourceID, params)
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to list ingestion runs for source "+sourceID+": "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{Data: runs, Total: total})
+}
+
+func (a *API) getIngestionRunHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	runID := c.Param("run_id")
+	run, err := a.store.GetIngestionRun(sourceID, runID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Ingestion run not found: "+runID)
+			return
+		}
+		handleStoreError(c, err, "Ingestion Run")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func (a *API) updateIngestionRunHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	runID := c.Param("run_id")
+	var req IngestionRun
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	switch req.Status {
+	case IngestionRunRunning, IngestionRunSucceeded, IngestionRunFailed:
+	default:
+		handleAPIError(c, http.StatusBadRequest, fmt.Sprintf("Invalid status %q. Must be one of running, succeeded, failed.", req.Status))
+		return
+	}
+
+	run, err := a.store.UpdateIngestionRun(sourceID, runID, req)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Ingestion run not found: "+runID)
+			return
+		}
+		handleStoreError(c, err, "Ingestion Run")
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// --- IngestedFile Handlers ---
+
+func (a *API) recordIngestedFileHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	var req IngestedFile
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if req.SourceID != "" && req.SourceID != sourceID {
+		handleAPIError(c, http.StatusBadRequest, "SourceID in path and payload do not match")
+		return
+	}
+	req.SourceID = sourceID
+	if req.Status != "processed" && req.Status != "error" {
+		handleAPIError(c, http.StatusBadRequest, fmt.Sprintf("Invalid status %q. Must be one of processed, error.", req.Status))
+		return
+	}
+
+	file, err := a.store.RecordIngestedFile(req)
+	if err != nil {
+		handleStoreError(c, err, "Ingested File")
+		return
+	}
+	c.JSON(http.StatusCreated, file)
+}
+
+func (a *API) listIngestedFilesHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	offsetStr := c.DefaultQuery("offset", "0")
+	limitStr := c.DefaultQuery("limit", DefaultLimitStr)
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		handleAPIError(c, http.StatusBadRequest, "Invalid offset parameter. Must be a non-negative integer.")
+		return
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		handleAPIError(c, http.StatusBadRequest, "Invalid limit parameter. Must be a positive integer.")
+		return
+	}
+
+	params := ListParams{Offset: offset, Limit: limit, Filters: make(map[string]interface{})}
+	for _, filter := range []string{"file_name", "checksum", "status"} {
+		if value := c.Query(filter); value != "" {
+			params.Filters[filter] = value
+		}
+	}
+
+	files, total, err := a.store.ListIngestedFiles(sourceID, params)
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to list ingested files for source "+sourceID+": "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{Data: files, Total: total})
+}
+
 // --- FieldMapping Handlers ---
 
 func (a *API) createFieldMappingHandler(c *gin.Context) {
@@ -949,6 +1261,12 @@ func (a *API) createFieldMappingHandler(c *gin.Context) {
 	}
 	req.SourceID = sourceID
 	req.ID = "" // ID is set by the store
+	if req.TransformationRule != "" {
+		if _, err := CompileTransformationRule(req.TransformationRule); err != nil {
+			handleAPIError(c, http.StatusBadRequest, "Invalid transformation_rule: "+err.Error())
+			return
+		}
+	}
 
 	mapping, err := a.store.CreateFieldMapping(req, req.Metadata) // Pass req.Metadata
 	if err != nil {
@@ -1019,6 +1337,12 @@ func (a *API) updateFieldMappingHandler(c *gin.Context) {
 		return
 	}
 	req.SourceID = sourceID
+	if req.TransformationRule != "" {
+		if _, err := CompileTransformationRule(req.TransformationRule); err != nil {
+			handleAPIError(c, http.StatusBadRequest, "Invalid transformation_rule: "+err.Error())
+			return
+		}
+	}
 
 	mapping, err := a.store.UpdateFieldMapping(sourceID, mappingID, req, req.Metadata) // Pass req.Metadata
 	if err != nil {
@@ -1053,6 +1377,484 @@ func handleStoreError(c *gin.Context, err error, resourceName string) {
 	}
 }
 
+// --- DataQualityRule Handlers ---
+// The same handlers serve the rules of data sources (source_id path parameter) and of entity
+// definitions (entity_id path parameter).
+
+// qualityRuleScope resolves the data source or entity definition in the path. It returns the IDs
+// to pass to the store and the entity definition the rule attributes belong to, or responds with
+// an error and returns false.
+func (a *API) qualityRuleScope(c *gin.Context) (sourceID, entityID, attributeEntityID string, ok bool) {
+	if sourceID = c.Param("source_id"); sourceID != "" {
+		ds, err := a.store.GetDataSource(sourceID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				handleAPIError(c, http.StatusNotFound, "Data source not found: "+sourceID)
+				return "", "", "", false
+			}
+			handleStoreError(c, err, "Data Source")
+			return "", "", "", false
+		}
+		return sourceID, "", ds.EntityID, true
+	}
+	entityID = c.Param("entity_id")
+	if _, err := a.store.GetEntity(entityID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Entity not found: "+entityID)
+			return "", "", "", false
+		}
+		handleStoreError(c, err, "Entity")
+		return "", "", "", false
+	}
+	return "", entityID, entityID, true
+}
+
+// validateQualityRule checks the rule type, action and parameters against the attribute the rule
+// checks, and defaults the action to "warn".
+func validateQualityRule(rule *DataQualityRule, attr AttributeDefinition) error {
+	if rule.Action == "" {
+		rule.Action = QualityActionWarn
+	}
+	switch rule.Action {
+	case QualityActionWarn, QualityActionReject, QualityActionFail:
+	default:
+		return fmt.Errorf("invalid action %q. Must be one of warn, reject, fail", rule.Action)
+	}
+
+	params := rule.Parameters
+	switch rule.RuleType {
+	case QualityRuleNotNull, QualityRuleUnique:
+	case QualityRuleRange:
+		_, hasMin := params["min"]
+		_, hasMax := params["max"]
+		if !hasMin && !hasMax {
+			return fmt.Errorf("range rules need a min or max parameter")
+		}
+		for _, bound := range []string{"min", "max"} {
+			value, ok := params[bound]
+			if !ok {
+				continue
+			}
+			switch attr.DataTypeName {
+			case BaseTypeDate, BaseTypeDateTime:
+				text, isString := value.(string)
+				_, errRFC := time.Parse(time.RFC3339, text)
+				_, errDate := time.Parse("2006-01-02", text)
+				if !isString || (errRFC != nil && errDate != nil) {
+					return fmt.Errorf("%s must be an RFC 3339 or YYYY-MM-DD date for %s attribute %s", bound, attr.DataTypeName, attr.Name)
+				}
+			case BaseTypeInteger, BaseTypeFloat:
+				if _, isNumber := value.(float64); !isNumber {
+					return fmt.Errorf("%s must be a number for %s attribute %s", bound, attr.DataTypeName, attr.Name)
+				}
+			default:
+				return fmt.Errorf("range rules only apply to number and date attributes, %s is %s", attr.Name, attr.DataTypeName)
+			}
+		}
+	case QualityRuleRegex:
+		pattern, _ := params["pattern"].(string)
+		if pattern == "" {
+			return fmt.Errorf("regex rules need a pattern parameter")
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	case QualityRuleReferenceExists:
+		referenced, _ := params["referenced_entity_id"].(string)
+		if referenced == "" {
+			referenced, _ = attr.DataTypeDetails["referenced_entity_id"].(string)
+		}
+		if referenced == "" {
+			return fmt.Errorf("reference_exists rules need a referenced_entity_id parameter unless %s is a reference attribute", attr.Name)
+		}
+	case QualityRuleMaxNullPercentage:
+		maxPercent, isNumber := params["max_percent"].(float64)
+		if !isNumber || maxPercent < 0 || maxPercent > 100 {
+			return fmt.Errorf("max_null_percentage rules need a max_percent parameter between 0 and 100")
+		}
+		if rule.Action == QualityActionReject {
+			return fmt.Errorf("max_null_percentage rules judge a whole batch and cannot reject single records; use warn or fail")
+		}
+	default:
+		return fmt.Errorf("invalid rule_type %q. Must be one of not_null, unique, range, regex, reference_exists, max_null_percentage", rule.RuleType)
+	}
+	return nil
+}
+
+// bindQualityRule reads and validates a rule from the request body for the scope resolved by
+// qualityRuleScope. It responds with an error and returns false if the rule is invalid.
+func (a *API) bindQualityRule(c *gin.Context, sourceID, entityID, attributeEntityID string) (DataQualityRule, bool) {
+	var req DataQualityRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return req, false
+	}
+	if (req.SourceID != "" && req.SourceID != sourceID) || (req.EntityID != "" && req.EntityID != entityID) {
+		handleAPIError(c, http.StatusBadRequest, "SourceID or EntityID in path and payload do not match")
+		return req, false
+	}
+	req.SourceID = sourceID
+	req.EntityID = entityID
+	if attributeEntityID == "" {
+		handleAPIError(c, http.StatusBadRequest, "Data source "+sourceID+" has no entity_id; its quality rules cannot refer to attributes")
+		return req, false
+	}
+	attr, err := a.store.GetAttribute(attributeEntityID, req.AttributeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusBadRequest, fmt.Sprintf("Attribute %s not found for entity %s", req.AttributeID, attributeEntityID))
+			return req, false
+		}
+		handleStoreError(c, err, "Attribute")
+		return req, false
+	}
+	if err := validateQualityRule(&req, attr); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid quality rule: "+err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+func (a *API) createQualityRuleHandler(c *gin.Context) {
+	sourceID, entityID, attributeEntityID, ok := a.qualityRuleScope(c)
+	if !ok {
+		return
+	}
+	req, ok := a.bindQualityRule(c, sourceID, entityID, attributeEntityID)
+	if !ok {
+		return
+	}
+	req.ID = "" // ID is set by the store
+
+	rule, err := a.store.CreateQualityRule(req)
+	if err != nil {
+		handleStoreError(c, err, "Quality Rule")
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// listQualityRulesHandler returns all rules of the data source or entity definition. Rules are few
+// per owner, so the list is not paged.
+func (a *API) listQualityRulesHandler(c *gin.Context) {
+	sourceID, entityID, _, ok := a.qualityRuleScope(c)
+	if !ok {
+		return
+	}
+	rules, err := a.store.ListQualityRules(sourceID, entityID)
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to list quality rules: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{Data: rules, Total: int64(len(rules))})
+}
+
+func (a *API) getQualityRuleHandler(c *gin.Context) {
+	ruleID := c.Param("rule_id")
+	rule, err := a.store.GetQualityRule(c.Param("source_id"), c.Param("entity_id"), ruleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Quality rule not found: "+ruleID)
+			return
+		}
+		handleStoreError(c, err, "Quality Rule")
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (a *API) updateQualityRuleHandler(c *gin.Context) {
+	ruleID := c.Param("rule_id")
+	sourceID, entityID, attributeEntityID, ok := a.qualityRuleScope(c)
+	if !ok {
+		return
+	}
+	req, ok := a.bindQualityRule(c, sourceID, entityID, attributeEntityID)
+	if !ok {
+		return
+	}
+
+	rule, err := a.store.UpdateQualityRule(sourceID, entityID, ruleID, req)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Quality rule not found: "+ruleID)
+			return
+		}
+		handleStoreError(c, err, "Quality Rule")
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (a *API) deleteQualityRuleHandler(c *gin.Context) {
+	ruleID := c.Param("rule_id")
+	err := a.store.DeleteQualityRule(c.Param("source_id"), c.Param("entity_id"), ruleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Quality rule not found: "+ruleID)
+			return
+		}
+		handleStoreError(c, err, "Quality Rule")
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// --- AttributeIndex Handlers ---
+
+// syncAttributeIndex starts building or dropping the index of an attribute that was created or updated.
+// The attribute is saved either way, so failures are only logged; the index status reports them.
+func (a *API) syncAttributeIndex(attribute AttributeDefinition) {
+	if _, err := a.store.SyncAttributeIndex(attribute, false); err != nil {
+		log.Printf("Failed to sync index of attribute %s: %v", attribute.ID, err)
+	}
+}
+
+// getAttributeIndexHandler returns the status of the index of an attribute.
+func (a *API) getAttributeIndexHandler(c *gin.Context) {
+	attributeID := c.Param("attribute_id")
+	index, err := a.store.GetAttributeIndex(c.Param("entity_id"), attributeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Attribute "+attributeID+" has no index; it was never filterable or indexed")
+			return
+		}
+		handleStoreError(c, err, "Attribute Index")
+		return
+	}
+	c.JSON(http.StatusOK, index)
+}
+
+// rebuildAttributeIndexHandler rebuilds the index of a filterable or indexed attribute, or drops it again if
+// the attribute is neither, e.g. after a failed build. The rebuild runs in the background.
+func (a *API) rebuildAttributeIndexHandler(c *gin.Context) {
+	attributeID := c.Param("attribute_id")
+	attribute, err := a.store.GetAttribute(c.Param("entity_id"), attributeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Attribute not found: "+attributeID)
+			return
+		}
+		handleStoreError(c, err, "Attribute")
+		return
+	}
+	index, err := a.store.SyncAttributeIndex(attribute, true)
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to rebuild attribute index: "+err.Error())
+		return
+	}
+	if index == nil {
+		handleAPIError(c, http.StatusBadRequest, "Attribute "+attributeID+" is neither filterable nor indexed")
+		return
+	}
+	c.JSON(http.StatusAccepted, index)
+}
+
+// listAttributeIndexesHandler returns the index statuses of the attributes of an entity definition.
+func (a *API) listAttributeIndexesHandler(c *gin.Context) {
+	indexes, err := a.store.ListAttributeIndexes(c.Param("entity_id"))
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to list attribute indexes: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{Data: indexes, Total: int64(len(indexes))})
+}
+
+// --- AttributePiiPolicy Handlers ---
+
+// getAttributePiiPolicyHandler returns the PII policy of an attribute.
+func (a *API) getAttributePiiPolicyHandler(c *gin.Context) {
+	attributeID := c.Param("attribute_id")
+	policy, err := a.store.GetAttributePiiPolicy(c.Param("entity_id"), attributeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Attribute "+attributeID+" has no PII policy")
+			return
+		}
+		handleStoreError(c, err, "Attribute PII Policy")
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetAttributePiiPolicyRequest is the body of PUT /entities/:entity_id/attributes/:attribute_id/pii-policy.
+type SetAttributePiiPolicyRequest struct {
+	Storage           PiiStorage `json:"storage" binding:"required"`
+	AuthorizedActions []string   `json:"authorized_actions"`
+}
+
+// setAttributePiiPolicyHandler sets how the values of a PII attribute are stored and which action types may
+// read them. A new storage applies to values processed from then on; stored values keep their form until
+// their records are ingested again.
+func (a *API) setAttributePiiPolicyHandler(c *gin.Context) {
+	entityID := c.Param("entity_id")
+	attributeID := c.Param("attribute_id")
+	var req SetAttributePiiPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if !req.Storage.IsValid() {
+		handleAPIError(c, http.StatusBadRequest, "Invalid storage '"+string(req.Storage)+"'. Must be 'as_is', 'hash', 'tokenize' or 'encrypt'.")
+		return
+	}
+	if req.Storage == PiiStorageHash && len(req.AuthorizedActions) > 0 {
+		handleAPIError(c, http.StatusBadRequest, "Hashed values cannot be revealed; authorized_actions must be empty")
+		return
+	}
+
+	attribute, err := a.store.GetAttribute(entityID, attributeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Attribute not found: "+attributeID)
+			return
+		}
+		handleStoreError(c, err, "Attribute")
+		return
+	}
+	if !attribute.IsPii {
+		handleAPIError(c, http.StatusBadRequest, "Attribute "+attributeID+" is not flagged is_pii")
+		return
+	}
+
+	policy, err := a.store.SetAttributePiiPolicy(AttributePiiPolicy{
+		AttributeID:       attributeID,
+		EntityID:          entityID,
+		Storage:           req.Storage,
+		AuthorizedActions: req.AuthorizedActions,
+	})
+	if err != nil {
+		handleStoreError(c, err, "Attribute PII Policy")
+		return
+	}
+	// Protected values are stored as text, which changes the index expression.
+	attribute.PiiPolicy = &policy
+	a.syncAttributeIndex(attribute)
+	c.JSON(http.StatusOK, policy)
+}
+
+// deleteAttributePiiPolicyHandler removes the PII policy of an attribute.
+func (a *API) deleteAttributePiiPolicyHandler(c *gin.Context) {
+	entityID := c.Param("entity_id")
+	attributeID := c.Param("attribute_id")
+	if err := a.store.DeleteAttributePiiPolicy(entityID, attributeID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, "Attribute "+attributeID+" has no PII policy")
+			return
+		}
+		handleStoreError(c, err, "Attribute PII Policy")
+		return
+	}
+	if attribute, err := a.store.GetAttribute(entityID, attributeID); err == nil {
+		a.syncAttributeIndex(attribute)
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// --- RetentionPolicy Handlers ---
+// The same handlers serve the policies of data sources (source_id path parameter) and of entity
+// definitions (entity_id path parameter).
+
+// retentionPolicyOwner names the data source or entity definition of a retention policy in messages.
+func retentionPolicyOwner(sourceID, entityID string) string {
+	if sourceID != "" {
+		return "Data source " + sourceID
+	}
+	return "Entity " + entityID
+}
+
+// getRetentionPolicyHandler returns the retention policy of a data source or entity definition.
+func (a *API) getRetentionPolicyHandler(c *gin.Context) {
+	sourceID, entityID := c.Param("source_id"), c.Param("entity_id")
+	policy, err := a.store.GetRetentionPolicy(sourceID, entityID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, retentionPolicyOwner(sourceID, entityID)+" has no retention policy")
+			return
+		}
+		handleStoreError(c, err, "Retention Policy")
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetRetentionPolicyRequest is the body of PUT /entities/:entity_id/retention-policy and
+// PUT /datasources/:source_id/retention-policy. Limits left out keep the data forever.
+type SetRetentionPolicyRequest struct {
+	EntityMaxAgeDays    *int `json:"entity_max_age_days"`
+	CalculationLogsKeep *int `json:"calculation_logs_keep"`
+	JobHistoryDays      *int `json:"job_history_days"`
+}
+
+// setRetentionPolicyHandler creates or replaces the retention policy of a data source or entity definition.
+// The janitors apply it on their next sweep.
+func (a *API) setRetentionPolicyHandler(c *gin.Context) {
+	sourceID, entityID, _, ok := a.qualityRuleScope(c)
+	if !ok {
+		return
+	}
+	var req SetRetentionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleAPIError(c, http.StatusBadRequest, "Invalid input: "+err.Error())
+		return
+	}
+	if req.EntityMaxAgeDays == nil && req.CalculationLogsKeep == nil && req.JobHistoryDays == nil {
+		handleAPIError(c, http.StatusBadRequest, "A retention policy needs at least one of entity_max_age_days, calculation_logs_keep and job_history_days")
+		return
+	}
+	limits := []struct {
+		name  string
+		value *int
+	}{{"entity_max_age_days", req.EntityMaxAgeDays}, {"calculation_logs_keep", req.CalculationLogsKeep}, {"job_history_days", req.JobHistoryDays}}
+	for _, limit := range limits {
+		if limit.value != nil && *limit.value < 1 {
+			handleAPIError(c, http.StatusBadRequest, limit.name+" must be at least 1")
+			return
+		}
+	}
+	if sourceID != "" && req.CalculationLogsKeep != nil {
+		handleAPIError(c, http.StatusBadRequest, "Groups belong to entity definitions; calculation_logs_keep can only be set on entity retention policies")
+		return
+	}
+
+	policy, err := a.store.SetRetentionPolicy(RetentionPolicy{
+		SourceID:            sourceID,
+		EntityID:            entityID,
+		EntityMaxAgeDays:    req.EntityMaxAgeDays,
+		CalculationLogsKeep: req.CalculationLogsKeep,
+		JobHistoryDays:      req.JobHistoryDays,
+	})
+	if err != nil {
+		handleStoreError(c, err, "Retention Policy")
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// deleteRetentionPolicyHandler removes the retention policy of a data source or entity definition.
+func (a *API) deleteRetentionPolicyHandler(c *gin.Context) {
+	sourceID, entityID := c.Param("source_id"), c.Param("entity_id")
+	if err := a.store.DeleteRetentionPolicy(sourceID, entityID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			handleAPIError(c, http.StatusNotFound, retentionPolicyOwner(sourceID, entityID)+" has no retention policy")
+			return
+		}
+		handleStoreError(c, err, "Retention Policy")
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// listRetentionPoliciesHandler returns the retention policies of all entity definitions and data sources.
+func (a *API) listRetentionPoliciesHandler(c *gin.Context) {
+	policies, err := a.store.ListRetentionPolicies()
+	if err != nil {
+		handleAPIError(c, http.StatusInternalServerError, "Failed to list retention policies: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{Data: policies, Total: int64(len(policies))})
+}
+
 // --- GroupDefinition Handlers ---
 
 // createGroupDefinitionHandler handles requests to create a new group definition.
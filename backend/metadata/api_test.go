@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"example.com/project/secrets"
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq" // PostgreSQL driver
 	"github.com/stretchr/testify/assert"
@@ -67,11 +68,18 @@ func clearAllTables(store *PostgresStore) error {
 		// but for initial schema creation, dependent tables come after their references.
 		"entity_relationship_definitions", // Depends on entities and attributes
 		"data_source_field_mappings",    // Depends on data_sources, entities, attributes
+		"data_quality_rules",            // Depends on data_sources, entities, attributes
+		"attribute_indexes",             // Depends on entities, attributes
+		"attribute_pii_policies",        // Depends on entities, attributes
+		"retention_policies",            // Depends on data_sources, entities
 		"group_definitions",             // Depends on entities
 		"attribute_definitions",         // Depends on entities
 		"schedule_definitions",          // May depend on other items via task_parameters
 		"workflow_definitions",          // May depend on other items via trigger_config/action_sequence
 		"action_templates",
+		"ingestion_watermarks",          // Depends on data_sources
+		"ingestion_runs",                // Depends on data_sources
+		"ingested_files",                // Depends on data_sources
 		"data_source_configs",           // May depend on entities
 		"entity_definitions",            // Base table
 	}
@@ -981,6 +989,66 @@ func TestUpdateDataSourceConfigHandler(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestDataSourceSecretRedaction(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	details := `{"host":"db","user":"ingest","password":"hunter2","auth":{"header":"Authorization","value":"Bearer abc"},"api_key":{"secret_ref":"env:API_KEY"}}`
+	createdDS, _ := testStore.CreateDataSource(DataSourceConfig{Name: "Secret DS", Type: "postgresql", ConnectionDetails: details})
+
+	w := performRequest(testRouter, "GET", "/api/v1/datasources/"+createdDS.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var ds DataSourceConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ds))
+	assert.NotContains(t, ds.ConnectionDetails, "hunter2")
+	assert.NotContains(t, ds.ConnectionDetails, "Bearer abc")
+	assert.Contains(t, ds.ConnectionDetails, `"secret_ref":"env:API_KEY"`, "secret references are not secret")
+	assert.Contains(t, ds.ConnectionDetails, `"user":"ingest"`)
+
+	w = performRequest(testRouter, "GET", "/api/v1/datasources/", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "hunter2")
+
+	// Sending the redacted details back keeps the stored secrets
+	payload, _ := json.Marshal(map[string]interface{}{"name": "Renamed DS", "type": "postgresql", "connection_details": strings.Replace(ds.ConnectionDetails, `"db"`, `"db2"`, 1)})
+	w = performRequest(testRouter, "PUT", "/api/v1/datasources/"+createdDS.ID, bytes.NewReader(payload), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "hunter2")
+
+	// Services read the unredacted details from the internal route
+	w = performRequest(testRouter, "GET", "/internal/v1/datasources/"+createdDS.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ds))
+	assert.Contains(t, ds.ConnectionDetails, `"password":"hunter2"`)
+	assert.Contains(t, ds.ConnectionDetails, `"value":"Bearer abc"`)
+	assert.Contains(t, ds.ConnectionDetails, `"host":"db2"`)
+}
+
+func TestDataSourceEncryptionAtRest(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	keys, err := NewKeyRing("k1", map[string][]byte{"k1": testMasterKey(1)})
+	require.NoError(t, err)
+	testStore.Keys = keys
+	defer func() { testStore.Keys = nil }()
+
+	details := `{"host":"db","password":"hunter2"}`
+	payload, _ := json.Marshal(map[string]interface{}{"name": "Encrypted DS", "type": "postgresql", "connection_details": details})
+	w := performRequest(testRouter, "POST", "/api/v1/datasources/", bytes.NewReader(payload), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var ds DataSourceConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ds))
+	assert.Equal(t, secrets.Redacted, ds.ConnectionDetails, "encrypted details are never returned by the public API")
+
+	// Sending the placeholder back keeps the stored details
+	payload, _ = json.Marshal(map[string]interface{}{"name": "Renamed Encrypted DS", "type": "postgresql", "connection_details": ds.ConnectionDetails})
+	w = performRequest(testRouter, "PUT", "/api/v1/datasources/"+ds.ID, bytes.NewReader(payload), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = performRequest(testRouter, "GET", "/internal/v1/datasources/"+ds.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ds))
+	assert.Equal(t, details, ds.ConnectionDetails)
+	assert.Equal(t, "Renamed Encrypted DS", ds.Name)
+}
+
 func TestDeleteDataSourceConfigHandler(t *testing.T) {
 	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
 	createdDS, _ := testStore.CreateDataSource(DataSourceConfig{Name: "DS To Delete", Type: "Temp", ConnectionDetails: "{}"})
@@ -997,6 +1065,143 @@ func TestDeleteDataSourceConfigHandler(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
+func TestIngestionWatermarkHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	createdDS, err := testStore.CreateDataSource(DataSourceConfig{Name: "Incremental DS", Type: "PostgreSQL", ConnectionDetails: "{}"})
+	require.NoError(t, err)
+	path := "/api/v1/datasources/" + createdDS.ID + "/watermark"
+
+	// No successful run yet
+	w := performRequest(testRouter, "GET", path, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// First watermark is created
+	w = performRequest(testRouter, "PUT", path, strings.NewReader(`{"watermark_column": "updated_at", "last_value": "2024-01-01T00:00:00Z"}`), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Advancing replaces the previous value
+	w = performRequest(testRouter, "PUT", path, strings.NewReader(`{"watermark_column": "updated_at", "last_value": "2024-02-01T00:00:00Z"}`), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(testRouter, "GET", path, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var wm IngestionWatermark
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &wm))
+	assert.Equal(t, createdDS.ID, wm.SourceID)
+	assert.Equal(t, "updated_at", wm.WatermarkColumn)
+	assert.Equal(t, "2024-02-01T00:00:00Z", wm.LastValue)
+
+	// Missing last_value
+	w = performRequest(testRouter, "PUT", path, strings.NewReader(`{"watermark_column": "updated_at"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Unknown data source violates the foreign key
+	w = performRequest(testRouter, "PUT", "/api/v1/datasources/nonexistent-ds-id/watermark", strings.NewReader(`{"watermark_column": "id", "last_value": "1"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Watermark is removed together with its data source
+	require.NoError(t, testStore.DeleteDataSource(createdDS.ID))
+	_, err = testStore.GetIngestionWatermark(createdDS.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestIngestionRunHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	createdDS, err := testStore.CreateDataSource(DataSourceConfig{Name: "Runs DS", Type: "CSV", ConnectionDetails: "{}"})
+	require.NoError(t, err)
+	basePath := "/api/v1/datasources/" + createdDS.ID + "/runs/"
+
+	// Create a run as the ingestion service does when triggered
+	w := performRequest(testRouter, "POST", basePath, strings.NewReader(`{"status": "running"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var run IngestionRun
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &run))
+	assert.NotEmpty(t, run.ID)
+	assert.Equal(t, createdDS.ID, run.SourceID)
+	assert.Equal(t, IngestionRunRunning, run.Status)
+	assert.Nil(t, run.FinishedAt)
+
+	// Finish it
+	payload := `{"status": "failed", "finished_at": "2024-01-01T00:05:00Z", "rows_read": 120, "rows_sent": 100, "rows_rejected": 3, "error": "batch 2 failed"}`
+	w = performRequest(testRouter, "PUT", basePath+run.ID, strings.NewReader(payload), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(testRouter, "GET", basePath+run.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var fetched IngestionRun
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	assert.Equal(t, IngestionRunFailed, fetched.Status)
+	require.NotNil(t, fetched.FinishedAt)
+	assert.Equal(t, int64(120), fetched.RowsRead)
+	assert.Equal(t, int64(100), fetched.RowsSent)
+	assert.Equal(t, int64(3), fetched.RowsRejected)
+	assert.Equal(t, "batch 2 failed", fetched.Error)
+	assert.WithinDuration(t, run.StartedAt, fetched.StartedAt, time.Second, "started_at must not change on update")
+
+	// Invalid status
+	w = performRequest(testRouter, "PUT", basePath+run.ID, strings.NewReader(`{"status": "exploded"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// List runs, most recent first
+	_, err = testStore.CreateIngestionRun(IngestionRun{SourceID: createdDS.ID})
+	require.NoError(t, err)
+	w = performRequest(testRouter, "GET", basePath, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(2), resp.Total)
+	var runs []IngestionRun
+	runsBytes, _ := json.Marshal(resp.Data)
+	require.NoError(t, json.Unmarshal(runsBytes, &runs))
+	require.Len(t, runs, 2)
+	assert.Equal(t, run.ID, runs[1].ID)
+
+	// Unknown run and unknown data source
+	w = performRequest(testRouter, "GET", basePath+"nonexistent-run-id", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = performRequest(testRouter, "GET", "/api/v1/datasources/nonexistent-ds-id/runs/", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestIngestedFileHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	createdDS, err := testStore.CreateDataSource(DataSourceConfig{Name: "Drop Folder", Type: "directory", ConnectionDetails: "{}"})
+	require.NoError(t, err)
+	basePath := "/api/v1/datasources/" + createdDS.ID + "/files/"
+
+	w := performRequest(testRouter, "POST", basePath, strings.NewReader(`{"file_name": "2024/01/a.csv", "size": 10, "checksum": "abc", "status": "processed"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = performRequest(testRouter, "POST", basePath, strings.NewReader(`{"file_name": "b.csv", "size": 3, "checksum": "def", "status": "error", "error": "bad header"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	// Recording the same content again replaces the entry
+	w = performRequest(testRouter, "POST", basePath, strings.NewReader(`{"file_name": "b.csv", "size": 3, "checksum": "def", "status": "processed"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = performRequest(testRouter, "POST", basePath, strings.NewReader(`{"file_name": "c.csv", "checksum": "123", "status": "unknown"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = performRequest(testRouter, "GET", basePath, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(2), resp.Total)
+
+	w = performRequest(testRouter, "GET", basePath+"?file_name=b.csv&checksum=def", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, int64(1), resp.Total)
+	var files []IngestedFile
+	filesBytes, _ := json.Marshal(resp.Data)
+	require.NoError(t, json.Unmarshal(filesBytes, &files))
+	assert.Equal(t, "processed", files[0].Status)
+	assert.Empty(t, files[0].Error)
+
+	w = performRequest(testRouter, "GET", basePath+"?file_name=b.csv&checksum=other", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(0), resp.Total)
+}
+
 // --- DataSourceFieldMapping Handler Tests (New) ---
 
 // setupPrerequisitesForFieldMappingTests creates an entity, attribute, and data source for field mapping tests.
@@ -1069,6 +1274,19 @@ func TestCreateFieldMappingHandler(t *testing.T) {
 	payloadMissingField := fmt.Sprintf(`{"entity_id": "%s", "attribute_id": "%s"}`, entity.ID, attribute.ID)
 	w = performRequest(testRouter, "POST", url, strings.NewReader(payloadMissingField), nil)
 	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Expression rules are validated when the mapping is created
+	rulePayload := func(rule string) io.Reader {
+		body, _ := json.Marshal(map[string]interface{}{"source_field_name": "source_col_3", "entity_id": entity.ID, "attribute_id": attribute.ID, "transformation_rule": rule})
+		return bytes.NewReader(body)
+	}
+	w = performRequest(testRouter, "POST", url, rulePayload(`concat(trim(first_name), " ", regex_replace(value, "[^0-9]", ""))`), nil)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	for _, rule := range []string{"shout(value)", `regex_extract(value, "(")`, `parse_date(value, "YYYY-MM-DD")`, "lower(value"} {
+		w = performRequest(testRouter, "POST", url, rulePayload(rule), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, rule)
+		assert.Contains(t, w.Body.String(), "Invalid transformation_rule", rule)
+	}
 }
 
 func TestListFieldMappingsHandler(t *testing.T) {
@@ -1248,6 +1466,104 @@ func TestDeleteFieldMappingHandler(t *testing.T) {
 
 // --- ScheduleDefinition Handler Tests (New) ---
 
+func TestQualityRuleHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	entity, err := testStore.CreateEntity("Customer", "Entity for quality rule tests", nil)
+	require.NoError(t, err)
+	email, err := testStore.CreateAttribute(entity.ID, "Email", BaseTypeString, nil, "", false, false, false)
+	require.NoError(t, err)
+	age, err := testStore.CreateAttribute(entity.ID, "Age", BaseTypeInteger, nil, "", false, false, false)
+	require.NoError(t, err)
+	createdDS, err := testStore.CreateDataSource(DataSourceConfig{Name: "Quality DS", Type: "CSV", ConnectionDetails: "{}", EntityID: entity.ID})
+	require.NoError(t, err)
+	sourcePath := "/api/v1/datasources/" + createdDS.ID + "/quality-rules/"
+	entityPath := "/api/v1/entities/" + entity.ID + "/quality-rules/"
+
+	// Create a data source rule
+	payload := fmt.Sprintf(`{"name": "Plausible age", "attribute_id": "%s", "rule_type": "range", "parameters": {"min": 0, "max": 120}, "action": "reject"}`, age.ID)
+	w := performRequest(testRouter, "POST", sourcePath, strings.NewReader(payload), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var rule DataQualityRule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+	assert.NotEmpty(t, rule.ID)
+	assert.Equal(t, createdDS.ID, rule.SourceID)
+	assert.Empty(t, rule.EntityID)
+	assert.Equal(t, QualityActionReject, rule.Action)
+	require.NotNil(t, rule.IsEnabled)
+	assert.True(t, *rule.IsEnabled, "rules are enabled by default")
+	assert.Equal(t, 120.0, rule.Parameters["max"])
+
+	// Create an entity rule; the action defaults to warn
+	payload = fmt.Sprintf(`{"name": "Unique email", "attribute_id": "%s", "rule_type": "unique"}`, email.ID)
+	w = performRequest(testRouter, "POST", entityPath, strings.NewReader(payload), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var entityRule DataQualityRule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entityRule))
+	assert.Equal(t, entity.ID, entityRule.EntityID)
+	assert.Equal(t, QualityActionWarn, entityRule.Action)
+
+	// Invalid rules
+	for name, body := range map[string]string{
+		"unknown rule type":           fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "sometimes"}`, email.ID),
+		"unknown action":              fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "not_null", "action": "panic"}`, email.ID),
+		"range on a string":           fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "range", "parameters": {"min": 1}}`, email.ID),
+		"range without bounds":        fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "range"}`, age.ID),
+		"invalid pattern":             fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "regex", "parameters": {"pattern": "("}}`, email.ID),
+		"reference without entity":    fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "reference_exists"}`, email.ID),
+		"null percentage too high":    fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "max_null_percentage", "parameters": {"max_percent": 101}}`, email.ID),
+		"null percentage rejects":     fmt.Sprintf(`{"name": "r", "attribute_id": "%s", "rule_type": "max_null_percentage", "parameters": {"max_percent": 5}, "action": "reject"}`, email.ID),
+		"attribute of another entity": `{"name": "r", "attribute_id": "nonexistent-attr-id", "rule_type": "not_null"}`,
+		"missing name":                fmt.Sprintf(`{"attribute_id": "%s", "rule_type": "not_null"}`, email.ID),
+	} {
+		w = performRequest(testRouter, "POST", sourcePath, strings.NewReader(body), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+
+	// Data sources without an entity cannot have rules
+	noEntityDS, err := testStore.CreateDataSource(DataSourceConfig{Name: "No Entity DS", Type: "CSV", ConnectionDetails: "{}"})
+	require.NoError(t, err)
+	payload = fmt.Sprintf(`{"name": "Email", "attribute_id": "%s", "rule_type": "not_null"}`, email.ID)
+	w = performRequest(testRouter, "POST", "/api/v1/datasources/"+noEntityDS.ID+"/quality-rules/", strings.NewReader(payload), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Update: disable the rule and make it fail the run
+	payload = fmt.Sprintf(`{"name": "Plausible age", "attribute_id": "%s", "rule_type": "range", "parameters": {"max": 150}, "action": "fail", "is_enabled": false}`, age.ID)
+	w = performRequest(testRouter, "PUT", sourcePath+rule.ID, strings.NewReader(payload), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = performRequest(testRouter, "GET", sourcePath+rule.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var fetched DataQualityRule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	assert.Equal(t, QualityActionFail, fetched.Action)
+	require.NotNil(t, fetched.IsEnabled)
+	assert.False(t, *fetched.IsEnabled)
+	assert.NotContains(t, fetched.Parameters, "min")
+
+	// Lists are scoped to the data source or entity
+	w = performRequest(testRouter, "GET", sourcePath, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Total)
+	w = performRequest(testRouter, "GET", entityPath, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(1), resp.Total)
+
+	// A rule is only reachable through its own data source or entity
+	w = performRequest(testRouter, "GET", entityPath+rule.ID, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = performRequest(testRouter, "GET", "/api/v1/datasources/nonexistent-ds-id/quality-rules/", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Delete
+	w = performRequest(testRouter, "DELETE", sourcePath+rule.ID, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = performRequest(testRouter, "DELETE", sourcePath+rule.ID, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func TestCreateScheduleDefinitionHandler(t *testing.T) {
 	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
 
@@ -1683,3 +1999,193 @@ func TestBulkDeleteEntitiesAPI(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestAttributeIndexHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	// processed_entities belongs to the processing service; a minimal version is enough to index.
+	_, err := testStore.DB.Exec(`CREATE TABLE IF NOT EXISTS processed_entities (id UUID PRIMARY KEY, entity_definition_id TEXT, attributes JSONB)`)
+	require.NoError(t, err)
+	entity, err := testStore.CreateEntity("Customer", "Entity for attribute index tests", nil)
+	require.NoError(t, err)
+	attributesPath := "/api/v1/entities/" + entity.ID + "/attributes/"
+
+	indexDef := func(name string) string {
+		var def string
+		require.NoError(t, testStore.DB.QueryRow(`SELECT COALESCE(MAX(indexdef), '') FROM pg_indexes WHERE indexname = $1`, name).Scan(&def))
+		return def
+	}
+	getIndex := func(attributeID string) AttributeIndex {
+		testStore.WaitForIndexJobs()
+		w := performRequest(testRouter, "GET", attributesPath+attributeID+"/index", nil, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var index AttributeIndex
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &index))
+		return index
+	}
+
+	// Creating a filterable attribute builds its index
+	w := performRequest(testRouter, "POST", attributesPath, strings.NewReader(`{"name": "Age", "data_type_name": "integer", "is_filterable": true}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var age AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &age))
+	index := getIndex(age.ID)
+	assert.Equal(t, IndexStatusReady, index.Status, index.Message)
+	assert.Equal(t, `((attributes->>'Age')::bigint)`, index.Expression)
+	assert.Contains(t, indexDef(index.IndexName), "::bigint")
+	assert.Contains(t, indexDef(index.IndexName), entity.ID)
+
+	// Attributes that were never flagged have no index
+	w = performRequest(testRouter, "POST", attributesPath, strings.NewReader(`{"name": "Notes", "data_type_name": "string"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var notes AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &notes))
+	testStore.WaitForIndexJobs()
+	w = performRequest(testRouter, "GET", attributesPath+notes.ID+"/index", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = performRequest(testRouter, "PUT", attributesPath+notes.ID+"/index", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Date values cannot be indexed with the cast the grouping service uses
+	w = performRequest(testRouter, "POST", attributesPath, strings.NewReader(`{"name": "Since", "data_type_name": "date", "is_indexed": true}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var since AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &since))
+	assert.Equal(t, IndexStatusUnsupported, getIndex(since.ID).Status)
+
+	w = performRequest(testRouter, "GET", "/api/v1/entities/"+entity.ID+"/indexes", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Data  []AttributeIndex `json:"data"`
+		Total int64            `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, int64(2), list.Total)
+
+	// Retyping rebuilds the index with the new cast, removing the flag drops it
+	w = performRequest(testRouter, "PUT", attributesPath+age.ID, strings.NewReader(`{"name": "Age", "data_type_name": "float", "is_indexed": true}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	index = getIndex(age.ID)
+	assert.Equal(t, IndexStatusReady, index.Status, index.Message)
+	assert.Contains(t, indexDef(index.IndexName), "::numeric")
+
+	w = performRequest(testRouter, "PUT", attributesPath+age.ID, strings.NewReader(`{"name": "Age", "data_type_name": "float"}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, IndexStatusDropped, getIndex(age.ID).Status)
+	assert.Empty(t, indexDef(index.IndexName))
+
+	// Rebuilding on request
+	w = performRequest(testRouter, "PUT", attributesPath+age.ID, strings.NewReader(`{"name": "Age", "data_type_name": "float", "is_filterable": true}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = performRequest(testRouter, "PUT", attributesPath+age.ID+"/index", nil, nil)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, IndexStatusReady, getIndex(age.ID).Status)
+
+	// Deleting the attribute drops its index
+	w = performRequest(testRouter, "DELETE", attributesPath+age.ID, nil, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	testStore.WaitForIndexJobs()
+	assert.Empty(t, indexDef(index.IndexName))
+}
+
+func TestAttributePiiPolicyHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	entity, err := testStore.CreateEntity("Customer", "Entity for PII policy tests", nil)
+	require.NoError(t, err)
+	attributesPath := "/api/v1/entities/" + entity.ID + "/attributes/"
+
+	w := performRequest(testRouter, "POST", attributesPath, strings.NewReader(`{"name": "Email", "data_type_name": "string", "is_pii": true}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var email AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &email))
+	policyPath := attributesPath + email.ID + "/pii-policy"
+
+	w = performRequest(testRouter, "GET", policyPath, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Setting a policy
+	w = performRequest(testRouter, "PUT", policyPath, strings.NewReader(`{"storage": "encrypt", "authorized_actions": ["email", " "]}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var policy AttributePiiPolicy
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.Equal(t, PiiStorageEncrypt, policy.Storage)
+	assert.Equal(t, []string{"EMAIL"}, policy.AuthorizedActions)
+
+	// The policy is returned with the attribute
+	w = performRequest(testRouter, "GET", attributesPath+email.ID, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var fetched AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	require.NotNil(t, fetched.PiiPolicy)
+	assert.Equal(t, PiiStorageEncrypt, fetched.PiiPolicy.Storage)
+
+	// Invalid policies
+	for _, body := range []string{`{"storage": "scramble"}`, `{"storage": "hash", "authorized_actions": ["EMAIL"]}`, `{}`} {
+		w = performRequest(testRouter, "PUT", policyPath, strings.NewReader(body), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	// Only PII attributes get a policy
+	w = performRequest(testRouter, "POST", attributesPath, strings.NewReader(`{"name": "Notes", "data_type_name": "string"}`), nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var notes AttributeDefinition
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &notes))
+	w = performRequest(testRouter, "PUT", attributesPath+notes.ID+"/pii-policy", strings.NewReader(`{"storage": "hash"}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Removing the policy
+	w = performRequest(testRouter, "DELETE", policyPath, nil, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = performRequest(testRouter, "DELETE", policyPath, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRetentionPolicyHandlers(t *testing.T) {
+	require.NoError(t, clearAllTables(testStore), "Failed to clear tables before test")
+	entity, err := testStore.CreateEntity("Customer", "Entity for retention policy tests", nil)
+	require.NoError(t, err)
+	ds, err := testStore.CreateDataSource(DataSourceConfig{Name: "Retention DS", Type: "csv", ConnectionDetails: "{}", EntityID: entity.ID})
+	require.NoError(t, err)
+	entityPolicyPath := "/api/v1/entities/" + entity.ID + "/retention-policy"
+	sourcePolicyPath := "/api/v1/datasources/" + ds.ID + "/retention-policy"
+
+	w := performRequest(testRouter, "GET", entityPolicyPath, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Setting policies
+	w = performRequest(testRouter, "PUT", entityPolicyPath, strings.NewReader(`{"entity_max_age_days": 90, "calculation_logs_keep": 10}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var policy RetentionPolicy
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+	assert.Equal(t, entity.ID, policy.EntityID)
+	require.NotNil(t, policy.CalculationLogsKeep)
+	assert.Equal(t, 10, *policy.CalculationLogsKeep)
+	assert.Nil(t, policy.JobHistoryDays)
+
+	w = performRequest(testRouter, "PUT", sourcePolicyPath, strings.NewReader(`{"job_history_days": 30}`), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = performRequest(testRouter, "GET", "/api/v1/retention-policies", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Data  []RetentionPolicy `json:"data"`
+		Total int64             `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.EqualValues(t, 2, list.Total)
+
+	// Invalid policies
+	for _, body := range []string{`{}`, `{"entity_max_age_days": 0}`, `{"job_history_days": -1}`} {
+		w = performRequest(testRouter, "PUT", entityPolicyPath, strings.NewReader(body), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	w = performRequest(testRouter, "PUT", sourcePolicyPath, strings.NewReader(`{"calculation_logs_keep": 3}`), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "groups belong to entity definitions")
+	w = performRequest(testRouter, "PUT", "/api/v1/entities/non-existent/retention-policy", strings.NewReader(`{"entity_max_age_days": 1}`), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Removing a policy
+	w = performRequest(testRouter, "DELETE", sourcePolicyPath, nil, nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = performRequest(testRouter, "DELETE", sourcePolicyPath, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,191 @@
+package metadata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Connection details are encrypted at rest with envelope encryption: every value gets its own
+// random data key, which encrypts the value with AES-256-GCM and is itself encrypted ("wrapped")
+// with a master key from the KeyRing. The stored form is
+//
+//	enc:v1:<master key ID>:<base64 wrapped data key>:<base64 ciphertext>
+//
+// where both base64 parts start with their GCM nonce. The ciphertext is bound to the data source
+// ID, so an encrypted value copied to another row does not decrypt.
+const encryptedPrefix = "enc:v1:"
+
+// masterKeySize is the size of master and data keys (AES-256).
+const masterKeySize = 32
+
+// ErrNoEncryptionKeys is returned when encrypted connection details are read from a store that has
+// no KeyRing.
+var ErrNoEncryptionKeys = errors.New("connection details are encrypted but no encryption keys are configured")
+
+// KeyRing holds the master keys for encrypting connection details. New values are encrypted with
+// the active key; the other keys are kept to decrypt values written before a key rotation.
+type KeyRing struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyRing creates a KeyRing from 32-byte master keys by key ID. activeID names the key used for
+// encryption.
+func NewKeyRing(activeID string, keys map[string][]byte) (*KeyRing, error) {
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid master key ID %q", id)
+		}
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes, got %d", id, masterKeySize, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active master key %q is not configured", activeID)
+	}
+	return &KeyRing{activeID: activeID, keys: keys}, nil
+}
+
+// LoadKeyRingFromEnv reads the master keys from METADATA_ENCRYPTION_KEYS, a comma-separated list
+// of "<key ID>:<base64 32-byte key>" entries, and the active key ID from
+// METADATA_ENCRYPTION_KEY_ID, which defaults to the last listed key. It returns nil when no keys
+// are configured, in which case connection details are stored in plaintext.
+func LoadKeyRingFromEnv() (*KeyRing, error) {
+	spec := strings.TrimSpace(os.Getenv("METADATA_ENCRYPTION_KEYS"))
+	if spec == "" {
+		return nil, nil
+	}
+	keys := make(map[string][]byte)
+	var lastID string
+	for i, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid METADATA_ENCRYPTION_KEYS entry %d: expected <key ID>:<base64 key>", i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 for master key %s: %w", id, err)
+		}
+		keys[id] = key
+		lastID = id
+	}
+	activeID := os.Getenv("METADATA_ENCRYPTION_KEY_ID")
+	if activeID == "" {
+		activeID = lastID
+	}
+	return NewKeyRing(activeID, keys)
+}
+
+// ActiveKeyID returns the ID of the master key used for encryption.
+func (k *KeyRing) ActiveKeyID() string {
+	return k.activeID
+}
+
+// Encrypt encrypts the connection details of the data source sourceID with a new data key wrapped
+// by the active master key.
+func (k *KeyRing) Encrypt(sourceID, plaintext string) (string, error) {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrappedKey, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(sourceID))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt connection details: %w", err)
+	}
+	return encryptedPrefix + k.activeID + ":" +
+		base64.StdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt returns the plaintext of a value produced by Encrypt for the same data source. Values
+// that are not encrypted, e.g. rows written before encryption was enabled, are returned unchanged.
+func (k *KeyRing) Decrypt(sourceID, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted connection details")
+	}
+	keyID := parts[0]
+	masterKey, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("master key %s is not configured", keyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed wrapped data key: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	dataKey, err := open(masterKey, wrappedKey, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key with master key %s: %w", keyID, err)
+	}
+	plaintext, err := open(dataKey, ciphertext, []byte(sourceID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt connection details: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether stored connection details are encrypted.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// EncryptionKeyID returns the ID of the master key that encrypted the value, or "" for plaintext.
+func EncryptionKeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return keyID
+}
+
+// seal encrypts plaintext with AES-GCM and returns the nonce followed by the ciphertext.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal.
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
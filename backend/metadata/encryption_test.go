@@ -0,0 +1,104 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMasterKey returns a deterministic 32-byte master key.
+func testMasterKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, masterKeySize)
+}
+
+func TestKeyRing(t *testing.T) {
+	keys, err := NewKeyRing("k1", map[string][]byte{"k1": testMasterKey(1)})
+	require.NoError(t, err)
+
+	t.Run("Round Trip", func(t *testing.T) {
+		encrypted, err := keys.Encrypt("source-1", `{"password":"hunter2"}`)
+		require.NoError(t, err)
+		assert.True(t, IsEncrypted(encrypted))
+		assert.Equal(t, "k1", EncryptionKeyID(encrypted))
+		assert.NotContains(t, encrypted, "hunter2")
+
+		decrypted, err := keys.Decrypt("source-1", encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, `{"password":"hunter2"}`, decrypted)
+
+		again, err := keys.Encrypt("source-1", `{"password":"hunter2"}`)
+		require.NoError(t, err)
+		assert.NotEqual(t, encrypted, again, "every encryption uses a new data key and nonce")
+	})
+
+	t.Run("Plaintext Passes Through", func(t *testing.T) {
+		decrypted, err := keys.Decrypt("source-1", `{"host":"db"}`)
+		require.NoError(t, err)
+		assert.Equal(t, `{"host":"db"}`, decrypted)
+		assert.Equal(t, "", EncryptionKeyID(`{"host":"db"}`))
+	})
+
+	t.Run("Bound To Data Source", func(t *testing.T) {
+		encrypted, err := keys.Encrypt("source-1", "secret")
+		require.NoError(t, err)
+		_, err = keys.Decrypt("source-2", encrypted)
+		assert.Error(t, err)
+	})
+
+	t.Run("Tampered Ciphertext", func(t *testing.T) {
+		encrypted, err := keys.Encrypt("source-1", "secret")
+		require.NoError(t, err)
+		parts := strings.Split(encrypted, ":")
+		ciphertext, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+		require.NoError(t, err)
+		ciphertext[len(ciphertext)-1] ^= 0xff
+		parts[len(parts)-1] = base64.StdEncoding.EncodeToString(ciphertext)
+		_, err = keys.Decrypt("source-1", strings.Join(parts, ":"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown Key", func(t *testing.T) {
+		other, err := NewKeyRing("k2", map[string][]byte{"k2": testMasterKey(2)})
+		require.NoError(t, err)
+		encrypted, err := keys.Encrypt("source-1", "secret")
+		require.NoError(t, err)
+		_, err = other.Decrypt("source-1", encrypted)
+		assert.ErrorContains(t, err, "master key k1 is not configured")
+	})
+
+	t.Run("Invalid Keys", func(t *testing.T) {
+		_, err := NewKeyRing("k1", map[string][]byte{"k1": []byte("short")})
+		assert.Error(t, err)
+		_, err = NewKeyRing("k2", map[string][]byte{"k1": testMasterKey(1)})
+		assert.Error(t, err)
+		_, err = NewKeyRing("a:b", map[string][]byte{"a:b": testMasterKey(1)})
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadKeyRingFromEnv(t *testing.T) {
+	t.Setenv("METADATA_ENCRYPTION_KEYS", "")
+	keys, err := LoadKeyRingFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, keys)
+
+	k1 := base64.StdEncoding.EncodeToString(testMasterKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testMasterKey(2))
+	t.Setenv("METADATA_ENCRYPTION_KEYS", "k1:"+k1+", k2:"+k2)
+	keys, err = LoadKeyRingFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keys.ActiveKeyID())
+
+	t.Setenv("METADATA_ENCRYPTION_KEY_ID", "k1")
+	keys, err = LoadKeyRingFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keys.ActiveKeyID())
+
+	t.Setenv("METADATA_ENCRYPTION_KEYS", k1)
+	_, err = LoadKeyRingFromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Attributes flagged IsFilterable or IsIndexed get an expression index on the processed_entities table of
+// the processing service, which lives in the same database. The index covers only the instances of the
+// attribute's entity definition and casts the value the way the grouping service does in its queries, so
+// that the planner can use it for group rules:
+//
+//	CREATE INDEX CONCURRENTLY idx_processed_entities_attr_<id> ON processed_entities (((attributes->>'Age')::bigint))
+//	    WHERE entity_definition_id = '<entity id>'
+//
+// Indexes are built and dropped in the background; their progress is tracked in attribute_indexes.
+
+// processedEntitiesTable is the table of the processing service holding the entity instances.
+const processedEntitiesTable = "processed_entities"
+
+// indexJobs runs the index builds and drops of a store, one at a time per attribute.
+type indexJobs struct {
+	running sync.WaitGroup
+	locks   sync.Map // *sync.Mutex by attribute ID
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// attributeIndexName derives the index name from the attribute ID, so it survives renames.
+func attributeIndexName(attributeID string) string {
+	name := "idx_processed_entities_attr_" + strings.ToLower(nonAlphanumeric.ReplaceAllString(attributeID, ""))
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// attributeIndexExpression returns the indexed expression for an attribute, or the reason why values of its
+// data type cannot be indexed.
+func attributeIndexExpression(attr AttributeDefinition) (string, string) {
+	value := "(attributes->>" + pq.QuoteLiteral(attr.Name) + ")"
+	if attr.IsPii && attr.PiiPolicy != nil && attr.PiiPolicy.Storage != PiiStorageAsIs {
+		// Hashed, tokenized and encrypted values are stored as text, whatever the data type.
+		return "(" + value + ")", ""
+	}
+	switch attr.DataTypeName {
+	case BaseTypeInteger:
+		return "(" + value + "::bigint)", ""
+	case BaseTypeFloat:
+		return "(" + value + "::numeric)", ""
+	case BaseTypeBoolean:
+		return "(" + value + "::boolean)", ""
+	case BaseTypeString, BaseTypeEnum, BaseTypeReference, BaseTypeTime:
+		return "(" + value + ")", ""
+	case BaseTypeDate, BaseTypeDateTime:
+		return "", fmt.Sprintf("%s values are queried as timestamptz, and casting text to timestamptz depends on the session time zone, so PostgreSQL cannot index it", attr.DataTypeName)
+	default:
+		return "", fmt.Sprintf("%s values are not filtered on by the grouping service", attr.DataTypeName)
+	}
+}
+
+// SyncAttributeIndex brings the index of an attribute in line with its flags and definition after it was
+// created or updated, and returns its status. A flagged attribute without a current index gets one built; the
+// index of an attribute whose flags were removed is dropped. Renaming an attribute or changing its data type
+// rebuilds its index. With rebuild set, the index is rebuilt even if it is current, e.g. to retry a failed build.
+// Attributes that were never flagged have no index status and nil is returned.
+func (s *PostgresStore) SyncAttributeIndex(attr AttributeDefinition, rebuild bool) (*AttributeIndex, error) {
+	current, err := s.GetAttributeIndex(attr.EntityID, attr.ID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("SyncAttributeIndex failed: %w", err)
+	}
+
+	idx := AttributeIndex{AttributeID: attr.ID, EntityID: attr.EntityID, IndexName: attributeIndexName(attr.ID), CreatedAt: current.CreatedAt}
+	if !attr.IsFilterable && !attr.IsIndexed {
+		if !exists {
+			return nil, nil
+		}
+		if current.Status == IndexStatusDropped && !rebuild {
+			return &current, nil
+		}
+		idx.Expression = current.Expression
+		idx.Status = IndexStatusDropping
+	} else if expression, reason := attributeIndexExpression(attr); reason != "" {
+		if exists && current.Status == IndexStatusUnsupported && current.Message == reason && !rebuild {
+			return &current, nil
+		}
+		idx.Status = IndexStatusUnsupported
+		idx.Message = reason
+	} else {
+		switch current.Status {
+		case IndexStatusPending, IndexStatusBuilding, IndexStatusReady:
+			if exists && current.Expression == expression && !rebuild {
+				return &current, nil
+			}
+		}
+		idx.Expression = expression
+		idx.Status = IndexStatusPending
+	}
+
+	saved, err := s.saveAttributeIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+	s.startIndexJob(saved.EntityID, saved.AttributeID, "")
+	return &saved, nil
+}
+
+// DropAttributeIndex drops the index of a deleted attribute in the background. Its status was deleted with
+// the attribute.
+func (s *PostgresStore) DropAttributeIndex(idx AttributeIndex) {
+	s.startIndexJob(idx.EntityID, idx.AttributeID, idx.IndexName)
+}
+
+// WaitForIndexJobs blocks until the running index builds and drops are done.
+func (s *PostgresStore) WaitForIndexJobs() {
+	s.indexJobs.running.Wait()
+}
+
+// startIndexJob carries out the pending change of the index of an attribute, or only drops the index
+// dropIndexName if set. Jobs of the same attribute run one after the other and each acts on the latest
+// status, so a job started while another one builds the index sees the outcome of the later change.
+func (s *PostgresStore) startIndexJob(entityID, attributeID, dropIndexName string) {
+	s.indexJobs.running.Add(1)
+	go func() {
+		defer s.indexJobs.running.Done()
+		lock, _ := s.indexJobs.locks.LoadOrStore(attributeID, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		var err error
+		if dropIndexName != "" {
+			err = s.dropIndex(dropIndexName)
+		} else {
+			err = s.runIndexJob(entityID, attributeID)
+		}
+		if err != nil {
+			log.Printf("Index job for attribute %s failed: %v", attributeID, err)
+		}
+	}()
+}
+
+func (s *PostgresStore) runIndexJob(entityID, attributeID string) error {
+	idx, err := s.GetAttributeIndex(entityID, attributeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // The attribute was deleted
+	}
+	if err != nil {
+		return err
+	}
+
+	switch idx.Status {
+	case IndexStatusPending:
+		if ok, err := s.setAttributeIndexStatus(idx, IndexStatusPending, IndexStatusBuilding, ""); !ok || err != nil {
+			return err
+		}
+		if buildErr := s.buildIndex(idx); buildErr != nil {
+			_, err = s.setAttributeIndexStatus(idx, IndexStatusBuilding, IndexStatusFailed, buildErr.Error())
+			return errors.Join(buildErr, err)
+		}
+		_, err = s.setAttributeIndexStatus(idx, IndexStatusBuilding, IndexStatusReady, "")
+		if err == nil {
+			log.Printf("Built index %s on %s for attribute %s.", idx.IndexName, processedEntitiesTable, attributeID)
+		}
+		return err
+	case IndexStatusDropping:
+		if dropErr := s.dropIndex(idx.IndexName); dropErr != nil {
+			_, err = s.setAttributeIndexStatus(idx, IndexStatusDropping, IndexStatusFailed, "failed to drop index: "+dropErr.Error())
+			return errors.Join(dropErr, err)
+		}
+		_, err = s.setAttributeIndexStatus(idx, IndexStatusDropping, IndexStatusDropped, "")
+		return err
+	case IndexStatusUnsupported:
+		// The attribute may have been indexed before its data type changed.
+		return s.dropIndex(idx.IndexName)
+	}
+	return nil
+}
+
+// buildIndex creates the index of an attribute without blocking writes to processed_entities. An index
+// left invalid by an interrupted build, or built with an older expression, is replaced.
+func (s *PostgresStore) buildIndex(idx AttributeIndex) error {
+	var tableExists bool
+	if err := s.DB.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, processedEntitiesTable).Scan(&tableExists); err != nil {
+		return fmt.Errorf("failed to look up table %s: %w", processedEntitiesTable, err)
+	}
+	if !tableExists {
+		return fmt.Errorf("table %s does not exist yet; start the processing service and rebuild the index", processedEntitiesTable)
+	}
+	if err := s.dropIndex(idx.IndexName); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE INDEX CONCURRENTLY %s ON %s (%s) WHERE entity_definition_id = %s",
+		pq.QuoteIdentifier(idx.IndexName), processedEntitiesTable, idx.Expression, pq.QuoteLiteral(idx.EntityID))
+	if _, err := s.DB.Exec(query); err != nil {
+		// A failed concurrent build leaves an invalid index behind.
+		if dropErr := s.dropIndex(idx.IndexName); dropErr != nil {
+			log.Printf("Failed to drop invalid index %s: %v", idx.IndexName, dropErr)
+		}
+		return fmt.Errorf("failed to create index %s: %w", idx.IndexName, err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) dropIndex(indexName string) error {
+	if _, err := s.DB.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + pq.QuoteIdentifier(indexName)); err != nil {
+		return fmt.Errorf("failed to drop index %s: %w", indexName, err)
+	}
+	return nil
+}
+
+// setAttributeIndexStatus moves the index status of an attribute from one status to another. It reports
+// false if the status or expression changed in the meantime, in which case a later job handles the index.
+func (s *PostgresStore) setAttributeIndexStatus(idx AttributeIndex, from, to AttributeIndexStatus, message string) (bool, error) {
+	result, err := s.DB.Exec(`UPDATE attribute_indexes SET status = $1, message = $2, updated_at = $3
+              WHERE attribute_id = $4 AND status = $5 AND COALESCE(expression, '') = $6`,
+		string(to), sql.NullString{String: message, Valid: message != ""}, time.Now().UTC(), idx.AttributeID, string(from), idx.Expression)
+	if err != nil {
+		return false, fmt.Errorf("setAttributeIndexStatus failed: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("setAttributeIndexStatus failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
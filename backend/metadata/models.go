@@ -280,6 +280,10 @@ type AttributeDefinition struct {
 	// IsPii indicates whether this attribute contains Personally Identifiable Information (PII).
 	// This can be used for data governance and privacy considerations.
 	IsPii bool `json:"is_pii"`
+	// PiiPolicy is how the processing service stores the values of this attribute and which action executors may
+	// read them. Only set on IsPii attributes that have a policy; the values of the others are stored as-is and
+	// redacted for every executor.
+	PiiPolicy *AttributePiiPolicy `json:"pii_policy,omitempty"`
 	// IsIndexed indicates whether this attribute should be indexed in the underlying data store for faster lookups.
 	IsIndexed bool `json:"is_indexed"`
 	// Metadata allows for storing arbitrary key-value pairs for user-defined extensions,
@@ -317,6 +321,75 @@ type DataSourceConfig struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// IngestionWatermark stores the position reached by incremental (watermark-based) ingestion of a data source.
+// It is only advanced by the ingestion service after a run's records have been processed successfully.
+type IngestionWatermark struct {
+	// SourceID is the foreign key referencing the DataSourceConfig this watermark belongs to.
+	SourceID string `json:"source_id"`
+	// WatermarkColumn is the source column the watermark was taken from (e.g., "updated_at").
+	// A watermark recorded for a different column than the one currently configured is ignored.
+	WatermarkColumn string `json:"watermark_column" binding:"required"`
+	// LastValue is the highest value of WatermarkColumn seen in the last successful run, in its text form.
+	LastValue string `json:"last_value" binding:"required"`
+	// UpdatedAt records the timestamp (UTC) when the watermark was last advanced.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IngestionRunStatus is the lifecycle state of an ingestion run.
+type IngestionRunStatus string
+
+const (
+	// IngestionRunRunning indicates the run has been accepted and is reading or sending records.
+	IngestionRunRunning IngestionRunStatus = "running"
+	// IngestionRunSucceeded indicates every record read was handed to the processing service.
+	IngestionRunSucceeded IngestionRunStatus = "succeeded"
+	// IngestionRunFailed indicates the run stopped early. Batches sent before the failure are kept.
+	IngestionRunFailed IngestionRunStatus = "failed"
+)
+
+// IngestionRun records a single execution of the ingestion pipeline for a data source.
+// Runs are created by the ingestion service when an ingestion is triggered and updated as it progresses.
+type IngestionRun struct {
+	// ID is the unique identifier for the run (e.g., a UUID). It is returned by the trigger endpoint as the job ID.
+	ID string `json:"id"`
+	// SourceID is the foreign key referencing the DataSourceConfig that was ingested.
+	SourceID string `json:"source_id"`
+	// Status is the current state of the run: "running", "succeeded" or "failed".
+	Status IngestionRunStatus `json:"status"`
+	// StartedAt records the timestamp (UTC) when the run started.
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt records the timestamp (UTC) when the run reached a final status. It is nil while running.
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// RowsRead is the number of records read from the data source.
+	RowsRead int64 `json:"rows_read"`
+	// RowsSent is the number of records accepted by the processing service.
+	RowsSent int64 `json:"rows_sent"`
+	// RowsRejected is the number of records that could not be read or were not stored by the processing service.
+	RowsRejected int64 `json:"rows_rejected"`
+	// Error holds the reason a failed run stopped.
+	Error string `json:"error,omitempty"`
+}
+
+// IngestedFile is an entry of the processed-file ledger of a directory data source.
+// A file is identified by its name and checksum, so a file that is replaced with new content under the same
+// name is ingested again.
+type IngestedFile struct {
+	// SourceID is the foreign key referencing the directory DataSourceConfig the file was read from.
+	SourceID string `json:"source_id"`
+	// FileName is the path of the file relative to the source directory.
+	FileName string `json:"file_name" binding:"required"`
+	// Size is the file size in bytes.
+	Size int64 `json:"size"`
+	// Checksum is the hex-encoded SHA-256 digest of the file content.
+	Checksum string `json:"checksum" binding:"required"`
+	// Status is "processed" when the file was ingested, or "error" when it could not be read.
+	Status string `json:"status" binding:"required"`
+	// Error holds the reason the file could not be read.
+	Error string `json:"error,omitempty"`
+	// IngestedAt records the timestamp (UTC) when the file was recorded in the ledger.
+	IngestedAt time.Time `json:"ingested_at"`
+}
+
 // DataSourceFieldMapping represents the mapping between a field in an external data source
 // and a specific attribute of an entity definition in the metadata system.
 type DataSourceFieldMapping struct {
@@ -331,8 +404,9 @@ type DataSourceFieldMapping struct {
 	EntityID string `json:"entity_id"`
 	// AttributeID is the foreign key referencing the AttributeDefinition that the source field maps to.
 	AttributeID string `json:"attribute_id"`
-	// TransformationRule is an optional rule or expression defining how to transform the source field's data
-	// before it's mapped to the attribute (e.g., "lowercase", "trim", "multiply_by_100").
+	// TransformationRule is an optional expression defining how to transform the source field's data
+	// before it's mapped to the attribute (e.g., "trim(value)", `concat(first_name, " ", last_name)`).
+	// It is validated when the mapping is saved; see transform_rules.go for the language.
 	TransformationRule string `json:"transformation_rule,omitempty"`
 	// Metadata allows for storing arbitrary key-value pairs for user-defined extensions,
 	// custom attributes, or annotations related to this field mapping.
@@ -343,6 +417,191 @@ type DataSourceFieldMapping struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// QualityRuleType is the kind of check a DataQualityRule performs on the values of its attribute.
+type QualityRuleType string
+
+const (
+	// QualityRuleNotNull requires a value. Empty strings count as null.
+	QualityRuleNotNull QualityRuleType = "not_null"
+	// QualityRuleUnique requires the value to differ from the values of all other instances of the entity.
+	QualityRuleUnique QualityRuleType = "unique"
+	// QualityRuleRange requires a number or date between the "min" and/or "max" parameters (inclusive).
+	// Date bounds are given as RFC 3339 or YYYY-MM-DD strings.
+	QualityRuleRange QualityRuleType = "range"
+	// QualityRuleRegex requires the value to match the regular expression in the "pattern" parameter.
+	QualityRuleRegex QualityRuleType = "regex"
+	// QualityRuleReferenceExists requires the value to identify a stored instance of the entity in the
+	// "referenced_entity_id" parameter, or of the entity the reference attribute points to.
+	QualityRuleReferenceExists QualityRuleType = "reference_exists"
+	// QualityRuleMaxNullPercentage limits the share of null values in a batch to the "max_percent" parameter (0-100).
+	QualityRuleMaxNullPercentage QualityRuleType = "max_null_percentage"
+)
+
+// QualityRuleAction is what the processing service does when a DataQualityRule is violated.
+type QualityRuleAction string
+
+const (
+	// QualityActionWarn stores the record and only counts the violation in the run's scorecard.
+	QualityActionWarn QualityRuleAction = "warn"
+	// QualityActionReject quarantines the violating record instead of storing it.
+	QualityActionReject QualityRuleAction = "reject"
+	// QualityActionFail stores nothing of the batch and fails the run.
+	QualityActionFail QualityRuleAction = "fail"
+)
+
+// DataQualityRule is a declarative check the processing service evaluates on every batch of records.
+// A rule is attached either to a data source, applying to the records ingested from it, or to an entity
+// definition, applying to the records of every data source that maps to it.
+type DataQualityRule struct {
+	// ID is the unique identifier for the rule (e.g., a UUID).
+	ID string `json:"id"`
+	// SourceID is the foreign key referencing the DataSourceConfig the rule is attached to. Empty for entity rules.
+	SourceID string `json:"source_id,omitempty"`
+	// EntityID is the foreign key referencing the EntityDefinition the rule is attached to. Empty for data source rules.
+	EntityID string `json:"entity_id,omitempty"`
+	// Name is a user-defined, human-readable name for the rule, shown in scorecards.
+	Name string `json:"name" binding:"required"`
+	// AttributeID is the foreign key referencing the AttributeDefinition whose values are checked.
+	// For data source rules it must belong to the data source's entity definition.
+	AttributeID string `json:"attribute_id" binding:"required"`
+	// RuleType is the kind of check, see QualityRuleType.
+	RuleType QualityRuleType `json:"rule_type" binding:"required"`
+	// Parameters holds the settings of the rule type, e.g. `{"min": 0, "max": 120}` for "range".
+	Parameters map[string]interface{} `json:"parameters,omitempty" gorm:"type:jsonb"`
+	// Action is taken when the rule is violated: "warn" (the default), "reject" or "fail".
+	// "max_null_percentage" judges a whole batch and cannot reject single records.
+	Action QualityRuleAction `json:"action"`
+	// IsEnabled indicates whether the processing service evaluates the rule. Defaults to true on creation.
+	IsEnabled *bool `json:"is_enabled,omitempty"`
+	// Description provides an optional, more detailed explanation of the rule.
+	Description string `json:"description,omitempty"`
+	// CreatedAt records the timestamp (UTC) when this rule was first created.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt records the timestamp (UTC) when this rule was last modified.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AttributeIndexStatus is the state of the expression index on processed_entities of an attribute.
+type AttributeIndexStatus string
+
+const (
+	// IndexStatusPending means the index is to be built.
+	IndexStatusPending AttributeIndexStatus = "pending"
+	// IndexStatusBuilding means CREATE INDEX CONCURRENTLY is running.
+	IndexStatusBuilding AttributeIndexStatus = "building"
+	// IndexStatusReady means the index is built and valid.
+	IndexStatusReady AttributeIndexStatus = "ready"
+	// IndexStatusFailed means building the index failed; Message holds the error.
+	IndexStatusFailed AttributeIndexStatus = "failed"
+	// IndexStatusDropping means the attribute is no longer filterable or indexed and the index is being dropped.
+	IndexStatusDropping AttributeIndexStatus = "dropping"
+	// IndexStatusDropped means the index was dropped.
+	IndexStatusDropped AttributeIndexStatus = "dropped"
+	// IndexStatusUnsupported means the attribute is flagged, but values of its data type cannot be indexed
+	// with the cast the grouping service queries them with.
+	IndexStatusUnsupported AttributeIndexStatus = "unsupported"
+)
+
+// AttributeIndex tracks the expression index on processed_entities that speeds up filtering the instances of
+// an entity definition by an attribute flagged IsFilterable or IsIndexed. It is created when the attribute is
+// created or flagged and dropped when the flags are removed.
+type AttributeIndex struct {
+	// AttributeID is the foreign key referencing the indexed AttributeDefinition.
+	AttributeID string `json:"attribute_id"`
+	// EntityID is the foreign key referencing the EntityDefinition the attribute belongs to.
+	EntityID string `json:"entity_id"`
+	// IndexName is the name of the index in the processing database.
+	IndexName string `json:"index_name"`
+	// Expression is the indexed expression, e.g. `((attributes->>'Age')::bigint)`.
+	Expression string `json:"expression,omitempty"`
+	// Status is the state of the index, see AttributeIndexStatus.
+	Status AttributeIndexStatus `json:"status"`
+	// Message explains a "failed" or "unsupported" status.
+	Message string `json:"message,omitempty"`
+	// CreatedAt records the timestamp (UTC) when the attribute was first flagged.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt records the timestamp (UTC) of the last status change.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PiiStorage is how the processing service stores the values of a PII attribute in processed_entities.
+type PiiStorage string
+
+const (
+	// PiiStorageAsIs stores values in cleartext. They are still redacted in logs, API responses and task messages.
+	PiiStorageAsIs PiiStorage = "as_is"
+	// PiiStorageHash stores a keyed hash (HMAC-SHA256) of values. Equal values hash equally, but cannot be read back.
+	PiiStorageHash PiiStorage = "hash"
+	// PiiStorageTokenize stores a token per distinct value and keeps the encrypted value in the token vault of the
+	// processing service. Equal values get the same token.
+	PiiStorageTokenize PiiStorage = "tokenize"
+	// PiiStorageEncrypt stores values encrypted with AES-256-GCM.
+	PiiStorageEncrypt PiiStorage = "encrypt"
+)
+
+// IsValid reports whether s is a known PII storage.
+func (s PiiStorage) IsValid() bool {
+	switch s {
+	case PiiStorageAsIs, PiiStorageHash, PiiStorageTokenize, PiiStorageEncrypt:
+		return true
+	}
+	return false
+}
+
+// AttributePiiPolicy governs the values of an attribute flagged IsPii: the processing service stores them as
+// Storage says, and only reveals them to the action executors of the AuthorizedActions types.
+type AttributePiiPolicy struct {
+	// AttributeID is the foreign key referencing the governed AttributeDefinition.
+	AttributeID string `json:"attribute_id"`
+	// EntityID is the foreign key referencing the EntityDefinition the attribute belongs to.
+	EntityID string `json:"entity_id"`
+	// Storage is how values are stored, see PiiStorage.
+	Storage PiiStorage `json:"storage"`
+	// AuthorizedActions lists the action types (e.g. "EMAIL", "WEBHOOK") whose executors may read the cleartext
+	// values. Hashed values cannot be read by anyone.
+	AuthorizedActions []string `json:"authorized_actions"`
+	// CreatedAt records the timestamp (UTC) when the policy was first set.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt records the timestamp (UTC) when the policy was last changed.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RetentionPolicy limits how long the data of an entity definition or of a data source is kept. A policy is
+// attached either to an entity definition or to a data source; where both cover the same rows, the shorter
+// limit applies. Limits left unset keep the data forever. The janitors of the metadata, processing and grouping
+// services enforce the limits in the background.
+type RetentionPolicy struct {
+	// ID is the unique identifier for the policy (e.g., a UUID).
+	ID string `json:"id"`
+	// SourceID is the foreign key referencing the DataSourceConfig the policy is attached to. Empty for entity policies.
+	SourceID string `json:"source_id,omitempty"`
+	// EntityID is the foreign key referencing the EntityDefinition the policy is attached to. Empty for data source policies.
+	EntityID string `json:"entity_id,omitempty"`
+	// EntityMaxAgeDays deletes the processed entity instances that were not refreshed by processing within this
+	// many days, together with their history. For data source policies, only the instances last processed from
+	// the data source are deleted.
+	EntityMaxAgeDays *int `json:"entity_max_age_days,omitempty"`
+	// CalculationLogsKeep keeps only this many of the most recent calculation logs of each group of the entity
+	// definition. Entity policies only.
+	CalculationLogsKeep *int `json:"calculation_logs_keep,omitempty"`
+	// JobHistoryDays deletes the finished ingestion runs that started more than this many days ago. For entity
+	// policies, the runs of all data sources mapped to the entity definition are deleted.
+	JobHistoryDays *int `json:"job_history_days,omitempty"`
+	// CreatedAt records the timestamp (UTC) when the policy was first set.
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt records the timestamp (UTC) when the policy was last changed.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RetentionPurge reports the rows a retention janitor deleted from one table under one policy in a sweep.
+type RetentionPurge struct {
+	PolicyID string `json:"policy_id"`
+	SourceID string `json:"source_id,omitempty"`
+	EntityID string `json:"entity_id,omitempty"`
+	Table    string `json:"table"`
+	Rows     int64  `json:"rows"`
+}
+
 // GroupDefinition defines the structure for a group of entities based on a set of rules or criteria.
 // Groups can be used for various purposes, such as segmentation, policy application, or triggering workflows.
 type GroupDefinition struct {
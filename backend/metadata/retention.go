@@ -0,0 +1,88 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Retention policies are enforced by a janitor in each service owning the data they limit: the metadata service
+// deletes old ingestion runs (JobHistoryDays), the processing service deletes stale entity instances
+// (EntityMaxAgeDays) and the grouping service trims calculation logs (CalculationLogsKeep). The janitors delete
+// in batches of a bounded number of rows, so that a sweep never locks much of a table at once, and log what
+// they purged.
+
+// DefaultRetentionBatchSize is the number of rows a retention janitor deletes per statement.
+const DefaultRetentionBatchSize = 500
+
+// PurgeIngestionRuns deletes the finished ingestion runs that started more than JobHistoryDays before now, at most
+// batchSize rows per statement, and reports the runs deleted per policy. Runs still in progress are kept. A policy
+// that fails does not stop the others; the errors are returned together.
+func (s *PostgresStore) PurgeIngestionRuns(now time.Time, batchSize int) ([]RetentionPurge, error) {
+	policies, err := s.ListRetentionPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("PurgeIngestionRuns failed: %w", err)
+	}
+	purges := []RetentionPurge{}
+	var errs []error
+	for _, policy := range policies {
+		if policy.JobHistoryDays == nil {
+			continue
+		}
+		scope, scopeID := "source_id = $1", policy.SourceID
+		if policy.SourceID == "" {
+			scope, scopeID = "source_id IN (SELECT id FROM data_source_configs WHERE entity_id = $1)", policy.EntityID
+		}
+		query := `DELETE FROM ingestion_runs WHERE id IN (
+                  SELECT id FROM ingestion_runs WHERE ` + scope + ` AND finished_at IS NOT NULL AND started_at < $2
+                  ORDER BY started_at LIMIT $3)`
+		cutoff := now.UTC().AddDate(0, 0, -*policy.JobHistoryDays)
+
+		var total int64
+		for {
+			result, err := s.DB.Exec(query, scopeID, cutoff, batchSize)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("PurgeIngestionRuns failed for retention policy %s: %w", policy.ID, err))
+				break
+			}
+			deleted, err := result.RowsAffected()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("PurgeIngestionRuns failed to get rows affected for retention policy %s: %w", policy.ID, err))
+				break
+			}
+			total += deleted
+			if deleted < int64(batchSize) {
+				break
+			}
+		}
+		if total > 0 {
+			purges = append(purges, RetentionPurge{PolicyID: policy.ID, SourceID: policy.SourceID, EntityID: policy.EntityID, Table: "ingestion_runs", Rows: total})
+		}
+	}
+	return purges, errors.Join(errs...)
+}
+
+// RunRetentionJanitor purges expired ingestion runs right away and then every interval until ctx is done.
+func (s *PostgresStore) RunRetentionJanitor(ctx context.Context, interval time.Duration, batchSize int) {
+	log.Printf("Retention janitor started; sweeping every %s in batches of %d rows.", interval, batchSize)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purges, err := s.PurgeIngestionRuns(time.Now(), batchSize)
+		if err != nil {
+			log.Printf("Retention janitor sweep failed: %v", err)
+		}
+		for _, purge := range purges {
+			log.Printf("Retention janitor purged %d rows of %s under retention policy %s (source %q, entity %q).",
+				purge.Rows, purge.Table, purge.PolicyID, purge.SourceID, purge.EntityID)
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Retention janitor stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
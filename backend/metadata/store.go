@@ -17,6 +17,10 @@ import (
 // PostgresStore implements the Store interface using a PostgreSQL database.
 type PostgresStore struct {
 	DB *sql.DB
+	// Keys encrypts data source connection details at rest. When nil they are stored in plaintext.
+	Keys *KeyRing
+
+	indexJobs indexJobs // Background builds of attribute indexes, see indexes.go
 }
 
 // NewPostgresStore creates a new PostgresStore, connects to the database,
@@ -91,6 +95,35 @@ func (s *PostgresStore) initSchema() error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_data_source_configs_name ON data_source_configs(name)`,
 		`CREATE INDEX IF NOT EXISTS idx_data_source_configs_entity_id ON data_source_configs(entity_id)`,
+
+		`CREATE TABLE IF NOT EXISTS ingestion_runs (
+			id TEXT PRIMARY KEY,
+			source_id TEXT NOT NULL REFERENCES data_source_configs(id) ON DELETE CASCADE,
+			status VARCHAR(50) NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL,
+			finished_at TIMESTAMPTZ NULL,
+			rows_read BIGINT NOT NULL DEFAULT 0,
+			rows_sent BIGINT NOT NULL DEFAULT 0,
+			rows_rejected BIGINT NOT NULL DEFAULT 0,
+			error TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ingestion_runs_source_id_started_at ON ingestion_runs(source_id, started_at DESC)`,
+		`CREATE TABLE IF NOT EXISTS ingested_files (
+			source_id TEXT NOT NULL REFERENCES data_source_configs(id) ON DELETE CASCADE,
+			file_name TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			checksum VARCHAR(64) NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			error TEXT,
+			ingested_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (source_id, file_name, checksum)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ingestion_watermarks (
+			source_id TEXT PRIMARY KEY REFERENCES data_source_configs(id) ON DELETE CASCADE,
+			watermark_column VARCHAR(255) NOT NULL,
+			last_value TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
 		
 		`CREATE TABLE IF NOT EXISTS data_source_field_mappings (
 			id TEXT PRIMARY KEY,
@@ -107,6 +140,58 @@ func (s *PostgresStore) initSchema() error {
 		`CREATE INDEX IF NOT EXISTS idx_data_source_field_mappings_source_id ON data_source_field_mappings(source_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_data_source_field_mappings_attribute_id ON data_source_field_mappings(attribute_id)`,
 
+		`CREATE TABLE IF NOT EXISTS data_quality_rules (
+			id TEXT PRIMARY KEY,
+			source_id TEXT REFERENCES data_source_configs(id) ON DELETE CASCADE,
+			entity_id TEXT REFERENCES entity_definitions(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			attribute_id TEXT NOT NULL REFERENCES attribute_definitions(id) ON DELETE CASCADE,
+			rule_type VARCHAR(50) NOT NULL,
+			parameters JSONB NULL,
+			action VARCHAR(50) NOT NULL,
+			is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			description TEXT,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL,
+			CHECK ((source_id IS NULL) <> (entity_id IS NULL))
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_data_quality_rules_source_id ON data_quality_rules(source_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_data_quality_rules_entity_id ON data_quality_rules(entity_id)`,
+
+		`CREATE TABLE IF NOT EXISTS attribute_indexes (
+			attribute_id TEXT PRIMARY KEY REFERENCES attribute_definitions(id) ON DELETE CASCADE,
+			entity_id TEXT NOT NULL REFERENCES entity_definitions(id) ON DELETE CASCADE,
+			index_name VARCHAR(63) NOT NULL,
+			expression TEXT,
+			status VARCHAR(50) NOT NULL,
+			message TEXT,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_attribute_indexes_entity_id ON attribute_indexes(entity_id)`,
+
+		`CREATE TABLE IF NOT EXISTS attribute_pii_policies (
+			attribute_id TEXT PRIMARY KEY REFERENCES attribute_definitions(id) ON DELETE CASCADE,
+			entity_id TEXT NOT NULL REFERENCES entity_definitions(id) ON DELETE CASCADE,
+			storage VARCHAR(50) NOT NULL,
+			authorized_actions JSONB,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_attribute_pii_policies_entity_id ON attribute_pii_policies(entity_id)`,
+
+		`CREATE TABLE IF NOT EXISTS retention_policies (
+			id TEXT PRIMARY KEY,
+			source_id TEXT UNIQUE REFERENCES data_source_configs(id) ON DELETE CASCADE,
+			entity_id TEXT UNIQUE REFERENCES entity_definitions(id) ON DELETE CASCADE,
+			entity_max_age_days INTEGER NULL,
+			calculation_logs_keep INTEGER NULL,
+			job_history_days INTEGER NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL,
+			CHECK ((source_id IS NULL) <> (entity_id IS NULL))
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS group_definitions (
 			id TEXT PRIMARY KEY,
 			name VARCHAR(255) NOT NULL UNIQUE,
@@ -759,7 +844,11 @@ func (s *PostgresStore) GetAttribute(entityID, attributeID string) (AttributeDef
 		attr.DataTypeDetails = nil // Ensure it's nil if DB value was NULL or empty
 	}
 
-	return attr, nil
+	attrs := []AttributeDefinition{attr}
+	if err := s.attachPiiPolicies(entityID, attrs); err != nil {
+		return AttributeDefinition{}, fmt.Errorf("GetAttribute failed: %w", err)
+	}
+	return attrs[0], nil
 }
 
 func (s *PostgresStore) ListAttributes(entityID string, params ListParams) ([]AttributeDefinition, int64, error) {
@@ -814,6 +903,9 @@ func (s *PostgresStore) ListAttributes(entityID string, params ListParams) ([]At
 	if err := rows.Err(); err != nil {
 		return nil, 0, fmt.Errorf("ListAttributes rows iteration error for entityID %s: %w", entityID, err)
 	}
+	if err := s.attachPiiPolicies(entityID, attrs); err != nil {
+		return nil, 0, fmt.Errorf("ListAttributes failed: %w", err)
+	}
 	return attrs, totalCount, nil
 }
 
@@ -856,7 +948,11 @@ func (s *PostgresStore) UpdateAttribute(entityID, attributeID, name string, data
 	} else {
 		attr.DataTypeDetails = nil
 	}
-	return attr, nil
+	attrs := []AttributeDefinition{attr}
+	if err := s.attachPiiPolicies(entityID, attrs); err != nil {
+		return AttributeDefinition{}, fmt.Errorf("UpdateAttribute failed: %w", err)
+	}
+	return attrs[0], nil
 }
 
 func (s *PostgresStore) DeleteAttribute(entityID, attributeID string) error {
@@ -884,10 +980,15 @@ func (s *PostgresStore) CreateDataSource(config DataSourceConfig) (DataSourceCon
 	}
 	config.CreatedAt = now
 	config.UpdatedAt = now
+	connectionDetails, err := s.encryptConnectionDetails(config.ID, config.ConnectionDetails)
+	if err != nil {
+		return DataSourceConfig{}, fmt.Errorf("CreateDataSource failed: %w", err)
+	}
+	config.ConnectionDetails = connectionDetails
 
 	query := `INSERT INTO data_source_configs (id, name, type, connection_details, entity_id, created_at, updated_at)
               VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := s.DB.Exec(query, config.ID, config.Name, config.Type, config.ConnectionDetails, sql.NullString{String: config.EntityID, Valid: config.EntityID != ""}, config.CreatedAt, config.UpdatedAt)
+	_, err = s.DB.Exec(query, config.ID, config.Name, config.Type, config.ConnectionDetails, sql.NullString{String: config.EntityID, Valid: config.EntityID != ""}, config.CreatedAt, config.UpdatedAt)
 	if err != nil {
 		return DataSourceConfig{}, fmt.Errorf("CreateDataSource failed: %w", err)
 	}
@@ -964,6 +1065,10 @@ func (s *PostgresStore) UpdateDataSource(id string, config DataSourceConfig) (Da
 	now := time.Now().UTC()
 	config.UpdatedAt = now
 	config.ID = id // Ensure ID is the one from path param
+	connectionDetails, err := s.encryptConnectionDetails(config.ID, config.ConnectionDetails)
+	if err != nil {
+		return DataSourceConfig{}, fmt.Errorf("UpdateDataSource failed: %w", err)
+	}
 
 	query := `UPDATE data_source_configs 
               SET name = $1, type = $2, connection_details = $3, entity_id = $4, updated_at = $5 
@@ -971,7 +1076,7 @@ func (s *PostgresStore) UpdateDataSource(id string, config DataSourceConfig) (Da
               RETURNING id, name, type, connection_details, entity_id, created_at, updated_at`
 	var updatedConfig DataSourceConfig
 	var entityID sql.NullString
-	err := s.DB.QueryRow(query, config.Name, config.Type, config.ConnectionDetails, sql.NullString{String: config.EntityID, Valid: config.EntityID != ""}, config.UpdatedAt, config.ID).Scan(
+	err = s.DB.QueryRow(query, config.Name, config.Type, connectionDetails, sql.NullString{String: config.EntityID, Valid: config.EntityID != ""}, config.UpdatedAt, config.ID).Scan(
 		&updatedConfig.ID, &updatedConfig.Name, &updatedConfig.Type, &updatedConfig.ConnectionDetails, &entityID, &updatedConfig.CreatedAt, &updatedConfig.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -985,6 +1090,85 @@ func (s *PostgresStore) UpdateDataSource(id string, config DataSourceConfig) (Da
 	return updatedConfig, nil
 }
 
+// GetDataSourceConnection returns a data source with its connection details decrypted. It serves
+// the service-to-service read path only; the other read methods return the stored ciphertext.
+func (s *PostgresStore) GetDataSourceConnection(id string) (DataSourceConfig, error) {
+	config, err := s.GetDataSource(id)
+	if err != nil {
+		return DataSourceConfig{}, err
+	}
+	if !IsEncrypted(config.ConnectionDetails) {
+		return config, nil
+	}
+	if s.Keys == nil {
+		return DataSourceConfig{}, fmt.Errorf("GetDataSourceConnection failed for data source %s: %w", id, ErrNoEncryptionKeys)
+	}
+	config.ConnectionDetails, err = s.Keys.Decrypt(id, config.ConnectionDetails)
+	if err != nil {
+		return DataSourceConfig{}, fmt.Errorf("GetDataSourceConnection failed for data source %s: %w", id, err)
+	}
+	return config, nil
+}
+
+// RotateDataSourceKeys re-encrypts the connection details of every data source with a new data key
+// wrapped by the active master key, including rows still stored in plaintext, and returns the
+// number of rows re-encrypted. A row updated while the rotation runs is left as the update wrote
+// it, which is already encrypted with the active key.
+func (s *PostgresStore) RotateDataSourceKeys() (int, error) {
+	if s.Keys == nil {
+		return 0, fmt.Errorf("RotateDataSourceKeys failed: no encryption keys are configured")
+	}
+	rows, err := s.DB.Query(`SELECT id, connection_details FROM data_source_configs
+		WHERE connection_details IS NOT NULL AND connection_details <> ''`)
+	if err != nil {
+		return 0, fmt.Errorf("RotateDataSourceKeys query failed: %w", err)
+	}
+	stored := make(map[string]string)
+	for rows.Next() {
+		var id, details string
+		if err := rows.Scan(&id, &details); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("RotateDataSourceKeys row scan failed: %w", err)
+		}
+		stored[id] = details
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("RotateDataSourceKeys rows iteration error: %w", err)
+	}
+
+	rotated := 0
+	for id, details := range stored {
+		plaintext, err := s.Keys.Decrypt(id, details)
+		if err != nil {
+			return rotated, fmt.Errorf("RotateDataSourceKeys failed for data source %s: %w", id, err)
+		}
+		encrypted, err := s.Keys.Encrypt(id, plaintext)
+		if err != nil {
+			return rotated, fmt.Errorf("RotateDataSourceKeys failed for data source %s: %w", id, err)
+		}
+		// Only replace the value that was read, so a concurrent update is not overwritten.
+		result, err := s.DB.Exec(`UPDATE data_source_configs SET connection_details = $1 WHERE id = $2 AND connection_details = $3`,
+			encrypted, id, details)
+		if err != nil {
+			return rotated, fmt.Errorf("RotateDataSourceKeys update failed for data source %s: %w", id, err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+// encryptConnectionDetails returns the connection details as they are stored: encrypted when the
+// store has a KeyRing, otherwise unchanged.
+func (s *PostgresStore) encryptConnectionDetails(id, details string) (string, error) {
+	if s.Keys == nil || details == "" {
+		return details, nil
+	}
+	return s.Keys.Encrypt(id, details)
+}
+
 func (s *PostgresStore) DeleteDataSource(id string) error {
 	query := `DELETE FROM data_source_configs WHERE id = $1`
 	result, err := s.DB.Exec(query, id)
@@ -1001,6 +1185,198 @@ func (s *PostgresStore) DeleteDataSource(id string) error {
 	return nil
 }
 
+// --- IngestionWatermark Methods ---
+
+func (s *PostgresStore) GetIngestionWatermark(sourceID string) (IngestionWatermark, error) {
+	var wm IngestionWatermark
+	query := `SELECT source_id, watermark_column, last_value, updated_at FROM ingestion_watermarks WHERE source_id = $1`
+	err := s.DB.QueryRow(query, sourceID).Scan(&wm.SourceID, &wm.WatermarkColumn, &wm.LastValue, &wm.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IngestionWatermark{}, sql.ErrNoRows
+		}
+		return IngestionWatermark{}, fmt.Errorf("GetIngestionWatermark failed: %w", err)
+	}
+	return wm, nil
+}
+
+// UpsertIngestionWatermark creates or replaces the watermark for a data source.
+func (s *PostgresStore) UpsertIngestionWatermark(wm IngestionWatermark) (IngestionWatermark, error) {
+	wm.UpdatedAt = time.Now().UTC()
+	query := `INSERT INTO ingestion_watermarks (source_id, watermark_column, last_value, updated_at)
+              VALUES ($1, $2, $3, $4)
+              ON CONFLICT (source_id) DO UPDATE
+              SET watermark_column = EXCLUDED.watermark_column, last_value = EXCLUDED.last_value, updated_at = EXCLUDED.updated_at`
+	_, err := s.DB.Exec(query, wm.SourceID, wm.WatermarkColumn, wm.LastValue, wm.UpdatedAt)
+	if err != nil {
+		return IngestionWatermark{}, fmt.Errorf("UpsertIngestionWatermark failed: %w", err)
+	}
+	return wm, nil
+}
+
+// --- IngestionRun Methods ---
+
+func (s *PostgresStore) CreateIngestionRun(run IngestionRun) (IngestionRun, error) {
+	if run.ID == "" {
+		run.ID = uuid.NewString()
+	}
+	if run.Status == "" {
+		run.Status = IngestionRunRunning
+	}
+	if run.StartedAt.IsZero() {
+		run.StartedAt = time.Now().UTC()
+	}
+
+	query := `INSERT INTO ingestion_runs (id, source_id, status, started_at, finished_at, rows_read, rows_sent, rows_rejected, error)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.DB.Exec(query, run.ID, run.SourceID, string(run.Status), run.StartedAt, run.FinishedAt, run.RowsRead, run.RowsSent, run.RowsRejected, sql.NullString{String: run.Error, Valid: run.Error != ""})
+	if err != nil {
+		return IngestionRun{}, fmt.Errorf("CreateIngestionRun failed: %w", err)
+	}
+	return run, nil
+}
+
+// scanIngestionRun scans a row selected with ingestionRunColumns.
+func scanIngestionRun(scanner interface{ Scan(dest ...interface{}) error }) (IngestionRun, error) {
+	var run IngestionRun
+	var finishedAt sql.NullTime
+	var errMsg sql.NullString
+	if err := scanner.Scan(&run.ID, &run.SourceID, &run.Status, &run.StartedAt, &finishedAt, &run.RowsRead, &run.RowsSent, &run.RowsRejected, &errMsg); err != nil {
+		return IngestionRun{}, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	run.Error = errMsg.String
+	return run, nil
+}
+
+const ingestionRunColumns = `id, source_id, status, started_at, finished_at, rows_read, rows_sent, rows_rejected, error`
+
+func (s *PostgresStore) GetIngestionRun(sourceID, runID string) (IngestionRun, error) {
+	query := `SELECT ` + ingestionRunColumns + ` FROM ingestion_runs WHERE source_id = $1 AND id = $2`
+	run, err := scanIngestionRun(s.DB.QueryRow(query, sourceID, runID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IngestionRun{}, sql.ErrNoRows
+		}
+		return IngestionRun{}, fmt.Errorf("GetIngestionRun failed: %w", err)
+	}
+	return run, nil
+}
+
+// ListIngestionRuns returns the runs of a data source, most recent first.
+func (s *PostgresStore) ListIngestionRuns(sourceID string, params ListParams) ([]IngestionRun, int64, error) {
+	var totalCount int64
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM ingestion_runs WHERE source_id = $1", sourceID).Scan(&totalCount)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListIngestionRuns count for sourceID %s failed: %w", sourceID, err)
+	}
+	if totalCount == 0 {
+		return []IngestionRun{}, 0, nil
+	}
+
+	query := `SELECT ` + ingestionRunColumns + ` FROM ingestion_runs WHERE source_id = $1
+              ORDER BY started_at DESC LIMIT $2 OFFSET $3`
+	rows, err := s.DB.Query(query, sourceID, params.GetLimit(), params.GetOffset())
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListIngestionRuns query for sourceID %s failed: %w", sourceID, err)
+	}
+	defer rows.Close()
+
+	runs := []IngestionRun{}
+	for rows.Next() {
+		run, err := scanIngestionRun(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ListIngestionRuns row scan for sourceID %s failed: %w", sourceID, err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ListIngestionRuns rows iteration for sourceID %s error: %w", sourceID, err)
+	}
+	return runs, totalCount, nil
+}
+
+// UpdateIngestionRun replaces the progress fields of a run. StartedAt is never changed.
+func (s *PostgresStore) UpdateIngestionRun(sourceID, runID string, run IngestionRun) (IngestionRun, error) {
+	query := `UPDATE ingestion_runs
+              SET status = $1, finished_at = $2, rows_read = $3, rows_sent = $4, rows_rejected = $5, error = $6
+              WHERE source_id = $7 AND id = $8
+              RETURNING ` + ingestionRunColumns
+	updated, err := scanIngestionRun(s.DB.QueryRow(query, string(run.Status), run.FinishedAt, run.RowsRead, run.RowsSent, run.RowsRejected,
+		sql.NullString{String: run.Error, Valid: run.Error != ""}, sourceID, runID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IngestionRun{}, sql.ErrNoRows
+		}
+		return IngestionRun{}, fmt.Errorf("UpdateIngestionRun failed: %w", err)
+	}
+	return updated, nil
+}
+
+// --- IngestedFile Methods ---
+
+// RecordIngestedFile adds a file to the processed-file ledger, replacing an earlier entry for the same content.
+func (s *PostgresStore) RecordIngestedFile(file IngestedFile) (IngestedFile, error) {
+	file.IngestedAt = time.Now().UTC()
+	query := `INSERT INTO ingested_files (source_id, file_name, size, checksum, status, error, ingested_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)
+              ON CONFLICT (source_id, file_name, checksum)
+              DO UPDATE SET size = EXCLUDED.size, status = EXCLUDED.status, error = EXCLUDED.error, ingested_at = EXCLUDED.ingested_at`
+	_, err := s.DB.Exec(query, file.SourceID, file.FileName, file.Size, file.Checksum, file.Status,
+		sql.NullString{String: file.Error, Valid: file.Error != ""}, file.IngestedAt)
+	if err != nil {
+		return IngestedFile{}, fmt.Errorf("RecordIngestedFile failed: %w", err)
+	}
+	return file, nil
+}
+
+// ListIngestedFiles returns the ledger of a data source, most recent first.
+// Supported filters are "file_name", "checksum" and "status".
+func (s *PostgresStore) ListIngestedFiles(sourceID string, params ListParams) ([]IngestedFile, int64, error) {
+	where := " WHERE source_id = $1"
+	args := []interface{}{sourceID}
+	for _, column := range []string{"file_name", "checksum", "status"} {
+		if value, ok := params.Filters[column]; ok {
+			args = append(args, value)
+			where += fmt.Sprintf(" AND %s = $%d", column, len(args))
+		}
+	}
+
+	var totalCount int64
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM ingested_files"+where, args...).Scan(&totalCount); err != nil {
+		return nil, 0, fmt.Errorf("ListIngestedFiles count for sourceID %s failed: %w", sourceID, err)
+	}
+	if totalCount == 0 {
+		return []IngestedFile{}, 0, nil
+	}
+
+	query := `SELECT source_id, file_name, size, checksum, status, error, ingested_at FROM ingested_files` + where +
+		fmt.Sprintf(" ORDER BY ingested_at DESC, file_name LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, params.GetLimit(), params.GetOffset())
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListIngestedFiles query for sourceID %s failed: %w", sourceID, err)
+	}
+	defer rows.Close()
+
+	files := []IngestedFile{}
+	for rows.Next() {
+		var file IngestedFile
+		var errMsg sql.NullString
+		if err := rows.Scan(&file.SourceID, &file.FileName, &file.Size, &file.Checksum, &file.Status, &errMsg, &file.IngestedAt); err != nil {
+			return nil, 0, fmt.Errorf("ListIngestedFiles row scan for sourceID %s failed: %w", sourceID, err)
+		}
+		file.Error = errMsg.String
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ListIngestedFiles rows iteration for sourceID %s error: %w", sourceID, err)
+	}
+	return files, totalCount, nil
+}
+
 // --- DataSourceFieldMapping Methods ---
 
 func (s *PostgresStore) CreateFieldMapping(mapping DataSourceFieldMapping) (DataSourceFieldMapping, error) {
@@ -1121,6 +1497,451 @@ func (s *PostgresStore) DeleteFieldMapping(sourceID, mappingID string) error {
 	return nil
 }
 
+// --- DataQualityRule Methods ---
+
+const qualityRuleColumns = `id, source_id, entity_id, name, attribute_id, rule_type, parameters, action, is_enabled, description, created_at, updated_at`
+
+// scanQualityRule scans a row selected with qualityRuleColumns.
+func scanQualityRule(scanner interface{ Scan(dest ...interface{}) error }) (DataQualityRule, error) {
+	var rule DataQualityRule
+	var sourceID, entityID, description sql.NullString
+	var parametersJSON []byte
+	var isEnabled bool
+	if err := scanner.Scan(&rule.ID, &sourceID, &entityID, &rule.Name, &rule.AttributeID, &rule.RuleType, &parametersJSON,
+		&rule.Action, &isEnabled, &description, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+		return DataQualityRule{}, err
+	}
+	rule.SourceID = sourceID.String
+	rule.EntityID = entityID.String
+	rule.Description = description.String
+	rule.IsEnabled = &isEnabled
+	if len(parametersJSON) > 0 {
+		if err := json.Unmarshal(parametersJSON, &rule.Parameters); err != nil {
+			return DataQualityRule{}, fmt.Errorf("failed to unmarshal parameters of quality rule %s: %w", rule.ID, err)
+		}
+	}
+	return rule, nil
+}
+
+// qualityRuleScope returns the condition selecting the rules of a data source or, if sourceID is empty,
+// of an entity definition.
+func qualityRuleScope(sourceID, entityID string) (string, string) {
+	if sourceID != "" {
+		return "source_id = $1", sourceID
+	}
+	return "entity_id = $1", entityID
+}
+
+// CreateQualityRule stores a rule attached to rule.SourceID or rule.EntityID, exactly one of which must be set.
+func (s *PostgresStore) CreateQualityRule(rule DataQualityRule) (DataQualityRule, error) {
+	now := time.Now().UTC()
+	if rule.ID == "" {
+		rule.ID = uuid.NewString()
+	}
+	if rule.IsEnabled == nil {
+		enabled := true
+		rule.IsEnabled = &enabled
+	}
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	parametersJSON, err := json.Marshal(rule.Parameters)
+	if err != nil {
+		return DataQualityRule{}, fmt.Errorf("failed to marshal parameters: %w", err)
+	}
+	query := `INSERT INTO data_quality_rules (` + qualityRuleColumns + `)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err = s.DB.Exec(query, rule.ID, sql.NullString{String: rule.SourceID, Valid: rule.SourceID != ""},
+		sql.NullString{String: rule.EntityID, Valid: rule.EntityID != ""}, rule.Name, rule.AttributeID, string(rule.RuleType),
+		parametersJSON, string(rule.Action), *rule.IsEnabled, rule.Description, rule.CreatedAt, rule.UpdatedAt)
+	if err != nil {
+		return DataQualityRule{}, fmt.Errorf("CreateQualityRule failed: %w", err)
+	}
+	return rule, nil
+}
+
+// GetQualityRule returns a rule of a data source or, if sourceID is empty, of an entity definition.
+func (s *PostgresStore) GetQualityRule(sourceID, entityID, ruleID string) (DataQualityRule, error) {
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	query := `SELECT ` + qualityRuleColumns + ` FROM data_quality_rules WHERE ` + condition + ` AND id = $2`
+	rule, err := scanQualityRule(s.DB.QueryRow(query, scopeID, ruleID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DataQualityRule{}, sql.ErrNoRows
+		}
+		return DataQualityRule{}, fmt.Errorf("GetQualityRule failed: %w", err)
+	}
+	return rule, nil
+}
+
+// ListQualityRules returns all rules of a data source or, if sourceID is empty, of an entity definition, in creation order.
+func (s *PostgresStore) ListQualityRules(sourceID, entityID string) ([]DataQualityRule, error) {
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	query := `SELECT ` + qualityRuleColumns + ` FROM data_quality_rules WHERE ` + condition + ` ORDER BY created_at, id`
+	rows, err := s.DB.Query(query, scopeID)
+	if err != nil {
+		return nil, fmt.Errorf("ListQualityRules query for %s failed: %w", scopeID, err)
+	}
+	defer rows.Close()
+
+	rules := []DataQualityRule{}
+	for rows.Next() {
+		rule, err := scanQualityRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ListQualityRules row scan for %s failed: %w", scopeID, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListQualityRules rows iteration for %s error: %w", scopeID, err)
+	}
+	return rules, nil
+}
+
+// UpdateQualityRule replaces the definition of a rule. The data source or entity definition it is attached to is never changed.
+func (s *PostgresStore) UpdateQualityRule(sourceID, entityID, ruleID string, rule DataQualityRule) (DataQualityRule, error) {
+	if rule.IsEnabled == nil {
+		enabled := true
+		rule.IsEnabled = &enabled
+	}
+	parametersJSON, err := json.Marshal(rule.Parameters)
+	if err != nil {
+		return DataQualityRule{}, fmt.Errorf("failed to marshal parameters: %w", err)
+	}
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	query := `UPDATE data_quality_rules
+              SET name = $3, attribute_id = $4, rule_type = $5, parameters = $6, action = $7, is_enabled = $8, description = $9, updated_at = $10
+              WHERE ` + condition + ` AND id = $2
+              RETURNING ` + qualityRuleColumns
+	updated, err := scanQualityRule(s.DB.QueryRow(query, scopeID, ruleID, rule.Name, rule.AttributeID, string(rule.RuleType),
+		parametersJSON, string(rule.Action), *rule.IsEnabled, rule.Description, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DataQualityRule{}, sql.ErrNoRows
+		}
+		return DataQualityRule{}, fmt.Errorf("UpdateQualityRule failed: %w", err)
+	}
+	return updated, nil
+}
+
+// DeleteQualityRule removes a rule of a data source or, if sourceID is empty, of an entity definition.
+func (s *PostgresStore) DeleteQualityRule(sourceID, entityID, ruleID string) error {
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	result, err := s.DB.Exec(`DELETE FROM data_quality_rules WHERE `+condition+` AND id = $2`, scopeID, ruleID)
+	if err != nil {
+		return fmt.Errorf("DeleteQualityRule failed: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DeleteQualityRule failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// --- AttributeIndex Methods ---
+
+const attributeIndexColumns = `attribute_id, entity_id, index_name, expression, status, message, created_at, updated_at`
+
+// scanAttributeIndex scans a row selected with attributeIndexColumns.
+func scanAttributeIndex(scanner interface{ Scan(dest ...interface{}) error }) (AttributeIndex, error) {
+	var idx AttributeIndex
+	var expression, message sql.NullString
+	if err := scanner.Scan(&idx.AttributeID, &idx.EntityID, &idx.IndexName, &expression, &idx.Status, &message,
+		&idx.CreatedAt, &idx.UpdatedAt); err != nil {
+		return AttributeIndex{}, err
+	}
+	idx.Expression = expression.String
+	idx.Message = message.String
+	return idx, nil
+}
+
+// GetAttributeIndex returns the index status of an attribute. Attributes that were never flagged have none.
+func (s *PostgresStore) GetAttributeIndex(entityID, attributeID string) (AttributeIndex, error) {
+	query := `SELECT ` + attributeIndexColumns + ` FROM attribute_indexes WHERE entity_id = $1 AND attribute_id = $2`
+	idx, err := scanAttributeIndex(s.DB.QueryRow(query, entityID, attributeID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AttributeIndex{}, sql.ErrNoRows
+		}
+		return AttributeIndex{}, fmt.Errorf("GetAttributeIndex failed: %w", err)
+	}
+	return idx, nil
+}
+
+// ListAttributeIndexes returns the index statuses of the attributes of an entity definition, ordered by index name.
+func (s *PostgresStore) ListAttributeIndexes(entityID string) ([]AttributeIndex, error) {
+	query := `SELECT ` + attributeIndexColumns + ` FROM attribute_indexes WHERE entity_id = $1 ORDER BY index_name`
+	rows, err := s.DB.Query(query, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("ListAttributeIndexes query for entityID %s failed: %w", entityID, err)
+	}
+	defer rows.Close()
+
+	indexes := []AttributeIndex{}
+	for rows.Next() {
+		idx, err := scanAttributeIndex(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ListAttributeIndexes row scan for entityID %s failed: %w", entityID, err)
+		}
+		indexes = append(indexes, idx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListAttributeIndexes rows iteration for entityID %s error: %w", entityID, err)
+	}
+	return indexes, nil
+}
+
+// saveAttributeIndex inserts or replaces the index status of an attribute.
+func (s *PostgresStore) saveAttributeIndex(idx AttributeIndex) (AttributeIndex, error) {
+	now := time.Now().UTC()
+	if idx.CreatedAt.IsZero() {
+		idx.CreatedAt = now
+	}
+	idx.UpdatedAt = now
+	query := `INSERT INTO attribute_indexes (` + attributeIndexColumns + `)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+              ON CONFLICT (attribute_id) DO UPDATE
+              SET index_name = EXCLUDED.index_name, expression = EXCLUDED.expression, status = EXCLUDED.status,
+                  message = EXCLUDED.message, updated_at = EXCLUDED.updated_at`
+	_, err := s.DB.Exec(query, idx.AttributeID, idx.EntityID, idx.IndexName, sql.NullString{String: idx.Expression, Valid: idx.Expression != ""},
+		string(idx.Status), sql.NullString{String: idx.Message, Valid: idx.Message != ""}, idx.CreatedAt, idx.UpdatedAt)
+	if err != nil {
+		return AttributeIndex{}, fmt.Errorf("saveAttributeIndex failed: %w", err)
+	}
+	return idx, nil
+}
+
+// --- AttributePiiPolicy Methods ---
+
+const attributePiiPolicyColumns = `attribute_id, entity_id, storage, authorized_actions, created_at, updated_at`
+
+// scanAttributePiiPolicy scans a row selected with attributePiiPolicyColumns.
+func scanAttributePiiPolicy(scanner interface{ Scan(dest ...interface{}) error }) (AttributePiiPolicy, error) {
+	var policy AttributePiiPolicy
+	var actionsJSON []byte
+	if err := scanner.Scan(&policy.AttributeID, &policy.EntityID, &policy.Storage, &actionsJSON,
+		&policy.CreatedAt, &policy.UpdatedAt); err != nil {
+		return AttributePiiPolicy{}, err
+	}
+	policy.AuthorizedActions = []string{}
+	if len(actionsJSON) > 0 {
+		if err := json.Unmarshal(actionsJSON, &policy.AuthorizedActions); err != nil {
+			return AttributePiiPolicy{}, fmt.Errorf("failed to unmarshal authorized actions of attribute %s: %w", policy.AttributeID, err)
+		}
+	}
+	return policy, nil
+}
+
+// GetAttributePiiPolicy returns the PII policy of an attribute. Attributes without one have none.
+func (s *PostgresStore) GetAttributePiiPolicy(entityID, attributeID string) (AttributePiiPolicy, error) {
+	query := `SELECT ` + attributePiiPolicyColumns + ` FROM attribute_pii_policies WHERE entity_id = $1 AND attribute_id = $2`
+	policy, err := scanAttributePiiPolicy(s.DB.QueryRow(query, entityID, attributeID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AttributePiiPolicy{}, sql.ErrNoRows
+		}
+		return AttributePiiPolicy{}, fmt.Errorf("GetAttributePiiPolicy failed: %w", err)
+	}
+	return policy, nil
+}
+
+// SetAttributePiiPolicy creates or replaces the PII policy of an attribute. Action types are stored in upper case.
+func (s *PostgresStore) SetAttributePiiPolicy(policy AttributePiiPolicy) (AttributePiiPolicy, error) {
+	now := time.Now().UTC()
+	if current, err := s.GetAttributePiiPolicy(policy.EntityID, policy.AttributeID); err == nil {
+		policy.CreatedAt = current.CreatedAt
+	} else if errors.Is(err, sql.ErrNoRows) {
+		policy.CreatedAt = now
+	} else {
+		return AttributePiiPolicy{}, fmt.Errorf("SetAttributePiiPolicy failed: %w", err)
+	}
+	policy.UpdatedAt = now
+
+	actions := []string{}
+	for _, action := range policy.AuthorizedActions {
+		if action = strings.ToUpper(strings.TrimSpace(action)); action != "" {
+			actions = append(actions, action)
+		}
+	}
+	policy.AuthorizedActions = actions
+	actionsJSON, err := json.Marshal(actions)
+	if err != nil {
+		return AttributePiiPolicy{}, fmt.Errorf("SetAttributePiiPolicy failed to marshal authorized actions: %w", err)
+	}
+	query := `INSERT INTO attribute_pii_policies (` + attributePiiPolicyColumns + `)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              ON CONFLICT (attribute_id) DO UPDATE
+              SET storage = EXCLUDED.storage, authorized_actions = EXCLUDED.authorized_actions, updated_at = EXCLUDED.updated_at`
+	_, err = s.DB.Exec(query, policy.AttributeID, policy.EntityID, string(policy.Storage), actionsJSON, policy.CreatedAt, policy.UpdatedAt)
+	if err != nil {
+		return AttributePiiPolicy{}, fmt.Errorf("SetAttributePiiPolicy failed: %w", err)
+	}
+	return policy, nil
+}
+
+// DeleteAttributePiiPolicy removes the PII policy of an attribute, after which its values are stored as-is and
+// redacted for every executor.
+func (s *PostgresStore) DeleteAttributePiiPolicy(entityID, attributeID string) error {
+	result, err := s.DB.Exec(`DELETE FROM attribute_pii_policies WHERE entity_id = $1 AND attribute_id = $2`, entityID, attributeID)
+	if err != nil {
+		return fmt.Errorf("DeleteAttributePiiPolicy failed: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DeleteAttributePiiPolicy failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// attachPiiPolicies sets the PiiPolicy of the IsPii attributes among attrs, which belong to entityID.
+func (s *PostgresStore) attachPiiPolicies(entityID string, attrs []AttributeDefinition) error {
+	rows, err := s.DB.Query(`SELECT `+attributePiiPolicyColumns+` FROM attribute_pii_policies WHERE entity_id = $1`, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to query PII policies of entity %s: %w", entityID, err)
+	}
+	defer rows.Close()
+
+	policies := make(map[string]AttributePiiPolicy)
+	for rows.Next() {
+		policy, err := scanAttributePiiPolicy(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan PII policy of entity %s: %w", entityID, err)
+		}
+		policies[policy.AttributeID] = policy
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read PII policies of entity %s: %w", entityID, err)
+	}
+	for i := range attrs {
+		if policy, ok := policies[attrs[i].ID]; ok && attrs[i].IsPii {
+			attrs[i].PiiPolicy = &policy
+		}
+	}
+	return nil
+}
+
+// --- RetentionPolicy Methods ---
+
+const retentionPolicyColumns = `id, source_id, entity_id, entity_max_age_days, calculation_logs_keep, job_history_days, created_at, updated_at`
+
+// nullableLimit converts an optional retention limit for storage.
+func nullableLimit(limit *int) sql.NullInt64 {
+	if limit == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*limit), Valid: true}
+}
+
+// scanRetentionPolicy scans a row selected with retentionPolicyColumns.
+func scanRetentionPolicy(scanner interface{ Scan(dest ...interface{}) error }) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	var sourceID, entityID sql.NullString
+	var limits [3]sql.NullInt64
+	if err := scanner.Scan(&policy.ID, &sourceID, &entityID, &limits[0], &limits[1], &limits[2],
+		&policy.CreatedAt, &policy.UpdatedAt); err != nil {
+		return RetentionPolicy{}, err
+	}
+	policy.SourceID = sourceID.String
+	policy.EntityID = entityID.String
+	for i, target := range []**int{&policy.EntityMaxAgeDays, &policy.CalculationLogsKeep, &policy.JobHistoryDays} {
+		if limits[i].Valid {
+			value := int(limits[i].Int64)
+			*target = &value
+		}
+	}
+	return policy, nil
+}
+
+// GetRetentionPolicy returns the retention policy of a data source or, if sourceID is empty, of an entity definition.
+func (s *PostgresStore) GetRetentionPolicy(sourceID, entityID string) (RetentionPolicy, error) {
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	query := `SELECT ` + retentionPolicyColumns + ` FROM retention_policies WHERE ` + condition
+	policy, err := scanRetentionPolicy(s.DB.QueryRow(query, scopeID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RetentionPolicy{}, sql.ErrNoRows
+		}
+		return RetentionPolicy{}, fmt.Errorf("GetRetentionPolicy failed: %w", err)
+	}
+	return policy, nil
+}
+
+// SetRetentionPolicy creates or replaces the retention policy of policy.SourceID or policy.EntityID, exactly one of
+// which must be set.
+func (s *PostgresStore) SetRetentionPolicy(policy RetentionPolicy) (RetentionPolicy, error) {
+	now := time.Now().UTC()
+	if current, err := s.GetRetentionPolicy(policy.SourceID, policy.EntityID); err == nil {
+		policy.ID = current.ID
+		policy.CreatedAt = current.CreatedAt
+	} else if errors.Is(err, sql.ErrNoRows) {
+		policy.ID = uuid.NewString()
+		policy.CreatedAt = now
+	} else {
+		return RetentionPolicy{}, fmt.Errorf("SetRetentionPolicy failed: %w", err)
+	}
+	policy.UpdatedAt = now
+
+	query := `INSERT INTO retention_policies (` + retentionPolicyColumns + `)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+              ON CONFLICT (id) DO UPDATE
+              SET entity_max_age_days = EXCLUDED.entity_max_age_days, calculation_logs_keep = EXCLUDED.calculation_logs_keep,
+                  job_history_days = EXCLUDED.job_history_days, updated_at = EXCLUDED.updated_at`
+	_, err := s.DB.Exec(query, policy.ID, sql.NullString{String: policy.SourceID, Valid: policy.SourceID != ""},
+		sql.NullString{String: policy.EntityID, Valid: policy.EntityID != ""}, nullableLimit(policy.EntityMaxAgeDays),
+		nullableLimit(policy.CalculationLogsKeep), nullableLimit(policy.JobHistoryDays), policy.CreatedAt, policy.UpdatedAt)
+	if err != nil {
+		return RetentionPolicy{}, fmt.Errorf("SetRetentionPolicy failed: %w", err)
+	}
+	return policy, nil
+}
+
+// DeleteRetentionPolicy removes the retention policy of a data source or, if sourceID is empty, of an entity
+// definition, after which its data is kept forever.
+func (s *PostgresStore) DeleteRetentionPolicy(sourceID, entityID string) error {
+	condition, scopeID := qualityRuleScope(sourceID, entityID)
+	result, err := s.DB.Exec(`DELETE FROM retention_policies WHERE `+condition, scopeID)
+	if err != nil {
+		return fmt.Errorf("DeleteRetentionPolicy failed: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DeleteRetentionPolicy failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListRetentionPolicies returns the retention policies of all entity definitions and data sources. They are few,
+// one per entity definition or data source at most, and are not paginated.
+func (s *PostgresStore) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	rows, err := s.DB.Query(`SELECT ` + retentionPolicyColumns + ` FROM retention_policies ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("ListRetentionPolicies query failed: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		policy, err := scanRetentionPolicy(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ListRetentionPolicies row scan failed: %w", err)
+		}
+		policies = append(policies, policy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListRetentionPolicies rows iteration error: %w", err)
+	}
+	return policies, nil
+}
+
 // --- GroupDefinition Methods ---
 
 func (s *PostgresStore) CreateGroupDefinition(def GroupDefinition) (GroupDefinition, error) {
@@ -1653,6 +2474,7 @@ func (s *PostgresStore) BulkDeleteEntities(entityIDs []string) ([]BulkOperationR
 
 
 func (s *PostgresStore) Close() error {
+	s.WaitForIndexJobs() // An interrupted concurrent build leaves an invalid index behind
 	if s.DB != nil {
 		return s.DB.Close()
 	}
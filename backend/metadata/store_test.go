@@ -670,3 +670,308 @@ func TestBulkDeleteEntities(t *testing.T) {
 	_, getErr = store.GetEntity(e2.ID)
 	assert.ErrorIs(t, getErr, sql.ErrNoRows)
 }
+
+func TestDataSourceEncryption(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping database-dependent tests in CI environment.")
+	}
+	store := setupTestDB(t)
+	defer store.Close()
+
+	oldKeys, err := NewKeyRing("k1", map[string][]byte{"k1": testMasterKey(1)})
+	require.NoError(t, err)
+	store.Keys = oldKeys
+
+	details := `{"host":"db","password":"hunter2"}`
+	created, err := store.CreateDataSource(DataSourceConfig{Name: "Encrypted Source", Type: "postgresql", ConnectionDetails: details})
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(created.ConnectionDetails))
+
+	var stored string
+	require.NoError(t, store.DB.QueryRow(`SELECT connection_details FROM data_source_configs WHERE id = $1`, created.ID).Scan(&stored))
+	assert.NotContains(t, stored, "hunter2")
+	assert.Equal(t, "k1", EncryptionKeyID(stored))
+
+	fetched, err := store.GetDataSource(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stored, fetched.ConnectionDetails, "GetDataSource must not decrypt")
+
+	connection, err := store.GetDataSourceConnection(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, details, connection.ConnectionDetails)
+
+	// A row written before encryption was enabled is read as it is and encrypted by the rotation.
+	store.Keys = nil
+	plain, err := store.CreateDataSource(DataSourceConfig{Name: "Plain Source", Type: "csv", ConnectionDetails: `{"file_path":"/data/a.csv"}`})
+	require.NoError(t, err)
+	assert.False(t, IsEncrypted(plain.ConnectionDetails))
+	_, err = store.GetDataSourceConnection(created.ID)
+	assert.ErrorIs(t, err, ErrNoEncryptionKeys)
+
+	store.Keys, err = NewKeyRing("k2", map[string][]byte{"k1": testMasterKey(1), "k2": testMasterKey(2)})
+	require.NoError(t, err)
+	rotated, err := store.RotateDataSourceKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+
+	for _, id := range []string{created.ID, plain.ID} {
+		fetched, err := store.GetDataSource(id)
+		require.NoError(t, err)
+		assert.Equal(t, "k2", EncryptionKeyID(fetched.ConnectionDetails))
+	}
+
+	// The old key is no longer needed after the rotation.
+	store.Keys, err = NewKeyRing("k2", map[string][]byte{"k2": testMasterKey(2)})
+	require.NoError(t, err)
+	for id, want := range map[string]string{created.ID: details, plain.ID: `{"file_path":"/data/a.csv"}`} {
+		connection, err := store.GetDataSourceConnection(id)
+		require.NoError(t, err)
+		assert.Equal(t, want, connection.ConnectionDetails)
+	}
+}
+
+func TestQualityRuleCRUD(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping database-dependent tests in CI environment.")
+	}
+	store := setupTestDB(t)
+	defer store.Close()
+
+	entity, err := store.CreateEntity("Customer", "Quality rule entity", nil)
+	require.NoError(t, err)
+	attr, err := store.CreateAttribute(entity.ID, "Email", BaseTypeString, nil, "", false, false, false)
+	require.NoError(t, err)
+	ds, err := store.CreateDataSource(DataSourceConfig{Name: "Quality Source", Type: "csv", ConnectionDetails: "{}", EntityID: entity.ID})
+	require.NoError(t, err)
+
+	sourceRule, err := store.CreateQualityRule(DataQualityRule{SourceID: ds.ID, Name: "Email format", AttributeID: attr.ID,
+		RuleType: QualityRuleRegex, Parameters: map[string]interface{}{"pattern": "@"}, Action: QualityActionReject})
+	require.NoError(t, err)
+	require.NotNil(t, sourceRule.IsEnabled)
+	assert.True(t, *sourceRule.IsEnabled)
+	entityRule, err := store.CreateQualityRule(DataQualityRule{EntityID: entity.ID, Name: "Email known", AttributeID: attr.ID,
+		RuleType: QualityRuleNotNull, Action: QualityActionWarn})
+	require.NoError(t, err)
+
+	// A rule belongs to exactly one data source or entity definition.
+	_, err = store.CreateQualityRule(DataQualityRule{SourceID: ds.ID, EntityID: entity.ID, Name: "Both", AttributeID: attr.ID,
+		RuleType: QualityRuleNotNull, Action: QualityActionWarn})
+	assert.Error(t, err)
+
+	fetched, err := store.GetQualityRule(ds.ID, "", sourceRule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "@", fetched.Parameters["pattern"])
+	_, err = store.GetQualityRule("", entity.ID, sourceRule.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	rules, err := store.ListQualityRules("", entity.ID)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, entityRule.ID, rules[0].ID)
+
+	disabled := false
+	updated, err := store.UpdateQualityRule(ds.ID, "", sourceRule.ID, DataQualityRule{Name: "Email format", AttributeID: attr.ID,
+		RuleType: QualityRuleRegex, Parameters: map[string]interface{}{"pattern": "^.+@.+$"}, Action: QualityActionFail, IsEnabled: &disabled})
+	require.NoError(t, err)
+	assert.Equal(t, ds.ID, updated.SourceID)
+	assert.Equal(t, QualityActionFail, updated.Action)
+	assert.False(t, *updated.IsEnabled)
+
+	require.NoError(t, store.DeleteQualityRule(ds.ID, "", sourceRule.ID))
+	assert.ErrorIs(t, store.DeleteQualityRule(ds.ID, "", sourceRule.ID), sql.ErrNoRows)
+
+	// Rules go with the entity definition they check.
+	require.NoError(t, store.DeleteEntity(entity.ID))
+	rules, err = store.ListQualityRules("", entity.ID)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestAttributeIndexExpression(t *testing.T) {
+	cases := map[BaseDataTypeName]string{
+		BaseTypeInteger: `((attributes->>'Order''s Total')::bigint)`,
+		BaseTypeFloat:   `((attributes->>'Order''s Total')::numeric)`,
+		BaseTypeBoolean: `((attributes->>'Order''s Total')::boolean)`,
+		BaseTypeString:  `((attributes->>'Order''s Total'))`,
+		BaseTypeEnum:    `((attributes->>'Order''s Total'))`,
+	}
+	for dataType, want := range cases {
+		expression, reason := attributeIndexExpression(AttributeDefinition{Name: "Order's Total", DataTypeName: dataType})
+		assert.Equal(t, want, expression, dataType)
+		assert.Empty(t, reason, dataType)
+	}
+	for _, dataType := range []BaseDataTypeName{BaseTypeDate, BaseTypeDateTime, BaseTypeArray, BaseTypeObject, BaseTypeJSON} {
+		expression, reason := attributeIndexExpression(AttributeDefinition{Name: "X", DataTypeName: dataType})
+		assert.Empty(t, expression, dataType)
+		assert.NotEmpty(t, reason, dataType)
+	}
+	hashed := AttributeDefinition{Name: "Age", DataTypeName: BaseTypeInteger, IsPii: true, PiiPolicy: &AttributePiiPolicy{Storage: PiiStorageHash}}
+	expression, reason := attributeIndexExpression(hashed)
+	assert.Equal(t, `((attributes->>'Age'))`, expression, "protected values are stored as text")
+	assert.Empty(t, reason)
+	assert.Equal(t, "idx_processed_entities_attr_0f8fad5bd9cb469fa16570867728950e", attributeIndexName("0f8fad5b-d9cb-469f-a165-70867728950e"))
+}
+
+func TestSyncAttributeIndex(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping database-dependent tests in CI environment.")
+	}
+	store := setupTestDB(t)
+	defer store.Close()
+
+	entity, err := store.CreateEntity("Customer", "Attribute index entity", nil)
+	require.NoError(t, err)
+	attr, err := store.CreateAttribute(entity.ID, "Age", BaseTypeInteger, nil, "", false, false, false)
+	require.NoError(t, err)
+
+	index, err := store.SyncAttributeIndex(attr, false)
+	require.NoError(t, err)
+	assert.Nil(t, index, "attributes that were never flagged have no index")
+
+	attr.IsFilterable = true
+	index, err = store.SyncAttributeIndex(attr, false)
+	require.NoError(t, err)
+	require.NotNil(t, index)
+	assert.Equal(t, IndexStatusPending, index.Status)
+	assert.Equal(t, attributeIndexName(attr.ID), index.IndexName)
+
+	// SQLite has no processed_entities table to build the index on.
+	store.WaitForIndexJobs()
+	stored, err := store.GetAttributeIndex(entity.ID, attr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, IndexStatusFailed, stored.Status)
+	assert.NotEmpty(t, stored.Message)
+
+	// Any later sync retries a failed build.
+	index, err = store.SyncAttributeIndex(attr, false)
+	require.NoError(t, err)
+	assert.Equal(t, IndexStatusPending, index.Status)
+	store.WaitForIndexJobs()
+
+	attr.DataTypeName = BaseTypeDateTime
+	index, err = store.SyncAttributeIndex(attr, false)
+	require.NoError(t, err)
+	assert.Equal(t, IndexStatusUnsupported, index.Status)
+	assert.Empty(t, index.Expression)
+	store.WaitForIndexJobs()
+
+	attr.IsFilterable = false
+	index, err = store.SyncAttributeIndex(attr, false)
+	require.NoError(t, err)
+	assert.Equal(t, IndexStatusDropping, index.Status)
+	store.WaitForIndexJobs()
+
+	indexes, err := store.ListAttributeIndexes(entity.ID)
+	require.NoError(t, err)
+	require.Len(t, indexes, 1)
+	assert.Equal(t, index.CreatedAt.Unix(), indexes[0].CreatedAt.Unix(), "the creation time is kept across syncs")
+
+	// The status goes with the attribute.
+	require.NoError(t, store.DeleteAttribute(entity.ID, attr.ID))
+	_, err = store.GetAttributeIndex(entity.ID, attr.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestAttributePiiPolicy(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping database-dependent tests in CI environment.")
+	}
+	store := setupTestDB(t)
+	defer store.Close()
+
+	entity, err := store.CreateEntity("Customer", "PII policy entity", nil)
+	require.NoError(t, err)
+	email, err := store.CreateAttribute(entity.ID, "Email", BaseTypeString, nil, "", false, true, false)
+	require.NoError(t, err)
+
+	_, err = store.GetAttributePiiPolicy(entity.ID, email.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	created, err := store.SetAttributePiiPolicy(AttributePiiPolicy{AttributeID: email.ID, EntityID: entity.ID, Storage: PiiStorageTokenize, AuthorizedActions: []string{"webhook"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"WEBHOOK"}, created.AuthorizedActions)
+
+	updated, err := store.SetAttributePiiPolicy(AttributePiiPolicy{AttributeID: email.ID, EntityID: entity.ID, Storage: PiiStorageEncrypt})
+	require.NoError(t, err)
+	assert.Equal(t, created.CreatedAt.Unix(), updated.CreatedAt.Unix(), "the creation time is kept")
+
+	attr, err := store.GetAttribute(entity.ID, email.ID)
+	require.NoError(t, err)
+	require.NotNil(t, attr.PiiPolicy)
+	assert.Equal(t, PiiStorageEncrypt, attr.PiiPolicy.Storage)
+	assert.Empty(t, attr.PiiPolicy.AuthorizedActions)
+
+	// A policy only applies while the attribute is flagged PII.
+	attr, err = store.UpdateAttribute(entity.ID, email.ID, "Email", BaseTypeString, nil, "", false, false, false)
+	require.NoError(t, err)
+	assert.Nil(t, attr.PiiPolicy)
+
+	require.NoError(t, store.DeleteAttributePiiPolicy(entity.ID, email.ID))
+	assert.ErrorIs(t, store.DeleteAttributePiiPolicy(entity.ID, email.ID), sql.ErrNoRows)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip("Skipping database-dependent tests in CI environment.")
+	}
+	store := setupTestDB(t)
+	defer store.Close()
+
+	entity, err := store.CreateEntity("Customer", "Retention entity", nil)
+	require.NoError(t, err)
+	ds, err := store.CreateDataSource(DataSourceConfig{Name: "Retention Source", Type: "csv", ConnectionDetails: "{}", EntityID: entity.ID})
+	require.NoError(t, err)
+	other, err := store.CreateDataSource(DataSourceConfig{Name: "Other Source", Type: "csv", ConnectionDetails: "{}"})
+	require.NoError(t, err)
+
+	_, err = store.GetRetentionPolicy(ds.ID, "")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	days, keep := 30, 5
+	created, err := store.SetRetentionPolicy(RetentionPolicy{SourceID: ds.ID, JobHistoryDays: &days})
+	require.NoError(t, err)
+	require.NotEmpty(t, created.ID)
+	updated, err := store.SetRetentionPolicy(RetentionPolicy{SourceID: ds.ID, JobHistoryDays: &days, EntityMaxAgeDays: &days})
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, updated.ID, "a data source has one policy")
+	_, err = store.SetRetentionPolicy(RetentionPolicy{EntityID: entity.ID, CalculationLogsKeep: &keep})
+	require.NoError(t, err)
+
+	fetched, err := store.GetRetentionPolicy(ds.ID, "")
+	require.NoError(t, err)
+	require.NotNil(t, fetched.EntityMaxAgeDays)
+	assert.Equal(t, 30, *fetched.EntityMaxAgeDays)
+	assert.Nil(t, fetched.CalculationLogsKeep)
+	policies, err := store.ListRetentionPolicies()
+	require.NoError(t, err)
+	assert.Len(t, policies, 2)
+
+	// Only finished runs of the data source older than the job history are purged, in batches.
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -40)
+	recent := now.AddDate(0, 0, -10)
+	runs := []IngestionRun{
+		{SourceID: ds.ID, Status: IngestionRunSucceeded, StartedAt: old, FinishedAt: &old},
+		{SourceID: ds.ID, Status: IngestionRunFailed, StartedAt: old.Add(time.Hour), FinishedAt: &old},
+		{SourceID: ds.ID, Status: IngestionRunRunning, StartedAt: old},
+		{SourceID: ds.ID, Status: IngestionRunSucceeded, StartedAt: recent, FinishedAt: &recent},
+		{SourceID: other.ID, Status: IngestionRunSucceeded, StartedAt: old, FinishedAt: &old},
+	}
+	for _, run := range runs {
+		_, err := store.CreateIngestionRun(run)
+		require.NoError(t, err)
+	}
+	purges, err := store.PurgeIngestionRuns(now, 1)
+	require.NoError(t, err)
+	require.Len(t, purges, 1)
+	assert.Equal(t, RetentionPurge{PolicyID: created.ID, SourceID: ds.ID, Table: "ingestion_runs", Rows: 2}, purges[0])
+	_, total, err := store.ListIngestionRuns(ds.ID, ListParams{Limit: 10})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	_, total, err = store.ListIngestionRuns(other.ID, ListParams{Limit: 10})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, total)
+
+	require.NoError(t, store.DeleteRetentionPolicy(ds.ID, ""))
+	assert.ErrorIs(t, store.DeleteRetentionPolicy(ds.ID, ""), sql.ErrNoRows)
+}
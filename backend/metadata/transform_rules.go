@@ -0,0 +1,1078 @@
+package metadata
+
+// Transformation rules are expressions evaluated against each raw record to produce the value of
+// a mapped attribute. The language is small and sandboxed: it has no loops, variables or access to
+// anything but the record being processed, and regular expressions use RE2, which runs in linear
+// time. Regular expressions and date layouts must be string literals so that they are checked
+// when a rule is compiled.
+//
+//	value                          the value of the mapping's source field
+//	first_name, field("First Name") other fields of the raw record (null when missing)
+//	"text", 'text', 42, 1.5, true, false, null
+//	+ - * / %                      arithmetic; numeric strings are converted to numbers
+//	== != < <= > >=                comparison
+//	&& || !                        logic
+//	cond ? a : b, if(cond, a, b)   conditionals
+//
+// Functions: lower, upper, trim, len, substr(s, start[, length]), replace(s, old, new),
+// split_part(s, sep, n), contains, starts_with, ends_with, concat(a, b, ...), coalesce(a, b, ...),
+// default(v, fallback), regex_extract(s, pattern[, group]), regex_replace(s, pattern, replacement),
+// to_number, to_string, round(x[, digits]), floor, ceil, abs, parse_date(s, layout, ...) and
+// format_date(t, layout). Date layouts use Go's reference time (e.g. "02/01/2006 15:04") or one of
+// RFC3339, RFC1123 and RFC822.
+//
+// The legacy rules "lowercase" and "trim" are still accepted and mean lower(value) and trim(value).
+//
+// The metadata service validates rules with a copy of this file (metadata/transform_rules.go);
+// keep the two identical apart from the package clause.
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxRuleLength is the maximum length in bytes of a transformation rule.
+	maxRuleLength = 4096
+	// maxRuleDepth is the maximum nesting depth of a transformation rule.
+	maxRuleDepth = 64
+)
+
+// legacyRules are the rule names supported before transformation rules became expressions.
+var legacyRules = map[string]string{
+	"lowercase": "lower(value)",
+	"trim":      "trim(value)",
+}
+
+// namedDateLayouts are the layout names accepted in addition to Go reference layouts.
+var namedDateLayouts = map[string]string{
+	"RFC3339": time.RFC3339,
+	"RFC1123": time.RFC1123,
+	"RFC822":  time.RFC822,
+}
+
+// CompiledRule is a parsed and validated transformation rule.
+type CompiledRule struct {
+	source string
+	root   exprNode
+}
+
+// CompileTransformationRule parses and validates a transformation rule.
+func CompileTransformationRule(rule string) (*CompiledRule, error) {
+	source := strings.TrimSpace(rule)
+	if expr, ok := legacyRules[strings.ToLower(source)]; ok {
+		source = expr
+	}
+	if source == "" {
+		return nil, fmt.Errorf("transformation rule is empty")
+	}
+	if len(source) > maxRuleLength {
+		return nil, fmt.Errorf("transformation rule is longer than %d bytes", maxRuleLength)
+	}
+	tokens, err := lexRule(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", tok)
+	}
+	return &CompiledRule{source: rule, root: root}, nil
+}
+
+// String returns the rule as it was written.
+func (r *CompiledRule) String() string {
+	return r.source
+}
+
+// Evaluate applies the rule to value, the value of the mapping's source field (nil when the record
+// does not have it), with record providing the other fields.
+func (r *CompiledRule) Evaluate(value interface{}, record map[string]interface{}) (interface{}, error) {
+	return r.root.eval(&evalEnv{value: value, record: record})
+}
+
+// --- Lexer ---
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type ruleToken struct {
+	kind tokenKind
+	text string // Operator or identifier text, or the unquoted string literal
+	num  float64
+	pos  int
+}
+
+func (t ruleToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of rule"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// ruleOperators lists operators longest first so that "<=" is not read as "<".
+var ruleOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ",", "?", ":"}
+
+func lexRule(source string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	i := 0
+	for i < len(source) {
+		r, size := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r >= '0' && r <= '9' || (r == '.' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9'):
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.') {
+				i++
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && source[i] >= '0' && source[i] <= '9' {
+					i++
+				}
+			}
+			num, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:i], start+1)
+			}
+			tokens = append(tokens, ruleToken{kind: tokNumber, text: source[start:i], num: num, pos: start})
+		case r == '"' || r == '\'':
+			start := i
+			text, end, err := lexString(source, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, ruleToken{kind: tokString, text: text, pos: start})
+			i = end
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(source) {
+				r, size := utf8.DecodeRuneInString(source[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, ruleToken{kind: tokIdent, text: source[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range ruleOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, ruleToken{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i+1)
+			}
+		}
+	}
+	return append(tokens, ruleToken{kind: tokEOF, pos: len(source)}), nil
+}
+
+// lexString reads the string literal starting with the quote at source[start] and returns its
+// value and the position after the closing quote.
+func lexString(source string, start int) (string, int, error) {
+	quote := source[start]
+	var sb strings.Builder
+	for i := start + 1; i < len(source); i++ {
+		c := source[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, nil
+		case c == '\\' && i+1 < len(source):
+			i++
+			switch source[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '\\', '"', '\'':
+				sb.WriteByte(source[i])
+			default:
+				// Keep unknown escapes, so regular expressions like "\d+" can be written as they are.
+				sb.WriteByte('\\')
+				sb.WriteByte(source[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", start+1)
+}
+
+// --- Parser ---
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) acceptOp(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *ruleParser) expectOp(op string) error {
+	if _, ok := p.acceptOp(op); !ok {
+		tok := p.peek()
+		return p.errorf(tok, "expected %q but found %s", op, tok)
+	}
+	return nil
+}
+
+func (p *ruleParser) errorf(tok ruleToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), tok.pos+1)
+}
+
+// binaryLevels lists the binary operators by increasing precedence.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *ruleParser) parseExpr(depth int) (exprNode, error) {
+	if depth > maxRuleDepth {
+		return nil, p.errorf(p.peek(), "rule nests deeper than %d levels", maxRuleDepth)
+	}
+	cond, err := p.parseBinary(0, depth)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptOp("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseExpr(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpr(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+func (p *ruleParser) parseBinary(level, depth int) (exprNode, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary(depth)
+	}
+	left, err := p.parseBinary(level+1, depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp(binaryLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level+1, depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *ruleParser) parseUnary(depth int) (exprNode, error) {
+	if op, ok := p.acceptOp("!", "-"); ok {
+		if depth > maxRuleDepth {
+			return nil, p.errorf(p.peek(), "rule nests deeper than %d levels", maxRuleDepth)
+		}
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary(depth)
+}
+
+func (p *ruleParser) parsePrimary(depth int) (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &literalNode{value: tok.num}, nil
+	case tokString:
+		return &literalNode{value: tok.text}, nil
+	case tokIdent:
+		if _, ok := p.acceptOp("("); ok {
+			return p.parseCall(tok, depth)
+		}
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case "value":
+			return &valueNode{}, nil
+		}
+		return &fieldNode{name: tok.text}, nil
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.parseExpr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, p.errorf(tok, "unexpected %s", tok)
+}
+
+func (p *ruleParser) parseCall(name ruleToken, depth int) (exprNode, error) {
+	var args []exprNode
+	if _, ok := p.acceptOp(")"); !ok {
+		for {
+			arg, err := p.parseExpr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.acceptOp(","); ok {
+				continue
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if name.text == "field" {
+		if len(args) != 1 {
+			return nil, p.errorf(name, "field expects 1 argument, got %d", len(args))
+		}
+		fieldName, ok := stringLiteral(args[0])
+		if !ok {
+			return nil, p.errorf(name, "field expects a string literal")
+		}
+		return &fieldNode{name: fieldName}, nil
+	}
+
+	fn, ok := ruleFunctions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.text)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, p.errorf(name, "%s expects %s, got %d", name.text, fn.arity(), len(args))
+	}
+	call := &callNode{name: name.text, fn: fn, args: args}
+	if fn.compile != nil {
+		if err := fn.compile(call); err != nil {
+			return nil, p.errorf(name, "%s: %v", name.text, err)
+		}
+	}
+	return call, nil
+}
+
+// stringLiteral returns the value of a string literal node.
+func stringLiteral(node exprNode) (string, bool) {
+	lit, ok := node.(*literalNode)
+	if !ok {
+		return "", false
+	}
+	s, ok := lit.value.(string)
+	return s, ok
+}
+
+// --- Evaluation ---
+
+type evalEnv struct {
+	value  interface{}
+	record map[string]interface{}
+}
+
+type exprNode interface {
+	eval(env *evalEnv) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(*evalEnv) (interface{}, error) { return n.value, nil }
+
+type valueNode struct{}
+
+func (n *valueNode) eval(env *evalEnv) (interface{}, error) {
+	return normalizeRuleValue(env.value), nil
+}
+
+type fieldNode struct{ name string }
+
+func (n *fieldNode) eval(env *evalEnv) (interface{}, error) {
+	return normalizeRuleValue(env.record[n.name]), nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(env *evalEnv) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(v), nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	num, err := toRuleNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	return -num, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(env *evalEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// && and || only evaluate their right operand when needed.
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return ruleEqual(left, right), nil
+	case "!=":
+		return !ruleEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return false, nil
+		}
+		cmp, err := ruleCompare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	}
+
+	// Arithmetic
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	a, err := toRuleNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toRuleNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	default:
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}
+}
+
+type conditionalNode struct {
+	cond, then, otherwise exprNode
+}
+
+func (n *conditionalNode) eval(env *evalEnv) (interface{}, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+type callNode struct {
+	name    string
+	fn      *ruleFunction
+	args    []exprNode
+	regex   *regexp.Regexp // Compiled pattern of regex_extract and regex_replace
+	layouts []string       // Layouts of parse_date and format_date
+}
+
+func (n *callNode) eval(env *evalEnv) (interface{}, error) {
+	if n.fn.lazy != nil {
+		return n.fn.lazy(n, env)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	result, err := n.fn.call(n, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return result, nil
+}
+
+// --- Functions ---
+
+type ruleFunction struct {
+	minArgs, maxArgs int // maxArgs is -1 for variadic functions
+	// compile checks literal arguments when the rule is compiled.
+	compile func(call *callNode) error
+	call    func(call *callNode, args []interface{}) (interface{}, error)
+	// lazy replaces call for functions that evaluate only some of their arguments.
+	lazy func(call *callNode, env *evalEnv) (interface{}, error)
+}
+
+func (f *ruleFunction) arity() string {
+	switch {
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	case f.minArgs == f.maxArgs && f.minArgs == 1:
+		return "1 argument"
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+	}
+}
+
+var ruleFunctions = map[string]*ruleFunction{
+	"lower": stringFunction(strings.ToLower),
+	"upper": stringFunction(strings.ToUpper),
+	"trim":  stringFunction(strings.TrimSpace),
+	"len": {minArgs: 1, maxArgs: 1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return 0.0, nil
+		}
+		return float64(utf8.RuneCountInString(toRuleString(args[0]))), nil
+	}},
+	"substr": {minArgs: 2, maxArgs: 3, call: callSubstr},
+	"replace": {minArgs: 3, maxArgs: 3, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return strings.ReplaceAll(toRuleString(args[0]), toRuleString(args[1]), toRuleString(args[2])), nil
+	}},
+	"split_part":  {minArgs: 3, maxArgs: 3, call: callSplitPart},
+	"contains":    stringPredicate(strings.Contains),
+	"starts_with": stringPredicate(strings.HasPrefix),
+	"ends_with":   stringPredicate(strings.HasSuffix),
+	"concat": {minArgs: 1, maxArgs: -1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			if arg != nil {
+				sb.WriteString(toRuleString(arg))
+			}
+		}
+		return sb.String(), nil
+	}},
+	"coalesce": {minArgs: 1, maxArgs: -1, lazy: lazyCoalesce},
+	"default":  {minArgs: 2, maxArgs: 2, lazy: lazyCoalesce},
+	"if": {minArgs: 3, maxArgs: 3, lazy: func(call *callNode, env *evalEnv) (interface{}, error) {
+		cond, err := call.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return call.args[1].eval(env)
+		}
+		return call.args[2].eval(env)
+	}},
+	"regex_extract": {minArgs: 2, maxArgs: 3, compile: compileRegexArg, call: callRegexExtract},
+	"regex_replace": {minArgs: 3, maxArgs: 3, compile: compileRegexArg, call: func(call *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return call.regex.ReplaceAllString(toRuleString(args[0]), toRuleString(args[2])), nil
+	}},
+	"to_number": {minArgs: 1, maxArgs: 1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil || args[0] == "" {
+			return nil, nil
+		}
+		return toRuleNumber(args[0])
+	}},
+	"to_string": {minArgs: 1, maxArgs: 1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return toRuleString(args[0]), nil
+	}},
+	"round":      {minArgs: 1, maxArgs: 2, call: callRound},
+	"floor":      numberFunction(math.Floor),
+	"ceil":       numberFunction(math.Ceil),
+	"abs":        numberFunction(math.Abs),
+	"parse_date": {minArgs: 2, maxArgs: -1, compile: compileLayoutArgs(1), call: callParseDate},
+	"format_date": {minArgs: 2, maxArgs: 2, compile: compileLayoutArgs(1), call: func(call *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		t, err := toRuleTime(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(call.layouts[0]), nil
+	}},
+}
+
+func stringFunction(f func(string) string) *ruleFunction {
+	return &ruleFunction{minArgs: 1, maxArgs: 1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		return f(toRuleString(args[0])), nil
+	}}
+}
+
+func stringPredicate(f func(s, sub string) bool) *ruleFunction {
+	return &ruleFunction{minArgs: 2, maxArgs: 2, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return false, nil
+		}
+		return f(toRuleString(args[0]), toRuleString(args[1])), nil
+	}}
+}
+
+func numberFunction(f func(float64) float64) *ruleFunction {
+	return &ruleFunction{minArgs: 1, maxArgs: 1, call: func(_ *callNode, args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		num, err := toRuleNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(num), nil
+	}}
+}
+
+// lazyCoalesce returns the first argument that is neither null nor an empty string, evaluating
+// the arguments only up to it.
+func lazyCoalesce(call *callNode, env *evalEnv) (interface{}, error) {
+	for _, arg := range call.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil && v != "" {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+func callSubstr(_ *callNode, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	runes := []rune(toRuleString(args[0]))
+	start, err := toRuleInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	start = clampInt(start, 0, len(runes))
+	end := len(runes)
+	if len(args) == 3 {
+		length, err := toRuleInt(args[2])
+		if err != nil {
+			return nil, err
+		}
+		end = clampInt(start+length, start, len(runes))
+	}
+	return string(runes[start:end]), nil
+}
+
+// callSplitPart returns the n-th (1-based) part of a string split by a separator, or "" when it has
+// fewer parts.
+func callSplitPart(_ *callNode, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	n, err := toRuleInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(toRuleString(args[0]), toRuleString(args[1]))
+	if n < 1 || n > len(parts) {
+		return "", nil
+	}
+	return parts[n-1], nil
+}
+
+func compileRegexArg(call *callNode) error {
+	pattern, ok := stringLiteral(call.args[1])
+	if !ok {
+		return fmt.Errorf("the pattern must be a string literal")
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	call.regex = regex
+	if len(call.args) == 3 && call.name == "regex_extract" {
+		group, ok := call.args[2].(*literalNode)
+		if !ok {
+			return fmt.Errorf("the group must be a number literal")
+		}
+		num, ok := group.value.(float64)
+		if !ok {
+			return fmt.Errorf("the group must be a number literal")
+		}
+		if num < 0 || int(num) > regex.NumSubexp() || num != math.Trunc(num) {
+			return fmt.Errorf("the pattern has no group %v", num)
+		}
+	}
+	return nil
+}
+
+// callRegexExtract returns the given group (by default the first group, or the whole match for
+// patterns without groups) of the first match, or null when nothing matches.
+func callRegexExtract(call *callNode, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	match := call.regex.FindStringSubmatch(toRuleString(args[0]))
+	if match == nil {
+		return nil, nil
+	}
+	group := 0
+	if call.regex.NumSubexp() > 0 {
+		group = 1
+	}
+	if len(args) == 3 {
+		group = int(args[2].(float64))
+	}
+	return match[group], nil
+}
+
+func callRound(_ *callNode, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	num, err := toRuleNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	digits := 0
+	if len(args) == 2 {
+		if digits, err = toRuleInt(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	scale := math.Pow(10, float64(digits))
+	return math.Round(num*scale) / scale, nil
+}
+
+// compileLayoutArgs checks that the arguments from index first on are date layout literals.
+func compileLayoutArgs(first int) func(call *callNode) error {
+	return func(call *callNode) error {
+		for _, arg := range call.args[first:] {
+			layout, ok := stringLiteral(arg)
+			if !ok {
+				return fmt.Errorf("date layouts must be string literals")
+			}
+			if named, ok := namedDateLayouts[layout]; ok {
+				layout = named
+			} else if layoutProbeTime.Format(layout) == layout {
+				return fmt.Errorf("layout %q has no date or time elements; write layouts with Go's reference time, e.g. \"2006-01-02 15:04:05\"", layout)
+			}
+			call.layouts = append(call.layouts, layout)
+		}
+		return nil
+	}
+}
+
+// layoutProbeTime differs from Go's reference time in every element, so a layout formats it as
+// the layout itself only when the layout has no elements.
+var layoutProbeTime = time.Date(1999, time.November, 28, 21, 37, 48, 0, time.UTC)
+
+// callParseDate parses a string with the first layout that matches. Times without a zone are UTC.
+func callParseDate(call *callNode, args []interface{}) (interface{}, error) {
+	if args[0] == nil || args[0] == "" {
+		return nil, nil
+	}
+	if t, ok := args[0].(time.Time); ok {
+		return t, nil
+	}
+	s := strings.TrimSpace(toRuleString(args[0]))
+	for _, layout := range call.layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q does not match any of the layouts %q", s, call.layouts)
+}
+
+// --- Values ---
+
+// normalizeRuleValue converts record values to the types rules work with: nil, string, float64,
+// bool, time.Time or, for nested values, the value as it is.
+func normalizeRuleValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case fmt.Stringer:
+		if _, isTime := v.(time.Time); !isTime {
+			return n.String() // e.g. json.Number
+		}
+	}
+	return v
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	case string:
+		return b != ""
+	case float64:
+		return b != 0
+	case time.Time:
+		return !b.IsZero()
+	default:
+		return true
+	}
+}
+
+func toRuleNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		num, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot use %q as a number", n)
+		}
+		return num, nil
+	default:
+		return 0, fmt.Errorf("cannot use %v (%T) as a number", v, v)
+	}
+}
+
+func toRuleInt(v interface{}) (int, error) {
+	num, err := toRuleNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	return int(num), nil
+}
+
+func toRuleString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case time.Time:
+		return s.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func toRuleTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot use %q as a date; parse it with parse_date first", t)
+		}
+		return parsed, nil
+	default:
+		return time.Time{}, fmt.Errorf("cannot use %v (%T) as a date", v, v)
+	}
+}
+
+// ruleEqual compares values, comparing a number with a numeric string as numbers.
+func ruleEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if ta, ok := a.(time.Time); ok {
+		tb, err := toRuleTime(b)
+		return err == nil && ta.Equal(tb)
+	}
+	_, aNum := a.(float64)
+	_, bNum := b.(float64)
+	if aNum || bNum {
+		x, errA := toRuleNumber(a)
+		y, errB := toRuleNumber(b)
+		return errA == nil && errB == nil && x == y
+	}
+	if aBool, ok := a.(bool); ok {
+		bBool, ok := b.(bool)
+		return ok && aBool == bBool
+	}
+	return toRuleString(a) == toRuleString(b)
+}
+
+// ruleCompare orders numbers, dates and strings; a number and a numeric string compare as numbers.
+func ruleCompare(a, b interface{}) (int, error) {
+	if ta, ok := a.(time.Time); ok {
+		tb, err := toRuleTime(b)
+		if err != nil {
+			return 0, err
+		}
+		return ta.Compare(tb), nil
+	}
+	if tb, ok := b.(time.Time); ok {
+		ta, err := toRuleTime(a)
+		if err != nil {
+			return 0, err
+		}
+		return ta.Compare(tb), nil
+	}
+	_, aNum := a.(float64)
+	_, bNum := b.(float64)
+	if aNum || bNum {
+		x, err := toRuleNumber(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := toRuleNumber(b)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	as, aStr := a.(string)
+	bs, bStr := b.(string)
+	if !aStr || !bStr {
+		return 0, fmt.Errorf("cannot compare %v (%T) with %v (%T)", a, a, b, b)
+	}
+	return strings.Compare(as, bs), nil
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -114,6 +114,10 @@ const (
 	groupEventsConsumerDurable  = "OrchestrationServiceGroupConsumer"
 	actionTasksStreamName       = "ACTIONS"
 	actionTasksSubjectQualifier = "actions"
+
+	// redactedValue replaces the values of PII attributes in task messages. Executors authorized for an
+	// attribute get its value from the processing service.
+	redactedValue = "[REDACTED]"
 )
 
 type OrchestrationService struct {
@@ -315,9 +319,11 @@ func (s *OrchestrationService) TriggerWorkflow(workflowID string) error {
 	return s.executeWorkflow(*workflow, groupMembers, triggerContext)
 }
 
+// fetchEntityInstanceData reads the attributes of an entity instance for a task message. PII attributes,
+// as recorded by the processing service in pii_attributes, are redacted, so they don't travel through NATS.
 func (s *OrchestrationService) fetchEntityInstanceData(entityInstanceID string) (map[string]interface{}, error) {
-	var jsonData []byte
-	err := s.db.QueryRow("SELECT attributes FROM processed_entities WHERE id = $1", entityInstanceID).Scan(&jsonData)
+	var jsonData, piiData []byte
+	err := s.db.QueryRow("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1", entityInstanceID).Scan(&jsonData, &piiData)
 	if err != nil {
 		if err == sql.ErrNoRows { return nil, fmt.Errorf("no processed_entity found with id %s", entityInstanceID) }
 		return nil, fmt.Errorf("failed to query processed_entity with id %s: %w", entityInstanceID, err)
@@ -328,6 +334,17 @@ func (s *OrchestrationService) fetchEntityInstanceData(entityInstanceID string)
 	}
 	var data map[string]interface{}
 	if err := json.Unmarshal(jsonData, &data); err != nil { return nil, fmt.Errorf("failed to unmarshal attributes for processed_entity %s: %w", entityInstanceID, err) }
+	if len(piiData) > 0 {
+		var piiAttributes map[string]string
+		if err := json.Unmarshal(piiData, &piiAttributes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pii_attributes for processed_entity %s: %w", entityInstanceID, err)
+		}
+		for name := range piiAttributes {
+			if value, ok := data[name]; ok && value != nil {
+				data[name] = redactedValue
+			}
+		}
+	}
 	return data, nil
 }
 
@@ -308,10 +308,10 @@ func TestExecuteWorkflow(t *testing.T) {
 		groupMembers := &GroupCalculationResult{MemberIDs: []string{"entity1", "entity2"}}
 
 		// Mock DB calls for fetchEntityInstanceData
-		dbMock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
-			WithArgs("entity1").WillReturnRows(sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(`{"email": "e1@test.com"}`)))
-		dbMock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
-			WithArgs("entity2").WillReturnRows(sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(`{"email": "e2@test.com"}`)))
+		dbMock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
+			WithArgs("entity1").WillReturnRows(sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(`{"email": "e1@test.com"}`), nil))
+		dbMock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
+			WithArgs("entity2").WillReturnRows(sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(`{"email": "e2@test.com"}`), nil))
 		
 		// Mock NATS publish calls
 		mockNatsJS.On("StreamInfo", actionTasksStreamName, mock.Anything).Return(&nats.StreamInfo{}, nil).Twice() // Called for each task
@@ -381,12 +381,12 @@ func TestExecuteWorkflow(t *testing.T) {
 		mockMetaFetchFail.On("GetActionTemplate", "at1").Return(&at, nil).Once()
 		groupMembers := &GroupCalculationResult{MemberIDs: []string{"entity1", "entity2_fails", "entity3"}}
 
-		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
-			WithArgs("entity1").WillReturnRows(sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(`{"data":"ok1"}`)))
-		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
+			WithArgs("entity1").WillReturnRows(sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(`{"data":"ok1"}`), nil))
+		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs("entity2_fails").WillReturnError(fmt.Errorf("db error for entity2"))
-		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
-			WithArgs("entity3").WillReturnRows(sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(`{"data":"ok3"}`)))
+		dbMockFetch.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
+			WithArgs("entity3").WillReturnRows(sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(`{"data":"ok3"}`), nil))
 		
 		mockNatsJSFetchFail.On("StreamInfo", actionTasksStreamName, mock.Anything).Return(&nats.StreamInfo{}, nil).Twice() // For entity1 and entity3
 		mockNatsJSFetchFail.On("Publish", "actions.webhook", mock.Anything, mock.Anything).Return(&nats.PubAck{}, nil).Twice()
@@ -412,8 +412,8 @@ func TestFetchEntityInstanceData(t *testing.T) {
 
 	t.Run("Successful data fetch", func(t *testing.T) {
 		jsonData := `{"key": "value", "num": 123}`
-		rows := sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(jsonData))
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		rows := sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(jsonData), nil)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs(entityID).WillReturnRows(rows)
 
 		data, err := service.fetchEntityInstanceData(entityID)
@@ -423,8 +423,20 @@ func TestFetchEntityInstanceData(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
+	t.Run("PII attributes are redacted", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"attributes", "pii_attributes"}).
+			AddRow([]byte(`{"email": "pii:enc:v1:abc", "name": "Ann", "phone": null, "status": "active"}`), []byte(`{"email": "encrypt", "name": "as_is", "phone": "tokenize"}`))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
+			WithArgs(entityID).WillReturnRows(rows)
+
+		data, err := service.fetchEntityInstanceData(entityID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"email": redactedValue, "name": redactedValue, "phone": nil, "status": "active"}, data)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
 	t.Run("sql.ErrNoRows", func(t *testing.T) {
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs(entityID).WillReturnError(sql.ErrNoRows)
 		
 		data, err := service.fetchEntityInstanceData(entityID)
@@ -436,7 +448,7 @@ func TestFetchEntityInstanceData(t *testing.T) {
 
 	t.Run("DB query error", func(t *testing.T) {
 		dbErr := errors.New("database query failed")
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs(entityID).WillReturnError(dbErr)
 
 		data, err := service.fetchEntityInstanceData(entityID)
@@ -448,8 +460,8 @@ func TestFetchEntityInstanceData(t *testing.T) {
 
 	t.Run("JSON unmarshal error", func(t *testing.T) {
 		invalidJsonData := `{"key": "value", num: 123}` // num not quoted - invalid JSON
-		rows := sqlmock.NewRows([]string{"attributes"}).AddRow([]byte(invalidJsonData))
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		rows := sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte(invalidJsonData), nil)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs(entityID).WillReturnRows(rows)
 
 		data, err := service.fetchEntityInstanceData(entityID)
@@ -460,8 +472,8 @@ func TestFetchEntityInstanceData(t *testing.T) {
 	})
 	
 	t.Run("Empty or NULL JSON data in DB", func(t *testing.T) {
-		rows := sqlmock.NewRows([]string{"attributes"}).AddRow([]byte{}) // Empty JSON
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes FROM processed_entities WHERE id = $1")).
+		rows := sqlmock.NewRows([]string{"attributes", "pii_attributes"}).AddRow([]byte{}, nil) // Empty JSON
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT attributes, pii_attributes FROM processed_entities WHERE id = $1")).
 			WithArgs(entityID).WillReturnRows(rows)
 
 		data, err := service.fetchEntityInstanceData(entityID)
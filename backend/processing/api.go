@@ -0,0 +1,393 @@
+package processing
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API exposes the quarantine, the data quality scorecards, the entity history, the materialized
+// entity tables and the erasure of data subjects of the processing service over HTTP.
+type API struct {
+	service *ProcessingService
+}
+
+// NewAPI creates a new API handler.
+func NewAPI(service *ProcessingService) *API {
+	return &API{service: service}
+}
+
+// RegisterRoutes sets up the quarantine, scorecard, entity history, materialization and erasure routes. The gateway exposes
+// them under /api/v1/processing. The internal routes are called by the action executors directly and are not
+// proxied by the gateway.
+func (a *API) RegisterRoutes(router *gin.Engine) {
+	v1 := router.Group("/api/v1")
+	quarantineRoutes := v1.Group("/process/quarantine")
+	{
+		quarantineRoutes.GET("", a.listQuarantinedRecordsHandler)
+		quarantineRoutes.GET("/:record_id", a.getQuarantinedRecordHandler)
+		quarantineRoutes.POST("/resubmit", a.resubmitQuarantinedRecordsHandler)
+		quarantineRoutes.PUT("/mappings/:source_id/:mapping_id", a.fixFieldMappingHandler)
+	}
+	scorecardRoutes := v1.Group("/process/scorecards")
+	{
+		scorecardRoutes.GET("", a.listScorecardsHandler)
+		scorecardRoutes.GET("/:scorecard_id", a.getScorecardHandler)
+	}
+	v1.GET("/process/entities/:instance_id/history", a.getEntityHistoryHandler)
+	materializationRoutes := v1.Group("/process/materializations")
+	{
+		materializationRoutes.GET("", a.listMaterializationsHandler)
+		materializationRoutes.GET("/:entity_id", a.getMaterializationHandler)
+		materializationRoutes.PUT("/:entity_id", a.materializeEntityHandler)
+		materializationRoutes.DELETE("/:entity_id", a.dropMaterializationHandler)
+	}
+	erasureRoutes := v1.Group("/process/erasures")
+	{
+		erasureRoutes.POST("", a.eraseSubjectHandler)
+		erasureRoutes.GET("", a.listErasureReceiptsHandler)
+		erasureRoutes.GET("/verification", a.verifyErasureReceiptsHandler)
+		erasureRoutes.GET("/:receipt_id", a.getErasureReceiptHandler)
+	}
+
+	internalRoutes := router.Group("/internal/v1")
+	{
+		internalRoutes.POST("/entities/:instance_id/reveal", a.revealEntityInstanceHandler)
+	}
+}
+
+// parsePaging reads the limit and offset query parameters into the given targets. It responds with
+// 400 and returns false if one is invalid.
+func parsePaging(c *gin.Context, limit, offset *int) bool {
+	for param, target := range map[string]*int{"limit": limit, "offset": offset} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " parameter. Must be a non-negative integer."})
+			return false
+		}
+		*target = n
+	}
+	return true
+}
+
+// listQuarantinedRecordsHandler lists quarantined records, filtered by the source_id, run_id,
+// status and mapping_id query parameters and paged with limit and offset.
+func (a *API) listQuarantinedRecordsHandler(c *gin.Context) {
+	filter := QuarantineFilter{
+		SourceID:  c.Query("source_id"),
+		RunID:     c.Query("run_id"),
+		Status:    c.Query("status"),
+		MappingID: c.Query("mapping_id"),
+	}
+	if filter.Status != "" && filter.Status != QuarantineStatusOpen && filter.Status != QuarantineStatusResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status parameter. Must be 'open' or 'resolved'."})
+		return
+	}
+	if !parsePaging(c, &filter.Limit, &filter.Offset) {
+		return
+	}
+
+	records, total, err := a.service.ListQuarantinedRecords(filter)
+	if err != nil {
+		log.Printf("Error listing quarantined records: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.service.RedactQuarantinedRecords(records)
+	c.JSON(http.StatusOK, gin.H{"data": records, "total": total})
+}
+
+func (a *API) getQuarantinedRecordHandler(c *gin.Context) {
+	record, err := a.service.GetQuarantinedRecord(c.Param("record_id"))
+	if err != nil {
+		c.JSON(quarantineErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	records := []QuarantinedRecord{*record}
+	a.service.RedactQuarantinedRecords(records)
+	c.JSON(http.StatusOK, records[0])
+}
+
+// ResubmitRequest selects the quarantined records to process again.
+type ResubmitRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// resubmitQuarantinedRecordsHandler processes the selected quarantined records again with the
+// current field mappings and returns them in their new state.
+func (a *API) resubmitQuarantinedRecordsHandler(c *gin.Context) {
+	var req ResubmitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids cannot be empty"})
+		return
+	}
+
+	records, err := a.service.ResubmitQuarantinedRecords(req.IDs)
+	if err != nil {
+		log.Printf("Error resubmitting quarantined records: %v", err)
+		c.JSON(quarantineErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	a.service.RedactQuarantinedRecords(records)
+	resolved := 0
+	for _, r := range records {
+		if r.Status == QuarantineStatusResolved {
+			resolved++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"records_resubmitted": len(records),
+		"records_resolved":    resolved,
+		"data":                records,
+	})
+}
+
+// fixFieldMappingHandler changes a field mapping of the data source in the metadata service and
+// returns the open quarantined records with an error of that mapping, ready to be resubmitted.
+func (a *API) fixFieldMappingHandler(c *gin.Context) {
+	sourceID := c.Param("source_id")
+	mappingID := c.Param("mapping_id")
+	var fix MappingFix
+	if err := c.ShouldBindJSON(&fix); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+
+	mapping, affected, err := a.service.FixFieldMapping(sourceID, mappingID, fix)
+	if err != nil {
+		log.Printf("Error fixing field mapping %s of source %s: %v", mappingID, sourceID, err)
+		c.JSON(quarantineErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mapping":             mapping,
+		"affected_record_ids": affected,
+	})
+}
+
+// listScorecardsHandler lists data quality scorecards, most recently updated first, filtered by the
+// source_id, run_id and status query parameters and paged with limit and offset.
+func (a *API) listScorecardsHandler(c *gin.Context) {
+	filter := ScorecardFilter{
+		SourceID: c.Query("source_id"),
+		RunID:    c.Query("run_id"),
+		Status:   c.Query("status"),
+	}
+	switch filter.Status {
+	case "", QualityStatusPassed, QualityStatusWarning, QualityStatusFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status parameter. Must be 'passed', 'warning' or 'failed'."})
+		return
+	}
+	if !parsePaging(c, &filter.Limit, &filter.Offset) {
+		return
+	}
+
+	cards, total, err := a.service.ListScorecards(filter)
+	if err != nil {
+		log.Printf("Error listing quality scorecards: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": cards, "total": total})
+}
+
+func (a *API) getScorecardHandler(c *gin.Context) {
+	card, err := a.service.GetScorecard(c.Param("scorecard_id"))
+	if errors.Is(err, ErrScorecardNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting quality scorecard: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// getEntityHistoryHandler returns the versions of an entity instance, oldest first. The attribute
+// query parameter restricts them to the versions in which that attribute changed.
+func (a *API) getEntityHistoryHandler(c *gin.Context) {
+	versions, err := a.service.GetEntityHistory(c.Param("instance_id"), c.Query("attribute"))
+	if errors.Is(err, ErrEntityNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting entity history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": versions, "total": len(versions)})
+}
+
+func (a *API) listMaterializationsHandler(c *gin.Context) {
+	materializations, err := a.service.ListMaterializations()
+	if err != nil {
+		log.Printf("Error listing materializations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": materializations, "total": len(materializations)})
+}
+
+func (a *API) getMaterializationHandler(c *gin.Context) {
+	m, err := a.service.GetMaterialization(c.Param("entity_id"))
+	if errors.Is(err, ErrMaterializationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting materialization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// materializeEntityHandler materializes an entity definition into a typed table, or rebuilds the
+// table from the current attribute definitions if it already is.
+func (a *API) materializeEntityHandler(c *gin.Context) {
+	m, err := a.service.MaterializeEntity(c.Param("entity_id"))
+	if err != nil {
+		log.Printf("Error materializing entity definition: %v", err)
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "non-OK status 404") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+func (a *API) dropMaterializationHandler(c *gin.Context) {
+	err := a.service.DropMaterialization(c.Param("entity_id"))
+	if errors.Is(err, ErrMaterializationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error dropping materialization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// eraseSubjectHandler erases the data subject identified by the request and returns the receipt.
+func (a *API) eraseSubjectHandler(c *gin.Context) {
+	var req ErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+	receipt, err := a.service.EraseSubject(req)
+	if err != nil {
+		log.Printf("Error erasing data subject of entity definition %s: %v", req.EntityDefinitionID, err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrInvalidErasureRequest):
+			status = http.StatusBadRequest
+		case strings.Contains(err.Error(), "non-OK status 404"):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, receipt)
+}
+
+// listErasureReceiptsHandler lists erasure receipts, latest first, filtered by the entity_definition_id
+// query parameter and paged with limit and offset.
+func (a *API) listErasureReceiptsHandler(c *gin.Context) {
+	filter := ErasureReceiptFilter{EntityDefinitionID: c.Query("entity_definition_id")}
+	if !parsePaging(c, &filter.Limit, &filter.Offset) {
+		return
+	}
+	receipts, total, err := a.service.ListErasureReceipts(filter)
+	if err != nil {
+		log.Printf("Error listing erasure receipts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": receipts, "total": total})
+}
+
+func (a *API) getErasureReceiptHandler(c *gin.Context) {
+	receipt, err := a.service.GetErasureReceipt(c.Param("receipt_id"))
+	if errors.Is(err, ErrErasureReceiptNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting erasure receipt: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// verifyErasureReceiptsHandler checks the erasure receipt chain. A broken chain is reported in the
+// response body, not as an error status.
+func (a *API) verifyErasureReceiptsHandler(c *gin.Context) {
+	verification, err := a.service.VerifyErasureReceipts()
+	if err != nil {
+		log.Printf("Error verifying erasure receipts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, verification)
+}
+
+// RevealRequest names the action type of the executor asking for an entity instance.
+type RevealRequest struct {
+	ActionType string `json:"action_type" binding:"required"`
+}
+
+// revealEntityInstanceHandler returns an entity instance with the PII attributes the action type is
+// authorized for in cleartext and the others redacted.
+func (a *API) revealEntityInstanceHandler(c *gin.Context) {
+	var req RevealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload: " + err.Error()})
+		return
+	}
+	entity, err := a.service.RevealEntityInstance(c.Param("instance_id"), req.ActionType)
+	if errors.Is(err, ErrEntityNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error revealing entity instance for action type %s: %v", req.ActionType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entity)
+}
+
+func quarantineErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrQuarantinedRecordNotFound), strings.Contains(err.Error(), "not found"):
+		return http.StatusNotFound
+	case errors.Is(err, ErrQuarantinedRecordResolved):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidMappingFix), strings.Contains(err.Error(), "non-OK status 400"):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
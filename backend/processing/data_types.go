@@ -0,0 +1,226 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeOfDayLayouts are the layouts accepted for "time" attributes.
+var timeOfDayLayouts = []string{
+	"15:04:05.999999999", "15:04:05", "15:04", "3:04:05 PM", "3:04:05PM", "3:04 PM", "3:04PM",
+	"15:04:05Z07:00", "15:04:05-0700",
+}
+
+// referenceChecker reports an error when instanceID is not an instance of the entity definition
+// entityDefinitionID.
+type referenceChecker func(entityDefinitionID, instanceID string) error
+
+// convertAttributeValue converts a mapped value to the data type of the attribute and validates it
+// against the attribute's DataTypeDetails. Scalar types are converted by convertToTargetType.
+func (s *ProcessingService) convertAttributeValue(value interface{}, attrDef *AttributeDefinition) (interface{}, error) {
+	return convertTypedValue(value, attrDef.DataType, attrDef.DataTypeDetails, s.checkReference)
+}
+
+// convertTypedValue converts value to the base data type typeName (see BaseDataTypeName in the
+// metadata service):
+//
+//   - date: a time.Time at midnight UTC
+//   - time: a "15:04:05" string
+//   - enum: one of details["values"]; a value differing only in case is replaced by the allowed one
+//   - array: a list (or a JSON array string) whose items are converted to details["item_type_name"]
+//     with details["item_type_details"]
+//   - object: a map (or a JSON object string) whose fields are converted to the types in
+//     details["schema"]; fields not in a given schema are rejected
+//   - reference: the ID of an existing instance of details["referenced_entity_id"]
+//   - json: any JSON value; strings holding a JSON document are decoded
+func convertTypedValue(value interface{}, typeName string, details map[string]interface{}, checkRef referenceChecker) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch strings.ToLower(typeName) {
+	case "date":
+		converted, err := convertToTargetType(value, "date")
+		if err != nil {
+			return nil, err
+		}
+		t := converted.(time.Time)
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case "time":
+		return convertTimeOfDay(value)
+	case "enum":
+		return convertEnum(value, details)
+	case "array":
+		return convertArray(value, details, checkRef)
+	case "object":
+		return convertObject(value, details, checkRef)
+	case "reference":
+		return convertReference(value, details, checkRef)
+	case "json":
+		if s, ok := value.(string); ok && json.Valid([]byte(s)) {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(s), &decoded); err == nil {
+				return decoded, nil
+			}
+		}
+		return value, nil
+	default:
+		return convertToTargetType(value, typeName)
+	}
+}
+
+func convertTimeOfDay(value interface{}) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.Format("15:04:05"), nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v (%T) to time", value, value)
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range timeOfDayLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("15:04:05"), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot parse time of day '%s'", s)
+}
+
+func convertEnum(value interface{}, details map[string]interface{}) (interface{}, error) {
+	allowed, ok := details["values"].([]interface{})
+	if !ok || len(allowed) == 0 {
+		return nil, fmt.Errorf("enum attribute has no allowed values in data_type_details.values")
+	}
+	s := strings.TrimSpace(fmt.Sprintf("%v", value))
+	for _, v := range allowed {
+		if fmt.Sprintf("%v", v) == s {
+			return v, nil
+		}
+	}
+	for _, v := range allowed {
+		if candidate, isStr := v.(string); isStr && strings.EqualFold(candidate, s) {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not one of the allowed values %v", s, allowed)
+}
+
+func convertArray(value interface{}, details map[string]interface{}, checkRef referenceChecker) (interface{}, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		s, isStr := value.(string)
+		if !isStr || json.Unmarshal([]byte(s), &items) != nil {
+			return nil, fmt.Errorf("cannot convert %v (%T) to array", value, value)
+		}
+	}
+	itemType, _ := details["item_type_name"].(string)
+	if itemType == "" {
+		return items, nil
+	}
+	itemDetails, _ := details["item_type_details"].(map[string]interface{})
+	converted := make([]interface{}, len(items))
+	for i, item := range items {
+		v, err := convertTypedValue(item, itemType, itemDetails, checkRef)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+func convertObject(value interface{}, details map[string]interface{}, checkRef referenceChecker) (interface{}, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		s, isStr := value.(string)
+		if !isStr || json.Unmarshal([]byte(s), &obj) != nil || obj == nil {
+			return nil, fmt.Errorf("cannot convert %v (%T) to object", value, value)
+		}
+	}
+	schema, ok := details["schema"].(map[string]interface{})
+	if !ok {
+		return obj, nil
+	}
+	converted := make(map[string]interface{}, len(obj))
+	fields := make([]string, 0, len(obj))
+	for field := range obj {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields) // Report the first invalid field deterministically
+	for _, field := range fields {
+		fieldType, fieldDetails, ok := schemaFieldType(schema[field])
+		if !ok {
+			return nil, fmt.Errorf("field '%s' is not in the object schema", field)
+		}
+		v, err := convertTypedValue(obj[field], fieldType, fieldDetails, checkRef)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %w", field, err)
+		}
+		converted[field] = v
+	}
+	return converted, nil
+}
+
+// schemaFieldType reads the type of an object schema field, given either as a type name
+// ("integer") or as {"data_type_name": "array", "data_type_details": {...}}.
+func schemaFieldType(spec interface{}) (string, map[string]interface{}, bool) {
+	switch s := spec.(type) {
+	case string:
+		return s, nil, s != ""
+	case map[string]interface{}:
+		name, _ := s["data_type_name"].(string)
+		details, _ := s["data_type_details"].(map[string]interface{})
+		return name, details, name != ""
+	default:
+		return "", nil, false
+	}
+}
+
+func convertReference(value interface{}, details map[string]interface{}, checkRef referenceChecker) (interface{}, error) {
+	entityID, _ := details["referenced_entity_id"].(string)
+	if entityID == "" {
+		return nil, fmt.Errorf("reference attribute has no data_type_details.referenced_entity_id")
+	}
+	var instanceID string
+	switch v := value.(type) {
+	case string:
+		instanceID = strings.TrimSpace(v)
+	case float64:
+		instanceID = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		instanceID = fmt.Sprintf("%v", v)
+	}
+	if instanceID == "" {
+		return nil, nil
+	}
+	if checkRef != nil {
+		if err := checkRef(entityID, instanceID); err != nil {
+			return nil, err
+		}
+	}
+	return instanceID, nil
+}
+
+// checkReference verifies that instanceID is the ID or the source record identifier of a stored,
+// not deleted instance of the entity definition. Instances stored by the same processing run are
+// not visible yet. Without a database there is nothing to check against.
+func (s *ProcessingService) checkReference(entityDefinitionID, instanceID string) error {
+	if s.db == nil {
+		return nil
+	}
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (
+            SELECT 1 FROM processed_entities
+            WHERE entity_definition_id = $1 AND (id::text = $2 OR raw_record_identifier = $2) AND deleted_at IS NULL)`,
+		entityDefinitionID, instanceID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up referenced instance '%s': %w", instanceID, err)
+	}
+	if !exists {
+		return fmt.Errorf("referenced instance '%s' of entity %s does not exist", instanceID, entityDefinitionID)
+	}
+	return nil
+}
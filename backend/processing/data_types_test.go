@@ -0,0 +1,112 @@
+package processing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertTypedValue(t *testing.T) {
+	knownInstances := map[string]bool{"customer:c-1": true, "customer:42": true}
+	checkRef := func(entityID, instanceID string) error {
+		if !knownInstances[entityID+":"+instanceID] {
+			return fmt.Errorf("referenced instance '%s' of entity %s does not exist", instanceID, entityID)
+		}
+		return nil
+	}
+	enumDetails := map[string]interface{}{"values": []interface{}{"active", "Suspended", "closed"}}
+	refDetails := map[string]interface{}{"referenced_entity_id": "customer"}
+	addressDetails := map[string]interface{}{"schema": map[string]interface{}{
+		"street": "string",
+		"zip":    "integer",
+		"tags":   map[string]interface{}{"data_type_name": "array", "data_type_details": map[string]interface{}{"item_type_name": "string"}},
+	}}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		typeName string
+		details  map[string]interface{}
+		expected interface{}
+	}{
+		{"Scalar Types Use convertToTargetType", "42", "integer", nil, int64(42)},
+		{"Date Drops Time Of Day", "2023-10-27T22:15:00Z", "date", nil, time.Date(2023, 10, 27, 0, 0, 0, 0, time.UTC)},
+		{"Time 24 Hour", "08:30", "time", nil, "08:30:00"},
+		{"Time 12 Hour", "2:05:09 PM", "time", nil, "14:05:09"},
+		{"Time From Datetime", time.Date(2023, 1, 1, 7, 8, 9, 0, time.UTC), "time", nil, "07:08:09"},
+		{"Enum Exact", "active", "enum", enumDetails, "active"},
+		{"Enum Case Insensitive Uses Allowed Spelling", "SUSPENDED", "enum", enumDetails, "Suspended"},
+		{"Array Of Integers", []interface{}{"1", 2.0, "3"}, "array", map[string]interface{}{"item_type_name": "integer"}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"Array From JSON String", `["a","b"]`, "array", nil, []interface{}{"a", "b"}},
+		{"Array Of Enums", []interface{}{"closed", "Active"}, "array", map[string]interface{}{"item_type_name": "enum", "item_type_details": enumDetails}, []interface{}{"closed", "active"}},
+		{"Object With Schema", map[string]interface{}{"street": "Main St", "zip": "12345", "tags": []interface{}{"home"}}, "object", addressDetails,
+			map[string]interface{}{"street": "Main St", "zip": int64(12345), "tags": []interface{}{"home"}}},
+		{"Object From JSON String", `{"zip": 1}`, "object", addressDetails, map[string]interface{}{"zip": int64(1)}},
+		{"Object Without Schema", map[string]interface{}{"any": true}, "object", nil, map[string]interface{}{"any": true}},
+		{"Reference", " c-1 ", "reference", refDetails, "c-1"},
+		{"Numeric Reference", 42.0, "reference", refDetails, "42"},
+		{"JSON Document String", `{"a":[1,2]}`, "json", nil, map[string]interface{}{"a": []interface{}{1.0, 2.0}}},
+		{"JSON Plain String", "not json", "json", nil, "not json"},
+		{"JSON Value", []interface{}{1.0}, "json", nil, []interface{}{1.0}},
+		{"Null", nil, "enum", enumDetails, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := convertTypedValue(tt.value, tt.typeName, tt.details, checkRef)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, converted)
+		})
+	}
+
+	failures := []struct {
+		name     string
+		value    interface{}
+		typeName string
+		details  map[string]interface{}
+		contains string
+	}{
+		{"Invalid Time", "25:99", "time", nil, "cannot parse time of day"},
+		{"Enum Value Not Allowed", "deleted", "enum", enumDetails, "not one of the allowed values"},
+		{"Enum Without Values", "x", "enum", nil, "no allowed values"},
+		{"Array Item Of Wrong Type", []interface{}{"1", "two"}, "array", map[string]interface{}{"item_type_name": "integer"}, "item 1"},
+		{"Not An Array", "a,b", "array", nil, "cannot convert"},
+		{"Object Field Of Wrong Type", map[string]interface{}{"zip": "abc"}, "object", addressDetails, "field 'zip'"},
+		{"Object Field Not In Schema", map[string]interface{}{"country": "NL"}, "object", addressDetails, "field 'country' is not in the object schema"},
+		{"Nested Array In Object", map[string]interface{}{"tags": "home"}, "object", addressDetails, "field 'tags': cannot convert"},
+		{"Not An Object", 12.0, "object", addressDetails, "cannot convert"},
+		{"Dangling Reference", "c-2", "reference", refDetails, "does not exist"},
+		{"Reference Without Entity", "c-1", "reference", nil, "referenced_entity_id"},
+		{"Unsupported Type", "x", "customtype", nil, "unsupported target data type"},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := convertTypedValue(tt.value, tt.typeName, tt.details, checkRef)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.contains)
+		})
+	}
+}
+
+func TestCheckReference(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+	service := NewProcessingService(&MockMetadataServiceClient{}, testDB)
+	require.NoError(t, clearTablesForDBTests(testDB, "processed_entities"))
+
+	instanceID := uuid.New()
+	_, err := testDB.Exec(`INSERT INTO processed_entities (id, entity_definition_id, entity_type_name, source_id, attributes, raw_record_identifier)
+        VALUES ($1, 'customer-def', 'Customer', 'crm', '{}', 'cust-7')`, instanceID)
+	require.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO processed_entities (id, entity_definition_id, entity_type_name, source_id, attributes, raw_record_identifier, deleted_at)
+        VALUES ($1, 'customer-def', 'Customer', 'crm', '{}', 'cust-8', NOW())`, uuid.New())
+	require.NoError(t, err)
+
+	assert.NoError(t, service.checkReference("customer-def", instanceID.String()), "instance ID")
+	assert.NoError(t, service.checkReference("customer-def", "cust-7"), "source record identifier")
+	assert.Error(t, service.checkReference("customer-def", "cust-8"), "deleted instance")
+	assert.Error(t, service.checkReference("order-def", "cust-7"), "instance of another entity")
+	assert.Error(t, service.checkReference("customer-def", "cust-9"), "unknown instance")
+}
@@ -0,0 +1,712 @@
+package processing
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Erasure honors data subject deletion requests. A request names an entity definition and a key, e.g. the
+// attribute Email with the value ann@example.com, and EraseSubject, in a single transaction:
+//
+//   - deletes the matching instances from processed_entities and from the typed table of the entity,
+//   - removes them from the group_memberships of the grouping service, which shares the database,
+//   - purges their history, the quarantined records of the subject and the token vault entries that no
+//     other instance uses,
+//   - adds the key value and the source records of the instances to the suppression list, and
+//   - appends a receipt to the chain of erasure receipts.
+//
+// Receipts and the suppression list hold no PII: the subject is recorded as a hash, keyed with the PII keys
+// if they are configured. Each receipt's hash covers its content and the hash of the receipt before it, so
+// VerifyErasureReceipts detects receipts that were changed, removed or reordered afterwards.
+//
+// Processing skips records that match the suppression list, so re-ingesting a source record of an erased
+// subject does not bring it back.
+
+// ErrInvalidErasureRequest is returned for erasure requests whose key cannot be matched.
+var ErrInvalidErasureRequest = errors.New("invalid erasure request")
+
+// ErrErasureReceiptNotFound is returned for unknown erasure receipt IDs.
+var ErrErasureReceiptNotFound = errors.New("erasure receipt not found")
+
+// erasureChainGenesis is the previous hash of the first receipt.
+var erasureChainGenesis = strings.Repeat("0", sha256.Size*2)
+
+// Scopes of the suppression list: the value of a key attribute, or the identifier of a source record.
+const (
+	suppressionScopeAttribute = "attribute:"
+	suppressionScopeRecord    = "record:"
+)
+
+// ErasureRequest identifies a data subject by the value of an attribute of an entity definition.
+type ErasureRequest struct {
+	EntityDefinitionID string `json:"entity_definition_id" binding:"required"`
+	Attribute          string `json:"attribute" binding:"required"` // Name of the key attribute
+	Value              string `json:"value" binding:"required"`     // Converted to the attribute's data type
+	RequestedBy        string `json:"requested_by,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+}
+
+// ErasureReceipt records what an erasure removed. It is the Sequence-th link of the receipt chain.
+type ErasureReceipt struct {
+	ID                       string    `json:"id"`
+	Sequence                 int64     `json:"sequence"`
+	EntityDefinitionID       string    `json:"entity_definition_id"`
+	AttributeID              string    `json:"attribute_id"`
+	AttributeName            string    `json:"attribute_name"`
+	SubjectHash              string    `json:"subject_hash"`
+	RequestedBy              string    `json:"requested_by,omitempty"`
+	Reason                   string    `json:"reason,omitempty"`
+	InstanceIDs              []string  `json:"instance_ids"`
+	GroupMembershipsRemoved  int       `json:"group_memberships_removed"`
+	HistoryVersionsPurged    int       `json:"history_versions_purged"`
+	QuarantinedRecordsPurged int       `json:"quarantined_records_purged"`
+	PIITokensPurged          int       `json:"pii_tokens_purged"`
+	ErasedAt                 time.Time `json:"erased_at"`
+	PreviousHash             string    `json:"previous_hash"`
+	ReceiptHash              string    `json:"receipt_hash"`
+}
+
+// computeHash returns the SHA-256 hash of the receipt's content, which includes the previous hash.
+func (r *ErasureReceipt) computeHash() string {
+	content := *r
+	content.ReceiptHash = ""
+	content.ErasedAt = r.ErasedAt.UTC().Truncate(time.Microsecond)
+	if content.InstanceIDs == nil {
+		content.InstanceIDs = []string{}
+	}
+	encoded, _ := json.Marshal(content)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErasureReceiptFilter selects erasure receipts.
+type ErasureReceiptFilter struct {
+	EntityDefinitionID string
+	Limit              int
+	Offset             int
+}
+
+// ErasureChainVerification is the outcome of VerifyErasureReceipts. LastHash is the hash of the latest
+// receipt; comparing it with a copy kept elsewhere also detects removed receipts at the end of the chain.
+type ErasureChainVerification struct {
+	Valid                bool   `json:"valid"`
+	Receipts             int    `json:"receipts"`
+	LastHash             string `json:"last_hash"`
+	FirstInvalidSequence int64  `json:"first_invalid_sequence,omitempty"`
+	Error                string `json:"error,omitempty"`
+}
+
+// suppressionKey returns the hash under which a subject is kept on the suppression list. scope is
+// suppressionScopeAttribute plus the attribute ID for a JSON encoded key value, or suppressionScopeRecord
+// plus the source ID for a record identifier. With PII keys the hash is keyed, so that the list cannot be
+// matched against guessed values without them.
+func (s *ProcessingService) suppressionKey(scope string, subject []byte) string {
+	if s.piiKeys != nil {
+		return hex.EncodeToString(s.piiKeys.mac("suppression:"+scope, subject))
+	}
+	h := sha256.New()
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write(subject)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// purgedInstance is an instance to be deleted, matched by an erasure request or expired by a retention policy.
+type purgedInstance struct {
+	id         string
+	sourceID   string
+	identifier string
+	attributes map[string]interface{}
+}
+
+// EraseSubject removes the data of the subject identified by req from the pipeline, suppresses its
+// re-ingestion and returns the receipt of the erasure. A request without matching instances still purges
+// quarantined records, suppresses the key value and gets a receipt.
+func (s *ProcessingService) EraseSubject(req ErasureRequest) (*ErasureReceipt, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("erasure requires a database")
+	}
+	attrDefs, err := s.metadataClient.ListAttributeDefinitions(req.EntityDefinitionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attribute definitions of entity definition %s: %w", req.EntityDefinitionID, err)
+	}
+	var attrDef *AttributeDefinition
+	for i := range attrDefs {
+		if attrDefs[i].Name == req.Attribute {
+			attrDef = &attrDefs[i]
+			break
+		}
+	}
+	if attrDef == nil {
+		return nil, fmt.Errorf("%w: entity definition %s has no attribute '%s'", ErrInvalidErasureRequest, req.EntityDefinitionID, req.Attribute)
+	}
+	value, err := convertToTargetType(req.Value, attrDef.DataType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: value is not a valid %s: %v", ErrInvalidErasureRequest, attrDef.DataType, err)
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to encode value: %v", ErrInvalidErasureRequest, err)
+	}
+	if err := s.checkPIIKeys(map[string]*AttributeDefinition{attrDef.ID: attrDef}); err != nil {
+		return nil, err
+	}
+	quarantineFields, err := s.quarantineKeyFields(req.EntityDefinitionID, attrDef.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &ErasureReceipt{
+		ID:                 uuid.NewString(),
+		EntityDefinitionID: req.EntityDefinitionID,
+		AttributeID:        attrDef.ID,
+		AttributeName:      attrDef.Name,
+		SubjectHash:        s.suppressionKey(suppressionScopeAttribute+attrDef.ID, encodedValue),
+		RequestedBy:        req.RequestedBy,
+		Reason:             req.Reason,
+		InstanceIDs:        []string{},
+		ErasedAt:           time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin database transaction: %w", err)
+	}
+	defer tx.Rollback()
+	// Receipts are chained, so erasures are recorded one at a time.
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('erasure_receipts'))`); err != nil {
+		return nil, fmt.Errorf("failed to lock the erasure receipt chain: %w", err)
+	}
+
+	instances, err := s.findSubjectInstances(tx, req.EntityDefinitionID, attrDef, value, encodedValue)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		receipt.InstanceIDs = append(receipt.InstanceIDs, instance.id)
+	}
+	purged, err := s.purgeInstances(tx, req.EntityDefinitionID, instances)
+	if err != nil {
+		return nil, err
+	}
+	receipt.GroupMembershipsRemoved = purged.groupMemberships
+	receipt.HistoryVersionsPurged = purged.historyVersions
+	receipt.PIITokensPurged = purged.piiTokens
+
+	// Quarantined records of the erased source records, and those with the key value in a source field
+	// mapped to the key attribute.
+	for _, instance := range instances {
+		if instance.identifier == "" {
+			continue
+		}
+		result, err := tx.Exec(`DELETE FROM quarantined_records WHERE source_id = $1 AND raw_record_identifier = $2`, instance.sourceID, instance.identifier)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge quarantined records of instance %s: %w", instance.id, err)
+		}
+		receipt.QuarantinedRecordsPurged += rowsAffected(result)
+	}
+	for sourceID, fields := range quarantineFields {
+		for _, field := range fields {
+			result, err := tx.Exec(`DELETE FROM quarantined_records WHERE source_id = $1 AND raw_record ->> $2 = $3`, sourceID, field, req.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to purge quarantined records of source %s: %w", sourceID, err)
+			}
+			receipt.QuarantinedRecordsPurged += rowsAffected(result)
+		}
+	}
+
+	suppressions := map[string]string{receipt.SubjectHash: suppressionScopeAttribute + attrDef.ID}
+	for _, instance := range instances {
+		if instance.identifier != "" {
+			scope := suppressionScopeRecord + instance.sourceID
+			suppressions[s.suppressionKey(scope, []byte(instance.identifier))] = scope
+		}
+	}
+	for key, scope := range suppressions {
+		if _, err := tx.Exec(`INSERT INTO erasure_suppressions (key_hash, scope, entity_definition_id, receipt_id, created_at)
+            VALUES ($1, $2, $3, $4, $5) ON CONFLICT (key_hash) DO NOTHING`, key, scope, req.EntityDefinitionID, receipt.ID, receipt.ErasedAt); err != nil {
+			return nil, fmt.Errorf("failed to add erased subject to the suppression list: %w", err)
+		}
+	}
+
+	if err := appendErasureReceipt(tx, receipt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+	log.Printf("Erased %d instances of entity definition %s by attribute '%s' (%d group memberships, %d history versions, %d quarantined records, %d tokens). Receipt %s, sequence %d.",
+		len(instances), req.EntityDefinitionID, attrDef.Name, receipt.GroupMembershipsRemoved, receipt.HistoryVersionsPurged, receipt.QuarantinedRecordsPurged, receipt.PIITokensPurged, receipt.ID, receipt.Sequence)
+	return receipt, nil
+}
+
+// quarantineKeyFields returns, by source ID, the source fields mapped to the key attribute of the sources
+// with quarantined records. Sources whose mappings cannot be fetched, e.g. because they were deleted, are
+// skipped; their records are only purged by record identifier.
+func (s *ProcessingService) quarantineKeyFields(entityDefinitionID, attributeID string) (map[string][]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT source_id FROM quarantined_records`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources of quarantined records: %w", err)
+	}
+	var sourceIDs []string
+	for rows.Next() {
+		var sourceID string
+		if err := rows.Scan(&sourceID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read source of quarantined records: %w", err)
+		}
+		sourceIDs = append(sourceIDs, sourceID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sources of quarantined records: %w", err)
+	}
+
+	fields := make(map[string][]string)
+	for _, sourceID := range sourceIDs {
+		mappings, err := s.metadataClient.GetDataSourceFieldMappings(sourceID)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch field mappings of source %s for erasure: %v. Its quarantined records are only purged by record identifier.", sourceID, err)
+			continue
+		}
+		for _, mapping := range mappings {
+			if mapping.AttributeID == attributeID && (mapping.EntityID == "" || mapping.EntityID == entityDefinitionID) {
+				fields[sourceID] = append(fields[sourceID], mapping.SourceFieldName)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// findSubjectInstances locks and returns the instances of the entity definition whose key attribute has
+// the value. Hashed and tokenized values are matched by their stored form, encrypted values are decrypted,
+// whatever the policy of the attribute was when they were stored.
+func (s *ProcessingService) findSubjectInstances(tx *sql.Tx, entityDefinitionID string, attrDef *AttributeDefinition, value interface{}, encodedValue []byte) ([]purgedInstance, error) {
+	forms := []string{string(encodedValue)}
+	if s.piiKeys != nil {
+		for _, storage := range []string{PiiStorageHash, PiiStorageTokenize} {
+			variant := *attrDef
+			variant.IsPii = true
+			variant.PiiPolicy = &AttributePiiPolicy{Storage: storage}
+			stored, _, err := s.piiKeys.protect(&variant, value)
+			if err != nil {
+				return nil, err
+			}
+			encoded, err := json.Marshal(stored)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode stored form of value: %w", err)
+			}
+			forms = append(forms, string(encoded))
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id, COALESCE(source_id, ''), COALESCE(raw_record_identifier, ''), attributes, pii_attributes
+        FROM processed_entities
+        WHERE entity_definition_id = $1
+          AND (attributes -> $2 = ANY($3::jsonb[]) OR pii_attributes ->> $2 = $4)
+        FOR UPDATE`, entityDefinitionID, attrDef.Name, pq.Array(forms), PiiStorageEncrypt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up instances of the subject: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []purgedInstance
+	for rows.Next() {
+		var instance purgedInstance
+		var attributesJSON, piiJSON []byte
+		if err := rows.Scan(&instance.id, &instance.sourceID, &instance.identifier, &attributesJSON, &piiJSON); err != nil {
+			return nil, fmt.Errorf("failed to read instance of the subject: %w", err)
+		}
+		if len(attributesJSON) > 0 {
+			if err := json.Unmarshal(attributesJSON, &instance.attributes); err != nil {
+				return nil, fmt.Errorf("failed to decode attributes of instance %s: %w", instance.id, err)
+			}
+		}
+		if stored, ok := instance.attributes[attrDef.Name].(string); ok && strings.HasPrefix(stored, piiEncryptedPrefix) {
+			cleartext, err := s.piiKeys.reveal(attrDef, stored, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt attribute '%s' of instance %s: %w", attrDef.Name, instance.id, err)
+			}
+			if encoded, err := json.Marshal(cleartext); err != nil || string(encoded) != string(encodedValue) {
+				continue
+			}
+		}
+		instances = append(instances, instance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up instances of the subject: %w", err)
+	}
+	return instances, nil
+}
+
+// purgeCounts counts the rows purgeInstances deleted along with the instances.
+type purgeCounts struct {
+	groupMemberships int
+	historyVersions  int
+	piiTokens        int
+}
+
+// purgeInstances deletes the instances, which belong to the entity definition, with their typed table rows,
+// group memberships, history and the tokens only they use.
+func (s *ProcessingService) purgeInstances(tx *sql.Tx, entityDefinitionID string, instances []purgedInstance) (purgeCounts, error) {
+	var counts purgeCounts
+	if len(instances) == 0 {
+		return counts, nil
+	}
+	ids := make([]string, len(instances))
+	for i, instance := range instances {
+		ids[i] = instance.id
+	}
+
+	// Tokens of the current attributes and of all versions.
+	tokens := make(map[string]bool)
+	collectTokens := func(attributes map[string]interface{}) {
+		for _, v := range attributes {
+			if token, ok := v.(string); ok && strings.HasPrefix(token, piiTokenPrefix) {
+				tokens[token] = true
+			}
+		}
+	}
+	for _, instance := range instances {
+		collectTokens(instance.attributes)
+	}
+
+	materialized, err := prepareMaterialized(tx, entityDefinitionID, map[string]*AttributeDefinition{})
+	if err != nil {
+		return counts, err
+	}
+	if materialized != nil {
+		defer materialized.Close()
+		for _, id := range ids {
+			if err := materialized.remove(id); err != nil {
+				return counts, err
+			}
+		}
+	}
+
+	var groupsExist bool
+	if err := tx.QueryRow(`SELECT to_regclass('group_memberships') IS NOT NULL`).Scan(&groupsExist); err != nil {
+		return counts, fmt.Errorf("failed to look up table group_memberships: %w", err)
+	}
+	if groupsExist {
+		rows, err := tx.Query(`DELETE FROM group_memberships WHERE processed_entity_instance_id = ANY($1::uuid[]) RETURNING group_definition_id`, pq.Array(ids))
+		if err != nil {
+			return counts, fmt.Errorf("failed to remove group memberships: %w", err)
+		}
+		groups := make(map[string]bool)
+		for rows.Next() {
+			var groupID string
+			if err := rows.Scan(&groupID); err != nil {
+				rows.Close()
+				return counts, fmt.Errorf("failed to read removed group membership: %w", err)
+			}
+			groups[groupID] = true
+			counts.groupMemberships++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return counts, fmt.Errorf("failed to remove group memberships: %w", err)
+		}
+		// Keep the member counts of the groups' last calculation in line with their memberships.
+		for groupID := range groups {
+			if _, err := tx.Exec(`UPDATE group_calculation_logs
+                SET member_count = (SELECT COUNT(*) FROM group_memberships WHERE group_definition_id = $1)
+                WHERE group_definition_id = $1`, groupID); err != nil {
+				return counts, fmt.Errorf("failed to update member count of group %s: %w", groupID, err)
+			}
+		}
+	}
+
+	rows, err := tx.Query(`DELETE FROM processed_entity_history WHERE entity_instance_id = ANY($1::uuid[]) RETURNING attributes`, pq.Array(ids))
+	if err != nil {
+		return counts, fmt.Errorf("failed to purge history: %w", err)
+	}
+	for rows.Next() {
+		var attributesJSON []byte
+		if err := rows.Scan(&attributesJSON); err != nil {
+			rows.Close()
+			return counts, fmt.Errorf("failed to read purged version: %w", err)
+		}
+		var attributes map[string]interface{}
+		if err := json.Unmarshal(attributesJSON, &attributes); err == nil {
+			collectTokens(attributes)
+		}
+		counts.historyVersions++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return counts, fmt.Errorf("failed to purge history: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM processed_entities WHERE id = ANY($1::uuid[])`, pq.Array(ids)); err != nil {
+		return counts, fmt.Errorf("failed to delete instances: %w", err)
+	}
+
+	// Equal values share a token, so tokens still used by other instances are kept. Tokens are per attribute,
+	// so only the instances of the same entity definition can use them.
+	for token := range tokens {
+		result, err := tx.Exec(`DELETE FROM pii_tokens WHERE token = $1
+            AND NOT EXISTS (SELECT 1 FROM processed_entities p, jsonb_each(p.attributes) a
+                            WHERE p.entity_definition_id = $2 AND a.value = to_jsonb($1::text))
+            AND NOT EXISTS (SELECT 1 FROM processed_entity_history h, jsonb_each(h.attributes) a
+                            WHERE h.entity_definition_id = $2 AND a.value = to_jsonb($1::text))`, token, entityDefinitionID)
+		if err != nil {
+			return counts, fmt.Errorf("failed to purge token: %w", err)
+		}
+		counts.piiTokens += rowsAffected(result)
+	}
+	return counts, nil
+}
+
+// appendErasureReceipt links the receipt to the latest one and stores it. The caller holds the chain lock.
+func appendErasureReceipt(tx *sql.Tx, receipt *ErasureReceipt) error {
+	var sequence int64
+	previousHash := erasureChainGenesis
+	err := tx.QueryRow(`SELECT sequence, receipt_hash FROM erasure_receipts ORDER BY sequence DESC LIMIT 1`).Scan(&sequence, &previousHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read the latest erasure receipt: %w", err)
+	}
+	receipt.Sequence = sequence + 1
+	receipt.PreviousHash = previousHash
+	receipt.ReceiptHash = receipt.computeHash()
+
+	instanceIDs, err := json.Marshal(receipt.InstanceIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance IDs: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO erasure_receipts (`+erasureReceiptColumns+`)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		receipt.ID, receipt.Sequence, receipt.EntityDefinitionID, receipt.AttributeID, receipt.AttributeName, receipt.SubjectHash,
+		sql.NullString{String: receipt.RequestedBy, Valid: receipt.RequestedBy != ""}, sql.NullString{String: receipt.Reason, Valid: receipt.Reason != ""},
+		instanceIDs, receipt.GroupMembershipsRemoved, receipt.HistoryVersionsPurged, receipt.QuarantinedRecordsPurged, receipt.PIITokensPurged,
+		receipt.ErasedAt, receipt.PreviousHash, receipt.ReceiptHash)
+	if err != nil {
+		return fmt.Errorf("failed to store erasure receipt: %w", err)
+	}
+	return nil
+}
+
+func rowsAffected(result sql.Result) int {
+	n, _ := result.RowsAffected()
+	return int(n)
+}
+
+// markSuppressed flags the records of a batch that match the suppression list: records with the identifier
+// of an erased source record, or with the value of an erased key. It returns the number of flagged records.
+func (s *ProcessingService) markSuppressed(sourceID string, attributeDefs map[string]*AttributeDefinition, rawData []map[string]interface{}, records []transformedRecord) (int, error) {
+	scopes := []string{suppressionScopeRecord + sourceID}
+	byScope := make(map[string]*AttributeDefinition)
+	for id, attrDef := range attributeDefs {
+		scope := suppressionScopeAttribute + id
+		scopes = append(scopes, scope)
+		byScope[scope] = attrDef
+	}
+	rows, err := s.db.Query(`SELECT DISTINCT scope FROM erasure_suppressions WHERE scope = ANY($1)`, pq.Array(scopes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the suppression list: %w", err)
+	}
+	var active []string
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read the suppression list: %w", err)
+		}
+		active = append(active, scope)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read the suppression list: %w", err)
+	}
+	if len(active) == 0 {
+		return 0, nil
+	}
+
+	candidates := make(map[string][]int)
+	for i := range records {
+		for _, scope := range active {
+			var subject []byte
+			if attrDef := byScope[scope]; attrDef != nil {
+				value := records[i].data[attrDef.Name]
+				if value == nil {
+					continue
+				}
+				if subject, err = json.Marshal(value); err != nil {
+					continue
+				}
+			} else if identifier := rawRecordIdentifier(rawData[i]); identifier != "" {
+				subject = []byte(identifier)
+			} else {
+				continue
+			}
+			key := s.suppressionKey(scope, subject)
+			candidates[key] = append(candidates[key], i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(candidates))
+	for key := range candidates {
+		keys = append(keys, key)
+	}
+	rows, err = s.db.Query(`SELECT key_hash FROM erasure_suppressions WHERE key_hash = ANY($1)`, pq.Array(keys))
+	if err != nil {
+		return 0, fmt.Errorf("failed to match records against the suppression list: %w", err)
+	}
+	defer rows.Close()
+	suppressed := 0
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return 0, fmt.Errorf("failed to match records against the suppression list: %w", err)
+		}
+		for _, i := range candidates[key] {
+			if !records[i].suppressed {
+				records[i].suppressed = true
+				suppressed++
+			}
+		}
+	}
+	return suppressed, rows.Err()
+}
+
+const erasureReceiptColumns = `id, sequence, entity_definition_id, attribute_id, attribute_name, subject_hash, requested_by, reason, instance_ids,
+        group_memberships_removed, history_versions_purged, quarantined_records_purged, pii_tokens_purged, erased_at, previous_hash, receipt_hash`
+
+func scanErasureReceipt(row interface{ Scan(...interface{}) error }) (*ErasureReceipt, error) {
+	var r ErasureReceipt
+	var requestedBy, reason sql.NullString
+	var instanceIDs []byte
+	if err := row.Scan(&r.ID, &r.Sequence, &r.EntityDefinitionID, &r.AttributeID, &r.AttributeName, &r.SubjectHash, &requestedBy, &reason,
+		&instanceIDs, &r.GroupMembershipsRemoved, &r.HistoryVersionsPurged, &r.QuarantinedRecordsPurged, &r.PIITokensPurged,
+		&r.ErasedAt, &r.PreviousHash, &r.ReceiptHash); err != nil {
+		return nil, err
+	}
+	r.RequestedBy = requestedBy.String
+	r.Reason = reason.String
+	r.ErasedAt = r.ErasedAt.UTC()
+	if err := json.Unmarshal(instanceIDs, &r.InstanceIDs); err != nil {
+		return nil, fmt.Errorf("failed to decode instance IDs of erasure receipt %s: %w", r.ID, err)
+	}
+	return &r, nil
+}
+
+// ListErasureReceipts returns the erasure receipts matching filter, latest first, and the total number of
+// matching receipts.
+func (s *ProcessingService) ListErasureReceipts(filter ErasureReceiptFilter) ([]ErasureReceipt, int, error) {
+	if s.db == nil {
+		return nil, 0, fmt.Errorf("erasure receipts require a database")
+	}
+	where := ""
+	var args []interface{}
+	if filter.EntityDefinitionID != "" {
+		args = append(args, filter.EntityDefinitionID)
+		where = " WHERE entity_definition_id = $1"
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM erasure_receipts"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count erasure receipts: %w", err)
+	}
+	query := "SELECT " + erasureReceiptColumns + " FROM erasure_receipts" + where + " ORDER BY sequence DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list erasure receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := []ErasureReceipt{}
+	for rows.Next() {
+		r, err := scanErasureReceipt(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read erasure receipt: %w", err)
+		}
+		receipts = append(receipts, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list erasure receipts: %w", err)
+	}
+	return receipts, total, nil
+}
+
+// GetErasureReceipt returns a single erasure receipt.
+func (s *ProcessingService) GetErasureReceipt(id string) (*ErasureReceipt, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("erasure receipts require a database")
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrErasureReceiptNotFound, id)
+	}
+	r, err := scanErasureReceipt(s.db.QueryRow("SELECT "+erasureReceiptColumns+" FROM erasure_receipts WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrErasureReceiptNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get erasure receipt %s: %w", id, err)
+	}
+	return r, nil
+}
+
+// VerifyErasureReceipts walks the receipt chain from the first receipt and checks that every receipt
+// matches its hash and links to the one before it without gaps.
+func (s *ProcessingService) VerifyErasureReceipts() (*ErasureChainVerification, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("erasure receipts require a database")
+	}
+	rows, err := s.db.Query("SELECT " + erasureReceiptColumns + " FROM erasure_receipts ORDER BY sequence")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read erasure receipts: %w", err)
+	}
+	defer rows.Close()
+
+	result := &ErasureChainVerification{Valid: true, LastHash: erasureChainGenesis}
+	for rows.Next() {
+		r, err := scanErasureReceipt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read erasure receipt: %w", err)
+		}
+		result.Receipts++
+		if !result.Valid {
+			continue
+		}
+		var problem string
+		switch {
+		case r.Sequence != int64(result.Receipts):
+			problem = fmt.Sprintf("expected sequence %d, receipts are missing", result.Receipts)
+		case r.PreviousHash != result.LastHash:
+			problem = "previous hash does not match the receipt before it"
+		case r.computeHash() != r.ReceiptHash:
+			problem = "receipt does not match its hash"
+		}
+		if problem != "" {
+			result.Valid = false
+			result.FirstInvalidSequence = r.Sequence
+			result.Error = fmt.Sprintf("receipt %s (sequence %d): %s", r.ID, r.Sequence, problem)
+			continue
+		}
+		result.LastHash = r.ReceiptHash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read erasure receipts: %w", err)
+	}
+	return result, nil
+}
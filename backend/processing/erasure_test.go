@@ -0,0 +1,229 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErasureReceiptHash(t *testing.T) {
+	receipt := ErasureReceipt{
+		ID:                 "5f0c7a9e-4a53-4c0e-9a55-2b0d8d7b7c11",
+		Sequence:           1,
+		EntityDefinitionID: "customer-def",
+		AttributeID:        "attr_email",
+		AttributeName:      "Email",
+		SubjectHash:        "abc",
+		InstanceIDs:        []string{"9b2f7f2e-0f1d-4a7c-8d8e-5f5b8a9c6d01"},
+		ErasedAt:           time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC),
+		PreviousHash:       erasureChainGenesis,
+	}
+	hash := receipt.computeHash()
+	assert.Len(t, hash, 64)
+
+	t.Run("Hash Survives A Database Round Trip", func(t *testing.T) {
+		stored := receipt
+		stored.ReceiptHash = hash
+		stored.ErasedAt = receipt.ErasedAt.Truncate(time.Microsecond).In(time.FixedZone("CET", 3600))
+		assert.Equal(t, hash, stored.computeHash())
+	})
+
+	t.Run("Hash Covers Content And Chain", func(t *testing.T) {
+		changed := receipt
+		changed.InstanceIDs = nil
+		assert.NotEqual(t, hash, changed.computeHash())
+		changed = receipt
+		changed.HistoryVersionsPurged = 1
+		assert.NotEqual(t, hash, changed.computeHash())
+		changed = receipt
+		changed.PreviousHash = strings.Repeat("1", 64)
+		assert.NotEqual(t, hash, changed.computeHash())
+	})
+
+	t.Run("Suppression Keys Are Keyed When PII Keys Are Configured", func(t *testing.T) {
+		service := NewProcessingService(nil, nil)
+		unkeyed := service.suppressionKey(suppressionScopeAttribute+"attr_email", []byte(`"ann@example.com"`))
+		assert.Equal(t, unkeyed, service.suppressionKey(suppressionScopeAttribute+"attr_email", []byte(`"ann@example.com"`)))
+		assert.NotEqual(t, unkeyed, service.suppressionKey(suppressionScopeAttribute+"attr_other", []byte(`"ann@example.com"`)))
+		service.SetPIIKeys(testPIIKeys(t))
+		assert.NotEqual(t, unkeyed, service.suppressionKey(suppressionScopeAttribute+"attr_email", []byte(`"ann@example.com"`)))
+	})
+}
+
+func TestErasure(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "erasureSource"
+	entityID := "erasure-def"
+	attrDefs := []AttributeDefinition{
+		*piiAttribute("attr_er_email", "Email", "string", PiiStorageEncrypt, "EMAIL"),
+		*piiAttribute("attr_er_phone", "Phone", "string", PiiStorageTokenize),
+		{ID: "attr_er_name", Name: "Name", DataType: "string"},
+	}
+	mappings := []DataSourceFieldMapping{
+		{ID: "map-er-email", SourceID: sourceID, SourceFieldName: "email", EntityID: entityID, AttributeID: "attr_er_email"},
+		{ID: "map-er-phone", SourceID: sourceID, SourceFieldName: "phone", EntityID: entityID, AttributeID: "attr_er_phone"},
+		{ID: "map-er-name", SourceID: sourceID, SourceFieldName: "name", EntityID: entityID, AttributeID: "attr_er_name"},
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			if sID != sourceID {
+				return nil, fmt.Errorf("metadata service returned non-OK status 404")
+			}
+			return mappings, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			for i := range attrDefs {
+				if attrDefs[i].ID == attrID {
+					return &attrDefs[i], nil
+				}
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		ListAttributeDefinitionsFunc: func(entID string) ([]AttributeDefinition, error) {
+			if entID != entityID {
+				return nil, fmt.Errorf("metadata service returned non-OK status 404 for attribute definitions")
+			}
+			return append([]AttributeDefinition(nil), attrDefs...), nil
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	service.SetPIIKeys(testPIIKeys(t))
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "processed_entity_history", "quarantined_records", "pii_tokens", "erasure_receipts", "erasure_suppressions"))
+	// Tables of the grouping service, which shares the database.
+	_, err := testDB.Exec(`CREATE TABLE IF NOT EXISTS group_calculation_logs (group_definition_id TEXT PRIMARY KEY, member_count INTEGER);
+        CREATE TABLE IF NOT EXISTS group_memberships (group_definition_id TEXT NOT NULL, processed_entity_instance_id UUID NOT NULL,
+            PRIMARY KEY (group_definition_id, processed_entity_instance_id));
+        TRUNCATE group_memberships, group_calculation_logs;`)
+	require.NoError(t, err)
+
+	ann := map[string]interface{}{"id": "c1", "email": "ann@example.com", "phone": "+1 555 0100", "name": "Ann"}
+	bob := map[string]interface{}{"id": "c2", "email": "bob@example.com", "phone": "+1 555 0199", "name": "Bob"}
+	_, err = service.ProcessAndStoreData(sourceID, "Customer", []map[string]interface{}{ann, bob})
+	require.NoError(t, err)
+	_, err = service.ProcessAndStoreData(sourceID, "Customer", []map[string]interface{}{{"id": "c1", "email": "ann@example.com", "phone": "+1 555 0101", "name": "Ann"}})
+	require.NoError(t, err)
+	instanceID := func(identifier string) string {
+		var id string
+		err := testDB.QueryRow(`SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = $2`, sourceID, identifier).Scan(&id)
+		if err != nil {
+			return ""
+		}
+		return id
+	}
+	annID, bobID := instanceID("c1"), instanceID("c2")
+	require.NotEmpty(t, annID)
+	_, err = testDB.Exec(`INSERT INTO group_calculation_logs (group_definition_id, member_count) VALUES ('grp-1', 2)`)
+	require.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO group_memberships (group_definition_id, processed_entity_instance_id) VALUES ('grp-1', $1), ('grp-1', $2)`, annID, bobID)
+	require.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO quarantined_records (id, source_id, entity_type_name, raw_record_identifier, raw_record, errors, rejected)
+        VALUES ('0d9c5b1e-8a8e-4c55-9f38-3f0b5d7c2a01', $1, 'Customer', 'c9', '{"id": "c9", "email": "ann@example.com"}', '[]', true),
+               ('0d9c5b1e-8a8e-4c55-9f38-3f0b5d7c2a02', $1, 'Customer', 'c8', '{"id": "c8", "email": "carl@example.com"}', '[]', true)`, sourceID)
+	require.NoError(t, err)
+
+	var receipt ErasureReceipt
+	t.Run("Erasure Removes The Subject Across The Pipeline", func(t *testing.T) {
+		w := request(http.MethodPost, "/api/v1/process/erasures", `{"entity_definition_id": "erasure-def", "attribute": "Email", "value": "ann@example.com", "requested_by": "dpo"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipt))
+		assert.NotContains(t, w.Body.String(), "ann@example.com")
+		assert.Equal(t, int64(1), receipt.Sequence)
+		assert.Equal(t, []string{annID}, receipt.InstanceIDs)
+		assert.Equal(t, 1, receipt.GroupMembershipsRemoved)
+		assert.Equal(t, 2, receipt.HistoryVersionsPurged)
+		assert.Equal(t, 1, receipt.QuarantinedRecordsPurged)
+		assert.Equal(t, 2, receipt.PIITokensPurged)
+
+		assert.Empty(t, instanceID("c1"))
+		assert.Equal(t, bobID, instanceID("c2"))
+		var count, memberCount int
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM processed_entity_history WHERE entity_instance_id = $1`, annID).Scan(&count))
+		assert.Zero(t, count)
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM group_memberships`).Scan(&count))
+		assert.Equal(t, 1, count)
+		require.NoError(t, testDB.QueryRow(`SELECT member_count FROM group_calculation_logs WHERE group_definition_id = 'grp-1'`).Scan(&memberCount))
+		assert.Equal(t, 1, memberCount)
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM quarantined_records`).Scan(&count))
+		assert.Equal(t, 1, count)
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM pii_tokens`).Scan(&count))
+		assert.Equal(t, 1, count, "Bob's token is kept")
+	})
+
+	t.Run("Re-Ingestion Is Suppressed", func(t *testing.T) {
+		count, err := service.ProcessAndStoreData(sourceID, "Customer", []map[string]interface{}{ann, bob, {"id": "c3", "email": "ann@example.com", "name": "Ann again"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Empty(t, instanceID("c1"))
+		assert.Empty(t, instanceID("c3"))
+		var quarantined int
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM quarantined_records`).Scan(&quarantined))
+		assert.Equal(t, 1, quarantined)
+	})
+
+	t.Run("Receipts Are Chained", func(t *testing.T) {
+		w := request(http.MethodPost, "/api/v1/process/erasures", `{"entity_definition_id": "erasure-def", "attribute": "Name", "value": "Nobody"}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var second ErasureReceipt
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+		assert.Equal(t, int64(2), second.Sequence)
+		assert.Equal(t, receipt.ReceiptHash, second.PreviousHash)
+		assert.Empty(t, second.InstanceIDs)
+
+		w = request(http.MethodGet, "/api/v1/process/erasures?entity_definition_id=erasure-def", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var list struct {
+			Data  []ErasureReceipt `json:"data"`
+			Total int              `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		assert.Equal(t, 2, list.Total)
+		assert.Equal(t, second.ID, list.Data[0].ID)
+
+		w = request(http.MethodGet, "/api/v1/process/erasures/"+receipt.ID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/v1/process/erasures/not-a-uuid", "").Code)
+
+		var verification ErasureChainVerification
+		w = request(http.MethodGet, "/api/v1/process/erasures/verification", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &verification))
+		assert.True(t, verification.Valid, verification.Error)
+		assert.Equal(t, 2, verification.Receipts)
+		assert.Equal(t, second.ReceiptHash, verification.LastHash)
+	})
+
+	t.Run("Tampering Is Detected", func(t *testing.T) {
+		_, err := testDB.Exec(`UPDATE erasure_receipts SET history_versions_purged = 0 WHERE sequence = 1`)
+		require.NoError(t, err)
+		verification, err := service.VerifyErasureReceipts()
+		require.NoError(t, err)
+		assert.False(t, verification.Valid)
+		assert.Equal(t, int64(1), verification.FirstInvalidSequence)
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/process/erasures", `{"entity_definition_id": "erasure-def", "attribute": "Unknown", "value": "x"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/process/erasures", `{"entity_definition_id": "erasure-def", "attribute": "Email"}`).Code)
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/api/v1/process/erasures", `{"entity_definition_id": "unknown-def", "attribute": "Email", "value": "x"}`).Code)
+	})
+}
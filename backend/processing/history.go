@@ -0,0 +1,241 @@
+package processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ErrEntityNotFound is returned for instance IDs without a stored instance or history.
+var ErrEntityNotFound = errors.New("entity instance not found")
+
+// EntityVersion is the state of an entity instance's attributes from ValidFrom until ValidTo
+// (slowly changing dimension type 2). The current version of an instance has no ValidTo; a
+// deleted instance has no current version.
+type EntityVersion struct {
+	ID                 string                 `json:"id"`
+	EntityInstanceID   string                 `json:"entity_instance_id"` // ID of the processed_entities row
+	EntityDefinitionID string                 `json:"entity_definition_id,omitempty"`
+	EntityTypeName     string                 `json:"entity_type_name"`
+	SourceID           string                 `json:"source_id,omitempty"`
+	Attributes         map[string]interface{} `json:"attributes"`
+	ChangedAttributes  []string               `json:"changed_attributes"` // Attributes that differ from the previous version
+	ValidFrom          time.Time              `json:"valid_from"`
+	ValidTo            *time.Time             `json:"valid_to,omitempty"`
+}
+
+// storedState is what an upsert is about to replace.
+type storedState struct {
+	attributes  map[string]interface{}
+	deleted     bool
+	processedAt time.Time
+}
+
+// historyWriter records attribute versions in the transaction of a processing batch.
+type historyWriter struct {
+	previousStmt *sql.Stmt
+	closeStmt    *sql.Stmt
+	insertStmt   *sql.Stmt
+	sourceID     string
+	entityDefID  sql.NullString
+	entityType   string
+	count        int // Versions written
+}
+
+func prepareHistory(tx *sql.Tx, sourceID, entityDefinitionID, entityTypeName string) (*historyWriter, error) {
+	h := &historyWriter{sourceID: sourceID, entityDefID: sql.NullString{String: entityDefinitionID, Valid: entityDefinitionID != ""}, entityType: entityTypeName}
+	var err error
+	// Locks the row so that concurrent batches for the same record write their versions in turn.
+	h.previousStmt, err = tx.Prepare(`SELECT attributes, deleted_at IS NOT NULL, processed_at FROM processed_entities
+        WHERE source_id = $1 AND raw_record_identifier = $2 FOR UPDATE`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare select statement for processed_entities: %w", err)
+	}
+	h.closeStmt, err = tx.Prepare(`UPDATE processed_entity_history SET valid_to = $2
+        WHERE entity_instance_id = $1 AND valid_to IS NULL`)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to prepare update statement for processed_entity_history: %w", err)
+	}
+	h.insertStmt, err = tx.Prepare(`INSERT INTO processed_entity_history (id, entity_instance_id, entity_definition_id, entity_type_name, source_id, attributes, changed_attributes, valid_from, valid_to)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to prepare insert statement for processed_entity_history: %w", err)
+	}
+	return h, nil
+}
+
+// Close releases the prepared statements.
+func (h *historyWriter) Close() {
+	for _, stmt := range []*sql.Stmt{h.previousStmt, h.closeStmt, h.insertStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// previous returns the stored state of the record with the given identifier, or nil if it was
+// never stored. Records without an identifier are always new.
+func (h *historyWriter) previous(identifier string) (*storedState, error) {
+	if identifier == "" {
+		return nil, nil
+	}
+	var state storedState
+	var attributesJSON []byte
+	err := h.previousStmt.QueryRow(h.sourceID, identifier).Scan(&attributesJSON, &state.deleted, &state.processedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored record %s: %w", identifier, err)
+	}
+	if err := json.Unmarshal(attributesJSON, &state.attributes); err != nil {
+		return nil, fmt.Errorf("failed to decode stored record %s: %w", identifier, err)
+	}
+	return &state, nil
+}
+
+// record writes a new version of an upserted instance if its attributes changed, or if it was
+// deleted before and reappears.
+func (h *historyWriter) record(instanceID string, prev *storedState, attributesJSON []byte, now time.Time) error {
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(attributesJSON, &attributes); err != nil {
+		return fmt.Errorf("failed to decode attributes of instance %s: %w", instanceID, err)
+	}
+	var changed []string
+	if prev == nil {
+		changed = changedAttributes(nil, attributes)
+	} else {
+		changed = changedAttributes(prev.attributes, attributes)
+		if len(changed) == 0 && !prev.deleted {
+			return nil
+		}
+		if err := h.end(instanceID, prev, now); err != nil {
+			return err
+		}
+	}
+	return h.insert(instanceID, attributesJSON, changed, now, nil)
+}
+
+// end closes the current version of an instance that is updated or deleted. An instance stored
+// before history was kept has no version yet; its stored state becomes its first version.
+func (h *historyWriter) end(instanceID string, prev *storedState, now time.Time) error {
+	if prev.deleted {
+		return nil
+	}
+	result, err := h.closeStmt.Exec(instanceID, now)
+	if err != nil {
+		return fmt.Errorf("failed to close current version of instance %s: %w", instanceID, err)
+	}
+	if closed, err := result.RowsAffected(); err != nil || closed > 0 {
+		return err
+	}
+	attributesJSON, err := json.Marshal(prev.attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attributes of instance %s: %w", instanceID, err)
+	}
+	return h.insert(instanceID, attributesJSON, changedAttributes(nil, prev.attributes), prev.processedAt, &now)
+}
+
+func (h *historyWriter) insert(instanceID string, attributesJSON []byte, changed []string, validFrom time.Time, validTo *time.Time) error {
+	_, err := h.insertStmt.Exec(uuid.New(), instanceID, h.entityDefID, h.entityType, h.sourceID, attributesJSON, pq.Array(changed), validFrom, validTo)
+	if err != nil {
+		return fmt.Errorf("failed to write version of instance %s: %w", instanceID, err)
+	}
+	h.count++
+	return nil
+}
+
+// changedAttributes returns the sorted names of the attributes that were added, removed or
+// changed between two versions.
+func changedAttributes(before, after map[string]interface{}) []string {
+	changed := []string{}
+	for name, value := range after {
+		if previous, ok := before[name]; !ok || !reflect.DeepEqual(previous, value) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// GetEntityHistory returns the versions of an entity instance, oldest first, with the values of its PII
+// attributes redacted. If attribute is set, only the versions in which that attribute changed are returned.
+func (s *ProcessingService) GetEntityHistory(instanceID string, attribute string) ([]EntityVersion, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("entity history requires a database")
+	}
+	if _, err := uuid.Parse(instanceID); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, instanceID)
+	}
+
+	var piiJSON []byte
+	exists := true
+	err := s.db.QueryRow(`SELECT pii_attributes FROM processed_entities WHERE id = $1`, instanceID).Scan(&piiJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		exists = false
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up instance %s: %w", instanceID, err)
+	}
+	piiAttributes := make(map[string]string)
+	if len(piiJSON) > 0 {
+		if err := json.Unmarshal(piiJSON, &piiAttributes); err != nil {
+			return nil, fmt.Errorf("failed to decode PII attributes of instance %s: %w", instanceID, err)
+		}
+	}
+
+	query := `SELECT id, entity_instance_id, COALESCE(entity_definition_id, ''), entity_type_name, COALESCE(source_id, ''),
+               attributes, changed_attributes, valid_from, valid_to
+        FROM processed_entity_history WHERE entity_instance_id = $1`
+	args := []interface{}{instanceID}
+	if attribute != "" {
+		query += ` AND $2 = ANY(changed_attributes)`
+		args = append(args, attribute)
+	}
+	rows, err := s.db.Query(query+` ORDER BY valid_from, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of instance %s: %w", instanceID, err)
+	}
+	defer rows.Close()
+
+	versions := []EntityVersion{}
+	for rows.Next() {
+		var v EntityVersion
+		var attributesJSON []byte
+		var validTo sql.NullTime
+		if err := rows.Scan(&v.ID, &v.EntityInstanceID, &v.EntityDefinitionID, &v.EntityTypeName, &v.SourceID,
+			&attributesJSON, pq.Array(&v.ChangedAttributes), &v.ValidFrom, &validTo); err != nil {
+			return nil, fmt.Errorf("failed to read history of instance %s: %w", instanceID, err)
+		}
+		if err := json.Unmarshal(attributesJSON, &v.Attributes); err != nil {
+			return nil, fmt.Errorf("failed to decode version %s: %w", v.ID, err)
+		}
+		v.Attributes = redactAttributes(v.Attributes, piiAttributes)
+		if validTo.Valid {
+			v.ValidTo = &validTo.Time
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get history of instance %s: %w", instanceID, err)
+	}
+
+	// An instance stored before history was kept has no versions yet.
+	if len(versions) == 0 && !exists {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, instanceID)
+	}
+	return versions, nil
+}
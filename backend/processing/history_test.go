@@ -0,0 +1,136 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedAttributes(t *testing.T) {
+	before := map[string]interface{}{"Status": "active", "Score": 3.0, "Tags": []interface{}{"a"}, "Old": "x"}
+	after := map[string]interface{}{"Status": "churned", "Score": 3.0, "Tags": []interface{}{"a"}, "New": "y"}
+	assert.Equal(t, []string{"New", "Old", "Status"}, changedAttributes(before, after))
+	assert.Empty(t, changedAttributes(after, after))
+	assert.Equal(t, []string{"Score", "Status"}, changedAttributes(nil, map[string]interface{}{"Status": "active", "Score": 1.0}))
+}
+
+func TestEntityHistory(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "historySource"
+	entityType := "Customer"
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: "customer-def"}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return []DataSourceFieldMapping{
+				{ID: "map-status", SourceID: sID, SourceFieldName: "status", EntityID: "customer-def", AttributeID: "attr_status"},
+				{ID: "map-name", SourceID: sID, SourceFieldName: "name", EntityID: "customer-def", AttributeID: "attr_name"},
+			}, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			switch attrID {
+			case "attr_status":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "Status", DataType: "string"}, nil
+			case "attr_name":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "Name", DataType: "string"}, nil
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+
+	reset := func(t *testing.T) {
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "processed_entity_history", "quarantined_records"))
+	}
+	instanceID := func(t *testing.T, identifier string) string {
+		var id string
+		require.NoError(t, testDB.QueryRow(`SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = $2`, sourceID, identifier).Scan(&id))
+		return id
+	}
+	ingest := func(t *testing.T, records ...map[string]interface{}) {
+		_, err := service.ProcessAndStoreData(sourceID, entityType, records)
+		require.NoError(t, err)
+	}
+
+	t.Run("Versions Follow Attribute Changes", func(t *testing.T) {
+		reset(t)
+		ingest(t, map[string]interface{}{"id": "c1", "status": "active", "name": "Ann"})
+		ingest(t, map[string]interface{}{"id": "c1", "status": "active", "name": "Ann"}) // Unchanged
+		ingest(t, map[string]interface{}{"id": "c1", "status": "churned", "name": "Ann"})
+		ingest(t, map[string]interface{}{"id": "c1", DeletedRecordField: true})
+		ingest(t, map[string]interface{}{"id": "c1", "status": "churned", "name": "Ann"}) // Reappears
+
+		versions, err := service.GetEntityHistory(instanceID(t, "c1"), "")
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+
+		assert.Equal(t, "active", versions[0].Attributes["Status"])
+		assert.Equal(t, []string{"Name", "Status"}, versions[0].ChangedAttributes)
+		require.NotNil(t, versions[0].ValidTo)
+		assert.Equal(t, *versions[0].ValidTo, versions[1].ValidFrom)
+
+		assert.Equal(t, "churned", versions[1].Attributes["Status"])
+		assert.Equal(t, []string{"Status"}, versions[1].ChangedAttributes)
+		require.NotNil(t, versions[1].ValidTo, "closed by the delete")
+
+		assert.Empty(t, versions[2].ChangedAttributes)
+		assert.Nil(t, versions[2].ValidTo)
+		assert.Equal(t, "customer-def", versions[2].EntityDefinitionID)
+
+		changed, err := service.GetEntityHistory(instanceID(t, "c1"), "Status")
+		require.NoError(t, err)
+		assert.Len(t, changed, 2)
+	})
+
+	t.Run("Instances Stored Before History Get Their Stored State As First Version", func(t *testing.T) {
+		reset(t)
+		ingest(t, map[string]interface{}{"id": "c2", "status": "active", "name": "Bob"})
+		id := instanceID(t, "c2")
+		_, err := testDB.Exec(`DELETE FROM processed_entity_history WHERE entity_instance_id = $1`, id)
+		require.NoError(t, err)
+
+		versions, err := service.GetEntityHistory(id, "")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+
+		ingest(t, map[string]interface{}{"id": "c2", "status": "active", "name": "Robert"})
+		versions, err = service.GetEntityHistory(id, "")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, "Bob", versions[0].Attributes["Name"])
+		require.NotNil(t, versions[0].ValidTo)
+		assert.Equal(t, []string{"Name"}, versions[1].ChangedAttributes)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		reset(t)
+		ingest(t, map[string]interface{}{"id": "c3", "status": "active", "name": "Cy"})
+		ingest(t, map[string]interface{}{"id": "c3", "status": "churned", "name": "Cy"})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/entities/"+instanceID(t, "c3")+"/history?attribute=Status", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Data  []EntityVersion `json:"data"`
+			Total int             `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, 2, resp.Total)
+
+		for _, id := range []string{"not-a-uuid", "00000000-0000-0000-0000-000000000000"} {
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/entities/"+id+"/history", nil))
+			assert.Equal(t, http.StatusNotFound, w.Code, id)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// The ingest consumer processes the record batches the ingestion service publishes to the durable INGEST
+// stream of NATS JetStream. Every service instance pulls batches from the same durable consumer with a number
+// of workers, so batches wait in the stream while processing is busy or down, and processing scales by running
+// more instances. A batch is acknowledged once it is stored. A batch that fails is redelivered with a growing
+// delay and published to IngestDeadLetterSubject after MaxDeliver deliveries; one that cannot be decoded is
+// published there right away.
+
+const (
+	// IngestStreamName is the JetStream stream of record batches. It must match the ingestion service.
+	IngestStreamName = "INGEST"
+	// IngestBatchSubject is the subject record batches are published to.
+	IngestBatchSubject = "ingest.batches"
+	// IngestDeadLetterSubject is the subject of the batches the consumer gave up on.
+	IngestDeadLetterSubject = "ingest.dead"
+	// IngestConsumerName is the durable consumer shared by all instances of the processing service.
+	IngestConsumerName = "processing"
+)
+
+// Headers of dead-lettered batches.
+const (
+	IngestErrorHeader      = "Ingest-Error"      // Why the batch was given up on
+	IngestDeliveriesHeader = "Ingest-Deliveries" // How often the batch was delivered
+)
+
+// Defaults of IngestConsumerConfig.
+const (
+	DefaultIngestWorkers    = 4
+	DefaultIngestMaxDeliver = 5
+	DefaultIngestAckWait    = 5 * time.Minute
+	DefaultIngestRetryDelay = 10 * time.Second
+)
+
+// maxIngestRetryDelay caps the delay before the redelivery of a failing batch.
+const maxIngestRetryDelay = 5 * time.Minute
+
+// ingestFetchWait is how long a worker waits for a batch before checking whether it should stop.
+const ingestFetchWait = 5 * time.Second
+
+// IngestConsumerConfig configures an IngestConsumer. Zero fields take their defaults.
+type IngestConsumerConfig struct {
+	Workers    int           // Batches this instance processes concurrently
+	MaxDeliver int           // Deliveries of a failing batch before it is dead-lettered
+	AckWait    time.Duration // Time a worker has to process a batch before it is redelivered
+	RetryDelay time.Duration // Delay before the second delivery of a failing batch, doubled for every further one
+}
+
+// IngestJetStream defines the NATS JetStream operations used by IngestConsumer.
+// nats.JetStreamContext implements it.
+type IngestJetStream interface {
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	ConsumerInfo(stream, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	AddConsumer(stream string, cfg *nats.ConsumerConfig, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	PullSubscribe(subj, durable string, opts ...nats.SubOpt) (*nats.Subscription, error)
+	PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+}
+
+// ingestDelivery is one delivery of a batch message.
+type ingestDelivery interface {
+	Data() []byte
+	NumDelivered() uint64
+	Ack() error
+	NakWithDelay(delay time.Duration) error
+}
+
+// natsDelivery implements ingestDelivery for JetStream messages.
+type natsDelivery struct {
+	msg *nats.Msg
+}
+
+func (d natsDelivery) Data() []byte { return d.msg.Data }
+
+func (d natsDelivery) NumDelivered() uint64 {
+	meta, err := d.msg.Metadata()
+	if err != nil {
+		return 1
+	}
+	return meta.NumDelivered
+}
+
+func (d natsDelivery) Ack() error { return d.msg.Ack() }
+
+func (d natsDelivery) NakWithDelay(delay time.Duration) error { return d.msg.NakWithDelay(delay) }
+
+// IngestConsumer processes the record batches of the INGEST stream.
+type IngestConsumer struct {
+	js      IngestJetStream
+	config  IngestConsumerConfig
+	process func(request ProcessDataRequest) (int, error)
+}
+
+// NewIngestConsumer creates a consumer storing the batches with service.
+func NewIngestConsumer(service *ProcessingService, js IngestJetStream, config IngestConsumerConfig) *IngestConsumer {
+	if config.Workers <= 0 {
+		config.Workers = DefaultIngestWorkers
+	}
+	if config.MaxDeliver <= 0 {
+		config.MaxDeliver = DefaultIngestMaxDeliver
+	}
+	if config.AckWait <= 0 {
+		config.AckWait = DefaultIngestAckWait
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultIngestRetryDelay
+	}
+	return &IngestConsumer{
+		js:     js,
+		config: config,
+		process: func(request ProcessDataRequest) (int, error) {
+			return service.ProcessAndStoreRunData(request.SourceID, request.EntityTypeName, request.RunID, request.RawData)
+		},
+	}
+}
+
+// Run creates the INGEST stream and the durable consumer if they do not exist and processes batches with
+// the configured number of workers until ctx is done.
+func (c *IngestConsumer) Run(ctx context.Context) error {
+	if err := c.ensureConsumer(); err != nil {
+		return err
+	}
+	// Binding to the durable consumer keeps it, and the position in the stream, when the subscription ends.
+	sub, err := c.js.PullSubscribe(IngestBatchSubject, IngestConsumerName, nats.Bind(IngestStreamName, IngestConsumerName))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to consumer %s of stream %s: %w", IngestConsumerName, IngestStreamName, err)
+	}
+	defer sub.Unsubscribe()
+
+	log.Printf("Ingest consumer started; processing batches from stream %s with %d workers.", IngestStreamName, c.config.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < c.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.work(ctx, sub)
+		}()
+	}
+	wg.Wait()
+	log.Println("Ingest consumer stopped.")
+	return nil
+}
+
+// ensureConsumer creates the INGEST stream and the durable consumer if they do not exist (idempotent).
+func (c *IngestConsumer) ensureConsumer() error {
+	if _, err := c.js.StreamInfo(IngestStreamName); err != nil {
+		log.Printf("Stream %s not found, attempting to create it for subject %s...", IngestStreamName, "ingest.>")
+		_, err := c.js.AddStream(&nats.StreamConfig{
+			Name:      IngestStreamName,
+			Subjects:  []string{"ingest.>"},
+			Retention: nats.WorkQueuePolicy,
+			Storage:   nats.FileStorage,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create NATS stream %s: %w", IngestStreamName, err)
+		}
+		log.Printf("Successfully created NATS stream %s", IngestStreamName)
+	}
+	if _, err := c.js.ConsumerInfo(IngestStreamName, IngestConsumerName); err == nil {
+		return nil
+	}
+	_, err := c.js.AddConsumer(IngestStreamName, &nats.ConsumerConfig{
+		Durable:       IngestConsumerName,
+		FilterSubject: IngestBatchSubject,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       c.config.AckWait,
+		// The delivery after the last one lets a worker dead-letter a batch whose previous worker never
+		// acknowledged it, e.g. because the instance stopped.
+		MaxDeliver: c.config.MaxDeliver + 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create consumer %s of stream %s: %w", IngestConsumerName, IngestStreamName, err)
+	}
+	return nil
+}
+
+// work fetches and handles one batch at a time until ctx is done.
+func (c *IngestConsumer) work(ctx context.Context, sub *nats.Subscription) {
+	for ctx.Err() == nil {
+		msgs, err := sub.Fetch(1, nats.MaxWait(ingestFetchWait))
+		if err != nil {
+			if !errors.Is(err, nats.ErrTimeout) {
+				log.Printf("Error fetching batch from stream %s: %v", IngestStreamName, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			c.handle(natsDelivery{msg: msg})
+		}
+	}
+}
+
+// handle processes one delivery of a batch and acknowledges, redelivers or dead-letters it.
+func (c *IngestConsumer) handle(d ingestDelivery) {
+	delivered := d.NumDelivered()
+	var request ProcessDataRequest
+	if err := json.Unmarshal(d.Data(), &request); err != nil {
+		c.deadLetter(d, delivered, fmt.Errorf("invalid batch: %w", err))
+		return
+	}
+	if delivered > uint64(c.config.MaxDeliver) {
+		c.deadLetter(d, delivered, fmt.Errorf("batch of source ID %s was not acknowledged within %d deliveries", request.SourceID, c.config.MaxDeliver))
+		return
+	}
+
+	processed, err := c.process(request)
+	if err != nil {
+		if delivered >= uint64(c.config.MaxDeliver) {
+			c.deadLetter(d, delivered, fmt.Errorf("failed to process batch of source ID %s: %w", request.SourceID, err))
+			return
+		}
+		delay := c.retryDelay(delivered)
+		log.Printf("Error processing batch of %d records for source ID %s (delivery %d of %d), retrying in %s: %v",
+			len(request.RawData), request.SourceID, delivered, c.config.MaxDeliver, delay, err)
+		if err := d.NakWithDelay(delay); err != nil {
+			log.Printf("Error Nacking batch for source ID %s: %v", request.SourceID, err)
+		}
+		return
+	}
+	log.Printf("Processed %d of %d queued records for source ID %s.", processed, len(request.RawData), request.SourceID)
+	if err := d.Ack(); err != nil {
+		log.Printf("Error Acknowledging batch for source ID %s: %v", request.SourceID, err)
+	}
+}
+
+// retryDelay returns the delay before the redelivery of a batch that failed on its delivered-th delivery.
+func (c *IngestConsumer) retryDelay(delivered uint64) time.Duration {
+	delay := c.config.RetryDelay
+	for i := uint64(1); i < delivered && delay < maxIngestRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxIngestRetryDelay)
+}
+
+// deadLetter publishes the batch to IngestDeadLetterSubject with the reason and acknowledges it. A batch
+// whose dead letter cannot be published is redelivered rather than lost.
+func (c *IngestConsumer) deadLetter(d ingestDelivery, delivered uint64, reason error) {
+	msg := nats.NewMsg(IngestDeadLetterSubject)
+	msg.Data = d.Data()
+	msg.Header.Set(IngestErrorHeader, reason.Error())
+	msg.Header.Set(IngestDeliveriesHeader, strconv.FormatUint(delivered, 10))
+	if _, err := c.js.PublishMsg(msg); err != nil {
+		log.Printf("Error dead-lettering batch after %d deliveries (%v): %v", delivered, reason, err)
+		if err := d.NakWithDelay(c.retryDelay(delivered)); err != nil {
+			log.Printf("Error Nacking batch: %v", err)
+		}
+		return
+	}
+	log.Printf("Dead-lettered batch to subject %s after %d deliveries: %v", IngestDeadLetterSubject, delivered, reason)
+	if err := d.Ack(); err != nil {
+		log.Printf("Error Acknowledging dead-lettered batch: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// --- Mock IngestJetStream ---
+type MockIngestJetStream struct {
+	Streams           map[string]*nats.StreamConfig
+	Consumers         map[string]*nats.ConsumerConfig
+	PublishedMessages []*nats.Msg
+	PublishMsgErr     error
+}
+
+func NewMockIngestJetStream() *MockIngestJetStream {
+	return &MockIngestJetStream{
+		Streams:   make(map[string]*nats.StreamConfig),
+		Consumers: make(map[string]*nats.ConsumerConfig),
+	}
+}
+
+func (m *MockIngestJetStream) StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	if cfg, ok := m.Streams[stream]; ok {
+		return &nats.StreamInfo{Config: *cfg}, nil
+	}
+	return nil, nats.ErrStreamNotFound
+}
+
+func (m *MockIngestJetStream) AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	m.Streams[cfg.Name] = cfg
+	return &nats.StreamInfo{Config: *cfg}, nil
+}
+
+func (m *MockIngestJetStream) ConsumerInfo(stream, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	if cfg, ok := m.Consumers[stream+"/"+name]; ok {
+		return &nats.ConsumerInfo{Stream: stream, Name: name, Config: *cfg}, nil
+	}
+	return nil, nats.ErrConsumerNotFound
+}
+
+func (m *MockIngestJetStream) AddConsumer(stream string, cfg *nats.ConsumerConfig, opts ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	m.Consumers[stream+"/"+cfg.Durable] = cfg
+	return &nats.ConsumerInfo{Stream: stream, Name: cfg.Durable, Config: *cfg}, nil
+}
+
+func (m *MockIngestJetStream) PullSubscribe(subj, durable string, opts ...nats.SubOpt) (*nats.Subscription, error) {
+	return nil, fmt.Errorf("not supported by the mock")
+}
+
+func (m *MockIngestJetStream) PublishMsg(msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	if m.PublishMsgErr != nil {
+		return nil, m.PublishMsgErr
+	}
+	m.PublishedMessages = append(m.PublishedMessages, msg)
+	return &nats.PubAck{Stream: IngestStreamName, Sequence: uint64(len(m.PublishedMessages))}, nil
+}
+
+// mockDelivery records how a batch delivery was settled.
+type mockDelivery struct {
+	data      []byte
+	delivered uint64
+	acked     bool
+	nakDelay  time.Duration
+	nakked    bool
+}
+
+func (d *mockDelivery) Data() []byte         { return d.data }
+func (d *mockDelivery) NumDelivered() uint64 { return d.delivered }
+func (d *mockDelivery) Ack() error           { d.acked = true; return nil }
+func (d *mockDelivery) NakWithDelay(delay time.Duration) error {
+	d.nakked, d.nakDelay = true, delay
+	return nil
+}
+
+func TestIngestConsumer(t *testing.T) {
+	js := NewMockIngestJetStream()
+	consumer := NewIngestConsumer(NewProcessingService(&MockMetadataServiceClient{}, nil), js, IngestConsumerConfig{})
+	var processed []ProcessDataRequest
+	var processErr error
+	consumer.process = func(request ProcessDataRequest) (int, error) {
+		processed = append(processed, request)
+		if processErr != nil {
+			return 0, processErr
+		}
+		return len(request.RawData), nil
+	}
+	batch, err := json.Marshal(ProcessDataRequest{SourceID: "src-1", EntityTypeName: "Customer", RunID: "run-1", RawData: []map[string]interface{}{{"id": "1"}}})
+	require.NoError(t, err)
+	reset := func() {
+		processed, processErr = nil, nil
+		js.PublishedMessages, js.PublishMsgErr = nil, nil
+	}
+
+	t.Run("Creates The Stream And Durable Consumer", func(t *testing.T) {
+		require.NoError(t, consumer.ensureConsumer())
+		require.Contains(t, js.Streams, IngestStreamName)
+		assert.Equal(t, []string{"ingest.>"}, js.Streams[IngestStreamName].Subjects)
+		assert.Equal(t, nats.WorkQueuePolicy, js.Streams[IngestStreamName].Retention)
+
+		cfg := js.Consumers[IngestStreamName+"/"+IngestConsumerName]
+		require.NotNil(t, cfg)
+		assert.Equal(t, IngestBatchSubject, cfg.FilterSubject)
+		assert.Equal(t, nats.AckExplicitPolicy, cfg.AckPolicy)
+		assert.Equal(t, DefaultIngestAckWait, cfg.AckWait)
+		assert.Equal(t, DefaultIngestMaxDeliver+1, cfg.MaxDeliver)
+
+		require.NoError(t, consumer.ensureConsumer(), "existing streams and consumers are kept")
+	})
+
+	t.Run("Processed Batches Are Acknowledged", func(t *testing.T) {
+		reset()
+		d := &mockDelivery{data: batch, delivered: 1}
+		consumer.handle(d)
+		assert.True(t, d.acked)
+		assert.False(t, d.nakked)
+		require.Len(t, processed, 1)
+		assert.Equal(t, "src-1", processed[0].SourceID)
+		assert.Equal(t, "run-1", processed[0].RunID)
+		assert.Empty(t, js.PublishedMessages)
+	})
+
+	t.Run("Failing Batches Are Redelivered With A Growing Delay", func(t *testing.T) {
+		reset()
+		processErr = fmt.Errorf("database unavailable")
+		d := &mockDelivery{data: batch, delivered: 1}
+		consumer.handle(d)
+		assert.True(t, d.nakked)
+		assert.False(t, d.acked)
+		assert.Equal(t, DefaultIngestRetryDelay, d.nakDelay)
+
+		d = &mockDelivery{data: batch, delivered: 3}
+		consumer.handle(d)
+		assert.Equal(t, 4*DefaultIngestRetryDelay, d.nakDelay)
+		assert.Equal(t, maxIngestRetryDelay, consumer.retryDelay(50))
+		assert.Empty(t, js.PublishedMessages)
+	})
+
+	t.Run("Batches Are Dead-Lettered After The Last Delivery", func(t *testing.T) {
+		reset()
+		processErr = fmt.Errorf("database unavailable")
+		d := &mockDelivery{data: batch, delivered: DefaultIngestMaxDeliver}
+		consumer.handle(d)
+		assert.True(t, d.acked)
+		assert.False(t, d.nakked)
+		require.Len(t, js.PublishedMessages, 1)
+		msg := js.PublishedMessages[0]
+		assert.Equal(t, IngestDeadLetterSubject, msg.Subject)
+		assert.Equal(t, batch, msg.Data)
+		assert.Contains(t, msg.Header.Get(IngestErrorHeader), "database unavailable")
+		assert.Equal(t, "5", msg.Header.Get(IngestDeliveriesHeader))
+	})
+
+	t.Run("Unacknowledged Batches Are Dead-Lettered Without Processing", func(t *testing.T) {
+		reset()
+		d := &mockDelivery{data: batch, delivered: DefaultIngestMaxDeliver + 1}
+		consumer.handle(d)
+		assert.True(t, d.acked)
+		assert.Empty(t, processed)
+		require.Len(t, js.PublishedMessages, 1)
+		assert.Contains(t, js.PublishedMessages[0].Header.Get(IngestErrorHeader), "not acknowledged")
+	})
+
+	t.Run("Invalid Batches Are Dead-Lettered Right Away", func(t *testing.T) {
+		reset()
+		d := &mockDelivery{data: []byte(`{not json`), delivered: 1}
+		consumer.handle(d)
+		assert.True(t, d.acked)
+		assert.Empty(t, processed)
+		require.Len(t, js.PublishedMessages, 1)
+		assert.Contains(t, js.PublishedMessages[0].Header.Get(IngestErrorHeader), "invalid batch")
+	})
+
+	t.Run("Batches Are Redelivered When The Dead Letter Cannot Be Published", func(t *testing.T) {
+		reset()
+		js.PublishMsgErr = fmt.Errorf("no responders")
+		d := &mockDelivery{data: []byte(`{not json`), delivered: 1}
+		consumer.handle(d)
+		assert.False(t, d.acked)
+		assert.True(t, d.nakked)
+	})
+}
@@ -0,0 +1,469 @@
+package processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrMaterializationNotFound is returned for entity definitions that are not materialized.
+var ErrMaterializationNotFound = errors.New("entity definition is not materialized")
+
+// Columns every materialized table has in front of the attribute columns. They are prefixed with an
+// underscore so they cannot clash with attribute names.
+const materializedFixedColumns = `_instance_id UUID PRIMARY KEY,
+        _source_id TEXT,
+        _raw_record_identifier TEXT,
+        _processed_at TIMESTAMPTZ`
+
+// maxIdentifierLength is the longest identifier PostgreSQL keeps without truncating it.
+const maxIdentifierLength = 63
+
+// Materialization is a typed table holding the current, not deleted instances of an entity
+// definition with one column per attribute, so queries need not cast JSONB values. The processing
+// service keeps it in sync with processed_entities as it stores records, and adds or replaces the
+// columns of mapped attributes that are new, renamed or retyped. Removed attributes keep their
+// column until the table is rebuilt.
+type Materialization struct {
+	EntityDefinitionID string               `json:"entity_definition_id"`
+	EntityTypeName     string               `json:"entity_type_name"`
+	TableName          string               `json:"table_name"`
+	Columns            []MaterializedColumn `json:"columns"`
+	CreatedAt          time.Time            `json:"created_at"`
+	UpdatedAt          time.Time            `json:"updated_at"`
+	RebuiltAt          time.Time            `json:"rebuilt_at"`
+}
+
+// MaterializedColumn is the column of an attribute in a materialized table. It is named like the
+// attribute.
+type MaterializedColumn struct {
+	AttributeID string `json:"attribute_id"`
+	Name        string `json:"name"`
+	DataType    string `json:"data_type_name"`
+	SQLType     string `json:"sql_type"`
+	Indexed     bool   `json:"indexed"`
+}
+
+// materializedSQLType returns the column type for a base data type. Composite values stay JSONB.
+func materializedSQLType(dataType string) string {
+	switch strings.ToLower(dataType) {
+	case "integer":
+		return "BIGINT"
+	case "float":
+		return "DOUBLE PRECISION"
+	case "boolean":
+		return "BOOLEAN"
+	case "date":
+		return "DATE"
+	case "datetime":
+		return "TIMESTAMPTZ"
+	case "time":
+		return "TIME"
+	case "array", "object", "json":
+		return "JSONB"
+	default: // string, enum, reference
+		return "TEXT"
+	}
+}
+
+// valueExpression selects the value of the column from the attributes column of processed_entities.
+func (c MaterializedColumn) valueExpression() string {
+	key := pq.QuoteLiteral(c.Name)
+	switch c.SQLType {
+	case "JSONB":
+		return "attributes->" + key
+	case "TEXT":
+		return "attributes->>" + key
+	default:
+		return "(attributes->>" + key + ")::" + c.SQLType
+	}
+}
+
+func newMaterializedColumn(attrDef *AttributeDefinition) (MaterializedColumn, bool) {
+	if len(attrDef.Name) > maxIdentifierLength || attrDef.Name == "" || strings.HasPrefix(attrDef.Name, "_") {
+		log.Printf("Warning: Attribute %s ('%s') is not materialized: its name is empty, starts with an underscore or is longer than %d bytes.", attrDef.ID, attrDef.Name, maxIdentifierLength)
+		return MaterializedColumn{}, false
+	}
+	sqlType := materializedSQLType(attrDef.DataType)
+	if attrDef.protectsValues() {
+		sqlType = "TEXT" // Hashes, tokens and ciphertexts
+	}
+	return MaterializedColumn{
+		AttributeID: attrDef.ID,
+		Name:        attrDef.Name,
+		DataType:    attrDef.DataType,
+		SQLType:     sqlType,
+		Indexed:     attrDef.IsIndexed,
+	}, true
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// materializedTableName derives the table name from the entity name, e.g. "entity_sales_order" for
+// "Sales Order".
+func materializedTableName(entityName string) string {
+	slug := strings.Trim(nonIdentifierChars.ReplaceAllString(strings.ToLower(entityName), "_"), "_")
+	if len(slug) > 40 {
+		slug = slug[:40]
+	}
+	if slug == "" {
+		slug = "unnamed"
+	}
+	return "entity_" + slug
+}
+
+// createIndexStatement indexes an attribute column flagged IsIndexed.
+func createIndexStatement(table string, c MaterializedColumn) string {
+	index := fmt.Sprintf("idx_%s_%s", table, nonIdentifierChars.ReplaceAllString(strings.ToLower(c.Name), "_"))
+	if len(index) > maxIdentifierLength {
+		index = index[:maxIdentifierLength]
+	}
+	method := ""
+	if c.SQLType == "JSONB" {
+		method = " USING GIN"
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s%s (%s)", pq.QuoteIdentifier(index), pq.QuoteIdentifier(table), method, pq.QuoteIdentifier(c.Name))
+}
+
+// copyStatement inserts or refreshes the rows of the instances of processed_entities matching where.
+func copyStatement(table string, columns []MaterializedColumn, where string) string {
+	names := []string{"_instance_id", "_source_id", "_raw_record_identifier", "_processed_at"}
+	values := []string{"id", "source_id", "raw_record_identifier", "processed_at"}
+	for _, c := range columns {
+		names = append(names, pq.QuoteIdentifier(c.Name))
+		values = append(values, c.valueExpression())
+	}
+	updates := make([]string, 0, len(names)-1)
+	for _, name := range names[1:] {
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", name, name))
+	}
+	return fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM processed_entities WHERE %s
+        ON CONFLICT (_instance_id) DO UPDATE SET %s`,
+		pq.QuoteIdentifier(table), strings.Join(names, ", "), strings.Join(values, ", "), where, strings.Join(updates, ", "))
+}
+
+const materializationColumns = `entity_definition_id, entity_type_name, table_name, columns, created_at, updated_at, rebuilt_at`
+
+func scanMaterialization(row interface{ Scan(...interface{}) error }) (*Materialization, error) {
+	var m Materialization
+	var columnsJSON []byte
+	if err := row.Scan(&m.EntityDefinitionID, &m.EntityTypeName, &m.TableName, &columnsJSON, &m.CreatedAt, &m.UpdatedAt, &m.RebuiltAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(columnsJSON, &m.Columns); err != nil {
+		return nil, fmt.Errorf("failed to decode columns of materialization %s: %w", m.EntityDefinitionID, err)
+	}
+	return &m, nil
+}
+
+// MaterializeEntity creates the typed table of an entity definition, or rebuilds it from the
+// current attribute definitions, and fills it from processed_entities.
+func (s *ProcessingService) MaterializeEntity(entityDefinitionID string) (*Materialization, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("materialization requires a database")
+	}
+	entity, err := s.metadataClient.GetEntityDefinition(entityDefinitionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch entity definition %s: %w", entityDefinitionID, err)
+	}
+	attrDefs, err := s.metadataClient.ListAttributeDefinitions(entityDefinitionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attribute definitions of entity %s: %w", entityDefinitionID, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := scanMaterialization(tx.QueryRow(`SELECT `+materializationColumns+` FROM materialized_entities WHERE entity_definition_id = $1 FOR UPDATE`, entityDefinitionID))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to read materialization of entity %s: %w", entityDefinitionID, err)
+	}
+	now := time.Now().UTC()
+	m := &Materialization{EntityDefinitionID: entityDefinitionID, EntityTypeName: entity.Name, CreatedAt: now, Columns: []MaterializedColumn{}}
+	if existing != nil {
+		m.TableName = existing.TableName // Renaming the entity does not move its table
+		m.CreatedAt = existing.CreatedAt
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(existing.TableName)); err != nil {
+			return nil, fmt.Errorf("failed to drop table %s: %w", existing.TableName, err)
+		}
+	} else {
+		m.TableName = materializedTableName(entity.Name)
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM materialized_entities WHERE table_name = $1)`, m.TableName).Scan(&taken); err != nil {
+			return nil, fmt.Errorf("failed to check table name %s: %w", m.TableName, err)
+		}
+		if taken {
+			suffix := nonIdentifierChars.ReplaceAllString(strings.ToLower(entityDefinitionID), "")
+			if len(suffix) > 8 {
+				suffix = suffix[:8]
+			}
+			m.TableName += "_" + suffix
+		}
+	}
+
+	definitions := []string{materializedFixedColumns}
+	seen := make(map[string]bool)
+	for i := range attrDefs {
+		c, ok := newMaterializedColumn(&attrDefs[i])
+		if !ok || seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		m.Columns = append(m.Columns, c)
+		definitions = append(definitions, pq.QuoteIdentifier(c.Name)+" "+c.SQLType)
+	}
+	statements := []string{fmt.Sprintf("CREATE TABLE %s (\n        %s\n    )", pq.QuoteIdentifier(m.TableName), strings.Join(definitions, ",\n        "))}
+	for _, c := range m.Columns {
+		if c.Indexed {
+			statements = append(statements, createIndexStatement(m.TableName, c))
+		}
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", m.TableName, err)
+		}
+	}
+	if _, err := tx.Exec(copyStatement(m.TableName, m.Columns, "entity_definition_id = $1 AND deleted_at IS NULL"), entityDefinitionID); err != nil {
+		return nil, fmt.Errorf("failed to fill table %s: %w", m.TableName, err)
+	}
+
+	m.UpdatedAt, m.RebuiltAt = now, now
+	columnsJSON, err := json.Marshal(m.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal columns: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO materialized_entities (`+materializationColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $6)
+        ON CONFLICT (entity_definition_id) DO UPDATE SET entity_type_name = EXCLUDED.entity_type_name, columns = EXCLUDED.columns,
+            updated_at = EXCLUDED.updated_at, rebuilt_at = EXCLUDED.rebuilt_at`,
+		m.EntityDefinitionID, m.EntityTypeName, m.TableName, columnsJSON, m.CreatedAt, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store materialization of entity %s: %w", entityDefinitionID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+	log.Printf("Materialized entity definition %s ('%s') into table %s with %d attribute columns.", entityDefinitionID, entity.Name, m.TableName, len(m.Columns))
+	return m, nil
+}
+
+// ListMaterializations returns the materialized entity definitions ordered by table name.
+func (s *ProcessingService) ListMaterializations() ([]Materialization, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("materialization requires a database")
+	}
+	rows, err := s.db.Query(`SELECT ` + materializationColumns + ` FROM materialized_entities ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list materializations: %w", err)
+	}
+	defer rows.Close()
+	materializations := []Materialization{}
+	for rows.Next() {
+		m, err := scanMaterialization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read materialization: %w", err)
+		}
+		materializations = append(materializations, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list materializations: %w", err)
+	}
+	return materializations, nil
+}
+
+// GetMaterialization returns the materialization of an entity definition.
+func (s *ProcessingService) GetMaterialization(entityDefinitionID string) (*Materialization, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("materialization requires a database")
+	}
+	m, err := scanMaterialization(s.db.QueryRow(`SELECT `+materializationColumns+` FROM materialized_entities WHERE entity_definition_id = $1`, entityDefinitionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrMaterializationNotFound, entityDefinitionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get materialization of entity %s: %w", entityDefinitionID, err)
+	}
+	return m, nil
+}
+
+// DropMaterialization drops the typed table of an entity definition. Its data stays in processed_entities.
+func (s *ProcessingService) DropMaterialization(entityDefinitionID string) error {
+	if s.db == nil {
+		return fmt.Errorf("materialization requires a database")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin database transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var table string
+	err = tx.QueryRow(`DELETE FROM materialized_entities WHERE entity_definition_id = $1 RETURNING table_name`, entityDefinitionID).Scan(&table)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrMaterializationNotFound, entityDefinitionID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete materialization of entity %s: %w", entityDefinitionID, err)
+	}
+	if _, err := tx.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(table)); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", table, err)
+	}
+	return tx.Commit()
+}
+
+// materializedWriter keeps the typed table of an entity definition in sync within the transaction
+// of a processing batch.
+type materializedWriter struct {
+	table      string
+	upsertStmt *sql.Stmt
+	deleteStmt *sql.Stmt
+}
+
+// prepareMaterialized returns the writer for the typed table of the entity definition, or nil if it
+// is not materialized. The columns of the mapped attributes are brought up to date first.
+func prepareMaterialized(tx *sql.Tx, entityDefinitionID string, attributeDefs map[string]*AttributeDefinition) (*materializedWriter, error) {
+	if entityDefinitionID == "" {
+		return nil, nil
+	}
+	query := `SELECT ` + materializationColumns + ` FROM materialized_entities WHERE entity_definition_id = $1`
+	m, err := scanMaterialization(tx.QueryRow(query, entityDefinitionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read materialization of entity %s: %w", entityDefinitionID, err)
+	}
+
+	if add, drop := columnChanges(m, entityDefinitionID, attributeDefs); len(add)+len(drop) > 0 {
+		// Read again with a lock, another batch may be changing the same columns.
+		if m, err = scanMaterialization(tx.QueryRow(query+` FOR UPDATE`, entityDefinitionID)); err != nil {
+			return nil, fmt.Errorf("failed to lock materialization of entity %s: %w", entityDefinitionID, err)
+		}
+		add, drop = columnChanges(m, entityDefinitionID, attributeDefs)
+		if err := alterColumns(tx, m, add, drop); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &materializedWriter{table: m.TableName}
+	if w.upsertStmt, err = tx.Prepare(copyStatement(m.TableName, m.Columns, "id = $1")); err != nil {
+		return nil, fmt.Errorf("failed to prepare upsert statement for %s: %w", m.TableName, err)
+	}
+	if w.deleteStmt, err = tx.Prepare(`DELETE FROM ` + pq.QuoteIdentifier(m.TableName) + ` WHERE _instance_id = $1`); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to prepare delete statement for %s: %w", m.TableName, err)
+	}
+	return w, nil
+}
+
+// columnChanges compares the table with the entity's mapped attributes. Attributes without a
+// column are added; the columns of renamed or retyped attributes are dropped and added again.
+func columnChanges(m *Materialization, entityDefinitionID string, attributeDefs map[string]*AttributeDefinition) (add, drop []MaterializedColumn) {
+	byAttribute := make(map[string]MaterializedColumn)
+	names := make(map[string]bool)
+	for _, c := range m.Columns {
+		byAttribute[c.AttributeID] = c
+		names[c.Name] = true
+	}
+	for _, attrDef := range attributeDefs {
+		if attrDef.EntityID != "" && attrDef.EntityID != entityDefinitionID {
+			continue
+		}
+		c, ok := newMaterializedColumn(attrDef)
+		if !ok {
+			continue
+		}
+		if existing, ok := byAttribute[c.AttributeID]; ok {
+			if existing.Name == c.Name && existing.SQLType == c.SQLType {
+				continue
+			}
+			drop = append(drop, existing)
+			delete(names, existing.Name)
+		}
+		if names[c.Name] {
+			continue // Another attribute has the name; a rebuild sorts it out
+		}
+		names[c.Name] = true
+		add = append(add, c)
+	}
+	return add, drop
+}
+
+// alterColumns drops and adds columns of a typed table, fills the added columns for the instances
+// already in it and records the new columns.
+func alterColumns(tx *sql.Tx, m *Materialization, add, drop []MaterializedColumn) error {
+	table := pq.QuoteIdentifier(m.TableName)
+	dropped := make(map[string]bool)
+	for _, c := range drop {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, pq.QuoteIdentifier(c.Name))); err != nil {
+			return fmt.Errorf("failed to drop column %s of %s: %w", c.Name, m.TableName, err)
+		}
+		dropped[c.AttributeID] = true
+		log.Printf("Dropped column %s of materialized table %s, attribute %s changed.", c.Name, m.TableName, c.AttributeID)
+	}
+	columns := make([]MaterializedColumn, 0, len(m.Columns)+len(add))
+	for _, c := range m.Columns {
+		if !dropped[c.AttributeID] {
+			columns = append(columns, c)
+		}
+	}
+	for _, c := range add {
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, pq.QuoteIdentifier(c.Name), c.SQLType),
+			fmt.Sprintf("UPDATE %s SET %s = %s FROM processed_entities WHERE processed_entities.id = %s._instance_id", table, pq.QuoteIdentifier(c.Name), c.valueExpression(), table),
+		}
+		if c.Indexed {
+			statements = append(statements, createIndexStatement(m.TableName, c))
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to add column %s to %s: %w", c.Name, m.TableName, err)
+			}
+		}
+		columns = append(columns, c)
+		log.Printf("Added column %s (%s) to materialized table %s.", c.Name, c.SQLType, m.TableName)
+	}
+	m.Columns = columns
+	columnsJSON, err := json.Marshal(m.Columns)
+	if err != nil {
+		return fmt.Errorf("failed to marshal columns: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE materialized_entities SET columns = $2, updated_at = NOW() WHERE entity_definition_id = $1`, m.EntityDefinitionID, columnsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update materialization of entity %s: %w", m.EntityDefinitionID, err)
+	}
+	return nil
+}
+
+// Close releases the prepared statements.
+func (w *materializedWriter) Close() {
+	for _, stmt := range []*sql.Stmt{w.upsertStmt, w.deleteStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// upsert copies a stored instance into the typed table.
+func (w *materializedWriter) upsert(instanceID string) error {
+	if _, err := w.upsertStmt.Exec(instanceID); err != nil {
+		return fmt.Errorf("failed to copy instance %s to %s: %w", instanceID, w.table, err)
+	}
+	return nil
+}
+
+// remove deletes an instance deleted at its source from the typed table.
+func (w *materializedWriter) remove(instanceID string) error {
+	if _, err := w.deleteStmt.Exec(instanceID); err != nil {
+		return fmt.Errorf("failed to remove instance %s from %s: %w", instanceID, w.table, err)
+	}
+	return nil
+}
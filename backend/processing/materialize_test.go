@@ -0,0 +1,231 @@
+package processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterializedColumns(t *testing.T) {
+	t.Run("Types And Value Expressions", func(t *testing.T) {
+		cases := map[string]struct{ sqlType, expr string }{
+			"integer":  {"BIGINT", `(attributes->>'Col')::BIGINT`},
+			"float":    {"DOUBLE PRECISION", `(attributes->>'Col')::DOUBLE PRECISION`},
+			"boolean":  {"BOOLEAN", `(attributes->>'Col')::BOOLEAN`},
+			"date":     {"DATE", `(attributes->>'Col')::DATE`},
+			"datetime": {"TIMESTAMPTZ", `(attributes->>'Col')::TIMESTAMPTZ`},
+			"time":     {"TIME", `(attributes->>'Col')::TIME`},
+			"array":    {"JSONB", `attributes->'Col'`},
+			"object":   {"JSONB", `attributes->'Col'`},
+			"string":   {"TEXT", `attributes->>'Col'`},
+			"enum":     {"TEXT", `attributes->>'Col'`},
+		}
+		for dataType, want := range cases {
+			c, ok := newMaterializedColumn(&AttributeDefinition{ID: "a1", Name: "Col", DataType: dataType})
+			require.True(t, ok, dataType)
+			assert.Equal(t, want.sqlType, c.SQLType, dataType)
+			assert.Equal(t, want.expr, c.valueExpression(), dataType)
+		}
+	})
+
+	t.Run("Names Are Quoted", func(t *testing.T) {
+		c, ok := newMaterializedColumn(&AttributeDefinition{ID: "a1", Name: "Customer's Age", DataType: "integer", IsIndexed: true})
+		require.True(t, ok)
+		assert.Equal(t, `(attributes->>'Customer''s Age')::BIGINT`, c.valueExpression())
+		assert.Equal(t, `CREATE INDEX IF NOT EXISTS "idx_entity_customer_customer_s_age" ON "entity_customer" ("Customer's Age")`, createIndexStatement("entity_customer", c))
+	})
+
+	t.Run("Protected PII Attributes Are Text", func(t *testing.T) {
+		c, ok := newMaterializedColumn(&AttributeDefinition{ID: "a1", Name: "Age", DataType: "integer", IsPii: true, PiiPolicy: &AttributePiiPolicy{Storage: PiiStorageHash}})
+		require.True(t, ok)
+		assert.Equal(t, "TEXT", c.SQLType)
+		c, ok = newMaterializedColumn(&AttributeDefinition{ID: "a1", Name: "Age", DataType: "integer", IsPii: true})
+		require.True(t, ok)
+		assert.Equal(t, "BIGINT", c.SQLType)
+	})
+
+	t.Run("Unusable Names Are Skipped", func(t *testing.T) {
+		for _, name := range []string{"", "_source_id", strings.Repeat("x", maxIdentifierLength+1)} {
+			_, ok := newMaterializedColumn(&AttributeDefinition{ID: "a1", Name: name, DataType: "string"})
+			assert.False(t, ok, name)
+		}
+	})
+
+	t.Run("Table Names", func(t *testing.T) {
+		assert.Equal(t, "entity_sales_order", materializedTableName("Sales Order"))
+		assert.Equal(t, "entity_unnamed", materializedTableName("???"))
+	})
+
+	t.Run("Column Changes", func(t *testing.T) {
+		m := &Materialization{Columns: []MaterializedColumn{
+			{AttributeID: "a1", Name: "Status", SQLType: "TEXT"},
+			{AttributeID: "a2", Name: "Score", SQLType: "BIGINT"},
+			{AttributeID: "a3", Name: "Old Name", SQLType: "TEXT"},
+		}}
+		add, drop := columnChanges(m, "customer-def", map[string]*AttributeDefinition{
+			"a1": {ID: "a1", EntityID: "customer-def", Name: "Status", DataType: "string"},
+			"a2": {ID: "a2", EntityID: "customer-def", Name: "Score", DataType: "float"},
+			"a3": {ID: "a3", EntityID: "customer-def", Name: "New Name", DataType: "string"},
+			"a4": {ID: "a4", EntityID: "customer-def", Name: "Region", DataType: "string"},
+			"b1": {ID: "b1", EntityID: "order-def", Name: "Total", DataType: "float"},
+		})
+		names := func(columns []MaterializedColumn) map[string]bool {
+			set := make(map[string]bool)
+			for _, c := range columns {
+				set[c.Name] = true
+			}
+			return set
+		}
+		assert.Equal(t, map[string]bool{"Score": true, "New Name": true, "Region": true}, names(add))
+		assert.Equal(t, map[string]bool{"Score": true, "Old Name": true}, names(drop))
+	})
+}
+
+func TestMaterialization(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "materializeSource"
+	entityID := "materialize-def"
+	attrDefs := map[string]*AttributeDefinition{
+		"attr_name":  {ID: "attr_name", EntityID: entityID, Name: "Name", DataType: "string", IsIndexed: true},
+		"attr_age":   {ID: "attr_age", EntityID: entityID, Name: "Age", DataType: "integer"},
+		"attr_since": {ID: "attr_since", EntityID: entityID, Name: "Since", DataType: "date"},
+	}
+	mappings := []DataSourceFieldMapping{
+		{ID: "map-name", SourceID: sourceID, SourceFieldName: "name", EntityID: entityID, AttributeID: "attr_name"},
+		{ID: "map-age", SourceID: sourceID, SourceFieldName: "age", EntityID: entityID, AttributeID: "attr_age"},
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return mappings, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			if attrDef, ok := attrDefs[attrID]; ok {
+				return attrDef, nil
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		GetEntityDefinitionFunc: func(entID string) (*EntityDefinition, error) {
+			if entID != entityID {
+				return nil, fmt.Errorf("metadata service returned non-OK status 404 for entity definition %s", entID)
+			}
+			return &EntityDefinition{ID: entID, Name: "Materialized Customer"}, nil
+		},
+		ListAttributeDefinitionsFunc: func(entID string) ([]AttributeDefinition, error) {
+			return []AttributeDefinition{*attrDefs["attr_name"], *attrDefs["attr_age"]}, nil
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+
+	reset := func(t *testing.T) {
+		if m, err := service.GetMaterialization(entityID); err == nil {
+			_, err := testDB.Exec("DROP TABLE IF EXISTS " + pq.QuoteIdentifier(m.TableName))
+			require.NoError(t, err)
+		}
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "processed_entity_history", "quarantined_records", "materialized_entities"))
+	}
+	ingest := func(t *testing.T, records ...map[string]interface{}) {
+		_, err := service.ProcessAndStoreData(sourceID, "Customer", records)
+		require.NoError(t, err)
+	}
+	row := func(t *testing.T, table, id string) (name sql.NullString, age sql.NullInt64) {
+		err := testDB.QueryRow(`SELECT "Name", "Age" FROM `+pq.QuoteIdentifier(table)+` WHERE _raw_record_identifier = $1`, id).Scan(&name, &age)
+		require.NoError(t, err)
+		return name, age
+	}
+	count := func(t *testing.T, table string) int {
+		var n int
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM `+pq.QuoteIdentifier(table)).Scan(&n))
+		return n
+	}
+
+	t.Run("Existing Instances Are Copied And New Ones Kept In Sync", func(t *testing.T) {
+		reset(t)
+		ingest(t, map[string]interface{}{"id": "c1", "name": "Ann", "age": "41"},
+			map[string]interface{}{"id": "c2", "name": "Bob", "age": 35})
+
+		m, err := service.MaterializeEntity(entityID)
+		require.NoError(t, err)
+		assert.Equal(t, "entity_materialized_customer", m.TableName)
+		require.Len(t, m.Columns, 2)
+		assert.Equal(t, 2, count(t, m.TableName))
+		name, age := row(t, m.TableName, "c1")
+		assert.Equal(t, "Ann", name.String)
+		assert.Equal(t, int64(41), age.Int64)
+
+		var indexed bool
+		require.NoError(t, testDB.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = $1 AND indexdef LIKE '%"Name"%')`, m.TableName).Scan(&indexed))
+		assert.True(t, indexed, "IsIndexed attribute should be indexed")
+
+		ingest(t, map[string]interface{}{"id": "c1", "name": "Ann", "age": 42},
+			map[string]interface{}{"id": "c3", "name": "Cy"},
+			map[string]interface{}{"id": "c2", DeletedRecordField: true})
+		assert.Equal(t, 2, count(t, m.TableName))
+		_, age = row(t, m.TableName, "c1")
+		assert.Equal(t, int64(42), age.Int64)
+		_, age = row(t, m.TableName, "c3")
+		assert.False(t, age.Valid)
+	})
+
+	t.Run("Columns Of Newly Mapped Attributes Are Added", func(t *testing.T) {
+		reset(t)
+		m, err := service.MaterializeEntity(entityID)
+		require.NoError(t, err)
+		ingest(t, map[string]interface{}{"id": "c1", "name": "Ann"})
+
+		mappings = append(mappings, DataSourceFieldMapping{ID: "map-since", SourceID: sourceID, SourceFieldName: "since", EntityID: entityID, AttributeID: "attr_since"})
+		defer func() { mappings = mappings[:2] }()
+		ingest(t, map[string]interface{}{"id": "c2", "name": "Bob", "since": "2024-03-01"})
+
+		var since sql.NullTime
+		require.NoError(t, testDB.QueryRow(`SELECT "Since" FROM `+pq.QuoteIdentifier(m.TableName)+` WHERE _raw_record_identifier = 'c2'`).Scan(&since))
+		assert.Equal(t, "2024-03-01", since.Time.Format("2006-01-02"))
+		updated, err := service.GetMaterialization(entityID)
+		require.NoError(t, err)
+		assert.Len(t, updated.Columns, 3)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		reset(t)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/process/materializations/"+entityID, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var m Materialization
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+		assert.Equal(t, entityID, m.EntityDefinitionID)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/materializations", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), m.TableName)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/process/materializations/unknown-def", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/v1/process/materializations/"+entityID, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		var exists bool
+		require.NoError(t, testDB.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, m.TableName).Scan(&exists))
+		assert.False(t, exists, "table should be dropped")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/materializations/"+entityID, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -4,6 +4,7 @@ package processing
 type ProcessDataRequest struct {
 	SourceID       string                   `json:"source_id"`
 	EntityTypeName string                   `json:"entity_type_name"`
+	RunID          string                   `json:"run_id,omitempty"` // Ingestion run the records were read by, recorded with quarantined records
 	RawData        []map[string]interface{} `json:"raw_data"`
 }
 
@@ -21,8 +22,15 @@ type DataSourceFieldMapping struct {
 type AttributeDefinition struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
-	// DataType might be used later for type casting or validation
-	DataType string `json:"data_type"`
+	// DataType is the base data type name used for type casting and validation
+	DataType string `json:"data_type_name"`
+	// DataTypeDetails holds enum values, array item types, object schemas and referenced entities.
+	DataTypeDetails map[string]interface{} `json:"data_type_details,omitempty"`
+	// IsIndexed attributes get an index on their column in materialized tables.
+	IsIndexed bool `json:"is_indexed"`
+	// IsPii attributes are protected according to PiiPolicy and redacted in logs and API responses, see pii.go.
+	IsPii     bool                `json:"is_pii"`
+	PiiPolicy *AttributePiiPolicy `json:"pii_policy,omitempty"`
 }
 
 // EntityDefinition mirrors the structure in the metadata service
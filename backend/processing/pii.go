@@ -0,0 +1,513 @@
+package processing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Values of attributes flagged IsPii are stored in processed_entities according to the storage of the
+// attribute's PII policy in the metadata service:
+//
+//	as_is     unchanged
+//	hash      pii:hash:<hex HMAC-SHA256 of the value>
+//	tokenize  pii:tok:<hex token>, with the encrypted value kept in pii_tokens
+//	encrypt   pii:enc:v1:<base64 nonce and AES-256-GCM ciphertext>
+//
+// Values are JSON encoded before they are protected, so that revealing them restores their type. Equal
+// values get equal hashes and, per attribute, equal tokens, so that they can still be compared.
+//
+// The pii_attributes column of processed_entities records the storage of the PII attributes of each
+// instance by name. Wherever entity data is logged or returned by the APIs, the values of these attributes
+// are replaced with RedactedValue. Executors get the cleartext values of the attributes their action type
+// is authorized for from RevealEntityInstance.
+const (
+	PiiStorageAsIs     = "as_is"
+	PiiStorageHash     = "hash"
+	PiiStorageTokenize = "tokenize"
+	PiiStorageEncrypt  = "encrypt"
+)
+
+// RedactedValue replaces the values of PII attributes in logs, API responses and task messages.
+const RedactedValue = "[REDACTED]"
+
+const (
+	piiHashPrefix      = "pii:hash:"
+	piiTokenPrefix     = "pii:tok:"
+	piiEncryptedPrefix = "pii:enc:v1:"
+)
+
+// piiKeySize is the size of the PII master key (AES-256).
+const piiKeySize = 32
+
+// ErrNoPIIKeys is returned when values have to be protected or revealed but no PII key is configured.
+var ErrNoPIIKeys = errors.New("PII attributes are hashed, tokenized or encrypted but no PII key is configured")
+
+// AttributePiiPolicy mirrors the PII policy of an attribute in the metadata service.
+type AttributePiiPolicy struct {
+	Storage string `json:"storage"`
+	// AuthorizedActions lists the action types whose executors may read the cleartext values.
+	AuthorizedActions []string `json:"authorized_actions"`
+}
+
+// piiStorage returns how the values of the attribute are stored, or "" if it is not PII. PII attributes
+// without a policy are stored as-is.
+func (d *AttributeDefinition) piiStorage() string {
+	if !d.IsPii {
+		return ""
+	}
+	if d.PiiPolicy == nil || d.PiiPolicy.Storage == "" {
+		return PiiStorageAsIs
+	}
+	return d.PiiPolicy.Storage
+}
+
+// protectsValues reports whether the values of the attribute are stored hashed, tokenized or encrypted,
+// and so as text whatever its data type.
+func (d *AttributeDefinition) protectsValues() bool {
+	storage := d.piiStorage()
+	return storage != "" && storage != PiiStorageAsIs
+}
+
+// revealableTo reports whether executors of actionType may read the cleartext values of the attribute.
+func (d *AttributeDefinition) revealableTo(actionType string) bool {
+	if d.piiStorage() == "" || d.PiiPolicy == nil {
+		return false
+	}
+	for _, authorized := range d.PiiPolicy.AuthorizedActions {
+		if strings.EqualFold(authorized, actionType) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedFieldValue returns the value to record in a FieldError for a value of attrDef.
+func redactedFieldValue(attrDef *AttributeDefinition, value interface{}) interface{} {
+	if attrDef.piiStorage() != "" && value != nil {
+		return RedactedValue
+	}
+	return value
+}
+
+// redactAttributes returns a copy of attributes with the values of the attributes named in piiAttributes
+// replaced with RedactedValue.
+func redactAttributes(attributes map[string]interface{}, piiAttributes map[string]string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(attributes))
+	for name, value := range attributes {
+		if _, pii := piiAttributes[name]; pii && value != nil {
+			value = RedactedValue
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// PIIKeys hashes and encrypts the values of PII attributes. Both keys are derived from one master key.
+type PIIKeys struct {
+	hashing    []byte
+	encryption []byte
+}
+
+// NewPIIKeys derives the PII keys from a 32-byte master key.
+func NewPIIKeys(masterKey []byte) (*PIIKeys, error) {
+	if len(masterKey) != piiKeySize {
+		return nil, fmt.Errorf("PII master key must be %d bytes, got %d", piiKeySize, len(masterKey))
+	}
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, masterKey)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return &PIIKeys{hashing: derive("pii-hashing"), encryption: derive("pii-encryption")}, nil
+}
+
+// LoadPIIKeysFromEnv reads the base64 master key from PII_MASTER_KEY. It returns nil when no key is
+// configured, in which case only PII attributes stored as-is can be processed.
+func LoadPIIKeysFromEnv() (*PIIKeys, error) {
+	encoded := strings.TrimSpace(os.Getenv("PII_MASTER_KEY"))
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 for PII_MASTER_KEY: %w", err)
+	}
+	return NewPIIKeys(key)
+}
+
+// SetPIIKeys sets the keys used to hash, tokenize and encrypt PII attributes.
+func (s *ProcessingService) SetPIIKeys(keys *PIIKeys) {
+	s.piiKeys = keys
+}
+
+func (k *PIIKeys) mac(label string, data []byte) []byte {
+	mac := hmac.New(sha256.New, k.hashing)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// encrypt seals plaintext with AES-256-GCM, bound to additionalData, and returns the base64 nonce and ciphertext.
+func (k *PIIKeys) encrypt(plaintext []byte, additionalData string) (string, error) {
+	gcm, err := newPIIGCM(k.encryption)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, []byte(additionalData))), nil
+}
+
+func (k *PIIKeys) decrypt(sealed string, additionalData string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	gcm, err := newPIIGCM(k.encryption)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(additionalData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newPIIGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// piiToken is a token of a tokenized value, to be kept in the token vault.
+type piiToken struct {
+	token          string
+	attributeID    string
+	encryptedValue string
+}
+
+// protect returns the stored form of a value of attrDef and, for tokenized values, the vault entry of its token.
+// Null values stay null.
+func (k *PIIKeys) protect(attrDef *AttributeDefinition, value interface{}) (interface{}, *piiToken, error) {
+	storage := attrDef.piiStorage()
+	if storage == "" || storage == PiiStorageAsIs || value == nil {
+		return value, nil, nil
+	}
+	if k == nil {
+		return nil, nil, ErrNoPIIKeys
+	}
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode value of attribute '%s': %w", attrDef.Name, err)
+	}
+	switch storage {
+	case PiiStorageHash:
+		return piiHashPrefix + hex.EncodeToString(k.mac("hash", plaintext)), nil, nil
+	case PiiStorageTokenize:
+		token := piiTokenPrefix + hex.EncodeToString(k.mac("token:"+attrDef.ID, plaintext)[:16])
+		encrypted, err := k.encrypt(plaintext, token)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to tokenize value of attribute '%s': %w", attrDef.Name, err)
+		}
+		return token, &piiToken{token: token, attributeID: attrDef.ID, encryptedValue: encrypted}, nil
+	case PiiStorageEncrypt:
+		encrypted, err := k.encrypt(plaintext, attrDef.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt value of attribute '%s': %w", attrDef.Name, err)
+		}
+		return piiEncryptedPrefix + encrypted, nil, nil
+	}
+	return nil, nil, fmt.Errorf("attribute '%s' has unknown PII storage '%s'", attrDef.Name, storage)
+}
+
+// reveal returns the cleartext of a stored value of attrDef. Values stored as-is are returned unchanged,
+// hashes cannot be reversed and are returned as they are. lookupToken returns the vault entry of a token.
+func (k *PIIKeys) reveal(attrDef *AttributeDefinition, stored interface{}, lookupToken func(token string) (string, error)) (interface{}, error) {
+	text, ok := stored.(string)
+	if !ok {
+		return stored, nil
+	}
+	var plaintext []byte
+	switch {
+	case strings.HasPrefix(text, piiEncryptedPrefix):
+		if k == nil {
+			return nil, ErrNoPIIKeys
+		}
+		var err error
+		if plaintext, err = k.decrypt(strings.TrimPrefix(text, piiEncryptedPrefix), attrDef.ID); err != nil {
+			return nil, fmt.Errorf("value of attribute '%s': %w", attrDef.Name, err)
+		}
+	case strings.HasPrefix(text, piiTokenPrefix):
+		if k == nil {
+			return nil, ErrNoPIIKeys
+		}
+		encrypted, err := lookupToken(text)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext, err = k.decrypt(encrypted, text); err != nil {
+			return nil, fmt.Errorf("token of attribute '%s': %w", attrDef.Name, err)
+		}
+	default:
+		return stored, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(plaintext, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode value of attribute '%s': %w", attrDef.Name, err)
+	}
+	return value, nil
+}
+
+// checkPIIKeys returns ErrNoPIIKeys if one of the attributes has to be protected without keys, before any
+// value is stored in cleartext.
+func (s *ProcessingService) checkPIIKeys(attributeDefs map[string]*AttributeDefinition) error {
+	if s.piiKeys != nil {
+		return nil
+	}
+	for _, attrDef := range attributeDefs {
+		if attrDef.protectsValues() {
+			return fmt.Errorf("%w: attribute '%s' is stored with %s", ErrNoPIIKeys, attrDef.Name, attrDef.piiStorage())
+		}
+	}
+	return nil
+}
+
+// piiWriter protects the PII attributes of the records of a single processing transaction.
+type piiWriter struct {
+	keys      *PIIKeys
+	byName    map[string]*AttributeDefinition // PII attributes by name
+	tokenStmt *sql.Stmt                       // Only prepared if an attribute is tokenized
+}
+
+func preparePII(tx *sql.Tx, keys *PIIKeys, attributeDefs map[string]*AttributeDefinition) (*piiWriter, error) {
+	p := &piiWriter{keys: keys, byName: make(map[string]*AttributeDefinition)}
+	for _, attrDef := range attributeDefs {
+		if attrDef.piiStorage() == "" {
+			continue
+		}
+		p.byName[attrDef.Name] = attrDef
+		if attrDef.piiStorage() == PiiStorageTokenize && p.tokenStmt == nil {
+			var err error
+			p.tokenStmt, err = tx.Prepare(`INSERT INTO pii_tokens (token, attribute_id, encrypted_value) VALUES ($1, $2, $3)
+                ON CONFLICT (token) DO NOTHING`)
+			if err != nil {
+				return nil, fmt.Errorf("failed to prepare token vault statement: %w", err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p *piiWriter) Close() {
+	if p.tokenStmt != nil {
+		p.tokenStmt.Close()
+	}
+}
+
+// protect replaces the values of the PII attributes of a processed record with their stored form and returns
+// the pii_attributes of the record, or nil if it has none.
+func (p *piiWriter) protect(data map[string]interface{}) ([]byte, error) {
+	storages := make(map[string]string)
+	for name, value := range data {
+		attrDef, ok := p.byName[name]
+		if !ok {
+			continue
+		}
+		stored, token, err := p.keys.protect(attrDef, value)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			if _, err := p.tokenStmt.Exec(token.token, token.attributeID, token.encryptedValue); err != nil {
+				return nil, fmt.Errorf("failed to store token of attribute '%s': %w", name, err)
+			}
+		}
+		data[name] = stored
+		storages[name] = attrDef.piiStorage()
+	}
+	if len(storages) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(storages)
+}
+
+// RedactQuarantinedRecords replaces the raw values of the source fields mapped to PII attributes, and the
+// values of PII attributes in the errors, with RedactedValue. If the mappings of a source cannot be fetched,
+// all values of its records are redacted.
+func (s *ProcessingService) RedactQuarantinedRecords(records []QuarantinedRecord) {
+	type piiFields struct {
+		sources    map[string]bool
+		attributes map[string]bool
+		err        error
+	}
+	bySource := make(map[string]*piiFields)
+	for i := range records {
+		r := &records[i]
+		fields, ok := bySource[r.SourceID]
+		if !ok {
+			fields = &piiFields{sources: make(map[string]bool), attributes: make(map[string]bool)}
+			fields.err = s.collectPIIFields(r.SourceID, fields.sources, fields.attributes)
+			if fields.err != nil {
+				log.Printf("Redacting all values of the quarantined records of source %s: %v", r.SourceID, fields.err)
+			}
+			bySource[r.SourceID] = fields
+		}
+		for name, value := range r.RawRecord {
+			if value != nil && (fields.err != nil || fields.sources[name]) {
+				r.RawRecord[name] = RedactedValue
+			}
+		}
+		for j := range r.Errors {
+			e := &r.Errors[j]
+			if e.Value != nil && (fields.err != nil || fields.sources[e.SourceField] || fields.attributes[e.Attribute]) {
+				e.Value = RedactedValue
+			}
+		}
+	}
+}
+
+// collectPIIFields adds the source fields of a data source that are mapped to PII attributes, and the names
+// of those attributes, to the given sets.
+func (s *ProcessingService) collectPIIFields(sourceID string, sourceFields, attributes map[string]bool) error {
+	mappings, err := s.metadataClient.GetDataSourceFieldMappings(sourceID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch field mappings: %w", err)
+	}
+	attributeDefs := make(map[string]*AttributeDefinition)
+	for _, mapping := range mappings {
+		attrDef, ok := attributeDefs[mapping.AttributeID]
+		if !ok {
+			if attrDef, err = s.metadataClient.GetAttributeDefinition(mapping.AttributeID, mapping.EntityID); err != nil {
+				return fmt.Errorf("failed to fetch attribute definition %s: %w", mapping.AttributeID, err)
+			}
+			attributeDefs[mapping.AttributeID] = attrDef
+		}
+		if attrDef.piiStorage() != "" {
+			sourceFields[mapping.SourceFieldName] = true
+			attributes[attrDef.Name] = true
+		}
+	}
+	return nil
+}
+
+// RevealedEntity is an entity instance as an action executor may see it.
+type RevealedEntity struct {
+	EntityInstanceID   string                 `json:"entity_instance_id"`
+	EntityDefinitionID string                 `json:"entity_definition_id,omitempty"`
+	Attributes         map[string]interface{} `json:"attributes"`
+	// RevealedAttributes lists the PII attributes whose cleartext values are included.
+	RevealedAttributes []string `json:"revealed_attributes"`
+}
+
+// RevealEntityInstance returns the attributes of an entity instance for an executor of actionType: the PII
+// attributes whose policy authorizes the action type are decrypted or detokenized, the other PII attributes
+// are redacted. Policies are fetched from the metadata service on every call, so that revoking an action
+// type takes effect immediately.
+func (s *ProcessingService) RevealEntityInstance(instanceID string, actionType string) (*RevealedEntity, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("revealing entity instances requires a database")
+	}
+	if _, err := uuid.Parse(instanceID); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, instanceID)
+	}
+	var entityDefinitionID string
+	var attributesJSON, piiJSON []byte
+	err := s.db.QueryRow(`SELECT COALESCE(entity_definition_id, ''), attributes, pii_attributes FROM processed_entities WHERE id = $1`,
+		instanceID).Scan(&entityDefinitionID, &attributesJSON, &piiJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrEntityNotFound, instanceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up instance %s: %w", instanceID, err)
+	}
+	var attributes map[string]interface{}
+	if len(attributesJSON) > 0 {
+		if err := json.Unmarshal(attributesJSON, &attributes); err != nil {
+			return nil, fmt.Errorf("failed to decode attributes of instance %s: %w", instanceID, err)
+		}
+	}
+	storedPII := make(map[string]string)
+	if len(piiJSON) > 0 {
+		if err := json.Unmarshal(piiJSON, &storedPII); err != nil {
+			return nil, fmt.Errorf("failed to decode PII attributes of instance %s: %w", instanceID, err)
+		}
+	}
+
+	byName := make(map[string]*AttributeDefinition)
+	if entityDefinitionID != "" {
+		attrDefs, err := s.metadataClient.ListAttributeDefinitions(entityDefinitionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the PII policies of entity definition %s: %w", entityDefinitionID, err)
+		}
+		for i := range attrDefs {
+			byName[attrDefs[i].Name] = &attrDefs[i]
+		}
+	}
+
+	revealed := &RevealedEntity{
+		EntityInstanceID:   instanceID,
+		EntityDefinitionID: entityDefinitionID,
+		Attributes:         make(map[string]interface{}, len(attributes)),
+		RevealedAttributes: []string{},
+	}
+	for name, value := range attributes {
+		attrDef := byName[name]
+		_, wasPII := storedPII[name]
+		switch {
+		case attrDef != nil && attrDef.revealableTo(actionType):
+			cleartext, err := s.piiKeys.reveal(attrDef, value, s.lookupPIIToken)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reveal attribute '%s' of instance %s: %w", name, instanceID, err)
+			}
+			revealed.Attributes[name] = cleartext
+			revealed.RevealedAttributes = append(revealed.RevealedAttributes, name)
+		case wasPII || (attrDef != nil && attrDef.piiStorage() != ""):
+			revealed.Attributes[name] = RedactedValue
+		default:
+			revealed.Attributes[name] = value
+		}
+	}
+	sort.Strings(revealed.RevealedAttributes)
+	if len(revealed.RevealedAttributes) > 0 {
+		log.Printf("Revealed PII attributes %v of instance %s to action type %s.", revealed.RevealedAttributes, instanceID, actionType)
+	}
+	return revealed, nil
+}
+
+// lookupPIIToken returns the encrypted value of a token from the token vault.
+func (s *ProcessingService) lookupPIIToken(token string) (string, error) {
+	var encrypted string
+	err := s.db.QueryRow(`SELECT encrypted_value FROM pii_tokens WHERE token = $1`, token).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("token %s is not in the token vault", token)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up token: %w", err)
+	}
+	return encrypted, nil
+}
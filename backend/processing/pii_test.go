@@ -0,0 +1,295 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPIIKeys(t *testing.T) *PIIKeys {
+	keys, err := NewPIIKeys(bytes.Repeat([]byte{7}, piiKeySize))
+	require.NoError(t, err)
+	return keys
+}
+
+func piiAttribute(id, name, dataType, storage string, actions ...string) *AttributeDefinition {
+	return &AttributeDefinition{ID: id, Name: name, DataType: dataType, IsPii: true,
+		PiiPolicy: &AttributePiiPolicy{Storage: storage, AuthorizedActions: actions}}
+}
+
+func TestPIIProtection(t *testing.T) {
+	keys := testPIIKeys(t)
+	noTokens := func(token string) (string, error) { return "", fmt.Errorf("unexpected token lookup") }
+
+	t.Run("Keys", func(t *testing.T) {
+		_, err := NewPIIKeys([]byte("short"))
+		assert.Error(t, err)
+
+		t.Setenv("PII_MASTER_KEY", "")
+		loaded, err := LoadPIIKeysFromEnv()
+		require.NoError(t, err)
+		assert.Nil(t, loaded)
+		t.Setenv("PII_MASTER_KEY", "not base64!")
+		_, err = LoadPIIKeysFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("Hashing Is Deterministic And Irreversible", func(t *testing.T) {
+		attr := piiAttribute("a1", "Email", "string", PiiStorageHash)
+		first, token, err := keys.protect(attr, "ann@example.com")
+		require.NoError(t, err)
+		assert.Nil(t, token)
+		second, _, err := keys.protect(attr, "ann@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+		assert.True(t, strings.HasPrefix(first.(string), piiHashPrefix))
+		assert.NotContains(t, first, "ann")
+
+		revealed, err := keys.reveal(attr, first, noTokens)
+		require.NoError(t, err)
+		assert.Equal(t, first, revealed)
+	})
+
+	t.Run("Encryption Round Trips And Is Bound To The Attribute", func(t *testing.T) {
+		attr := piiAttribute("a2", "Age", "integer", PiiStorageEncrypt)
+		stored, _, err := keys.protect(attr, int64(41))
+		require.NoError(t, err)
+		again, _, err := keys.protect(attr, int64(41))
+		require.NoError(t, err)
+		assert.NotEqual(t, stored, again, "every encryption gets a new nonce")
+
+		revealed, err := keys.reveal(attr, stored, noTokens)
+		require.NoError(t, err)
+		assert.Equal(t, float64(41), revealed)
+
+		_, err = keys.reveal(piiAttribute("other", "Age", "integer", PiiStorageEncrypt), stored, noTokens)
+		assert.Error(t, err)
+		_, err = (*PIIKeys)(nil).reveal(attr, stored, noTokens)
+		assert.ErrorIs(t, err, ErrNoPIIKeys)
+	})
+
+	t.Run("Tokens Are Stable Per Attribute", func(t *testing.T) {
+		attr := piiAttribute("a3", "Phone", "string", PiiStorageTokenize)
+		stored, token, err := keys.protect(attr, "+1 555 0100")
+		require.NoError(t, err)
+		require.NotNil(t, token)
+		assert.Equal(t, stored, token.token)
+		again, _, err := keys.protect(attr, "+1 555 0100")
+		require.NoError(t, err)
+		assert.Equal(t, stored, again)
+		other, _, err := keys.protect(piiAttribute("b3", "Phone", "string", PiiStorageTokenize), "+1 555 0100")
+		require.NoError(t, err)
+		assert.NotEqual(t, stored, other)
+
+		revealed, err := keys.reveal(attr, stored, func(t string) (string, error) { return token.encryptedValue, nil })
+		require.NoError(t, err)
+		assert.Equal(t, "+1 555 0100", revealed)
+	})
+
+	t.Run("As-Is And Null Values Are Unchanged", func(t *testing.T) {
+		stored, _, err := keys.protect(piiAttribute("a4", "Name", "string", PiiStorageAsIs), "Ann")
+		require.NoError(t, err)
+		assert.Equal(t, "Ann", stored)
+		stored, _, err = keys.protect(&AttributeDefinition{ID: "a5", Name: "Name", DataType: "string", IsPii: true}, "Ann")
+		require.NoError(t, err)
+		assert.Equal(t, "Ann", stored, "PII attributes without a policy are stored as-is")
+		stored, _, err = (*PIIKeys)(nil).protect(piiAttribute("a6", "Email", "string", PiiStorageEncrypt), nil)
+		require.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+
+	t.Run("Authorization", func(t *testing.T) {
+		assert.True(t, piiAttribute("a", "Email", "string", PiiStorageEncrypt, "EMAIL").revealableTo("email"))
+		assert.False(t, piiAttribute("a", "Email", "string", PiiStorageEncrypt, "EMAIL").revealableTo("WEBHOOK"))
+		assert.False(t, (&AttributeDefinition{Name: "Email", IsPii: true}).revealableTo("EMAIL"))
+	})
+
+	t.Run("Redaction", func(t *testing.T) {
+		redacted := redactAttributes(map[string]interface{}{"Email": "ann@example.com", "Phone": nil, "Status": "active"},
+			map[string]string{"Email": PiiStorageAsIs, "Phone": PiiStorageEncrypt})
+		assert.Equal(t, map[string]interface{}{"Email": RedactedValue, "Phone": nil, "Status": "active"}, redacted)
+	})
+}
+
+func TestPIIInProcessing(t *testing.T) {
+	mappings := []DataSourceFieldMapping{
+		{ID: "map-email", SourceID: "piiSource", SourceFieldName: "email", EntityID: "pii-def", AttributeID: "attr_email"},
+		{ID: "map-age", SourceID: "piiSource", SourceFieldName: "age", EntityID: "pii-def", AttributeID: "attr_age"},
+	}
+	attrDefs := map[string]*AttributeDefinition{
+		"attr_email": piiAttribute("attr_email", "Email", "string", PiiStorageEncrypt, "EMAIL"),
+		"attr_age":   {ID: "attr_age", Name: "Age", DataType: "integer"},
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: "pii-def"}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return mappings, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			return attrDefs[attrID], nil
+		},
+	}
+
+	t.Run("Protected Attributes Require Keys", func(t *testing.T) {
+		service := NewProcessingService(mockMetaClient, nil)
+		_, err := service.ProcessAndStoreData("piiSource", "Customer", []map[string]interface{}{{"id": "c1", "email": "ann@example.com"}})
+		assert.ErrorIs(t, err, ErrNoPIIKeys)
+
+		service.SetPIIKeys(testPIIKeys(t))
+		count, err := service.ProcessAndStoreData("piiSource", "Customer", []map[string]interface{}{{"id": "c1", "email": "ann@example.com"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("Field Errors Do Not Keep PII Values", func(t *testing.T) {
+		attrDefs["attr_age"] = piiAttribute("attr_age", "Age", "integer", PiiStorageAsIs)
+		defer func() { attrDefs["attr_age"] = &AttributeDefinition{ID: "attr_age", Name: "Age", DataType: "integer"} }()
+		service := NewProcessingService(mockMetaClient, nil)
+		_, _, fieldErrors := service.transformAndConvertRecord(map[string]interface{}{"age": "forty-one"}, mappings, attrDefs, 1, "piiSource")
+		require.Len(t, fieldErrors, 1)
+		assert.Equal(t, RedactedValue, fieldErrors[0].Value)
+	})
+
+	t.Run("Quarantined Records Are Redacted", func(t *testing.T) {
+		service := NewProcessingService(mockMetaClient, nil)
+		records := []QuarantinedRecord{{
+			SourceID:  "piiSource",
+			RawRecord: map[string]interface{}{"id": "c1", "email": "ann@example.com", "age": "x"},
+			Errors:    []FieldError{{SourceField: "email", Attribute: "Email", Value: "ann@example.com"}, {SourceField: "age", Attribute: "Age", Value: "x"}},
+		}, {
+			SourceID:  "unknownSource",
+			RawRecord: map[string]interface{}{"id": "c2", "email": "bob@example.com"},
+		}}
+		mockMetaClient.GetDataSourceFieldMappingsFunc = func(sID string) ([]DataSourceFieldMapping, error) {
+			if sID != "piiSource" {
+				return nil, fmt.Errorf("metadata service returned non-OK status 404")
+			}
+			return mappings, nil
+		}
+		service.RedactQuarantinedRecords(records)
+		assert.Equal(t, map[string]interface{}{"id": "c1", "email": RedactedValue, "age": "x"}, records[0].RawRecord)
+		assert.Equal(t, RedactedValue, records[0].Errors[0].Value)
+		assert.Equal(t, "x", records[0].Errors[1].Value)
+		assert.Equal(t, map[string]interface{}{"id": RedactedValue, "email": RedactedValue}, records[1].RawRecord, "all values of sources without mappings are redacted")
+	})
+}
+
+func TestPIIStorageAndReveal(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "piiStoreSource"
+	entityID := "pii-store-def"
+	attrDefs := []AttributeDefinition{
+		*piiAttribute("attr_email", "Email", "string", PiiStorageEncrypt, "EMAIL"),
+		*piiAttribute("attr_phone", "Phone", "string", PiiStorageTokenize, "WEBHOOK"),
+		*piiAttribute("attr_ssn", "SSN", "string", PiiStorageHash),
+		*piiAttribute("attr_name", "Name", "string", PiiStorageAsIs, "EMAIL"),
+		{ID: "attr_status", Name: "Status", DataType: "string"},
+	}
+	var mappings []DataSourceFieldMapping
+	for _, attrDef := range attrDefs {
+		field := strings.ToLower(attrDef.Name)
+		mappings = append(mappings, DataSourceFieldMapping{ID: "map-" + field, SourceID: sourceID, SourceFieldName: field, EntityID: entityID, AttributeID: attrDef.ID})
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return mappings, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			for i := range attrDefs {
+				if attrDefs[i].ID == attrID {
+					return &attrDefs[i], nil
+				}
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		ListAttributeDefinitionsFunc: func(entID string) ([]AttributeDefinition, error) {
+			return append([]AttributeDefinition(nil), attrDefs...), nil
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	service.SetPIIKeys(testPIIKeys(t))
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+
+	require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "processed_entity_history", "quarantined_records", "pii_tokens"))
+	record := map[string]interface{}{"id": "c1", "email": "ann@example.com", "phone": "+1 555 0100", "ssn": "123-45-6789", "name": "Ann", "status": "active"}
+	_, err := service.ProcessAndStoreData(sourceID, "Customer", []map[string]interface{}{record})
+	require.NoError(t, err)
+
+	var instanceID string
+	var attributesJSON, piiJSON []byte
+	require.NoError(t, testDB.QueryRow(`SELECT id, attributes, pii_attributes FROM processed_entities WHERE source_id = $1`, sourceID).Scan(&instanceID, &attributesJSON, &piiJSON))
+
+	t.Run("Values Are Stored In Their Protected Form", func(t *testing.T) {
+		for _, cleartext := range []string{"ann@example.com", "555", "123-45-6789"} {
+			assert.NotContains(t, string(attributesJSON), cleartext)
+		}
+		var attributes map[string]interface{}
+		require.NoError(t, json.Unmarshal(attributesJSON, &attributes))
+		assert.True(t, strings.HasPrefix(attributes["Email"].(string), piiEncryptedPrefix))
+		assert.True(t, strings.HasPrefix(attributes["Phone"].(string), piiTokenPrefix))
+		assert.True(t, strings.HasPrefix(attributes["SSN"].(string), piiHashPrefix))
+		assert.Equal(t, "Ann", attributes["Name"])
+
+		var pii map[string]string
+		require.NoError(t, json.Unmarshal(piiJSON, &pii))
+		assert.Equal(t, map[string]string{"Email": PiiStorageEncrypt, "Phone": PiiStorageTokenize, "SSN": PiiStorageHash, "Name": PiiStorageAsIs}, pii)
+
+		var tokens int
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM pii_tokens WHERE token = $1`, attributes["Phone"]).Scan(&tokens))
+		assert.Equal(t, 1, tokens)
+	})
+
+	t.Run("History Is Redacted", func(t *testing.T) {
+		versions, err := service.GetEntityHistory(instanceID, "")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, RedactedValue, versions[0].Attributes["Name"])
+		assert.Equal(t, RedactedValue, versions[0].Attributes["Email"])
+		assert.Equal(t, "active", versions[0].Attributes["Status"])
+	})
+
+	t.Run("Executors Only See The Attributes They Are Authorized For", func(t *testing.T) {
+		reveal := func(actionType string) RevealedEntity {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/internal/v1/entities/"+instanceID+"/reveal", strings.NewReader(`{"action_type": "`+actionType+`"}`)))
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var entity RevealedEntity
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entity))
+			return entity
+		}
+
+		email := reveal("EMAIL")
+		assert.Equal(t, []string{"Email", "Name"}, email.RevealedAttributes)
+		assert.Equal(t, "ann@example.com", email.Attributes["Email"])
+		assert.Equal(t, "Ann", email.Attributes["Name"])
+		assert.Equal(t, RedactedValue, email.Attributes["Phone"])
+		assert.Equal(t, RedactedValue, email.Attributes["SSN"])
+		assert.Equal(t, "active", email.Attributes["Status"])
+
+		webhook := reveal("WEBHOOK")
+		assert.Equal(t, "+1 555 0100", webhook.Attributes["Phone"])
+		assert.Equal(t, RedactedValue, webhook.Attributes["Email"])
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/internal/v1/entities/00000000-0000-0000-0000-000000000000/reveal", strings.NewReader(`{"action_type": "EMAIL"}`)))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/internal/v1/entities/"+instanceID+"/reveal", strings.NewReader(`{}`)))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,646 @@
+package processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Types of data quality rules, see DataQualityRule in the metadata service.
+const (
+	QualityRuleNotNull           = "not_null"
+	QualityRuleUnique            = "unique"
+	QualityRuleRange             = "range"
+	QualityRuleRegex             = "regex"
+	QualityRuleReferenceExists   = "reference_exists"
+	QualityRuleMaxNullPercentage = "max_null_percentage"
+)
+
+// Actions taken when a data quality rule is violated.
+const (
+	QualityActionWarn   = "warn"   // Only count the violation in the scorecard
+	QualityActionReject = "reject" // Quarantine the violating record instead of storing it
+	QualityActionFail   = "fail"   // Store nothing of the batch and fail the run
+)
+
+// Statuses of scorecards and of the rules in them, from best to worst.
+const (
+	QualityStatusPassed  = "passed"
+	QualityStatusWarning = "warning"
+	QualityStatusFailed  = "failed"
+)
+
+// ErrQualityCheckFailed is returned when a rule with the "fail" action is violated. Nothing of the
+// batch is stored.
+var ErrQualityCheckFailed = errors.New("data quality check failed")
+
+// ErrScorecardNotFound is returned for unknown scorecard IDs.
+var ErrScorecardNotFound = errors.New("quality scorecard not found")
+
+// DataQualityRule mirrors the structure in the metadata service.
+type DataQualityRule struct {
+	ID          string                 `json:"id"`
+	SourceID    string                 `json:"source_id,omitempty"`
+	EntityID    string                 `json:"entity_id,omitempty"`
+	Name        string                 `json:"name"`
+	AttributeID string                 `json:"attribute_id,omitempty"`
+	RuleType    string                 `json:"rule_type"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Action      string                 `json:"action"`
+	IsEnabled   bool                   `json:"is_enabled"`
+}
+
+// RuleScore is the outcome of a single rule in a scorecard.
+type RuleScore struct {
+	RuleID    string `json:"rule_id"`
+	Name      string `json:"name"`
+	RuleType  string `json:"rule_type"`
+	Attribute string `json:"attribute,omitempty"`
+	Action    string `json:"action"`
+	Evaluated int    `json:"evaluated"` // Records the rule was evaluated on
+	Failed    int    `json:"failed"`    // Records violating the rule; null values for max_null_percentage
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"` // Why the rule could not be evaluated
+}
+
+// QualityScorecard summarizes the data quality rules evaluated for the batches of an ingestion run,
+// or for a single batch sent without a run ID. A failed scorecard means the load should not be
+// acted upon.
+type QualityScorecard struct {
+	ID               string      `json:"id"`
+	SourceID         string      `json:"source_id"`
+	RunID            string      `json:"run_id,omitempty"`
+	EntityTypeName   string      `json:"entity_type_name"`
+	Status           string      `json:"status"`
+	Batches          int         `json:"batches"`
+	RecordsEvaluated int         `json:"records_evaluated"`
+	RecordsRejected  int         `json:"records_rejected"`
+	Rules            []RuleScore `json:"rules"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// ScorecardFilter selects scorecards. Empty fields do not filter.
+type ScorecardFilter struct {
+	SourceID string
+	RunID    string
+	Status   string
+	Limit    int
+	Offset   int
+}
+
+// transformedRecord is a raw record after mapping and conversion.
+type transformedRecord struct {
+	deleted     bool
+	suppressed  bool // On the erasure suppression list, see erasure.go
+	data        map[string]interface{}
+	identifier  string
+	fieldErrors []FieldError
+}
+
+// qualityResult is the outcome of the quality rules for a batch.
+type qualityResult struct {
+	scorecard  *QualityScorecard // nil when no rules apply
+	rejections map[int][]FieldError
+	failure    string // Reason the batch failed, if a rule with the "fail" action was violated
+}
+
+func worseStatus(a, b string) string {
+	rank := map[string]int{QualityStatusPassed: 0, QualityStatusWarning: 1, QualityStatusFailed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// violationStatus is the status of a rule that was violated.
+func violationStatus(action string) string {
+	if action == QualityActionWarn {
+		return QualityStatusWarning
+	}
+	return QualityStatusFailed
+}
+
+// checkQuality evaluates the enabled rules on the records of a batch. Deleted and suppressed records,
+// and records that are rejected anyway because nothing could be mapped, are not evaluated.
+func (s *ProcessingService) checkQuality(rules []DataQualityRule, attributeDefs map[string]*AttributeDefinition, entityDefinitionID string, records []transformedRecord) *qualityResult {
+	result := &qualityResult{rejections: make(map[int][]FieldError)}
+	var evaluated []int
+	for i, r := range records {
+		if !r.deleted && !r.suppressed && len(r.data) > 0 {
+			evaluated = append(evaluated, i)
+		}
+	}
+
+	card := &QualityScorecard{Status: QualityStatusPassed, Batches: 1, RecordsEvaluated: len(evaluated), Rules: []RuleScore{}}
+	for _, rule := range rules {
+		if !rule.IsEnabled {
+			continue
+		}
+		score := RuleScore{RuleID: rule.ID, Name: rule.Name, RuleType: rule.RuleType, Action: rule.Action, Status: QualityStatusPassed}
+		attrDef, err := s.qualityRuleAttribute(rule, attributeDefs, entityDefinitionID)
+		if err == nil {
+			score.Attribute = attrDef.Name
+			var violations map[int]string
+			violations, err = s.evaluateQualityRule(rule, attrDef, entityDefinitionID, records, evaluated)
+			score.Evaluated = len(evaluated)
+			score.Failed = len(violations)
+			if rule.RuleType == QualityRuleMaxNullPercentage {
+				score.Failed = countNulls(records, evaluated, attrDef.Name)
+			}
+			if err == nil && len(violations) > 0 {
+				score.Status = violationStatus(rule.Action)
+				for i, reason := range violations {
+					switch rule.Action {
+					case QualityActionReject:
+						result.rejections[i] = append(result.rejections[i], FieldError{
+							RuleID: rule.ID, Attribute: attrDef.Name, Value: redactedFieldValue(attrDef, records[i].data[attrDef.Name]),
+							Error: fmt.Sprintf("quality rule '%s' (%s) failed: %s", rule.Name, rule.RuleType, reason),
+						})
+					case QualityActionFail:
+						if result.failure == "" {
+							result.failure = fmt.Sprintf("rule '%s' (%s) failed for record #%d: %s", rule.Name, rule.RuleType, i+1, reason)
+						}
+					}
+				}
+			}
+		}
+		if err != nil {
+			// A rule that cannot be evaluated does not block the load, but it is visible in the scorecard.
+			log.Printf("Warning: Data quality rule %s ('%s') could not be evaluated: %v", rule.ID, rule.Name, err)
+			score.Error = err.Error()
+			score.Status = QualityStatusWarning
+		}
+		card.Status = worseStatus(card.Status, score.Status)
+		card.Rules = append(card.Rules, score)
+	}
+	if len(card.Rules) == 0 {
+		return result
+	}
+	card.RecordsRejected = len(result.rejections)
+	result.scorecard = card
+	return result
+}
+
+// qualityRuleAttribute returns the attribute a rule checks, fetching it if no mapping uses it.
+func (s *ProcessingService) qualityRuleAttribute(rule DataQualityRule, attributeDefs map[string]*AttributeDefinition, entityDefinitionID string) (*AttributeDefinition, error) {
+	if rule.AttributeID == "" {
+		return nil, fmt.Errorf("rule has no attribute")
+	}
+	if attrDef, ok := attributeDefs[rule.AttributeID]; ok {
+		return attrDef, nil
+	}
+	entityID := rule.EntityID
+	if entityID == "" {
+		entityID = entityDefinitionID
+	}
+	attrDef, err := s.metadataClient.GetAttributeDefinition(rule.AttributeID, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attribute definition %s: %w", rule.AttributeID, err)
+	}
+	attributeDefs[rule.AttributeID] = attrDef
+	return attrDef, nil
+}
+
+func isNullValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	s, ok := value.(string)
+	return ok && strings.TrimSpace(s) == ""
+}
+
+func countNulls(records []transformedRecord, evaluated []int, attribute string) int {
+	nulls := 0
+	for _, i := range evaluated {
+		if isNullValue(records[i].data[attribute]) {
+			nulls++
+		}
+	}
+	return nulls
+}
+
+// evaluateQualityRule returns the violating records of the batch by index with the reason.
+// A max_null_percentage violation is reported for every record with a null value.
+func (s *ProcessingService) evaluateQualityRule(rule DataQualityRule, attrDef *AttributeDefinition, entityDefinitionID string, records []transformedRecord, evaluated []int) (map[int]string, error) {
+	name := attrDef.Name
+	violations := make(map[int]string)
+	params := rule.Parameters
+
+	switch rule.RuleType {
+	case QualityRuleNotNull:
+		for _, i := range evaluated {
+			if isNullValue(records[i].data[name]) {
+				violations[i] = fmt.Sprintf("%s is null", name)
+			}
+		}
+
+	case QualityRuleMaxNullPercentage:
+		maxPercent, ok := numericParam(params, "max_percent")
+		if !ok {
+			return nil, fmt.Errorf("parameter max_percent is required")
+		}
+		if len(evaluated) == 0 {
+			break
+		}
+		nulls := countNulls(records, evaluated, name)
+		percent := float64(nulls) * 100 / float64(len(evaluated))
+		if percent > maxPercent {
+			reason := fmt.Sprintf("%.1f%% of the values of %s are null, more than %g%%", percent, name, maxPercent)
+			for _, i := range evaluated {
+				if isNullValue(records[i].data[name]) {
+					violations[i] = reason
+				}
+			}
+		}
+
+	case QualityRuleRange:
+		minValue, hasMin := params["min"]
+		maxValue, hasMax := params["max"]
+		if !hasMin && !hasMax {
+			return nil, fmt.Errorf("parameter min or max is required")
+		}
+		for _, i := range evaluated {
+			value := records[i].data[name]
+			if isNullValue(value) {
+				continue
+			}
+			if hasMin {
+				if cmp, err := compareQualityValues(value, minValue); err != nil {
+					violations[i] = err.Error()
+					continue
+				} else if cmp < 0 {
+					violations[i] = fmt.Sprintf("%v is less than the minimum %v", value, minValue)
+					continue
+				}
+			}
+			if hasMax {
+				if cmp, err := compareQualityValues(value, maxValue); err != nil {
+					violations[i] = err.Error()
+				} else if cmp > 0 {
+					violations[i] = fmt.Sprintf("%v is greater than the maximum %v", value, maxValue)
+				}
+			}
+		}
+
+	case QualityRuleRegex:
+		pattern, _ := params["pattern"].(string)
+		if pattern == "" {
+			return nil, fmt.Errorf("parameter pattern is required")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		for _, i := range evaluated {
+			value := records[i].data[name]
+			if isNullValue(value) {
+				continue
+			}
+			if text := qualityKey(value); !re.MatchString(text) {
+				violations[i] = fmt.Sprintf("'%s' does not match %s", text, pattern)
+			}
+		}
+
+	case QualityRuleReferenceExists:
+		referencedEntityID, _ := params["referenced_entity_id"].(string)
+		if referencedEntityID == "" {
+			referencedEntityID, _ = attrDef.DataTypeDetails["referenced_entity_id"].(string)
+		}
+		if referencedEntityID == "" {
+			return nil, fmt.Errorf("parameter referenced_entity_id is required")
+		}
+		for _, i := range evaluated {
+			value := records[i].data[name]
+			if isNullValue(value) {
+				continue
+			}
+			if err := s.checkReference(referencedEntityID, qualityKey(value)); err != nil {
+				violations[i] = err.Error()
+			}
+		}
+
+	case QualityRuleUnique:
+		first := make(map[string]int)
+		for _, i := range evaluated {
+			value := records[i].data[name]
+			if isNullValue(value) {
+				continue
+			}
+			key := qualityKey(value)
+			if j, seen := first[key]; seen {
+				violations[i] = fmt.Sprintf("%s '%s' is not unique in the batch", name, key)
+				if identifier := records[j].identifier; identifier != "" && identifier == records[i].identifier {
+					delete(violations, i) // The same record sent twice
+				}
+				continue
+			}
+			first[key] = i
+		}
+		lookup := first
+		if attrDef.protectsValues() {
+			// Stored values are hashes, tokens or ciphertexts. Equal values get equal hashes and tokens, so
+			// those are compared in their stored form; random ciphertexts cannot be compared at all.
+			lookup = make(map[string]int)
+			if storage := attrDef.piiStorage(); storage == PiiStorageHash || storage == PiiStorageTokenize {
+				for _, i := range first {
+					protected, _, err := s.piiKeys.protect(attrDef, records[i].data[name])
+					if err != nil {
+						return nil, err
+					}
+					lookup[protected.(string)] = i
+				}
+			}
+		}
+		stored, err := s.storedValueOwners(entityDefinitionID, name, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for key, owners := range stored {
+			i := lookup[key]
+			for _, owner := range owners {
+				if owner == "" || owner != records[i].identifier {
+					violations[i] = fmt.Sprintf("%s '%s' already belongs to another instance", name, qualityKey(records[i].data[name]))
+					break
+				}
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported rule type '%s'", rule.RuleType)
+	}
+	if attrDef.piiStorage() != "" {
+		// Reasons are quarantined and logged.
+		for i, reason := range violations {
+			if key := qualityKey(records[i].data[name]); key != "" && !isNullValue(records[i].data[name]) {
+				violations[i] = strings.ReplaceAll(reason, key, RedactedValue)
+			}
+		}
+	}
+	return violations, nil
+}
+
+// storedValueOwners returns, for the values of values that stored instances of the entity
+// definition already have for attribute, the raw record identifiers of those instances.
+func (s *ProcessingService) storedValueOwners(entityDefinitionID, attribute string, values map[string]int) (map[string][]string, error) {
+	if s.db == nil || entityDefinitionID == "" || len(values) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	rows, err := s.db.Query(`SELECT attributes->>$2, COALESCE(raw_record_identifier, '') FROM processed_entities
+        WHERE entity_definition_id = $1 AND deleted_at IS NULL AND attributes->>$2 = ANY($3)`,
+		entityDefinitionID, attribute, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up stored values of %s: %w", attribute, err)
+	}
+	defer rows.Close()
+	owners := make(map[string][]string)
+	for rows.Next() {
+		var value, identifier string
+		if err := rows.Scan(&value, &identifier); err != nil {
+			return nil, fmt.Errorf("failed to read stored values of %s: %w", attribute, err)
+		}
+		owners[value] = append(owners[value], identifier)
+	}
+	return owners, rows.Err()
+}
+
+// qualityKey returns the text of a processed value as it reads from the attributes JSONB column
+// with the ->> operator.
+func qualityKey(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	var text string
+	if json.Unmarshal(encoded, &text) == nil {
+		return text // Values encoded as JSON strings, e.g. dates
+	}
+	return string(encoded)
+}
+
+func numericParam(params map[string]interface{}, name string) (float64, bool) {
+	value, ok := params[name]
+	if !ok || value == nil {
+		return 0, false
+	}
+	f, err := convertToTargetType(value, "float")
+	if err != nil {
+		return 0, false
+	}
+	return f.(float64), true
+}
+
+// compareQualityValues compares a processed value with a range bound: numbers numerically and
+// dates chronologically (bounds given as RFC 3339 or YYYY-MM-DD strings).
+func compareQualityValues(value, bound interface{}) (int, error) {
+	if t, ok := value.(time.Time); ok {
+		boundText, _ := bound.(string)
+		boundTime, err := time.Parse(time.RFC3339, boundText)
+		if err != nil {
+			if boundTime, err = time.Parse("2006-01-02", boundText); err != nil {
+				return 0, fmt.Errorf("range bound %v is not a date", bound)
+			}
+		}
+		return t.Compare(boundTime), nil
+	}
+	v, err := convertToTargetType(value, "float")
+	if err != nil {
+		return 0, fmt.Errorf("%v is not a number", value)
+	}
+	b, err := convertToTargetType(bound, "float")
+	if err != nil {
+		return 0, fmt.Errorf("range bound %v is not a number", bound)
+	}
+	switch vf, bf := v.(float64), b.(float64); {
+	case vf < bf:
+		return -1, nil
+	case vf > bf:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// saveScorecard adds the scorecard of a batch to the scorecard of its run, or stores it on its own
+// when the batch has no run ID.
+func saveScorecard(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, card *QualityScorecard) error {
+	now := time.Now().UTC()
+	if card.RunID != "" {
+		var id string
+		var rulesJSON []byte
+		var existing QualityScorecard
+		err := db.QueryRow(`SELECT id, status, batches, records_evaluated, records_rejected, rules FROM quality_scorecards
+            WHERE source_id = $1 AND run_id = $2 FOR UPDATE`, card.SourceID, card.RunID).Scan(
+			&id, &existing.Status, &existing.Batches, &existing.RecordsEvaluated, &existing.RecordsRejected, &rulesJSON)
+		if err == nil {
+			if err := json.Unmarshal(rulesJSON, &existing.Rules); err != nil {
+				return fmt.Errorf("failed to decode scorecard %s: %w", id, err)
+			}
+			merged := mergeScorecards(existing, *card)
+			merged.ID = id
+			*card = merged
+			rulesJSON, err = json.Marshal(card.Rules)
+			if err != nil {
+				return fmt.Errorf("failed to marshal scorecard rules: %w", err)
+			}
+			_, err = db.Exec(`UPDATE quality_scorecards SET status = $2, batches = $3, records_evaluated = $4, records_rejected = $5, rules = $6, updated_at = $7
+                WHERE id = $1`, id, card.Status, card.Batches, card.RecordsEvaluated, card.RecordsRejected, rulesJSON, now)
+			if err != nil {
+				return fmt.Errorf("failed to update scorecard %s: %w", id, err)
+			}
+			card.UpdatedAt = now
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to read scorecard of run %s: %w", card.RunID, err)
+		}
+	}
+
+	card.ID = uuid.NewString()
+	card.CreatedAt, card.UpdatedAt = now, now
+	rulesJSON, err := json.Marshal(card.Rules)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scorecard rules: %w", err)
+	}
+	_, err = db.Exec(`INSERT INTO quality_scorecards (id, source_id, run_id, entity_type_name, status, batches, records_evaluated, records_rejected, rules, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)`,
+		card.ID, card.SourceID, sql.NullString{String: card.RunID, Valid: card.RunID != ""}, card.EntityTypeName,
+		card.Status, card.Batches, card.RecordsEvaluated, card.RecordsRejected, rulesJSON, now)
+	if err != nil {
+		return fmt.Errorf("failed to store scorecard: %w", err)
+	}
+	return nil
+}
+
+// mergeScorecards adds the counts of a batch to the scorecard of its run.
+func mergeScorecards(run, batch QualityScorecard) QualityScorecard {
+	merged := batch
+	merged.Status = worseStatus(run.Status, batch.Status)
+	merged.Batches = run.Batches + batch.Batches
+	merged.RecordsEvaluated = run.RecordsEvaluated + batch.RecordsEvaluated
+	merged.RecordsRejected = run.RecordsRejected + batch.RecordsRejected
+	merged.Rules = append([]RuleScore(nil), run.Rules...)
+	for _, score := range batch.Rules {
+		found := false
+		for i := range merged.Rules {
+			if merged.Rules[i].RuleID == score.RuleID {
+				merged.Rules[i].Evaluated += score.Evaluated
+				merged.Rules[i].Failed += score.Failed
+				merged.Rules[i].Status = worseStatus(merged.Rules[i].Status, score.Status)
+				if score.Error != "" {
+					merged.Rules[i].Error = score.Error
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Rules = append(merged.Rules, score)
+		}
+	}
+	return merged
+}
+
+const scorecardColumns = `id, source_id, run_id, entity_type_name, status, batches, records_evaluated, records_rejected, rules, created_at, updated_at`
+
+func scanScorecard(row interface{ Scan(...interface{}) error }) (*QualityScorecard, error) {
+	var card QualityScorecard
+	var runID sql.NullString
+	var rulesJSON []byte
+	if err := row.Scan(&card.ID, &card.SourceID, &runID, &card.EntityTypeName, &card.Status, &card.Batches,
+		&card.RecordsEvaluated, &card.RecordsRejected, &rulesJSON, &card.CreatedAt, &card.UpdatedAt); err != nil {
+		return nil, err
+	}
+	card.RunID = runID.String
+	if err := json.Unmarshal(rulesJSON, &card.Rules); err != nil {
+		return nil, fmt.Errorf("failed to decode rules of scorecard %s: %w", card.ID, err)
+	}
+	return &card, nil
+}
+
+// ListScorecards returns the scorecards matching filter, most recent first, and the total number
+// of matching scorecards.
+func (s *ProcessingService) ListScorecards(filter ScorecardFilter) ([]QualityScorecard, int, error) {
+	if s.db == nil {
+		return nil, 0, fmt.Errorf("scorecards require a database")
+	}
+	var conditions []string
+	var args []interface{}
+	for column, value := range map[string]string{"source_id": filter.SourceID, "run_id": filter.RunID, "status": filter.Status} {
+		if value != "" {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM quality_scorecards"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count scorecards: %w", err)
+	}
+	query := "SELECT " + scorecardColumns + " FROM quality_scorecards" + where + " ORDER BY updated_at DESC, id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list scorecards: %w", err)
+	}
+	defer rows.Close()
+
+	cards := []QualityScorecard{}
+	for rows.Next() {
+		card, err := scanScorecard(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read scorecard: %w", err)
+		}
+		cards = append(cards, *card)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list scorecards: %w", err)
+	}
+	return cards, total, nil
+}
+
+// GetScorecard returns a single scorecard.
+func (s *ProcessingService) GetScorecard(id string) (*QualityScorecard, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("scorecards require a database")
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScorecardNotFound, id)
+	}
+	card, err := scanScorecard(s.db.QueryRow("SELECT "+scorecardColumns+" FROM quality_scorecards WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrScorecardNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scorecard %s: %w", id, err)
+	}
+	return card, nil
+}
@@ -0,0 +1,275 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckQuality(t *testing.T) {
+	service := NewProcessingService(&MockMetadataServiceClient{}, nil)
+	attributeDefs := map[string]*AttributeDefinition{
+		"attr_email": {ID: "attr_email", Name: "Email", DataType: "string"},
+		"attr_age":   {ID: "attr_age", Name: "Age", DataType: "integer"},
+		"attr_since": {ID: "attr_since", Name: "Since", DataType: "date"},
+	}
+	records := []transformedRecord{
+		{data: map[string]interface{}{"Email": "a@example.com", "Age": int64(30), "Since": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}, identifier: "c1"},
+		{data: map[string]interface{}{"Email": "not-an-email", "Age": int64(150)}, identifier: "c2"},
+		{data: map[string]interface{}{"Email": "a@example.com", "Since": time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)}, identifier: "c3"},
+		{deleted: true},
+		{data: map[string]interface{}{}, identifier: "c5"},
+	}
+	rule := func(id, ruleType, attributeID, action string, params map[string]interface{}) DataQualityRule {
+		return DataQualityRule{ID: id, Name: id, RuleType: ruleType, AttributeID: attributeID, Action: action, Parameters: params, IsEnabled: true}
+	}
+
+	t.Run("No Rules", func(t *testing.T) {
+		result := service.checkQuality(nil, attributeDefs, "customer-def", records)
+		assert.Nil(t, result.scorecard)
+		assert.Empty(t, result.rejections)
+		assert.Empty(t, result.failure)
+	})
+
+	t.Run("Violations By Rule Type", func(t *testing.T) {
+		rules := []DataQualityRule{
+			rule("age-not-null", QualityRuleNotNull, "attr_age", QualityActionWarn, nil),
+			rule("email-unique", QualityRuleUnique, "attr_email", QualityActionWarn, nil),
+			rule("age-range", QualityRuleRange, "attr_age", QualityActionWarn, map[string]interface{}{"min": 0.0, "max": 120.0}),
+			rule("since-range", QualityRuleRange, "attr_since", QualityActionWarn, map[string]interface{}{"min": "2000-01-01"}),
+			rule("email-regex", QualityRuleRegex, "attr_email", QualityActionWarn, map[string]interface{}{"pattern": `^[^@]+@[^@]+$`}),
+			rule("since-nulls", QualityRuleMaxNullPercentage, "attr_since", QualityActionWarn, map[string]interface{}{"max_percent": 50.0}),
+		}
+		result := service.checkQuality(rules, attributeDefs, "customer-def", records)
+		require.NotNil(t, result.scorecard)
+		assert.Equal(t, QualityStatusWarning, result.scorecard.Status)
+		assert.Equal(t, 3, result.scorecard.RecordsEvaluated, "deleted and empty records are not evaluated")
+		assert.Empty(t, result.rejections, "warnings reject nothing")
+
+		failed := make(map[string]int)
+		for _, score := range result.scorecard.Rules {
+			assert.Empty(t, score.Error, score.RuleID)
+			assert.Equal(t, 3, score.Evaluated, score.RuleID)
+			failed[score.RuleID] = score.Failed
+		}
+		assert.Equal(t, map[string]int{
+			"age-not-null": 1, // c3
+			"email-unique": 1, // c3 repeats the email of c1
+			"age-range":    1, // c2
+			"since-range":  1, // c3
+			"email-regex":  1, // c2
+			"since-nulls":  1, // c2, 33% of the records
+		}, failed)
+		for _, score := range result.scorecard.Rules {
+			if score.RuleID == "since-nulls" {
+				assert.Equal(t, QualityStatusPassed, score.Status)
+			} else {
+				assert.Equal(t, QualityStatusWarning, score.Status, score.RuleID)
+			}
+		}
+	})
+
+	t.Run("Reject Action", func(t *testing.T) {
+		rules := []DataQualityRule{rule("age-range", QualityRuleRange, "attr_age", QualityActionReject, map[string]interface{}{"max": 120})}
+		result := service.checkQuality(rules, attributeDefs, "customer-def", records)
+		require.Len(t, result.rejections, 1)
+		require.Len(t, result.rejections[1], 1)
+		assert.Equal(t, "age-range", result.rejections[1][0].RuleID)
+		assert.Equal(t, "Age", result.rejections[1][0].Attribute)
+		assert.Equal(t, int64(150), result.rejections[1][0].Value)
+		assert.Equal(t, QualityStatusFailed, result.scorecard.Status)
+		assert.Equal(t, 1, result.scorecard.RecordsRejected)
+		assert.Empty(t, result.failure)
+	})
+
+	t.Run("Fail Action", func(t *testing.T) {
+		rules := []DataQualityRule{rule("since-nulls", QualityRuleMaxNullPercentage, "attr_since", QualityActionFail, map[string]interface{}{"max_percent": 10})}
+		result := service.checkQuality(rules, attributeDefs, "customer-def", records)
+		assert.Contains(t, result.failure, "since-nulls")
+		assert.Equal(t, QualityStatusFailed, result.scorecard.Status)
+		assert.Empty(t, result.rejections)
+	})
+
+	t.Run("Rules That Cannot Be Evaluated Only Warn", func(t *testing.T) {
+		rules := []DataQualityRule{
+			rule("bad-regex", QualityRuleRegex, "attr_email", QualityActionFail, map[string]interface{}{"pattern": "("}),
+			rule("unknown-type", "sometimes_null", "attr_email", QualityActionFail, nil),
+			rule("unknown-attribute", QualityRuleNotNull, "attr_missing", QualityActionFail, nil),
+		}
+		result := service.checkQuality(rules, attributeDefs, "customer-def", records)
+		assert.Empty(t, result.failure)
+		assert.Equal(t, QualityStatusWarning, result.scorecard.Status)
+		for _, score := range result.scorecard.Rules {
+			assert.NotEmpty(t, score.Error, score.RuleID)
+		}
+	})
+
+	t.Run("Disabled Rules Are Skipped", func(t *testing.T) {
+		disabled := rule("age-not-null", QualityRuleNotNull, "attr_age", QualityActionFail, nil)
+		disabled.IsEnabled = false
+		result := service.checkQuality([]DataQualityRule{disabled}, attributeDefs, "customer-def", records)
+		assert.Nil(t, result.scorecard)
+		assert.Empty(t, result.failure)
+	})
+}
+
+func TestMergeScorecards(t *testing.T) {
+	run := QualityScorecard{Status: QualityStatusPassed, Batches: 1, RecordsEvaluated: 10, Rules: []RuleScore{
+		{RuleID: "r1", Evaluated: 10, Failed: 0, Status: QualityStatusPassed},
+	}}
+	batch := QualityScorecard{Status: QualityStatusWarning, Batches: 1, RecordsEvaluated: 5, RecordsRejected: 1, Rules: []RuleScore{
+		{RuleID: "r1", Evaluated: 5, Failed: 2, Status: QualityStatusWarning},
+		{RuleID: "r2", Evaluated: 5, Status: QualityStatusPassed},
+	}}
+	merged := mergeScorecards(run, batch)
+	assert.Equal(t, QualityStatusWarning, merged.Status)
+	assert.Equal(t, 2, merged.Batches)
+	assert.Equal(t, 15, merged.RecordsEvaluated)
+	assert.Equal(t, 1, merged.RecordsRejected)
+	require.Len(t, merged.Rules, 2)
+	assert.Equal(t, RuleScore{RuleID: "r1", Evaluated: 15, Failed: 2, Status: QualityStatusWarning}, merged.Rules[0])
+	assert.Len(t, run.Rules, 1, "the run scorecard is not modified")
+}
+
+func TestQualityRulesProcessing(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "qualitySource"
+	entityType := "Customer"
+	entityDefID := "customer-def"
+	var rules []DataQualityRule
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityDefID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return []DataSourceFieldMapping{
+				{ID: "map-email", SourceID: sID, SourceFieldName: "email", EntityID: entityDefID, AttributeID: "attr_email"},
+				{ID: "map-age", SourceID: sID, SourceFieldName: "age", EntityID: entityDefID, AttributeID: "attr_age"},
+			}, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			switch attrID {
+			case "attr_email":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "Email", DataType: "string"}, nil
+			case "attr_age":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "Age", DataType: "integer"}, nil
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		GetQualityRulesFunc: func(sID string, entID string) ([]DataQualityRule, error) {
+			assert.Equal(t, entityDefID, entID)
+			return rules, nil
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+
+	reset := func(t *testing.T) {
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "quarantined_records", "quality_scorecards"))
+	}
+
+	t.Run("Rejected Records Are Quarantined And Scored", func(t *testing.T) {
+		reset(t)
+		rules = []DataQualityRule{
+			{ID: "email-unique", Name: "Unique email", RuleType: QualityRuleUnique, AttributeID: "attr_email", Action: QualityActionReject, IsEnabled: true},
+			{ID: "age-range", Name: "Plausible age", RuleType: QualityRuleRange, AttributeID: "attr_age", Action: QualityActionWarn, IsEnabled: true,
+				Parameters: map[string]interface{}{"min": 0.0, "max": 120.0}},
+		}
+		count, err := service.ProcessAndStoreRunData(sourceID, entityType, "run-1", []map[string]interface{}{
+			{"id": "c1", "email": "a@example.com", "age": "30"},
+			{"id": "c2", "email": "b@example.com", "age": "150"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = service.ProcessAndStoreRunData(sourceID, entityType, "run-1", []map[string]interface{}{
+			{"id": "c1", "email": "a@example.com", "age": "31"}, // Its own stored email
+			{"id": "c3", "email": "a@example.com", "age": "40"}, // Email of c1
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Len(t, fetchProcessedRecords(t, testDB, sourceID, entityType), 2)
+
+		quarantined, _, err := service.ListQuarantinedRecords(QuarantineFilter{RunID: "run-1"})
+		require.NoError(t, err)
+		require.Len(t, quarantined, 1)
+		assert.Equal(t, "c3", quarantined[0].RawRecordIdentifier)
+		assert.True(t, quarantined[0].Rejected)
+		require.Len(t, quarantined[0].Errors, 1)
+		assert.Equal(t, "email-unique", quarantined[0].Errors[0].RuleID)
+
+		cards, total, err := service.ListScorecards(ScorecardFilter{SourceID: sourceID, RunID: "run-1"})
+		require.NoError(t, err)
+		require.Equal(t, 1, total, "batches of a run share a scorecard")
+		card := cards[0]
+		assert.Equal(t, QualityStatusFailed, card.Status)
+		assert.Equal(t, 2, card.Batches)
+		assert.Equal(t, 4, card.RecordsEvaluated)
+		assert.Equal(t, 1, card.RecordsRejected)
+		require.Len(t, card.Rules, 2)
+		assert.Equal(t, "Email", card.Rules[0].Attribute)
+		assert.Equal(t, 1, card.Rules[0].Failed)
+		assert.Equal(t, QualityStatusWarning, card.Rules[1].Status)
+	})
+
+	t.Run("Failing Rule Stores Nothing", func(t *testing.T) {
+		reset(t)
+		rules = []DataQualityRule{
+			{ID: "age-nulls", Name: "Ages known", RuleType: QualityRuleMaxNullPercentage, AttributeID: "attr_age", Action: QualityActionFail, IsEnabled: true,
+				Parameters: map[string]interface{}{"max_percent": 20.0}},
+		}
+		count, err := service.ProcessAndStoreRunData(sourceID, entityType, "run-2", []map[string]interface{}{
+			{"id": "c1", "email": "a@example.com", "age": "30"},
+			{"id": "c2", "email": "b@example.com"},
+		})
+		assert.ErrorIs(t, err, ErrQualityCheckFailed)
+		assert.Equal(t, 0, count)
+		assert.Empty(t, fetchProcessedRecords(t, testDB, sourceID, entityType))
+
+		cards, _, err := service.ListScorecards(ScorecardFilter{Status: QualityStatusFailed})
+		require.NoError(t, err)
+		require.Len(t, cards, 1)
+		assert.Equal(t, "run-2", cards[0].RunID)
+	})
+
+	t.Run("API", func(t *testing.T) {
+		reset(t)
+		rules = []DataQualityRule{
+			{ID: "email-not-null", Name: "Email", RuleType: QualityRuleNotNull, AttributeID: "attr_email", Action: QualityActionWarn, IsEnabled: true},
+		}
+		_, err := service.ProcessAndStoreData(sourceID, entityType, []map[string]interface{}{{"id": "c1", "age": "30"}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/scorecards?source_id="+sourceID+"&status=warning", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var list struct {
+			Data  []QualityScorecard `json:"data"`
+			Total int                `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		require.Equal(t, 1, list.Total)
+		assert.Empty(t, list.Data[0].RunID)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/scorecards/"+list.Data[0].ID, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/scorecards/not-a-uuid", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/scorecards?status=bad", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
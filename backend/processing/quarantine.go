@@ -0,0 +1,399 @@
+package processing
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Statuses of quarantined records.
+const (
+	QuarantineStatusOpen     = "open"
+	QuarantineStatusResolved = "resolved"
+)
+
+// ErrQuarantinedRecordNotFound is returned for unknown quarantined record IDs.
+var ErrQuarantinedRecordNotFound = errors.New("quarantined record not found")
+
+// ErrQuarantinedRecordResolved is returned when a resolved record is resubmitted.
+var ErrQuarantinedRecordResolved = errors.New("quarantined record is already resolved")
+
+// ErrInvalidMappingFix is returned for mapping changes that cannot be applied.
+var ErrInvalidMappingFix = errors.New("invalid mapping fix")
+
+// FieldError describes why a mapped field of a record could not be stored. Errors that concern
+// the whole record have no mapping; violations of data quality rules have the rule ID instead.
+type FieldError struct {
+	MappingID   string      `json:"mapping_id,omitempty"`
+	RuleID      string      `json:"rule_id,omitempty"`
+	SourceField string      `json:"source_field,omitempty"`
+	Attribute   string      `json:"attribute,omitempty"`
+	Value       interface{} `json:"value,omitempty"`
+	Error       string      `json:"error"`
+}
+
+// QuarantinedRecord is a raw record that processing rejected, or stored without some of its
+// fields (Rejected is false). It stays open until a resubmission processes it without errors, or
+// a later ingestion stores the record with the same identifier without errors.
+type QuarantinedRecord struct {
+	ID                  string                 `json:"id"`
+	SourceID            string                 `json:"source_id"`
+	EntityTypeName      string                 `json:"entity_type_name"`
+	RunID               string                 `json:"run_id,omitempty"`
+	RecordIndex         int                    `json:"record_index"` // 1-based position in the batch it was received in
+	RawRecordIdentifier string                 `json:"raw_record_identifier,omitempty"`
+	RawRecord           map[string]interface{} `json:"raw_record"`
+	Errors              []FieldError           `json:"errors"`
+	Rejected            bool                   `json:"rejected"`
+	Status              string                 `json:"status"`
+	Attempts            int                    `json:"attempts"`
+	CreatedAt           time.Time              `json:"created_at"`
+	UpdatedAt           time.Time              `json:"updated_at"`
+	ResolvedAt          *time.Time             `json:"resolved_at,omitempty"`
+}
+
+// QuarantineFilter selects quarantined records. Empty fields do not filter.
+type QuarantineFilter struct {
+	SourceID  string
+	RunID     string
+	Status    string
+	MappingID string // Records with an error of this mapping
+	Limit     int
+	Offset    int
+}
+
+// quarantineWriter records the quarantine outcome of the records of a single processing
+// transaction.
+type quarantineWriter struct {
+	insertStmt  *sql.Stmt
+	updateStmt  *sql.Stmt
+	resolveStmt *sql.Stmt
+	sourceID    string
+	entityType  string
+	runID       sql.NullString
+	count       int // Records quarantined or kept in quarantine
+}
+
+func prepareQuarantine(tx *sql.Tx, sourceID, entityTypeName, runID string) (*quarantineWriter, error) {
+	q := &quarantineWriter{sourceID: sourceID, entityType: entityTypeName, runID: sql.NullString{String: runID, Valid: runID != ""}}
+	var err error
+	q.insertStmt, err = tx.Prepare(`INSERT INTO quarantined_records (id, source_id, entity_type_name, run_id, record_index, raw_record_identifier, raw_record, errors, rejected)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare insert statement for quarantined_records: %w", err)
+	}
+	q.updateStmt, err = tx.Prepare(`UPDATE quarantined_records
+        SET errors = $2, rejected = $3, attempts = attempts + 1, updated_at = NOW()
+        WHERE id = $1`)
+	if err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to prepare update statement for quarantined_records: %w", err)
+	}
+	// A record stored without errors resolves the resubmitted entry as well as every open entry of
+	// an earlier run for the same source record.
+	q.resolveStmt, err = tx.Prepare(`UPDATE quarantined_records
+        SET status = 'resolved', resolved_at = NOW(), updated_at = NOW(),
+            attempts = attempts + CASE WHEN id::text = $1 THEN 1 ELSE 0 END
+        WHERE status = 'open' AND (id::text = $1 OR (source_id = $2 AND raw_record_identifier = $3))`)
+	if err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to prepare resolve statement for quarantined_records: %w", err)
+	}
+	return q, nil
+}
+
+// Close releases the prepared statements.
+func (q *quarantineWriter) Close() {
+	for _, stmt := range []*sql.Stmt{q.insertStmt, q.updateStmt, q.resolveStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// reject quarantines a record that was not stored. quarantineID is the entry the record was
+// resubmitted from, if any.
+func (q *quarantineWriter) reject(quarantineID string, recordIndex int, rawRecord map[string]interface{}, identifier string, fieldErrors []FieldError) error {
+	return q.put(quarantineID, recordIndex, rawRecord, identifier, fieldErrors, true)
+}
+
+// storePartial quarantines a record that was stored without the fields in fieldErrors.
+func (q *quarantineWriter) storePartial(quarantineID string, recordIndex int, rawRecord map[string]interface{}, identifier string, fieldErrors []FieldError) error {
+	return q.put(quarantineID, recordIndex, rawRecord, identifier, fieldErrors, false)
+}
+
+func (q *quarantineWriter) put(quarantineID string, recordIndex int, rawRecord map[string]interface{}, identifier string, fieldErrors []FieldError, rejected bool) error {
+	errorsJSON, err := json.Marshal(fieldErrors)
+	if err != nil {
+		return fmt.Errorf("failed to marshal errors of quarantined record #%d: %w", recordIndex, err)
+	}
+	q.count++
+	if quarantineID != "" {
+		if _, err := q.updateStmt.Exec(quarantineID, errorsJSON, rejected); err != nil {
+			return fmt.Errorf("failed to update quarantined record %s: %w", quarantineID, err)
+		}
+		return nil
+	}
+
+	rawJSON, err := json.Marshal(rawRecord)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantined record #%d: %w", recordIndex, err)
+	}
+	_, err = q.insertStmt.Exec(uuid.New(), q.sourceID, q.entityType, q.runID, recordIndex,
+		sql.NullString{String: identifier, Valid: identifier != ""}, rawJSON, errorsJSON, rejected)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine record #%d for source %s: %w", recordIndex, q.sourceID, err)
+	}
+	return nil
+}
+
+// resolve closes the quarantine entries of a record that was stored without errors.
+func (q *quarantineWriter) resolve(quarantineID string, identifier string) error {
+	if quarantineID == "" && identifier == "" {
+		return nil
+	}
+	_, err := q.resolveStmt.Exec(quarantineID, q.sourceID, sql.NullString{String: identifier, Valid: identifier != ""})
+	if err != nil {
+		return fmt.Errorf("failed to resolve quarantined records of record '%s': %w", identifier, err)
+	}
+	return nil
+}
+
+const quarantineColumns = `id, source_id, entity_type_name, run_id, record_index, raw_record_identifier, raw_record, errors,
+        rejected, status, attempts, created_at, updated_at, resolved_at`
+
+func scanQuarantinedRecord(row interface{ Scan(...interface{}) error }) (*QuarantinedRecord, error) {
+	var r QuarantinedRecord
+	var runID, identifier sql.NullString
+	var recordIndex sql.NullInt64
+	var rawJSON, errorsJSON []byte
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&r.ID, &r.SourceID, &r.EntityTypeName, &runID, &recordIndex, &identifier, &rawJSON, &errorsJSON,
+		&r.Rejected, &r.Status, &r.Attempts, &r.CreatedAt, &r.UpdatedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	r.RunID = runID.String
+	r.RecordIndex = int(recordIndex.Int64)
+	r.RawRecordIdentifier = identifier.String
+	if resolvedAt.Valid {
+		r.ResolvedAt = &resolvedAt.Time
+	}
+	if err := json.Unmarshal(rawJSON, &r.RawRecord); err != nil {
+		return nil, fmt.Errorf("failed to decode raw record of quarantined record %s: %w", r.ID, err)
+	}
+	if err := json.Unmarshal(errorsJSON, &r.Errors); err != nil {
+		return nil, fmt.Errorf("failed to decode errors of quarantined record %s: %w", r.ID, err)
+	}
+	return &r, nil
+}
+
+// ListQuarantinedRecords returns the quarantined records matching filter, oldest first, and the
+// total number of matching records.
+func (s *ProcessingService) ListQuarantinedRecords(filter QuarantineFilter) ([]QuarantinedRecord, int, error) {
+	if s.db == nil {
+		return nil, 0, fmt.Errorf("quarantine requires a database")
+	}
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.SourceID != "" {
+		addCondition("source_id = $%d", filter.SourceID)
+	}
+	if filter.RunID != "" {
+		addCondition("run_id = $%d", filter.RunID)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if filter.MappingID != "" {
+		mappingErrors, _ := json.Marshal([]map[string]string{{"mapping_id": filter.MappingID}})
+		addCondition("errors @> $%d::jsonb", string(mappingErrors))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM quarantined_records"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count quarantined records: %w", err)
+	}
+
+	query := "SELECT " + quarantineColumns + " FROM quarantined_records" + where + " ORDER BY created_at, record_index, id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list quarantined records: %w", err)
+	}
+	defer rows.Close()
+
+	records := []QuarantinedRecord{}
+	for rows.Next() {
+		r, err := scanQuarantinedRecord(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read quarantined record: %w", err)
+		}
+		records = append(records, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list quarantined records: %w", err)
+	}
+	return records, total, nil
+}
+
+// GetQuarantinedRecord returns a single quarantined record.
+func (s *ProcessingService) GetQuarantinedRecord(id string) (*QuarantinedRecord, error) {
+	records, err := s.getQuarantinedRecords([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// getQuarantinedRecords returns the quarantined records with the given IDs in the same order.
+func (s *ProcessingService) getQuarantinedRecords(ids []string) ([]QuarantinedRecord, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("quarantine requires a database")
+	}
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrQuarantinedRecordNotFound, id)
+		}
+	}
+	rows, err := s.db.Query("SELECT "+quarantineColumns+" FROM quarantined_records WHERE id::text = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined records: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[string]QuarantinedRecord, len(ids))
+	for rows.Next() {
+		r, err := scanQuarantinedRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quarantined record: %w", err)
+		}
+		byID[r.ID] = *r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get quarantined records: %w", err)
+	}
+
+	records := make([]QuarantinedRecord, len(ids))
+	for i, id := range ids {
+		r, ok := byID[strings.ToLower(id)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrQuarantinedRecordNotFound, id)
+		}
+		records[i] = r
+	}
+	return records, nil
+}
+
+// ResubmitQuarantinedRecords processes the raw records of open quarantined records again with the
+// current mappings, as ProcessAndStoreData does. Records processed without errors are resolved;
+// the others stay open with their new errors. It returns the records in their new state.
+func (s *ProcessingService) ResubmitQuarantinedRecords(ids []string) ([]QuarantinedRecord, error) {
+	records, err := s.getQuarantinedRecords(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records are processed per source and entity type, in the order they were first selected.
+	type batchKey struct{ sourceID, entityTypeName string }
+	var order []batchKey
+	rawData := make(map[batchKey][]map[string]interface{})
+	quarantineIDs := make(map[batchKey][]string)
+	seen := make(map[string]bool, len(records))
+	for _, r := range records {
+		if r.Status != QuarantineStatusOpen {
+			return nil, fmt.Errorf("%w: %s", ErrQuarantinedRecordResolved, r.ID)
+		}
+		if seen[r.ID] {
+			continue
+		}
+		seen[r.ID] = true
+		key := batchKey{r.SourceID, r.EntityTypeName}
+		if _, ok := rawData[key]; !ok {
+			order = append(order, key)
+		}
+		rawData[key] = append(rawData[key], r.RawRecord)
+		quarantineIDs[key] = append(quarantineIDs[key], r.ID)
+	}
+
+	for _, key := range order {
+		log.Printf("Resubmitting %d quarantined records for source %s", len(rawData[key]), key.sourceID)
+		if _, err := s.processAndStore(key.sourceID, key.entityTypeName, "", rawData[key], quarantineIDs[key]); err != nil {
+			return nil, fmt.Errorf("failed to resubmit quarantined records of source %s: %w", key.sourceID, err)
+		}
+	}
+	return s.getQuarantinedRecords(ids)
+}
+
+// MappingFix holds the changes to a field mapping made to fix quarantined records. Nil fields are
+// left unchanged.
+type MappingFix struct {
+	SourceFieldName    *string `json:"source_field_name"`
+	AttributeID        *string `json:"attribute_id"`
+	TransformationRule *string `json:"transformation_rule"`
+}
+
+// FixFieldMapping updates a field mapping of a data source in the metadata service and returns it
+// together with the IDs of the open quarantined records that have an error of this mapping, which
+// can then be resubmitted.
+func (s *ProcessingService) FixFieldMapping(sourceID, mappingID string, fix MappingFix) (*DataSourceFieldMapping, []string, error) {
+	changes := make(map[string]interface{})
+	if fix.SourceFieldName != nil {
+		if strings.TrimSpace(*fix.SourceFieldName) == "" {
+			return nil, nil, fmt.Errorf("%w: source_field_name cannot be empty", ErrInvalidMappingFix)
+		}
+		changes["source_field_name"] = *fix.SourceFieldName
+	}
+	if fix.AttributeID != nil {
+		if strings.TrimSpace(*fix.AttributeID) == "" {
+			return nil, nil, fmt.Errorf("%w: attribute_id cannot be empty", ErrInvalidMappingFix)
+		}
+		changes["attribute_id"] = *fix.AttributeID
+	}
+	if fix.TransformationRule != nil {
+		if *fix.TransformationRule != "" {
+			if _, err := CompileTransformationRule(*fix.TransformationRule); err != nil {
+				return nil, nil, fmt.Errorf("%w: invalid transformation_rule: %v", ErrInvalidMappingFix, err)
+			}
+		}
+		changes["transformation_rule"] = *fix.TransformationRule
+	}
+	if len(changes) == 0 {
+		return nil, nil, fmt.Errorf("%w: no mapping changes given", ErrInvalidMappingFix)
+	}
+
+	mapping, err := s.metadataClient.UpdateDataSourceFieldMapping(sourceID, mappingID, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+	affected, _, err := s.ListQuarantinedRecords(QuarantineFilter{SourceID: sourceID, Status: QuarantineStatusOpen, MappingID: mappingID})
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]string, len(affected))
+	for i, r := range affected {
+		ids[i] = r.ID
+	}
+	return mapping, ids, nil
+}
@@ -0,0 +1,212 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	sourceID := "quarantineSource"
+	entityType := "Order"
+	entityDefID := "order-def"
+	mappings := []DataSourceFieldMapping{
+		{ID: "map-qty", SourceID: sourceID, SourceFieldName: "quantity", EntityID: entityDefID, AttributeID: "attr_qty"},
+		{ID: "map-date", SourceID: sourceID, SourceFieldName: "order_date", EntityID: entityDefID, AttributeID: "attr_order_dt"},
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityDefID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return append([]DataSourceFieldMapping(nil), mappings...), nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			switch attrID {
+			case "attr_qty":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "Quantity", DataType: "integer"}, nil
+			case "attr_order_dt":
+				return &AttributeDefinition{ID: attrID, EntityID: entID, Name: "OrderDate", DataType: "date"}, nil
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		UpdateDataSourceFieldMappingFunc: func(sID string, mappingID string, changes map[string]interface{}) (*DataSourceFieldMapping, error) {
+			for i := range mappings {
+				if mappings[i].ID == mappingID {
+					if rule, ok := changes["transformation_rule"].(string); ok {
+						mappings[i].TransformationRule = rule
+					}
+					return &mappings[i], nil
+				}
+			}
+			return nil, fmt.Errorf("field mapping %s of source %s not found", mappingID, sID)
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+	router := gin.New()
+	NewAPI(service).RegisterRoutes(router)
+
+	reset := func(t *testing.T) {
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "quarantined_records"))
+		mappings[0].TransformationRule = ""
+	}
+	rawData := []map[string]interface{}{
+		{"id": "o1", "quantity": "2", "order_date": "2023-01-15"},
+		{"id": "o2", "quantity": "3 pcs", "order_date": "yesterday"},
+		{"id": "o3", "quantity": "4", "order_date": "last week"},
+		{"id": "o4", "comment": "no mapped fields"},
+	}
+
+	t.Run("Rejected And Partial Records Are Quarantined", func(t *testing.T) {
+		reset(t)
+		count, err := service.ProcessAndStoreRunData(sourceID, entityType, "run-1", rawData)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count, "o1 and o3 are stored")
+
+		records, total, err := service.ListQuarantinedRecords(QuarantineFilter{RunID: "run-1"})
+		require.NoError(t, err)
+		require.Equal(t, 3, total)
+		require.Len(t, records, 3)
+
+		assert.Equal(t, "o2", records[0].RawRecordIdentifier)
+		assert.Equal(t, 2, records[0].RecordIndex)
+		assert.True(t, records[0].Rejected)
+		assert.Equal(t, QuarantineStatusOpen, records[0].Status)
+		assert.Equal(t, "3 pcs", records[0].RawRecord["quantity"])
+		require.Len(t, records[0].Errors, 2)
+		assert.Equal(t, "map-qty", records[0].Errors[0].MappingID)
+		assert.Equal(t, "map-date", records[0].Errors[1].MappingID)
+
+		assert.Equal(t, "o3", records[1].RawRecordIdentifier)
+		assert.False(t, records[1].Rejected, "o3 is stored without its order date")
+		require.Len(t, records[1].Errors, 1)
+		assert.Equal(t, "OrderDate", records[1].Errors[0].Attribute)
+
+		assert.Equal(t, "o4", records[2].RawRecordIdentifier)
+		require.Len(t, records[2].Errors, 1)
+		assert.Contains(t, records[2].Errors[0].Error, "none of the mapped source fields")
+
+		byMapping, _, err := service.ListQuarantinedRecords(QuarantineFilter{MappingID: "map-qty"})
+		require.NoError(t, err)
+		require.Len(t, byMapping, 1)
+		assert.Equal(t, "o2", byMapping[0].RawRecordIdentifier)
+	})
+
+	t.Run("Fix Mapping And Resubmit", func(t *testing.T) {
+		reset(t)
+		_, err := service.ProcessAndStoreRunData(sourceID, entityType, "run-2", rawData[:2])
+		require.NoError(t, err)
+
+		rule := `regex_extract(value, "\d+")`
+		mapping, affected, err := service.FixFieldMapping(sourceID, "map-qty", MappingFix{TransformationRule: &rule})
+		require.NoError(t, err)
+		assert.Equal(t, rule, mapping.TransformationRule)
+		require.Len(t, affected, 1)
+
+		records, err := service.ResubmitQuarantinedRecords(affected)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, QuarantineStatusOpen, records[0].Status, "the order date still fails")
+		assert.False(t, records[0].Rejected)
+		assert.Equal(t, 2, records[0].Attempts)
+		require.Len(t, records[0].Errors, 1)
+		assert.Equal(t, "map-date", records[0].Errors[0].MappingID)
+
+		stored := fetchProcessedRecords(t, testDB, sourceID, entityType)
+		require.Len(t, stored, 2)
+
+		_, err = testDB.Exec(`UPDATE quarantined_records SET raw_record = raw_record || '{"order_date": "2023-02-01"}' WHERE id = $1`, records[0].ID)
+		require.NoError(t, err)
+		records, err = service.ResubmitQuarantinedRecords(affected)
+		require.NoError(t, err)
+		assert.Equal(t, QuarantineStatusResolved, records[0].Status)
+		assert.NotNil(t, records[0].ResolvedAt)
+
+		_, err = service.ResubmitQuarantinedRecords(affected)
+		assert.ErrorIs(t, err, ErrQuarantinedRecordResolved)
+
+		invalid := "shout(value)"
+		_, _, err = service.FixFieldMapping(sourceID, "map-qty", MappingFix{TransformationRule: &invalid})
+		assert.ErrorIs(t, err, ErrInvalidMappingFix)
+	})
+
+	t.Run("Clean Re-Ingestion Resolves Open Records", func(t *testing.T) {
+		reset(t)
+		_, err := service.ProcessAndStoreData(sourceID, entityType, rawData[2:3])
+		require.NoError(t, err)
+		_, err = service.ProcessAndStoreData(sourceID, entityType, []map[string]interface{}{
+			{"id": "o3", "quantity": "4", "order_date": "2023-03-01"},
+		})
+		require.NoError(t, err)
+
+		records, _, err := service.ListQuarantinedRecords(QuarantineFilter{SourceID: sourceID})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, QuarantineStatusResolved, records[0].Status)
+		assert.Equal(t, 1, records[0].Attempts, "the entry itself was not resubmitted")
+	})
+
+	t.Run("API", func(t *testing.T) {
+		reset(t)
+		_, err := service.ProcessAndStoreRunData(sourceID, entityType, "run-3", rawData)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/quarantine?source_id="+sourceID+"&status=open&limit=2", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var list struct {
+			Data  []QuarantinedRecord `json:"data"`
+			Total int                 `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		assert.Equal(t, 3, list.Total)
+		require.Len(t, list.Data, 2)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/quarantine/"+list.Data[0].ID, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/quarantine/not-a-uuid", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/process/quarantine?status=pending", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/process/quarantine/mappings/"+sourceID+"/map-date",
+			bytes.NewBufferString(`{"transformation_rule": "shout(value)"}`)))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/process/quarantine/mappings/"+sourceID+"/map-unknown",
+			bytes.NewBufferString(`{"transformation_rule": "trim"}`)))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		body, _ := json.Marshal(ResubmitRequest{IDs: []string{list.Data[0].ID, list.Data[1].ID}})
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/process/quarantine/resubmit", bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resubmitted struct {
+			RecordsResubmitted int `json:"records_resubmitted"`
+			RecordsResolved    int `json:"records_resolved"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resubmitted))
+		assert.Equal(t, 2, resubmitted.RecordsResubmitted)
+		assert.Equal(t, 0, resubmitted.RecordsResolved)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/process/quarantine/resubmit", bytes.NewBufferString(`{"ids": []}`)))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
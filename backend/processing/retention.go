@@ -0,0 +1,168 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The retention janitor deletes the instances in processed_entities that processing has not refreshed within
+// the EntityMaxAgeDays of the retention policies in the metadata service, with their typed table rows, group
+// memberships, history and the tokens only they use. It deletes in transactions of a bounded number of
+// instances, so that a sweep never locks much of the table at once, and skips instances that are being
+// processed; they are refreshed anyway.
+
+// DefaultRetentionBatchSize is the number of instances the retention janitor deletes per transaction.
+const DefaultRetentionBatchSize = 500
+
+// RetentionPolicy limits how long the instances of an entity definition, or those last processed from a data
+// source, are kept. Exactly one of SourceID and EntityID is set. Policies without EntityMaxAgeDays keep the
+// instances forever.
+type RetentionPolicy struct {
+	ID               string `json:"id"`
+	SourceID         string `json:"source_id,omitempty"`
+	EntityID         string `json:"entity_id,omitempty"`
+	EntityMaxAgeDays *int   `json:"entity_max_age_days,omitempty"`
+}
+
+// RetentionPurge reports what the retention janitor deleted under one policy in a sweep.
+type RetentionPurge struct {
+	PolicyID         string `json:"policy_id"`
+	SourceID         string `json:"source_id,omitempty"`
+	EntityID         string `json:"entity_id,omitempty"`
+	Instances        int    `json:"instances"`
+	HistoryVersions  int    `json:"history_versions"`
+	GroupMemberships int    `json:"group_memberships"`
+	PIITokens        int    `json:"pii_tokens"`
+}
+
+// PurgeStaleEntities deletes the instances last processed more than EntityMaxAgeDays before now, at most
+// batchSize per transaction, and reports what it deleted per policy. A policy that fails does not stop the
+// others; the errors are returned together.
+func (s *ProcessingService) PurgeStaleEntities(now time.Time, batchSize int) ([]RetentionPurge, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database connection is not configured")
+	}
+	policies, err := s.metadataClient.ListRetentionPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch retention policies: %w", err)
+	}
+	purges := []RetentionPurge{}
+	var errs []error
+	for _, policy := range policies {
+		if policy.EntityMaxAgeDays == nil {
+			continue
+		}
+		purge := RetentionPurge{PolicyID: policy.ID, SourceID: policy.SourceID, EntityID: policy.EntityID}
+		cutoff := now.UTC().AddDate(0, 0, -*policy.EntityMaxAgeDays)
+		for {
+			deleted, err := s.purgeStaleBatch(policy, cutoff, batchSize, &purge)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to purge stale instances under retention policy %s: %w", policy.ID, err))
+				break
+			}
+			if deleted < batchSize {
+				break
+			}
+		}
+		if purge.Instances > 0 {
+			purges = append(purges, purge)
+		}
+	}
+	return purges, errors.Join(errs...)
+}
+
+// purgeStaleBatch deletes up to batchSize instances in the scope of the policy last processed before cutoff
+// in one transaction, adds the counts to purge and returns the number of instances deleted.
+func (s *ProcessingService) purgeStaleBatch(policy RetentionPolicy, cutoff time.Time, batchSize int, purge *RetentionPurge) (int, error) {
+	scope, scopeID := "source_id = $1", policy.SourceID
+	if policy.SourceID == "" {
+		scope, scopeID = "entity_definition_id = $1", policy.EntityID
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, COALESCE(entity_definition_id, ''), attributes FROM processed_entities
+        WHERE `+scope+` AND processed_at < $2
+        ORDER BY processed_at LIMIT $3
+        FOR UPDATE SKIP LOCKED`, scopeID, cutoff, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up stale instances: %w", err)
+	}
+	// Instances of a data source can belong to several entity definitions.
+	byEntity := make(map[string][]purgedInstance)
+	deleted := 0
+	for rows.Next() {
+		var instance purgedInstance
+		var entityDefinitionID string
+		var attributesJSON []byte
+		if err := rows.Scan(&instance.id, &entityDefinitionID, &attributesJSON); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read stale instance: %w", err)
+		}
+		if len(attributesJSON) > 0 {
+			if err := json.Unmarshal(attributesJSON, &instance.attributes); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to decode attributes of instance %s: %w", instance.id, err)
+			}
+		}
+		byEntity[entityDefinitionID] = append(byEntity[entityDefinitionID], instance)
+		deleted++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to look up stale instances: %w", err)
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	var counts purgeCounts
+	for entityDefinitionID, instances := range byEntity {
+		purged, err := s.purgeInstances(tx, entityDefinitionID, instances)
+		if err != nil {
+			return 0, err
+		}
+		counts.groupMemberships += purged.groupMemberships
+		counts.historyVersions += purged.historyVersions
+		counts.piiTokens += purged.piiTokens
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit database transaction: %w", err)
+	}
+	purge.Instances += deleted
+	purge.GroupMemberships += counts.groupMemberships
+	purge.HistoryVersions += counts.historyVersions
+	purge.PIITokens += counts.piiTokens
+	return deleted, nil
+}
+
+// RunRetentionJanitor purges stale instances right away and then every interval until ctx is done.
+func (s *ProcessingService) RunRetentionJanitor(ctx context.Context, interval time.Duration, batchSize int) {
+	log.Printf("Retention janitor started; sweeping every %s in batches of %d instances.", interval, batchSize)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purges, err := s.PurgeStaleEntities(time.Now(), batchSize)
+		if err != nil {
+			log.Printf("Retention janitor sweep failed: %v", err)
+		}
+		for _, purge := range purges {
+			log.Printf("Retention janitor purged %d stale instances (%d history versions, %d group memberships, %d tokens) under retention policy %s (source %q, entity %q).",
+				purge.Instances, purge.HistoryVersions, purge.GroupMemberships, purge.PIITokens, purge.PolicyID, purge.SourceID, purge.EntityID)
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Retention janitor stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
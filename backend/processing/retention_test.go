@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	require.NotNil(t, testDB, "Test DB connection should be initialized by TestMain")
+
+	entityID := "retention-def"
+	attrDefs := map[string]*AttributeDefinition{
+		"attr_rt_name": {ID: "attr_rt_name", Name: "Name", DataType: "string"},
+	}
+	maxAge := func(days int) *int { return &days }
+	policies := []RetentionPolicy{
+		{ID: "policy-source-a", SourceID: "retentionSourceA", EntityMaxAgeDays: maxAge(30)},
+		{ID: "policy-entity", EntityID: entityID, EntityMaxAgeDays: maxAge(60)},
+		{ID: "policy-source-b", SourceID: "retentionSourceB"},
+	}
+	mockMetaClient := &MockMetadataServiceClient{
+		GetDataSourceConfigFunc: func(sID string) (*DataSourceConfig, error) {
+			return &DataSourceConfig{ID: sID, EntityID: entityID}, nil
+		},
+		GetDataSourceFieldMappingsFunc: func(sID string) ([]DataSourceFieldMapping, error) {
+			return []DataSourceFieldMapping{{ID: "map-rt-name-" + sID, SourceID: sID, SourceFieldName: "name", EntityID: entityID, AttributeID: "attr_rt_name"}}, nil
+		},
+		GetAttributeDefinitionFunc: func(attrID string, entID string) (*AttributeDefinition, error) {
+			if attrDef, ok := attrDefs[attrID]; ok {
+				return attrDef, nil
+			}
+			return nil, fmt.Errorf("unexpected attributeID: %s", attrID)
+		},
+		ListRetentionPoliciesFunc: func() ([]RetentionPolicy, error) {
+			return policies, nil
+		},
+	}
+	service := NewProcessingService(mockMetaClient, testDB)
+
+	require.NoError(t, clearTablesForDBTests(testDB, "processed_entities", "processed_entity_history"))
+	// Tables of the grouping service, which shares the database.
+	_, err := testDB.Exec(`CREATE TABLE IF NOT EXISTS group_calculation_logs (group_definition_id TEXT PRIMARY KEY, member_count INTEGER);
+        CREATE TABLE IF NOT EXISTS group_memberships (group_definition_id TEXT NOT NULL, processed_entity_instance_id UUID NOT NULL,
+            PRIMARY KEY (group_definition_id, processed_entity_instance_id));
+        TRUNCATE group_memberships, group_calculation_logs;`)
+	require.NoError(t, err)
+
+	_, err = service.ProcessAndStoreData("retentionSourceA", "Customer", []map[string]interface{}{
+		{"id": "a1", "name": "Ann"}, {"id": "a2", "name": "Bob"}, {"id": "a3", "name": "Carl"},
+	})
+	require.NoError(t, err)
+	_, err = service.ProcessAndStoreData("retentionSourceB", "Customer", []map[string]interface{}{{"id": "b1", "name": "Dora"}})
+	require.NoError(t, err)
+	instanceID := func(sourceID, identifier string) string {
+		var id string
+		err := testDB.QueryRow(`SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = $2`, sourceID, identifier).Scan(&id)
+		if err != nil {
+			return ""
+		}
+		return id
+	}
+	staleID := instanceID("retentionSourceA", "a1")
+	require.NotEmpty(t, staleID)
+	now := time.Now().UTC()
+	_, err = testDB.Exec(`UPDATE processed_entities SET processed_at = $1
+        WHERE (source_id = 'retentionSourceA' AND raw_record_identifier IN ('a1', 'a2')) OR source_id = 'retentionSourceB'`, now.AddDate(0, 0, -40))
+	require.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO group_calculation_logs (group_definition_id, member_count) VALUES ('grp-rt', 1)`)
+	require.NoError(t, err)
+	_, err = testDB.Exec(`INSERT INTO group_memberships (group_definition_id, processed_entity_instance_id) VALUES ('grp-rt', $1)`, staleID)
+	require.NoError(t, err)
+
+	t.Run("Stale Instances Of A Source Are Purged In Batches", func(t *testing.T) {
+		purges, err := service.PurgeStaleEntities(now, 1)
+		require.NoError(t, err)
+		require.Len(t, purges, 1)
+		assert.Equal(t, RetentionPurge{PolicyID: "policy-source-a", SourceID: "retentionSourceA", Instances: 2, HistoryVersions: 2, GroupMemberships: 1}, purges[0])
+
+		assert.Empty(t, instanceID("retentionSourceA", "a1"))
+		assert.Empty(t, instanceID("retentionSourceA", "a2"))
+		assert.NotEmpty(t, instanceID("retentionSourceA", "a3"), "refreshed instances are kept")
+		assert.NotEmpty(t, instanceID("retentionSourceB", "b1"), "the entity policy keeps instances for 60 days")
+		var count, memberCount int
+		require.NoError(t, testDB.QueryRow(`SELECT COUNT(*) FROM processed_entity_history WHERE entity_instance_id = $1`, staleID).Scan(&count))
+		assert.Zero(t, count)
+		require.NoError(t, testDB.QueryRow(`SELECT member_count FROM group_calculation_logs WHERE group_definition_id = 'grp-rt'`).Scan(&memberCount))
+		assert.Zero(t, memberCount)
+	})
+
+	t.Run("The Shorter Limit Applies", func(t *testing.T) {
+		policies[1].EntityMaxAgeDays = maxAge(30)
+		purges, err := service.PurgeStaleEntities(now, 10)
+		require.NoError(t, err)
+		require.Len(t, purges, 1)
+		assert.Equal(t, "policy-entity", purges[0].PolicyID)
+		assert.Equal(t, 1, purges[0].Instances)
+		assert.Empty(t, instanceID("retentionSourceB", "b1"))
+		assert.NotEmpty(t, instanceID("retentionSourceA", "a3"))
+	})
+
+	t.Run("Metadata Errors Are Returned", func(t *testing.T) {
+		failing := NewProcessingService(&MockMetadataServiceClient{}, testDB)
+		_, err := failing.PurgeStaleEntities(now, 10)
+		assert.ErrorContains(t, err, "failed to fetch retention policies")
+	})
+}
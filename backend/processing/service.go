@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,7 +22,16 @@ type MetadataServiceAPIClient interface {
 	GetDataSourceFieldMappings(sourceID string) ([]DataSourceFieldMapping, error)
 	GetAttributeDefinition(attributeID string, entityID string) (*AttributeDefinition, error)
 	GetDataSourceConfig(sourceID string) (*DataSourceConfig, error)
-	// GetEntityDefinition(entityID string) (*EntityDefinition, error) // Not used by current ProcessAndStoreData
+	// UpdateDataSourceFieldMapping changes the given fields of a mapping and returns the updated mapping.
+	UpdateDataSourceFieldMapping(sourceID string, mappingID string, changes map[string]interface{}) (*DataSourceFieldMapping, error)
+	// GetQualityRules returns the enabled data quality rules of a data source and of its entity definition.
+	GetQualityRules(sourceID string, entityID string) ([]DataQualityRule, error)
+	// GetEntityDefinition fetches an entity definition. Used to materialize it into a typed table.
+	GetEntityDefinition(entityID string) (*EntityDefinition, error)
+	// ListAttributeDefinitions returns all attribute definitions of an entity definition.
+	ListAttributeDefinitions(entityID string) ([]AttributeDefinition, error)
+	// ListRetentionPolicies returns the retention policies of all entity definitions and data sources.
+	ListRetentionPolicies() ([]RetentionPolicy, error)
 }
 
 // HTTPMetadataClient is an implementation of MetadataServiceAPIClient using HTTP.
@@ -102,7 +113,163 @@ func (c *HTTPMetadataClient) GetDataSourceConfig(sourceID string) (*DataSourceCo
 	return &config, nil
 }
 
-// initSchema creates the processed_entities table if it doesn't exist.
+// UpdateDataSourceFieldMapping reads the mapping from the metadata service, applies changes to it and
+// writes it back. Fields of the mapping not known to this service are preserved.
+func (c *HTTPMetadataClient) UpdateDataSourceFieldMapping(sourceID string, mappingID string, changes map[string]interface{}) (*DataSourceFieldMapping, error) {
+	url := fmt.Sprintf("%s/api/v1/datasources/%s/mappings/%s", c.BaseURL, sourceID, mappingID)
+	resp, err := c.HttpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get field mapping from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("field mapping %s of source %s not found", mappingID, sourceID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned non-OK status %d for field mapping at %s", resp.StatusCode, url)
+	}
+	var mapping map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&mapping); err != nil {
+		return nil, fmt.Errorf("failed to decode field mapping response: %w", err)
+	}
+
+	for field, value := range changes {
+		mapping[field] = value
+	}
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal field mapping: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request to metadata service: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	putResp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update field mapping at %s: %w", url, err)
+	}
+	defer putResp.Body.Close()
+	if putResp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(putResp.Body, 4096))
+		return nil, fmt.Errorf("metadata service returned non-OK status %d when updating field mapping at %s: %s", putResp.StatusCode, url, strings.TrimSpace(string(detail)))
+	}
+	var updated DataSourceFieldMapping
+	if err := json.NewDecoder(putResp.Body).Decode(&updated); err != nil {
+		return nil, fmt.Errorf("failed to decode updated field mapping response: %w", err)
+	}
+	return &updated, nil
+}
+
+// GetQualityRules fetches the data quality rules attached to the data source and, if entityID is
+// set, to the entity definition, and returns the enabled ones.
+func (c *HTTPMetadataClient) GetQualityRules(sourceID string, entityID string) ([]DataQualityRule, error) {
+	urls := []string{fmt.Sprintf("%s/api/v1/datasources/%s/quality-rules", c.BaseURL, sourceID)}
+	if entityID != "" {
+		urls = append(urls, fmt.Sprintf("%s/api/v1/entities/%s/quality-rules", c.BaseURL, entityID))
+	}
+	var rules []DataQualityRule
+	for _, url := range urls {
+		resp, err := c.HttpClient.Get(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data quality rules from %s: %w", url, err)
+		}
+		var list struct {
+			Data []DataQualityRule `json:"data"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("metadata service returned non-OK status %d for data quality rules at %s", resp.StatusCode, url)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode data quality rules response: %w", err)
+		}
+		for _, rule := range list.Data {
+			if rule.IsEnabled {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// GetEntityDefinition fetches a single entity definition.
+func (c *HTTPMetadataClient) GetEntityDefinition(entityID string) (*EntityDefinition, error) {
+	url := fmt.Sprintf("%s/api/v1/entities/%s", c.BaseURL, entityID)
+	resp, err := c.HttpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entity definition from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned non-OK status %d for entity definition at %s", resp.StatusCode, url)
+	}
+
+	var entity EntityDefinition
+	if err := json.NewDecoder(resp.Body).Decode(&entity); err != nil {
+		return nil, fmt.Errorf("failed to decode entity definition response: %w", err)
+	}
+	return &entity, nil
+}
+
+// ListAttributeDefinitions fetches all attribute definitions of an entity, page by page.
+func (c *HTTPMetadataClient) ListAttributeDefinitions(entityID string) ([]AttributeDefinition, error) {
+	const pageSize = 100
+	attrDefs := []AttributeDefinition{}
+	for {
+		url := fmt.Sprintf("%s/api/v1/entities/%s/attributes/?offset=%d&limit=%d", c.BaseURL, entityID, len(attrDefs), pageSize)
+		resp, err := c.HttpClient.Get(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get attribute definitions from %s: %w", url, err)
+		}
+		var page struct {
+			Data  []AttributeDefinition `json:"data"`
+			Total int                   `json:"total"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("metadata service returned non-OK status %d for attribute definitions at %s", resp.StatusCode, url)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode attribute definitions response: %w", err)
+		}
+		attrDefs = append(attrDefs, page.Data...)
+		if len(page.Data) == 0 || len(attrDefs) >= page.Total {
+			return attrDefs, nil
+		}
+	}
+}
+
+// ListRetentionPolicies fetches the retention policies of all entity definitions and data sources.
+func (c *HTTPMetadataClient) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	url := fmt.Sprintf("%s/api/v1/retention-policies", c.BaseURL)
+	resp, err := c.HttpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention policies from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned non-OK status %d for retention policies at %s", resp.StatusCode, url)
+	}
+
+	var list struct {
+		Data []RetentionPolicy `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode retention policies response: %w", err)
+	}
+	return list.Data, nil
+}
+
+// initSchema creates the processed_entities, quarantined_records, processed_entity_history,
+// quality_scorecards, materialized_entities, pii_tokens, erasure_receipts and erasure_suppressions
+// tables if they don't exist.
 func initSchema(db *sql.DB) error {
 	schema := `
     CREATE TABLE IF NOT EXISTS processed_entities (
@@ -114,15 +281,137 @@ func initSchema(db *sql.DB) error {
         raw_record_identifier TEXT,
         processed_at TIMESTAMPTZ DEFAULT NOW()
     );
+    -- Set when the record was deleted at its source (CDC tombstone); cleared when it reappears.
+    ALTER TABLE processed_entities ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+    -- Storage of the PII attributes of the instance by name, see pii.go.
+    ALTER TABLE processed_entities ADD COLUMN IF NOT EXISTS pii_attributes JSONB;
     CREATE INDEX IF NOT EXISTS idx_processed_entities_entity_def_id ON processed_entities(entity_definition_id);
     CREATE INDEX IF NOT EXISTS idx_processed_entities_entity_type_name ON processed_entities(entity_type_name);
     CREATE INDEX IF NOT EXISTS idx_processed_entities_source_id ON processed_entities(source_id);
+    -- Stale instances are looked up by processed_at for the retention janitor, see retention.go.
+    CREATE INDEX IF NOT EXISTS idx_processed_entities_processed_at ON processed_entities(processed_at);
+
+    -- Re-ingestion used to insert a new row per run. Collapse such duplicates onto the oldest
+    -- instance (the ID most likely referenced downstream) before enforcing uniqueness.
+    DELETE FROM processed_entities newer
+        USING processed_entities older
+        WHERE newer.source_id = older.source_id
+          AND newer.raw_record_identifier = older.raw_record_identifier
+          AND (newer.processed_at, newer.id) > (older.processed_at, older.id);
+    CREATE UNIQUE INDEX IF NOT EXISTS uq_processed_entities_source_record
+        ON processed_entities(source_id, raw_record_identifier)
+        WHERE raw_record_identifier IS NOT NULL;
+
+    -- Records that were rejected or stored without some of their fields, see quarantine.go.
+    CREATE TABLE IF NOT EXISTS quarantined_records (
+        id UUID PRIMARY KEY,
+        source_id TEXT NOT NULL,
+        entity_type_name TEXT NOT NULL,
+        run_id TEXT,
+        record_index INTEGER,
+        raw_record_identifier TEXT,
+        raw_record JSONB NOT NULL,
+        errors JSONB NOT NULL,
+        rejected BOOLEAN NOT NULL,
+        status TEXT NOT NULL DEFAULT 'open',
+        attempts INTEGER NOT NULL DEFAULT 1,
+        created_at TIMESTAMPTZ DEFAULT NOW(),
+        updated_at TIMESTAMPTZ DEFAULT NOW(),
+        resolved_at TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS idx_quarantined_records_source_status ON quarantined_records(source_id, status);
+    CREATE INDEX IF NOT EXISTS idx_quarantined_records_run_id ON quarantined_records(run_id);
+
+    -- Versions of the attributes of each instance (SCD type 2), see history.go.
+    CREATE TABLE IF NOT EXISTS processed_entity_history (
+        id UUID PRIMARY KEY,
+        entity_instance_id UUID NOT NULL,
+        entity_definition_id TEXT,
+        entity_type_name TEXT NOT NULL,
+        source_id TEXT,
+        attributes JSONB NOT NULL,
+        changed_attributes TEXT[] NOT NULL DEFAULT '{}',
+        valid_from TIMESTAMPTZ NOT NULL,
+        valid_to TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS idx_processed_entity_history_instance ON processed_entity_history(entity_instance_id, valid_from);
+    CREATE INDEX IF NOT EXISTS idx_processed_entity_history_entity_def ON processed_entity_history(entity_definition_id, valid_from);
+    CREATE UNIQUE INDEX IF NOT EXISTS uq_processed_entity_history_current
+        ON processed_entity_history(entity_instance_id)
+        WHERE valid_to IS NULL;
+
+    -- Outcome of the data quality rules per ingestion run, see quality.go.
+    CREATE TABLE IF NOT EXISTS quality_scorecards (
+        id UUID PRIMARY KEY,
+        source_id TEXT NOT NULL,
+        run_id TEXT,
+        entity_type_name TEXT NOT NULL,
+        status TEXT NOT NULL,
+        batches INTEGER NOT NULL,
+        records_evaluated INTEGER NOT NULL,
+        records_rejected INTEGER NOT NULL,
+        rules JSONB NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT NOW(),
+        updated_at TIMESTAMPTZ DEFAULT NOW()
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS uq_quality_scorecards_source_run
+        ON quality_scorecards(source_id, run_id)
+        WHERE run_id IS NOT NULL;
+
+    -- Entity definitions copied into typed tables, see materialize.go.
+    CREATE TABLE IF NOT EXISTS materialized_entities (
+        entity_definition_id TEXT PRIMARY KEY,
+        entity_type_name TEXT NOT NULL,
+        table_name TEXT NOT NULL UNIQUE,
+        columns JSONB NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT NOW(),
+        updated_at TIMESTAMPTZ DEFAULT NOW(),
+        rebuilt_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+    -- Encrypted values of tokenized PII attributes, see pii.go.
+    CREATE TABLE IF NOT EXISTS pii_tokens (
+        token TEXT PRIMARY KEY,
+        attribute_id TEXT NOT NULL,
+        encrypted_value TEXT NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+
+    -- Hash chain of the receipts of erased data subjects, see erasure.go.
+    CREATE TABLE IF NOT EXISTS erasure_receipts (
+        id UUID PRIMARY KEY,
+        sequence BIGINT NOT NULL UNIQUE,
+        entity_definition_id TEXT NOT NULL,
+        attribute_id TEXT NOT NULL,
+        attribute_name TEXT NOT NULL,
+        subject_hash TEXT NOT NULL,
+        requested_by TEXT,
+        reason TEXT,
+        instance_ids JSONB NOT NULL,
+        group_memberships_removed INTEGER NOT NULL,
+        history_versions_purged INTEGER NOT NULL,
+        quarantined_records_purged INTEGER NOT NULL,
+        pii_tokens_purged INTEGER NOT NULL,
+        erased_at TIMESTAMPTZ NOT NULL,
+        previous_hash TEXT NOT NULL,
+        receipt_hash TEXT NOT NULL
+    );
+
+    -- Hashes of erased key values and source records that processing does not store again.
+    CREATE TABLE IF NOT EXISTS erasure_suppressions (
+        key_hash TEXT PRIMARY KEY,
+        scope TEXT NOT NULL,
+        entity_definition_id TEXT NOT NULL,
+        receipt_id UUID NOT NULL,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS idx_erasure_suppressions_scope ON erasure_suppressions(scope);
     `
 	_, err := db.Exec(schema)
 	if err != nil {
 		return fmt.Errorf("failed to execute schema initialization for processed_entities: %w", err)
 	}
-	log.Println("Schema for 'processed_entities' table initialized successfully.")
+	log.Println("Schema for 'processed_entities', 'quarantined_records', 'processed_entity_history', 'quality_scorecards', 'materialized_entities', 'pii_tokens', 'erasure_receipts' and 'erasure_suppressions' tables initialized successfully.")
 	return nil
 }
 
@@ -130,6 +419,8 @@ func initSchema(db *sql.DB) error {
 type ProcessingService struct {
 	metadataClient MetadataServiceAPIClient
 	db             *sql.DB
+	rules          sync.Map // Compiled transformation rules by rule text
+	piiKeys        *PIIKeys // Keys for hashing, tokenizing and encrypting PII attributes, see SetPIIKeys
 }
 
 // NewProcessingService creates a new ProcessingService.
@@ -242,70 +533,127 @@ func convertToTargetType(value interface{}, targetType string) (interface{}, err
 }
 
 // transformAndConvertRecord processes a single raw record based on mappings and attribute definitions.
-// It returns the processed data map and a raw record identifier string.
+// It returns the processed data map, a raw record identifier string and the errors of the fields
+// that were skipped.
 func (s *ProcessingService) transformAndConvertRecord(
 	rawRecord map[string]interface{},
 	mappings []DataSourceFieldMapping,
 	attributeDefs map[string]*AttributeDefinition,
 	recordIndex int,
 	sourceID string,
-) (map[string]interface{}, string) {
+) (map[string]interface{}, string, []FieldError) {
 	processedRecordData := make(map[string]interface{})
-	var rawRecordIdentifierValue string
-
-	if idVal, idOk := rawRecord["id"]; idOk {
-		rawRecordIdentifierValue = fmt.Sprintf("%v", idVal)
-	} else if idVal, idOk := rawRecord["source_record_id"]; idOk {
-		rawRecordIdentifierValue = fmt.Sprintf("%v", idVal)
-	}
+	rawRecordIdentifierValue := rawRecordIdentifier(rawRecord)
+	var fieldErrors []FieldError
 
 	for _, mapping := range mappings {
-		rawValue, ok := rawRecord[mapping.SourceFieldName]
-		if !ok {
+		// Rules can derive a value from other fields, so a mapping with a rule is evaluated even when
+		// its source field is missing.
+		rawValue, present := rawRecord[mapping.SourceFieldName]
+		if !present && mapping.TransformationRule == "" {
 			continue
 		}
+		fieldError := FieldError{MappingID: mapping.ID, SourceField: mapping.SourceFieldName, Value: rawValue}
 
 		targetAttrDef, ok := attributeDefs[mapping.AttributeID]
 		if !ok {
 			log.Printf("Attribute definition for ID %s not found in cache for record #%d. Skipping field.", mapping.AttributeID, recordIndex)
+			fieldError.Error = fmt.Sprintf("attribute definition %s is not available", mapping.AttributeID)
+			fieldErrors = append(fieldErrors, fieldError)
 			continue
 		}
 		targetAttrName := targetAttrDef.Name
 		targetDataType := targetAttrDef.DataType
+		fieldError.Attribute = targetAttrName
+		fieldError.Value = redactedFieldValue(targetAttrDef, rawValue)
 		transformedValue := rawValue
 
 		if mapping.TransformationRule != "" {
-			switch strings.ToLower(mapping.TransformationRule) {
-			case "lowercase":
-				if strVal, isStr := transformedValue.(string); isStr {
-					transformedValue = strings.ToLower(strVal)
-				} else {
-					log.Printf("Warning: 'lowercase' rule for field '%s' (type %T) for record #%d, sourceID '%s'. Value not a string.", mapping.SourceFieldName, transformedValue, recordIndex, sourceID)
-				}
-			case "trim":
-				if strVal, isStr := transformedValue.(string); isStr {
-					transformedValue = strings.TrimSpace(strVal)
-				} else {
-					log.Printf("Warning: 'trim' rule for field '%s' (type %T) for record #%d, sourceID '%s'. Value not a string.", mapping.SourceFieldName, transformedValue, recordIndex, sourceID)
-				}
-			default:
-				log.Printf("Warning: Transformation rule '%s' for field '%s' (record #%d, sourceID '%s') is not implemented.", mapping.TransformationRule, mapping.SourceFieldName, recordIndex, sourceID)
+			rule, err := s.compileRule(mapping.TransformationRule)
+			if err != nil {
+				log.Printf("Warning: Invalid transformation rule '%s' for field '%s' (record #%d, sourceID '%s'). Skipping field. Error: %v", mapping.TransformationRule, mapping.SourceFieldName, recordIndex, sourceID, err)
+				fieldError.Error = fmt.Sprintf("invalid transformation rule: %v", err)
+				fieldErrors = append(fieldErrors, fieldError)
+				continue
+			}
+			transformedValue, err = rule.Evaluate(rawValue, rawRecord)
+			if err != nil {
+				log.Printf("Transformation rule '%s' failed for field '%s' (record #%d, sourceID '%s'). Skipping field. Error: %v", mapping.TransformationRule, mapping.SourceFieldName, recordIndex, sourceID, err)
+				fieldError.Error = fmt.Sprintf("transformation rule failed: %v", err)
+				fieldErrors = append(fieldErrors, fieldError)
+				continue
+			}
+			if !present && transformedValue == nil {
+				continue
 			}
 		}
 
-		convertedValue, err := convertToTargetType(transformedValue, targetDataType)
+		convertedValue, err := s.convertAttributeValue(transformedValue, targetAttrDef)
 		if err != nil {
 			log.Printf("Could not convert value '%v' (original: '%v') for source field '%s' to target type '%s' for attribute '%s' (record #%d, sourceID '%s'). Skipping field. Error: %v",
-				transformedValue, rawValue, mapping.SourceFieldName, targetDataType, targetAttrName, recordIndex, sourceID, err)
+				redactedFieldValue(targetAttrDef, transformedValue), fieldError.Value, mapping.SourceFieldName, targetDataType, targetAttrName, recordIndex, sourceID, err)
+			fieldError.Error = fmt.Sprintf("cannot convert to %s: %v", targetDataType, err)
+			fieldErrors = append(fieldErrors, fieldError)
 			continue
 		}
 		processedRecordData[targetAttrName] = convertedValue
 	}
-	return processedRecordData, rawRecordIdentifierValue
+	return processedRecordData, rawRecordIdentifierValue, fieldErrors
+}
+
+// compileRule returns the compiled transformation rule, compiling it on first use.
+func (s *ProcessingService) compileRule(rule string) (*CompiledRule, error) {
+	if compiled, ok := s.rules.Load(rule); ok {
+		return compiled.(*CompiledRule), nil
+	}
+	compiled, err := CompileTransformationRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	s.rules.Store(rule, compiled)
+	return compiled, nil
+}
+
+// rawRecordIdentifier returns the identifier of a raw record from its "id" or "source_record_id"
+// field, or "" if it has neither.
+func rawRecordIdentifier(rawRecord map[string]interface{}) string {
+	if idVal, idOk := rawRecord["id"]; idOk {
+		return fmt.Sprintf("%v", idVal)
+	} else if idVal, idOk := rawRecord["source_record_id"]; idOk {
+		return fmt.Sprintf("%v", idVal)
+	}
+	return ""
+}
+
+// DeletedRecordField marks a raw record as deleted at its source, e.g. a row deleted in a database
+// replicated through CDC. Such a record only needs its identifier; the stored instance is kept as a
+// tombstone (deleted_at set) so group memberships and workflow history referencing it remain valid.
+const DeletedRecordField = "_deleted"
+
+// isDeletedRecord reports whether rawRecord is a tombstone marked with DeletedRecordField.
+func isDeletedRecord(rawRecord map[string]interface{}) bool {
+	deleted, _ := rawRecord[DeletedRecordField].(bool)
+	return deleted
 }
 
 // ProcessAndStoreData processes raw data based on mappings and stores it.
+// Records marked with DeletedRecordField tombstone the stored instance with the same identifier.
+// Rejected records and records stored without some of their fields are quarantined. Records of
+// erased data subjects on the suppression list are skipped.
 func (s *ProcessingService) ProcessAndStoreData(sourceID string, entityTypeName string, rawData []map[string]interface{}) (int, error) {
+	return s.processAndStore(sourceID, entityTypeName, "", rawData, nil)
+}
+
+// ProcessAndStoreRunData is ProcessAndStoreData for records read by an ingestion run; the run ID
+// is recorded with the records it quarantines.
+func (s *ProcessingService) ProcessAndStoreRunData(sourceID string, entityTypeName string, runID string, rawData []map[string]interface{}) (int, error) {
+	return s.processAndStore(sourceID, entityTypeName, runID, rawData, nil)
+}
+
+// processAndStore implements ProcessAndStoreData. When quarantineIDs is not nil, rawData[i] is a
+// resubmission of the quarantined record quarantineIDs[i]: that entry is resolved once the record
+// is processed without errors and updated with the new errors otherwise.
+func (s *ProcessingService) processAndStore(sourceID string, entityTypeName string, runID string, rawData []map[string]interface{}, quarantineIDs []string) (int, error) {
 	log.Printf("Processing data for sourceID: %s, entityTypeName: %s. Records received: %d", sourceID, entityTypeName, len(rawData))
 
 	dsConfig, err := s.metadataClient.GetDataSourceConfig(sourceID)
@@ -343,43 +691,215 @@ func (s *ProcessingService) ProcessAndStoreData(sourceID string, entityTypeName
 	if len(attributeDefs) == 0 && len(mappings) > 0 {
 		return 0, fmt.Errorf("no valid attribute definitions could be fetched for the provided mappings for source %s", sourceID)
 	}
+	if err := s.checkPIIKeys(attributeDefs); err != nil {
+		return 0, err
+	}
+
+	qualityRules, err := s.metadataClient.GetQualityRules(sourceID, entityDefinitionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch data quality rules for source %s: %w", sourceID, err)
+	}
+
+	// All records are transformed before any is stored, so that quality rules can judge the batch
+	// as a whole.
+	records := make([]transformedRecord, len(rawData))
+	for i, rawRecord := range rawData {
+		if isDeletedRecord(rawRecord) {
+			records[i].deleted = true
+			continue
+		}
+		records[i].data, records[i].identifier, records[i].fieldErrors = s.transformAndConvertRecord(rawRecord, mappings, attributeDefs, i+1, sourceID)
+	}
+	suppressedCount := 0
+	if s.db != nil {
+		if suppressedCount, err = s.markSuppressed(sourceID, attributeDefs, rawData, records); err != nil {
+			return 0, err
+		}
+	}
+	quality := s.checkQuality(qualityRules, attributeDefs, entityDefinitionID, records)
+	if quality.scorecard != nil {
+		quality.scorecard.SourceID = sourceID
+		quality.scorecard.RunID = runID
+		quality.scorecard.EntityTypeName = entityTypeName
+	}
 
 	if s.db == nil {
 		log.Println("Warning: ProcessingService.db is nil. Skipping database operations. This should only occur in specific test scenarios.")
+		if quality.failure != "" {
+			return 0, fmt.Errorf("%w: %s", ErrQualityCheckFailed, quality.failure)
+		}
 		processedCountForLogicTest := 0
 		for i, rawRecord := range rawData {
-			processedRecord, _ := s.transformAndConvertRecord(rawRecord, mappings, attributeDefs, i+1, sourceID)
-			if len(processedRecord) > 0 {
+			if isDeletedRecord(rawRecord) {
+				if rawRecordIdentifier(rawRecord) != "" {
+					processedCountForLogicTest++
+				}
+				continue
+			}
+			if _, rejected := quality.rejections[i]; len(records[i].data) > 0 && !rejected {
 				processedCountForLogicTest++
 			}
 		}
 		return processedCountForLogicTest, nil
 	}
 
+	// A failed check stores nothing of the batch; only the scorecard records why.
+	if quality.failure != "" {
+		log.Printf("Data quality check failed for sourceID: %s, entityTypeName: %s: %s", sourceID, entityTypeName, quality.failure)
+		if err := saveScorecard(s.db, quality.scorecard); err != nil {
+			log.Printf("Failed to store the quality scorecard of source %s: %v", sourceID, err)
+		}
+		return 0, fmt.Errorf("%w: %s", ErrQualityCheckFailed, quality.failure)
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin database transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT INTO processed_entities (id, entity_definition_id, entity_type_name, source_id, attributes, raw_record_identifier, processed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")
+	// Records are keyed by (source_id, raw_record_identifier). Re-ingesting a known record updates it
+	// in place and keeps its instance ID, so group memberships and workflow history remain valid.
+	// Records without an identifier cannot be matched and are always inserted.
+	stmt, err := tx.Prepare(`INSERT INTO processed_entities (id, entity_definition_id, entity_type_name, source_id, attributes, raw_record_identifier, processed_at, pii_attributes)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (source_id, raw_record_identifier) WHERE raw_record_identifier IS NOT NULL
+        DO UPDATE SET entity_definition_id = EXCLUDED.entity_definition_id,
+                      entity_type_name = EXCLUDED.entity_type_name,
+                      attributes = EXCLUDED.attributes,
+                      processed_at = EXCLUDED.processed_at,
+                      pii_attributes = EXCLUDED.pii_attributes,
+                      deleted_at = NULL
+        RETURNING id, (xmax = 0) AS inserted`)
 	if err != nil {
-		return 0, fmt.Errorf("failed to prepare insert statement for processed_entities: %w", err)
+		return 0, fmt.Errorf("failed to prepare upsert statement for processed_entities: %w", err)
 	}
 	defer stmt.Close()
 
+	// Deletes keep the instance and only set deleted_at. A delete of a record that was never stored
+	// still leaves an empty tombstone.
+	tombstoneStmt, err := tx.Prepare(`INSERT INTO processed_entities (id, entity_definition_id, entity_type_name, source_id, attributes, raw_record_identifier, processed_at, deleted_at)
+        VALUES ($1, $2, $3, $4, '{}', $5, $6, $6)
+        ON CONFLICT (source_id, raw_record_identifier) WHERE raw_record_identifier IS NOT NULL
+        DO UPDATE SET processed_at = EXCLUDED.processed_at,
+                      deleted_at = EXCLUDED.deleted_at
+        RETURNING id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare tombstone statement for processed_entities: %w", err)
+	}
+	defer tombstoneStmt.Close()
+
+	quarantine, err := prepareQuarantine(tx, sourceID, entityTypeName, runID)
+	if err != nil {
+		return 0, err
+	}
+	defer quarantine.Close()
+
+	// Every change of an instance's attributes closes its current version and opens a new one.
+	history, err := prepareHistory(tx, sourceID, entityDefinitionID, entityTypeName)
+	if err != nil {
+		return 0, err
+	}
+	defer history.Close()
+
+	// Typed table of the entity definition, if it is materialized.
+	materialized, err := prepareMaterialized(tx, entityDefinitionID, attributeDefs)
+	if err != nil {
+		return 0, err
+	}
+	if materialized != nil {
+		defer materialized.Close()
+	}
+
+	// PII attributes are hashed, tokenized or encrypted before they are stored or versioned.
+	pii, err := preparePII(tx, s.piiKeys, attributeDefs)
+	if err != nil {
+		return 0, err
+	}
+	defer pii.Close()
+	quarantineID := func(i int) string {
+		if quarantineIDs == nil {
+			return ""
+		}
+		return quarantineIDs[i]
+	}
+
 	processedCount := 0
+	insertedCount := 0
+	deletedCount := 0
 	for i, rawRecord := range rawData {
-		processedRecordData, rawRecordIdentifierStr := s.transformAndConvertRecord(rawRecord, mappings, attributeDefs, i+1, sourceID)
+		if records[i].suppressed {
+			// Neither the record nor a quarantine entry is kept, they would hold the erased data again.
+			log.Printf("Record #%d for source %s belongs to an erased data subject on the suppression list. Skipping.", i+1, sourceID)
+			continue
+		}
+		if isDeletedRecord(rawRecord) {
+			identifier := rawRecordIdentifier(rawRecord)
+			if identifier == "" {
+				log.Printf("Deleted record #%d for source %s has no identifier. Skipping.", i+1, sourceID)
+				if err := quarantine.reject(quarantineID(i), i+1, rawRecord, "", []FieldError{{Error: "deleted record has no identifier"}}); err != nil {
+					return processedCount, err
+				}
+				continue
+			}
+			prev, err := history.previous(identifier)
+			if err != nil {
+				return processedCount, err
+			}
+			now := time.Now().UTC()
+			var storedID string
+			if err := tombstoneStmt.QueryRow(uuid.New(), sql.NullString{String: entityDefinitionID, Valid: entityDefinitionID != ""}, entityTypeName, sourceID, identifier, now).Scan(&storedID); err != nil {
+				return processedCount, fmt.Errorf("failed to mark record %s as deleted: %w", identifier, err)
+			}
+			if prev != nil {
+				if err := history.end(storedID, prev, now); err != nil {
+					return processedCount, err
+				}
+			}
+			if materialized != nil {
+				if err := materialized.remove(storedID); err != nil {
+					return processedCount, err
+				}
+			}
+			if err := quarantine.resolve(quarantineID(i), identifier); err != nil {
+				return processedCount, err
+			}
+			deletedCount++
+			processedCount++
+			continue
+		}
+
+		processedRecordData, rawRecordIdentifierStr, fieldErrors := records[i].data, records[i].identifier, records[i].fieldErrors
 		
+		if violations, rejected := quality.rejections[i]; rejected {
+			log.Printf("Record #%d for source %s violates %d data quality rule(s). Skipping.", i+1, sourceID, len(violations))
+			if err := quarantine.reject(quarantineID(i), i+1, rawRecord, rawRecordIdentifierStr, append(fieldErrors, violations...)); err != nil {
+				return processedCount, err
+			}
+			continue
+		}
+
 		if len(processedRecordData) == 0 {
 			log.Printf("Record #%d for source %s resulted in empty processed data after mapping and conversion. Skipping.", i+1, sourceID)
+			if len(fieldErrors) == 0 {
+				fieldErrors = []FieldError{{Error: "record has none of the mapped source fields"}}
+			}
+			if err := quarantine.reject(quarantineID(i), i+1, rawRecord, rawRecordIdentifierStr, fieldErrors); err != nil {
+				return processedCount, err
+			}
 			continue
 		}
 
+		piiAttributes, err := pii.protect(processedRecordData)
+		if err != nil {
+			return processedCount, fmt.Errorf("failed to protect the PII attributes of record #%d: %w", i+1, err)
+		}
 		jsonData, err := json.Marshal(processedRecordData)
 		if err != nil {
 			log.Printf("Failed to marshal processed record #%d for source %s: %v. Skipping.", i+1, sourceID, err)
+			if err := quarantine.reject(quarantineID(i), i+1, rawRecord, rawRecordIdentifierStr, []FieldError{{Error: fmt.Sprintf("failed to marshal processed record: %v", err)}}); err != nil {
+				return processedCount, err
+			}
 			continue
 		}
 
@@ -395,19 +915,54 @@ func (s *ProcessingService) ProcessAndStoreData(sourceID string, entityTypeName
 			dbRawRecordIdentifier.Valid = true
 		}
 
-		_, err = stmt.Exec(recordID, dbEntityDefinitionID, entityTypeName, sourceID, jsonData, dbRawRecordIdentifier, time.Now().UTC())
+		prev, err := history.previous(rawRecordIdentifierStr)
+		if err != nil {
+			return processedCount, err
+		}
+
+		var storedID string
+		var inserted bool
+		now := time.Now().UTC()
+		err = stmt.QueryRow(recordID, dbEntityDefinitionID, entityTypeName, sourceID, jsonData, dbRawRecordIdentifier, now, sql.NullString{String: string(piiAttributes), Valid: piiAttributes != nil}).Scan(&storedID, &inserted)
 		if err != nil {
-			log.Printf("Failed to insert processed record #%d (ID: %s) for source %s: %v", i+1, recordID, sourceID, err)
-			return processedCount, fmt.Errorf("failed to insert record %s: %w", recordID, err)
+			log.Printf("Failed to upsert processed record #%d (ID: %s) for source %s: %v", i+1, recordID, sourceID, err)
+			return processedCount, fmt.Errorf("failed to upsert record %s: %w", recordID, err)
+		}
+		if err := history.record(storedID, prev, jsonData, now); err != nil {
+			return processedCount, err
+		}
+		if materialized != nil {
+			if err := materialized.upsert(storedID); err != nil {
+				return processedCount, err
+			}
+		}
+		if inserted {
+			insertedCount++
 		}
 		processedCount++
+
+		// The record is stored, but without the fields listed in fieldErrors.
+		if len(fieldErrors) > 0 {
+			err = quarantine.storePartial(quarantineID(i), i+1, rawRecord, rawRecordIdentifierStr, fieldErrors)
+		} else {
+			err = quarantine.resolve(quarantineID(i), rawRecordIdentifierStr)
+		}
+		if err != nil {
+			return processedCount, err
+		}
+	}
+
+	if quality.scorecard != nil {
+		if err := saveScorecard(tx, quality.scorecard); err != nil {
+			return processedCount, err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("failed to commit database transaction: %w", err)
 	}
 
-	log.Printf("Successfully processed and stored %d records for sourceID: %s, entityTypeName: %s", processedCount, sourceID, entityTypeName)
+	log.Printf("Successfully processed and stored %d records (%d inserted, %d updated, %d deleted, %d quarantined, %d suppressed, %d versions written) for sourceID: %s, entityTypeName: %s", processedCount, insertedCount, processedCount-insertedCount-deletedCount, deletedCount, quarantine.count, suppressedCount, history.count, sourceID, entityTypeName)
 	return processedCount, nil
 }
 
@@ -425,7 +980,14 @@ type AttributeDefinition struct {
 	ID       string `json:"id"`
 	EntityID string `json:"entity_id"`
 	Name     string `json:"name"`
-	DataType string `json:"data_type"`
+	DataType string `json:"data_type_name"`
+	// DataTypeDetails holds enum values, array item types, object schemas and referenced entities.
+	DataTypeDetails map[string]interface{} `json:"data_type_details,omitempty"`
+	// IsIndexed attributes get an index on their column in materialized tables.
+	IsIndexed bool `json:"is_indexed"`
+	// IsPii attributes are protected according to PiiPolicy and redacted in logs and API responses, see pii.go.
+	IsPii     bool                `json:"is_pii"`
+	PiiPolicy *AttributePiiPolicy `json:"pii_policy,omitempty"`
 }
 
 type DataSourceConfig struct {
@@ -438,7 +1000,7 @@ type DataSourceConfig struct {
 
 // EntityDefinition is not directly used in ProcessAndStoreData if EntityTypeName is passed in,
 // but GetDataSourceConfig might provide EntityID that refers to an EntityDefinition.
-// type EntityDefinition struct {
-// 	ID   string `json:"id"`
-// 	Name string `json:"name"`
-// }
+type EntityDefinition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -1,24 +1,28 @@
 package processing
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
+	"os"
 	"testing"
 	"time"
 
+	_ "github.com/lib/pq" // PostgreSQL driver
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 // --- Mock MetadataServiceAPIClient ---
 type MockMetadataServiceClient struct {
-	GetDataSourceFieldMappingsFunc func(sourceID string) ([]DataSourceFieldMapping, error)
-	GetAttributeDefinitionFunc     func(attributeID string, entityID string) (*AttributeDefinition, error)
-	GetDataSourceConfigFunc        func(sourceID string) (*DataSourceConfig, error)
-	// GetEntityDefinitionFunc is not currently called by ProcessAndStoreData directly, so not strictly needed for these tests
-	// GetEntityDefinitionFunc        func(entityID string) (*EntityDefinition, error)
+	GetDataSourceFieldMappingsFunc   func(sourceID string) ([]DataSourceFieldMapping, error)
+	GetAttributeDefinitionFunc       func(attributeID string, entityID string) (*AttributeDefinition, error)
+	GetDataSourceConfigFunc          func(sourceID string) (*DataSourceConfig, error)
+	UpdateDataSourceFieldMappingFunc func(sourceID string, mappingID string, changes map[string]interface{}) (*DataSourceFieldMapping, error)
+	GetQualityRulesFunc              func(sourceID string, entityID string) ([]DataQualityRule, error)
+	GetEntityDefinitionFunc          func(entityID string) (*EntityDefinition, error)
+	ListAttributeDefinitionsFunc     func(entityID string) ([]AttributeDefinition, error)
+	ListRetentionPoliciesFunc        func() ([]RetentionPolicy, error)
 }
 
 func (m *MockMetadataServiceClient) GetDataSourceFieldMappings(sourceID string) ([]DataSourceFieldMapping, error) {
@@ -42,6 +46,42 @@ func (m *MockMetadataServiceClient) GetDataSourceConfig(sourceID string) (*DataS
 	return nil, fmt.Errorf("GetDataSourceConfigFunc not implemented")
 }
 
+func (m *MockMetadataServiceClient) UpdateDataSourceFieldMapping(sourceID string, mappingID string, changes map[string]interface{}) (*DataSourceFieldMapping, error) {
+	if m.UpdateDataSourceFieldMappingFunc != nil {
+		return m.UpdateDataSourceFieldMappingFunc(sourceID, mappingID, changes)
+	}
+	return nil, fmt.Errorf("UpdateDataSourceFieldMappingFunc not implemented")
+}
+
+// GetQualityRules returns no rules unless GetQualityRulesFunc is set, as most tests don't need any.
+func (m *MockMetadataServiceClient) GetQualityRules(sourceID string, entityID string) ([]DataQualityRule, error) {
+	if m.GetQualityRulesFunc != nil {
+		return m.GetQualityRulesFunc(sourceID, entityID)
+	}
+	return nil, nil
+}
+
+func (m *MockMetadataServiceClient) GetEntityDefinition(entityID string) (*EntityDefinition, error) {
+	if m.GetEntityDefinitionFunc != nil {
+		return m.GetEntityDefinitionFunc(entityID)
+	}
+	return nil, fmt.Errorf("GetEntityDefinitionFunc not implemented")
+}
+
+func (m *MockMetadataServiceClient) ListAttributeDefinitions(entityID string) ([]AttributeDefinition, error) {
+	if m.ListAttributeDefinitionsFunc != nil {
+		return m.ListAttributeDefinitionsFunc(entityID)
+	}
+	return nil, fmt.Errorf("ListAttributeDefinitionsFunc not implemented")
+}
+
+func (m *MockMetadataServiceClient) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	if m.ListRetentionPoliciesFunc != nil {
+		return m.ListRetentionPoliciesFunc()
+	}
+	return nil, fmt.Errorf("ListRetentionPoliciesFunc not implemented")
+}
+
 // --- Tests for convertToTargetType ---
 func TestConvertToTargetType(t *testing.T) {
 	t.Run("String Conversions", func(t *testing.T) {
@@ -236,7 +276,7 @@ func TestTransformAndConvertRecord(t *testing.T) {
 			{SourceFieldName: "joined_at", AttributeID: "attr_regdate"},
 		}
 
-		processedData, rawID := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSource")
+		processedData, rawID, _ := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSource")
 
 		assert.Equal(t, "user123", rawID)
 		require.Len(t, processedData, 6)
@@ -260,12 +300,17 @@ func TestTransformAndConvertRecord(t *testing.T) {
 			{SourceFieldName: "user_notes", AttributeID: "attr_notes"}, // string to string
 			{SourceFieldName: "user_age", AttributeID: "attr_age"},   // string "thirty" to integer
 		}
-		processedData, _ := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSourceConvError")
+		processedData, _, fieldErrors := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSourceConvError")
 		
 		require.Len(t, processedData, 1) // Only notes should be processed
 		assert.Equal(t, "this is okay", processedData["Notes"])
 		_, ageExists := processedData["Age"]
 		assert.False(t, ageExists, "Age should be skipped due to conversion error")
+		require.Len(t, fieldErrors, 1)
+		assert.Equal(t, "user_age", fieldErrors[0].SourceField)
+		assert.Equal(t, "Age", fieldErrors[0].Attribute)
+		assert.Equal(t, "thirty", fieldErrors[0].Value)
+		assert.Contains(t, fieldErrors[0].Error, "cannot convert to integer")
 	})
 
 	t.Run("Mapping for Non-Existent Raw Field", func(t *testing.T) {
@@ -274,7 +319,7 @@ func TestTransformAndConvertRecord(t *testing.T) {
 			{SourceFieldName: "non_existent_field", AttributeID: "attr_name"},
 			{SourceFieldName: "actual_field", AttributeID: "attr_notes"},
 		}
-		processedData, _ := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSourceNonExistentField")
+		processedData, _, _ := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSourceNonExistentField")
 
 		require.Len(t, processedData, 1)
 		assert.Equal(t, "data", processedData["Notes"])
@@ -282,20 +327,43 @@ func TestTransformAndConvertRecord(t *testing.T) {
 		assert.False(t, nameExists, "FullName should not exist as its source field was missing")
 	})
 
+	t.Run("Expression Rules", func(t *testing.T) {
+		rawRecord := map[string]interface{}{
+			"first_name": "Ada",
+			"last_name":  "Lovelace",
+			"age_text":   "age: 36",
+			"signup":     "15/01/2023",
+		}
+		mappings := []DataSourceFieldMapping{
+			// The source field "name" does not exist; the rule builds the value from other fields
+			{SourceFieldName: "name", AttributeID: "attr_name", TransformationRule: `concat(first_name, " ", upper(last_name))`},
+			{SourceFieldName: "age_text", AttributeID: "attr_age", TransformationRule: `to_number(regex_extract(value, "\d+")) + 1`},
+			{SourceFieldName: "signup", AttributeID: "attr_regdate", TransformationRule: `parse_date(value, "02/01/2006")`},
+			{SourceFieldName: "email", AttributeID: "attr_email", TransformationRule: "lower(value)"}, // Missing and null: skipped
+			{SourceFieldName: "first_name", AttributeID: "attr_notes", TransformationRule: "lower(value"}, // Invalid: skipped
+		}
+		processedData, _, _ := service.transformAndConvertRecord(rawRecord, mappings, attrDefs, 1, "testSourceExpressions")
+
+		require.Len(t, processedData, 3)
+		assert.Equal(t, "Ada LOVELACE", processedData["FullName"])
+		assert.Equal(t, int64(37), processedData["Age"])
+		assert.Equal(t, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC), processedData["RegistrationDate"])
+	})
+
 	t.Run("Raw Record Identifier Derivation", func(t *testing.T) {
 		// Case 1: "id" field exists
 		rawRecord1 := map[string]interface{}{"id": "record_xyz", "data": "value1"}
-		_, rawID1 := service.transformAndConvertRecord(rawRecord1, []DataSourceFieldMapping{}, attrDefs, 1, "s1")
+		_, rawID1, _ := service.transformAndConvertRecord(rawRecord1, []DataSourceFieldMapping{}, attrDefs, 1, "s1")
 		assert.Equal(t, "record_xyz", rawID1)
 
 		// Case 2: "source_record_id" field exists
 		rawRecord2 := map[string]interface{}{"source_record_id": "record_abc", "data": "value2"}
-		_, rawID2 := service.transformAndConvertRecord(rawRecord2, []DataSourceFieldMapping{}, attrDefs, 1, "s2")
+		_, rawID2, _ := service.transformAndConvertRecord(rawRecord2, []DataSourceFieldMapping{}, attrDefs, 1, "s2")
 		assert.Equal(t, "record_abc", rawID2)
 		
 		// Case 3: Neither exists
 		rawRecord3 := map[string]interface{}{"other_field": "other_value", "data": "value3"}
-		_, rawID3 := service.transformAndConvertRecord(rawRecord3, []DataSourceFieldMapping{}, attrDefs, 1, "s3")
+		_, rawID3, _ := service.transformAndConvertRecord(rawRecord3, []DataSourceFieldMapping{}, attrDefs, 1, "s3")
 		assert.Empty(t, rawID3, "Raw ID should be empty if no specific ID field is found")
 	})
 }
@@ -591,6 +659,87 @@ func TestProcessAndStoreData_DBInteraction(t *testing.T) {
 		// The current ProcessAndStoreData returns on the *first* stmt.Exec error, so a "partial batch commit" isn't possible.
 	})
 
+	t.Run("Re-ingestion Upserts By Raw Record Identifier", func(t *testing.T) {
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities"), "Failed to clear table")
+
+		firstRun := []map[string]interface{}{
+			{"id": "order1", "product_name": "Laptop", "quantity": "1"},
+			{"product_name": "No Identifier", "quantity": "1"},
+		}
+		count, err := service.ProcessAndStoreData(sourceID, entityType, firstRun)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		var originalID string
+		require.NoError(t, testDB.QueryRow("SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = 'order1'", sourceID).Scan(&originalID))
+
+		secondRun := []map[string]interface{}{
+			{"id": "order1", "product_name": "Laptop Pro", "quantity": "2"},
+			{"product_name": "No Identifier", "quantity": "1"},
+		}
+		count, err = service.ProcessAndStoreData(sourceID, entityType, secondRun)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		var instanceIDs []string
+		rows, err := testDB.Query("SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = 'order1'", sourceID)
+		require.NoError(t, err)
+		for rows.Next() {
+			var id string
+			require.NoError(t, rows.Scan(&id))
+			instanceIDs = append(instanceIDs, id)
+		}
+		rows.Close()
+		assert.Equal(t, []string{originalID}, instanceIDs, "re-ingested record must keep its instance ID")
+
+		dbRecords := fetchProcessedRecords(t, testDB, sourceID, entityType)
+		assert.Len(t, dbRecords, 3, "records without an identifier are still inserted on every run")
+		for _, rec := range dbRecords {
+			if rec["_db_raw_record_identifier"] == "order1" {
+				assert.Equal(t, "Laptop Pro", rec["ProductName"])
+				assert.Equal(t, float64(2), rec["Quantity"])
+			}
+		}
+	})
+
+	t.Run("Deleted Records Become Tombstones", func(t *testing.T) {
+		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities"), "Failed to clear table")
+
+		count, err := service.ProcessAndStoreData(sourceID, entityType, []map[string]interface{}{
+			{"id": "order1", "product_name": "Laptop", "quantity": "1"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		var originalID string
+		require.NoError(t, testDB.QueryRow("SELECT id FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = 'order1'", sourceID).Scan(&originalID))
+
+		count, err = service.ProcessAndStoreData(sourceID, entityType, []map[string]interface{}{
+			{"id": "order1", DeletedRecordField: true},
+			{"id": "never_seen", DeletedRecordField: true},
+			{DeletedRecordField: true}, // No identifier, skipped
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		var deletedID string
+		var attributes []byte
+		require.NoError(t, testDB.QueryRow("SELECT id, attributes FROM processed_entities WHERE source_id = $1 AND raw_record_identifier = 'order1' AND deleted_at IS NOT NULL", sourceID).Scan(&deletedID, &attributes))
+		assert.Equal(t, originalID, deletedID, "tombstone must keep the instance ID")
+		assert.Contains(t, string(attributes), "Laptop", "tombstone keeps the last known attributes")
+		var tombstones int
+		require.NoError(t, testDB.QueryRow("SELECT COUNT(*) FROM processed_entities WHERE source_id = $1 AND deleted_at IS NOT NULL", sourceID).Scan(&tombstones))
+		assert.Equal(t, 2, tombstones)
+
+		// Re-inserting the record at the source revives it
+		_, err = service.ProcessAndStoreData(sourceID, entityType, []map[string]interface{}{
+			{"id": "order1", "product_name": "Laptop", "quantity": "1"},
+		})
+		require.NoError(t, err)
+		var revived bool
+		require.NoError(t, testDB.QueryRow("SELECT deleted_at IS NULL FROM processed_entities WHERE id = $1", originalID).Scan(&revived))
+		assert.True(t, revived)
+	})
+
 	t.Run("Empty rawData", func(t *testing.T) {
 		require.NoError(t, clearTablesForDBTests(testDB, "processed_entities"), "Failed to clear table")
 		
@@ -0,0 +1,142 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledRuleEvaluate(t *testing.T) {
+	record := map[string]interface{}{
+		"first_name": "  Ada ",
+		"last_name":  "Lovelace",
+		"Full Name":  "Ada Lovelace",
+		"price":      "19.99",
+		"quantity":   float64(3),
+		"email":      "ADA@Example.COM",
+		"phone":      "tel: +44 (20) 7946-0000",
+		"signup":     "15/01/2023 10:30",
+		"nickname":   "",
+		"status":     "A",
+	}
+	tests := []struct {
+		name     string
+		rule     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"Legacy Lowercase", "lowercase", "ABC", "abc"},
+		{"Legacy Trim", "Trim", "  abc ", "abc"},
+		{"String Functions", "upper(trim(value))", " ada ", "ADA"},
+		{"Substring", "substr(value, 1, 3)", "abcdef", "bcd"},
+		{"Substring Out Of Range", "substr(value, 4, 10)", "abcdef", "ef"},
+		{"Replace", `replace(value, "-", "")`, "12-34-56", "123456"},
+		{"Split Part", `split_part(value, "@", 2)`, "ada@example.com", "example.com"},
+		{"Length", "len(value)", "héllo", 5.0},
+		{"Concatenation Of Fields", `concat(trim(first_name), " ", last_name)`, nil, "Ada Lovelace"},
+		{"Field With Spaces", `field("Full Name")`, nil, "Ada Lovelace"},
+		{"Arithmetic With Numeric Strings", "price * quantity", nil, 59.97},
+		{"Precedence", "1 + 2 * 3 - 4 / 2", nil, 5.0},
+		{"Parentheses And Unary Minus", "-(1 + 2) * 2", nil, -6.0},
+		{"Modulo", "7 % 3", nil, 1.0},
+		{"Round", "round(price * quantity, 1)", nil, 60.0},
+		{"Floor And Abs", "floor(abs(-2.5))", nil, 2.0},
+		{"Regex Extract Group", `regex_extract(value, "(\d+)-(\d+)", 2)`, "order 12-345", "345"},
+		{"Regex Extract Default Group", `regex_extract(email, "@(.+)$")`, nil, "Example.COM"},
+		{"Regex Extract No Match", `regex_extract(value, "\d+")`, "none", nil},
+		{"Regex Replace", `regex_replace(phone, "[^0-9+]", "")`, nil, "+442079460000"},
+		{"Regex Replace Groups", `regex_replace(value, "(\w+) (\w+)", "$2, $1")`, "Ada Lovelace", "Lovelace, Ada"},
+		{"Coalesce Skips Null And Empty", "coalesce(missing, nickname, first_name)", nil, "  Ada "},
+		{"Default", `default(value, "unknown")`, nil, "unknown"},
+		{"Default Keeps Value", `default(value, "unknown")`, "known", "known"},
+		{"Ternary", `status == "A" ? "active" : "inactive"`, nil, "active"},
+		{"Nested Ternary", `quantity > 5 ? "bulk" : quantity > 1 ? "few" : "one"`, nil, "few"},
+		{"If Function", `if(contains(lower(email), "example"), "test", "real")`, nil, "test"},
+		{"Logic", `starts_with(value, "a") && !ends_with(value, "z") || false`, "abc", true},
+		{"Number Equals Numeric String", `price == 19.99`, nil, true},
+		{"Null Comparison", `missing == null`, nil, true},
+		{"Null Propagates Through Arithmetic", "missing * 2", nil, nil},
+		{"Null Propagates Through String Functions", "lower(missing)", nil, nil},
+		{"Parse Date", `parse_date(signup, "02/01/2006 15:04")`, nil, time.Date(2023, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{"Parse Date Tries Layouts In Order", `parse_date(value, "2006-01-02", "Jan 2, 2006")`, "Mar 4, 2024", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"Parse Date Named Layout", `parse_date(value, "RFC3339")`, "2024-03-04T05:06:07Z", time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{"Parse Empty Date", `parse_date(value, "2006-01-02")`, "", nil},
+		{"Format Date", `format_date(parse_date(signup, "02/01/2006 15:04"), "2006-01-02")`, nil, "2023-01-15"},
+		{"Date Comparison", `parse_date(value, "2006-01-02") < parse_date("2024-01-01", "2006-01-02")`, "2023-06-30", true},
+		{"To Number And String", `to_string(to_number(value) + 1)`, "41", "42"},
+		{"Escaped Quotes", `concat(value, "\"", 'it\'s')`, "say ", `say "it's`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := CompileTransformationRule(tt.rule)
+			require.NoError(t, err)
+			result, err := rule.Evaluate(tt.value, record)
+			require.NoError(t, err)
+			if expected, ok := tt.expected.(float64); ok {
+				assert.InDelta(t, expected, result, 1e-9)
+				return
+			}
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestCompiledRuleRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		value interface{}
+	}{
+		{"Non Numeric Arithmetic", "value * 2", "abc"},
+		{"Division By Zero", "value / 0", 1.0},
+		{"Date Matches No Layout", `parse_date(value, "2006-01-02")`, "yesterday"},
+		{"Format Non Date", `format_date(value, "2006")`, "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := CompileTransformationRule(tt.rule)
+			require.NoError(t, err)
+			_, err = rule.Evaluate(tt.value, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCompileTransformationRuleErrors(t *testing.T) {
+	deep := ""
+	for i := 0; i < maxRuleDepth+2; i++ {
+		deep += "("
+	}
+	tests := []struct {
+		name     string
+		rule     string
+		contains string
+	}{
+		{"Empty", "   ", "empty"},
+		{"Unknown Function", "shout(value)", `unknown function "shout"`},
+		{"Legacy Name Not A Function", "multiply_by_100(value)", "unknown function"},
+		{"Too Few Arguments", "substr(value)", "substr expects 2 to 3 arguments, got 1"},
+		{"Too Many Arguments", "lower(value, 1)", "lower expects 1 argument, got 2"},
+		{"Invalid Regex", `regex_extract(value, "(")`, "invalid pattern"},
+		{"Dynamic Regex", `regex_replace(value, pattern, "")`, "pattern must be a string literal"},
+		{"Missing Regex Group", `regex_extract(value, "(a)", 2)`, "no group 2"},
+		{"Layout Without Elements", `parse_date(value, "YYYY-MM-DD")`, "no date or time elements"},
+		{"Dynamic Layout", `parse_date(value, layout)`, "string literals"},
+		{"Field Needs Literal", "field(value)", "field expects a string literal"},
+		{"Unterminated String", `concat(value, "abc)`, "unterminated string"},
+		{"Unexpected Character", "value # 2", "unexpected character"},
+		{"Trailing Tokens", "value value", "unexpected"},
+		{"Missing Parenthesis", "lower(value", `expected ")"`},
+		{"Incomplete Ternary", `value ? "a"`, `expected ":"`},
+		{"Too Deep", deep + "1", "nests deeper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileTransformationRule(tt.rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.contains)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package secrets implements secret references: configuration values such as
+// {"secret_ref": "env:PG_PASS"} or {"secret_ref": "file:/run/secrets/pg_pass"} that name where a
+// secret is kept instead of containing it. The metadata service stores and returns only the
+// reference; the service that needs the secret resolves it when it connects.
+//
+// The ingestion service and the webhook action executor are separate modules and mirror Resolve
+// and Value; keep them in sync with this package.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RefKey is the key of a secret reference object.
+const RefKey = "secret_ref"
+
+// Redacted replaces plaintext secrets in API responses.
+const Redacted = "[REDACTED]"
+
+// ErrUnsupportedRef is returned for references that are neither "env:" nor "file:" references.
+var ErrUnsupportedRef = errors.New("unsupported secret reference")
+
+// Resolve returns the secret named by ref: "env:NAME" reads the environment variable NAME and
+// "file:/path" reads the file at /path without its trailing newline, e.g. a Docker or Kubernetes
+// secret mount. An unset or empty secret is an error.
+func Resolve(ref string) (string, error) {
+	kind, name, _ := strings.Cut(ref, ":")
+	if name == "" {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedRef, ref)
+	}
+	switch kind {
+	case "env":
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", ref, name)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		value := strings.TrimRight(string(content), "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("secret %s: file is empty", ref)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnsupportedRef, ref)
+	}
+}
+
+// Value is a configuration value given either as a plain JSON string or as a secret reference
+// object. Plain strings are still accepted so that existing configurations keep working.
+type Value struct {
+	Plain string
+	Ref   string
+}
+
+// UnmarshalJSON accepts a string, a {"secret_ref": "..."} object or null.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	*v = Value{}
+	if string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &v.Plain); err == nil {
+		return nil
+	}
+	var ref map[string]string
+	if err := json.Unmarshal(data, &ref); err != nil || ref[RefKey] == "" {
+		return fmt.Errorf("secret value must be a string or an object with a %q key", RefKey)
+	}
+	v.Ref = ref[RefKey]
+	return nil
+}
+
+// MarshalJSON writes the reference object, or the plain string.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Ref != "" {
+		return json.Marshal(map[string]string{RefKey: v.Ref})
+	}
+	return json.Marshal(v.Plain)
+}
+
+// Resolve returns the plain value or the secret its reference points to.
+func (v Value) Resolve() (string, error) {
+	if v.Ref != "" {
+		return Resolve(v.Ref)
+	}
+	return v.Plain, nil
+}
+
+// IsRef reports whether a decoded JSON value is a secret reference object.
+func IsRef(value interface{}) bool {
+	obj, ok := value.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return false
+	}
+	ref, ok := obj[RefKey].(string)
+	return ok && ref != ""
+}
+
+// secretKeys are configuration keys, normalized by normalizeKey, that hold secrets. Keys ending in
+// "_" followed by one of secretSuffixes (e.g. "smtp_password", "x_api_key") do as well.
+var (
+	secretKeys     = map[string]bool{"password": true, "passwd": true, "pass": true, "secret": true, "token": true, "apikey": true, "api_key": true, "private_key": true, "authorization": true, "credentials": true}
+	secretSuffixes = []string{"password", "passwd", "pass", "secret", "token", "apikey", "api_key", "private_key"}
+)
+
+// IsSecretKey reports whether a configuration key, or a header name such as "Authorization" or
+// "X-Api-Key", holds a secret. Keys naming where a secret is kept, like "secret_env" or
+// "token_header", do not.
+func IsSecretKey(key string) bool {
+	key = normalizeKey(key)
+	if secretKeys[key] {
+		return true
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, "_"+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+// RedactJSON returns the JSON document with every non-empty string stored under a secret key
+// (see IsSecretKey, and "value" inside an "auth" object) replaced by Redacted. Secret references
+// are kept since they do not contain the secret. A document that is not valid JSON is returned
+// unchanged.
+func RedactJSON(document string) string {
+	if document == "" {
+		return document
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(document), &decoded); err != nil {
+		return document
+	}
+	redacted, err := json.Marshal(redact(decoded, ""))
+	if err != nil {
+		return document
+	}
+	return string(redacted)
+}
+
+func redact(value interface{}, parentKey string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if s, ok := child.(string); ok {
+				if s != "" && isSecretField(parentKey, key) {
+					v[key] = Redacted
+				}
+				continue
+			}
+			v[key] = redact(child, key)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child, parentKey)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSecretField(parentKey, key string) bool {
+	return IsSecretKey(key) || (normalizeKey(parentKey) == "auth" && normalizeKey(key) == "value")
+}
+
+// RestoreRedacted replaces the Redacted placeholders in document by the value at the same place in
+// previous, so that a document read from the API can be sent back with only its other fields
+// changed. Placeholders without a previous value are removed. Documents that are not valid JSON
+// are returned unchanged.
+func RestoreRedacted(document, previous string) string {
+	if !strings.Contains(document, Redacted) {
+		return document
+	}
+	var decoded, old interface{}
+	if err := json.Unmarshal([]byte(document), &decoded); err != nil {
+		return document
+	}
+	_ = json.Unmarshal([]byte(previous), &old) // Without a valid previous document placeholders are dropped
+	restored, err := json.Marshal(restore(decoded, old))
+	if err != nil {
+		return document
+	}
+	return string(restored)
+}
+
+func restore(value, previous interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		old, _ := previous.(map[string]interface{})
+		for key, child := range v {
+			if child == Redacted {
+				if oldValue, ok := old[key].(string); ok && oldValue != Redacted {
+					v[key] = oldValue
+				} else {
+					delete(v, key)
+				}
+				continue
+			}
+			v[key] = restore(child, old[key])
+		}
+		return v
+	case []interface{}:
+		old, _ := previous.([]interface{})
+		for i, child := range v {
+			var oldChild interface{}
+			if i < len(old) {
+				oldChild = old[i]
+			}
+			v[i] = restore(child, oldChild)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("SECRETS_TEST_PASS", "s3cret")
+	value, err := Resolve("env:SECRETS_TEST_PASS")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+
+	path := filepath.Join(t.TempDir(), "pass")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+	value, err = Resolve("file:" + path)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", value)
+
+	_, err = Resolve("env:SECRETS_TEST_UNSET")
+	assert.Error(t, err)
+	_, err = Resolve("vault:secret/pg")
+	assert.ErrorIs(t, err, ErrUnsupportedRef)
+	_, err = Resolve("plaintext")
+	assert.ErrorIs(t, err, ErrUnsupportedRef)
+}
+
+func TestValue(t *testing.T) {
+	t.Setenv("SECRETS_TEST_PASS", "s3cret")
+	var params struct {
+		Password Value `json:"password"`
+		Token    Value `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"password": {"secret_ref": "env:SECRETS_TEST_PASS"}, "token": "plain"}`), &params))
+	password, err := params.Password.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", password)
+	token, err := params.Token.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, "plain", token)
+
+	encoded, err := json.Marshal(params)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"password": {"secret_ref": "env:SECRETS_TEST_PASS"}, "token": "plain"}`, string(encoded))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"password": {"ref": "env:X"}}`), &params))
+}
+
+func TestIsSecretKey(t *testing.T) {
+	for _, key := range []string{"password", "Password", "smtp_pass", "client_secret", "Authorization", "X-Api-Key", "access_token"} {
+		assert.True(t, IsSecretKey(key), key)
+	}
+	for _, key := range []string{"user", "secret_env", "token_header", "value_env", "passive", "host"} {
+		assert.False(t, IsSecretKey(key), key)
+	}
+}
+
+func TestRedactAndRestore(t *testing.T) {
+	stored := `{"host":"db","password":"hunter2","headers":{"Authorization":"Bearer abc","Accept":"application/json"},"auth":{"header":"X-Token","value":"t0k"},"secret":{"secret_ref":"file:/run/secrets/x"}}`
+
+	redacted := RedactJSON(stored)
+	assert.JSONEq(t, `{"host":"db","password":"[REDACTED]","headers":{"Authorization":"[REDACTED]","Accept":"application/json"},"auth":{"header":"X-Token","value":"[REDACTED]"},"secret":{"secret_ref":"file:/run/secrets/x"}}`, redacted)
+	assert.Equal(t, "not json", RedactJSON("not json"))
+
+	edited := `{"host":"db2","password":"[REDACTED]","headers":{"Authorization":"[REDACTED]"},"auth":{"header":"X-Token","value":"new"},"api_key":"[REDACTED]"}`
+	assert.JSONEq(t, `{"host":"db2","password":"hunter2","headers":{"Authorization":"Bearer abc"},"auth":{"header":"X-Token","value":"new"}}`, RestoreRedacted(edited, stored))
+	assert.Equal(t, `{"host":"db"}`, RestoreRedacted(`{"host":"db"}`, stored))
+}